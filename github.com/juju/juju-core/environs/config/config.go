@@ -341,6 +341,24 @@ func (c *Config) SSLHostnameVerification() bool {
 	return c.m["ssl-hostname-verification"].(bool)
 }
 
+// HTTPProxy returns the URL of the proxy through which the
+// environment's machines make HTTP requests, if any.
+func (c *Config) HTTPProxy() string {
+	return c.asString("http-proxy")
+}
+
+// HTTPSProxy returns the URL of the proxy through which the
+// environment's machines make HTTPS requests, if any.
+func (c *Config) HTTPSProxy() string {
+	return c.asString("https-proxy")
+}
+
+// NoProxy returns the comma-separated list of hosts
+// that should be reached without a proxy.
+func (c *Config) NoProxy() string {
+	return c.asString("no-proxy")
+}
+
 // UnknownAttrs returns a copy of the raw configuration attributes
 // that are supposedly specific to the environment type. They could
 // also be wrong attributes, though. Only the specific environment
@@ -388,6 +406,9 @@ var fields = schema.Fields{
 	"ssl-hostname-verification": schema.Bool(),
 	"state-port":                schema.ForceInt(),
 	"api-port":                  schema.ForceInt(),
+	"http-proxy":                schema.String(),
+	"https-proxy":               schema.String(),
+	"no-proxy":                  schema.String(),
 }
 
 var defaults = schema.Defaults{
@@ -405,6 +426,9 @@ var defaults = schema.Defaults{
 	"ssl-hostname-verification": true,
 	"state-port":                schema.Omit,
 	"api-port":                  schema.Omit,
+	"http-proxy":                schema.Omit,
+	"https-proxy":               schema.Omit,
+	"no-proxy":                  schema.Omit,
 }
 
 var checker = schema.FieldMap(fields, defaults)
@@ -315,6 +315,15 @@ var configTests = []configTest{
 			"ssl-hostname-verification": "yes please",
 		},
 		err: `ssl-hostname-verification: expected bool, got "yes please"`,
+	}, {
+		about: "Proxy settings",
+		attrs: attrs{
+			"type":        "my-type",
+			"name":        "my-name",
+			"http-proxy":  "http://proxy.example.com:3128",
+			"https-proxy": "https://proxy.example.com:3129",
+			"no-proxy":    "localhost,10.0.3.1",
+		},
 	}, {
 		about: "Explicit state port",
 		attrs: attrs{
@@ -562,6 +571,13 @@ func (test configTest) check(c *gc.C, home *testing.FakeHome) {
 	if v, ok := test.attrs["ssl-hostname-verification"]; ok {
 		c.Assert(cfg.SSLHostnameVerification(), gc.Equals, v)
 	}
+
+	httpProxy, _ := test.attrs["http-proxy"].(string)
+	c.Assert(cfg.HTTPProxy(), gc.Equals, httpProxy)
+	httpsProxy, _ := test.attrs["https-proxy"].(string)
+	c.Assert(cfg.HTTPSProxy(), gc.Equals, httpsProxy)
+	noProxy, _ := test.attrs["no-proxy"].(string)
+	c.Assert(cfg.NoProxy(), gc.Equals, noProxy)
 }
 
 func (*ConfigSuite) TestConfigAttrs(c *gc.C) {
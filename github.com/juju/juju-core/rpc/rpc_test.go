@@ -540,6 +540,65 @@ func (*suite) TestRootIsKilled(c *C) {
 	c.Assert(root.killed, Equals, true)
 }
 
+type InterceptorRoot struct {
+	Root
+	mu       sync.Mutex
+	requests []rpc.Request
+	veto     bool
+}
+
+func (r *InterceptorRoot) Intercept(req rpc.Request, invoke func() (interface{}, error)) (interface{}, error) {
+	r.mu.Lock()
+	r.requests = append(r.requests, req)
+	veto := r.veto
+	r.mu.Unlock()
+	if veto {
+		return nil, fmt.Errorf("vetoed %s.%s", req.Type, req.Action)
+	}
+	return invoke()
+}
+
+func (*suite) TestRootIsIntercepted(c *C) {
+	root := &InterceptorRoot{}
+	root.simple = map[string]*SimpleMethods{
+		"a0": {root: &root.Root, id: "a0"},
+	}
+	client, srvDone := newRPCClientServer(c, root, nil, false)
+	defer closeClient(c, client, srvDone)
+
+	var r stringVal
+	err := client.Call("SimpleMethods", "a0", "Call1r1", stringVal{"arg"}, &r)
+	c.Assert(err, IsNil)
+	c.Assert(r, Equals, stringVal{"Call1r1 ret"})
+	err = client.Call("SimpleMethods", "a0", "Call0r0", nil, nil)
+	c.Assert(err, IsNil)
+
+	root.mu.Lock()
+	root.veto = true
+	root.mu.Unlock()
+	err = client.Call("SimpleMethods", "a0", "Call0r0", nil, nil)
+	c.Assert(err, ErrorMatches, "request error: vetoed SimpleMethods.Call0r0")
+
+	root.mu.Lock()
+	defer root.mu.Unlock()
+	c.Assert(root.requests, DeepEquals, []rpc.Request{{
+		Type:   "SimpleMethods",
+		Id:     "a0",
+		Action: "Call1r1",
+		Params: stringVal{"arg"},
+	}, {
+		Type:   "SimpleMethods",
+		Id:     "a0",
+		Action: "Call0r0",
+	}, {
+		Type:   "SimpleMethods",
+		Id:     "a0",
+		Action: "Call0r0",
+	}})
+	// The vetoed request was never run.
+	c.Assert(root.calls, HasLen, 2)
+}
+
 func (*suite) TestBidirectional(c *C) {
 	srvRoot := &Root{}
 	client, srvDone := newRPCClientServer(c, srvRoot, nil, true)
@@ -253,6 +253,32 @@ type Killer interface {
 	Kill()
 }
 
+// Interceptor represents a root type that wishes to take control of
+// each request made on it, for instance to time or trace it, or to
+// refuse it. The Intercept method is called to serve every request,
+// and should call invoke to run the request itself; the result
+// and error it returns are sent as the reply. A nil result is
+// sent as an empty reply.
+type Interceptor interface {
+	Intercept(req Request, invoke func() (interface{}, error)) (interface{}, error)
+}
+
+// Request describes a request being served.
+type Request struct {
+	// Type holds the type of the object acted on.
+	Type string
+
+	// Id holds the id of the object acted on.
+	Id string
+
+	// Action holds the name of the method invoked on the object.
+	Action string
+
+	// Params holds the request parameters, or nil if the
+	// method takes none.
+	Params interface{}
+}
+
 // input reads messages from the connection and handles them
 // appropriately.
 func (conn *Conn) input() {
@@ -372,6 +398,9 @@ func (conn *Conn) writeErrorResponse(reqId uint64, err error) error {
 }
 
 type requestInfo struct {
+	root            reflect.Value
+	objType         string
+	request         string
 	obtain          *obtainer
 	action          *action
 	transformErrors func(error) error
@@ -399,6 +428,9 @@ func (conn *Conn) findRequest(hdr *Header) (requestInfo, error) {
 		return requestInfo{}, fmt.Errorf("no such request %q on %s", hdr.Request, hdr.Type)
 	}
 	info := requestInfo{
+		root:            rootValue,
+		objType:         hdr.Type,
+		request:         hdr.Request,
 		obtain:          o,
 		action:          a,
 		transformErrors: transformErrors,
@@ -409,32 +441,48 @@ func (conn *Conn) findRequest(hdr *Header) (requestInfo, error) {
 // runRequest runs the given request and sends the reply.
 func (conn *Conn) runRequest(reqId uint64, objId string, reqInfo requestInfo, arg reflect.Value) {
 	defer conn.srvPending.Done()
-	rv, err := conn.runRequest0(reqId, objId, reqInfo.obtain, reqInfo.action, arg)
+	rv, err := conn.runRequest0(objId, reqInfo, arg)
 	if err != nil {
 		err = conn.writeErrorResponse(reqId, reqInfo.transformErrors(err))
 	} else {
-		var rvi interface{}
 		hdr := &Header{
 			RequestId: reqId,
 		}
 		conn.sending.Lock()
 		defer conn.sending.Unlock()
-		if rv.IsValid() {
-			rvi = rv.Interface()
-		} else {
-			rvi = struct{}{}
+		if rv == nil {
+			rv = struct{}{}
 		}
-		err = conn.codec.WriteMessage(hdr, rvi)
+		err = conn.codec.WriteMessage(hdr, rv)
 	}
 	if err != nil {
 		log.Errorf("rpc: error writing response: %v", err)
 	}
 }
 
-func (conn *Conn) runRequest0(reqId uint64, objId string, obtain *obtainer, act *action, arg reflect.Value) (reflect.Value, error) {
-	obj, err := obtain.call(conn.rootValue, objId)
-	if err != nil {
-		return reflect.Value{}, err
+func (conn *Conn) runRequest0(objId string, reqInfo requestInfo, arg reflect.Value) (interface{}, error) {
+	invoke := func() (interface{}, error) {
+		obj, err := reqInfo.obtain.call(reqInfo.root, objId)
+		if err != nil {
+			return nil, err
+		}
+		rv, err := reqInfo.action.call(obj, arg)
+		if err != nil || !rv.IsValid() {
+			return nil, err
+		}
+		return rv.Interface(), nil
+	}
+	interceptor, ok := reqInfo.root.Interface().(Interceptor)
+	if !ok {
+		return invoke()
+	}
+	req := Request{
+		Type:   reqInfo.objType,
+		Id:     objId,
+		Action: reqInfo.request,
+	}
+	if arg.IsValid() {
+		req.Params = arg.Interface()
 	}
-	return act.call(obj, arg)
+	return interceptor.Intercept(req, invoke)
 }
@@ -62,6 +62,7 @@ func (a *annotator) SetAnnotations(pairs map[string]string) (err error) {
 	// can never share names with old ones).
 	for i := 0; i < 2; i++ {
 		var ops []txn.Op
+		a.st.noteRead()
 		if count, err := a.st.annotations.FindId(a.globalKey).Count(); err != nil {
 			return err
 		} else if count == 0 {
@@ -124,6 +125,7 @@ func (a *annotator) updateOps(toUpdate map[string]string, toRemove map[string]bo
 // Annotations returns all the annotations corresponding to an entity.
 func (a *annotator) Annotations() (map[string]string, error) {
 	doc := new(annotatorDoc)
+	a.st.noteRead()
 	err := a.st.annotations.FindId(a.globalKey).One(doc)
 	if err == mgo.ErrNotFound {
 		// Returning an empty map if there are no annotations.
@@ -7,6 +7,7 @@ import (
 	"code.google.com/p/go.net/websocket"
 	"crypto/tls"
 	"crypto/x509"
+	"fmt"
 	"launchpad.net/juju-core/cert"
 	"launchpad.net/juju-core/log"
 	"launchpad.net/juju-core/rpc"
@@ -24,6 +25,11 @@ type State struct {
 	client *rpc.Conn
 	conn   *websocket.Conn
 
+	// addr and tlsConfig are used to
+	// make HTTP requests to the server.
+	addr      string
+	tlsConfig *tls.Config
+
 	// broken is a channel that gets closed when the connection is
 	// broken.
 	broken chan struct{}
@@ -118,8 +124,10 @@ func Open(info *Info, opts DialOpts) (*State, error) {
 	client := rpc.NewConn(jsoncodec.NewWebsocket(conn))
 	client.Start()
 	st := &State{
-		client: client,
-		conn:   conn,
+		client:    client,
+		conn:      conn,
+		addr:      info.Addrs[0],
+		tlsConfig: cfg.TlsConfig,
 	}
 	if info.Tag != "" || info.Password != "" {
 		if err := st.Login(info.Tag, info.Password, info.Nonce); err != nil {
@@ -132,6 +140,38 @@ func Open(info *Info, opts DialOpts) (*State, error) {
 	return st, nil
 }
 
+// MeasureLatency pings the server, returning the round trip time of
+// the call and how far the server's clock is ahead of the client's.
+func (s *State) MeasureLatency() (rtt, skew time.Duration, err error) {
+	nonce, err := utils.RandomPassword()
+	if err != nil {
+		return 0, 0, err
+	}
+	var result params.PingResult
+	sent := time.Now()
+	if err := s.Call("Pinger", "", "Ping", params.Ping{Nonce: nonce}, &result); err != nil {
+		return 0, 0, err
+	}
+	rtt = time.Since(sent)
+	if result.Nonce != nonce {
+		return 0, 0, fmt.Errorf("ping returned nonce %q, expected %q", result.Nonce, nonce)
+	}
+	// Assume the server read its clock halfway through the call.
+	skew = result.ServerTime.Sub(sent.Add(rtt / 2))
+	return rtt, skew, nil
+}
+
+// DeprecationNotices returns the notices the server has recorded for
+// the deprecated methods called on the connection since it was last
+// called, so that the client can log them.
+func (s *State) DeprecationNotices() ([]params.DeprecationNotice, error) {
+	var result params.DeprecationNotices
+	if err := s.Call("Deprecations", "", "Notices", nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Notices, nil
+}
+
 func (s *State) heartbeatMonitor() {
 	ping := func() error {
 		return s.Call("Pinger", "", "Ping", nil, nil)
@@ -0,0 +1,63 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// blobURL returns the URL of the blob with the given token.
+func (s *State) blobURL(token string) string {
+	return "https://" + s.addr + "/blobs/" + token
+}
+
+// httpClient returns a client that makes
+// HTTP requests to the API server.
+func (s *State) httpClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{TLSClientConfig: s.tlsConfig},
+	}
+}
+
+// DownloadBlob returns the data of the blob with the given token, as
+// offered by a facade method. The data is read directly from the API
+// server rather than through the RPC connection. The caller must
+// close the returned reader.
+func (s *State) DownloadBlob(token string) (io.ReadCloser, error) {
+	resp, err := s.httpClient().Get(s.blobURL(token))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("cannot download blob: %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// UploadBlob sends the size bytes read from r as the data of the blob
+// with the given token, as accepted by a facade method. The data is
+// written directly to the API server rather than through the RPC
+// connection.
+func (s *State) UploadBlob(token string, r io.Reader, size int64) error {
+	req, err := http.NewRequest("PUT", s.blobURL(token), r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	resp, err := s.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("cannot upload blob: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -4,6 +4,9 @@
 package api
 
 import (
+	"fmt"
+	"time"
+
 	"launchpad.net/juju-core/charm"
 	"launchpad.net/juju-core/constraints"
 	"launchpad.net/juju-core/state/api/params"
@@ -170,6 +173,25 @@ type CharmInfo struct {
 	Meta     *charm.Meta
 }
 
+// LastConnection returns the time at which the entity with
+// the given tag last logged in to the API.
+func (c *Client) LastConnection(tag string) (time.Time, error) {
+	args := params.Entities{
+		Entities: []params.Entity{{Tag: tag}},
+	}
+	var results params.LastConnectionResults
+	if err := c.st.Call("Client", "", "LastConnections", args, &results); err != nil {
+		return time.Time{}, err
+	}
+	if len(results.Results) != 1 {
+		return time.Time{}, fmt.Errorf("expected one result, got %d", len(results.Results))
+	}
+	if err := results.Results[0].Error; err != nil {
+		return time.Time{}, err
+	}
+	return results.Results[0].Time, nil
+}
+
 // CharmInfo returns information about the requested charm.
 func (c *Client) CharmInfo(charmURL string) (*CharmInfo, error) {
 	args := params.CharmInfo{CharmURL: charmURL}
@@ -210,6 +232,18 @@ func (c *Client) WatchAll() (*AllWatcher, error) {
 	return newAllWatcher(c, &info.AllWatcherId), nil
 }
 
+// MachinesCursor returns a MachinesCursor, from which the details of
+// the machines in the environment can be read at most pageSize at a
+// time.
+func (c *Client) MachinesCursor(pageSize int) (*MachinesCursor, error) {
+	args := params.MachinesCursor{PageSize: pageSize}
+	info := new(params.MachinesCursorId)
+	if err := c.st.Call("Client", "", "MachinesCursor", args, info); err != nil {
+		return nil, err
+	}
+	return &MachinesCursor{c, info.MachinesCursorId}, nil
+}
+
 // GetAnnotations returns annotations that have been set on the given entity.
 func (c *Client) GetAnnotations(tag string) (map[string]string, error) {
 	args := params.GetAnnotations{tag}
@@ -98,3 +98,25 @@ func (m *Machine) Watch() (*watcher.NotifyWatcher, error) {
 	w := watcher.NewNotifyWatcher(m.st.caller, result)
 	return w, nil
 }
+
+// WatchAddresses returns a watcher for observing changes
+// to the machine's addresses.
+func (m *Machine) WatchAddresses() (*watcher.NotifyWatcher, error) {
+	var results params.NotifyWatchResults
+	args := params.Entities{
+		Entities: []params.Entity{{Tag: m.tag}},
+	}
+	err := m.st.caller.Call("Machiner", "", "WatchAddresses", args, &results)
+	if err != nil {
+		return nil, err
+	}
+	if len(results.Results) != 1 {
+		return nil, fmt.Errorf("expected one result, got %d", len(results.Results))
+	}
+	result := results.Results[0]
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	w := watcher.NewNotifyWatcher(m.st.caller, result)
+	return w, nil
+}
@@ -156,3 +156,30 @@ func (s *machinerSuite) TestWatch(c *gc.C) {
 	statetesting.AssertStop(c, w)
 	wc.AssertClosed()
 }
+
+func (s *machinerSuite) TestWatchAddresses(c *gc.C) {
+	machine, err := s.machiner.Machine(s.machine.Tag())
+	c.Assert(err, gc.IsNil)
+
+	w, err := machine.WatchAddresses()
+	c.Assert(err, gc.IsNil)
+	defer statetesting.AssertStop(c, w)
+	wc := statetesting.NewNotifyWatcherC(c, s.BackingState, w)
+
+	// Initial event.
+	wc.AssertOneChange()
+
+	// Change something other than the addresses and make sure it's
+	// not detected.
+	err = machine.SetStatus(params.StatusStarted, "not really")
+	c.Assert(err, gc.IsNil)
+	wc.AssertNoChange()
+
+	// Change the addresses and check it's detected.
+	err = s.machine.SetAddresses([]state.Address{{Value: "10.0.0.1", Type: state.Ipv4Address}})
+	c.Assert(err, gc.IsNil)
+	wc.AssertOneChange()
+
+	statetesting.AssertStop(c, w)
+	wc.AssertClosed()
+}
@@ -0,0 +1,28 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api
+
+import (
+	"launchpad.net/juju-core/state/api/params"
+)
+
+// MachinesCursor holds information allowing us to read the details
+// of the machines in the environment a page at a time.
+type MachinesCursor struct {
+	client *Client
+	id     string
+}
+
+// Next returns the next page of machines, and whether it is the last.
+// The cursor is stopped by the server once the last page is read.
+func (cursor *MachinesCursor) Next() ([]params.MachineDetails, bool, error) {
+	page := new(params.MachinesPage)
+	err := cursor.client.st.Call("MachinesCursor", cursor.id, "Next", nil, page)
+	return page.Machines, page.Done, err
+}
+
+// Stop stops the cursor before all its pages have been read.
+func (cursor *MachinesCursor) Stop() error {
+	return cursor.client.st.Call("MachinesCursor", cursor.id, "Stop", nil, nil)
+}
@@ -43,6 +43,18 @@ const (
 	CodeStopped             = "stopped"
 	CodeHasAssignedUnits    = "machine has assigned units"
 	CodeNotProvisioned      = "not provisioned"
+
+	CodeLeadershipClaimDenied = "leadership claim denied"
+	CodeTryAgain              = "try again"
+	CodeMessageTooLarge       = "message too large"
+	CodeEntityRemoved         = "entity removed"
+	CodeBadRequest            = "bad request"
+	CodeTimeout               = "timeout"
+	CodeConflict              = "conflict"
+	CodeUnsupportedCapability = "unsupported capability"
+	CodeOperationBlocked      = "operation is blocked"
+	CodeConcurrentNext        = "concurrent next"
+	CodeWatcherExpired        = "watcher expired"
 )
 
 // ErrCode returns the error code associated with
@@ -50,3 +50,43 @@ const (
 	// detected.
 	StatusDown Status = "down"
 )
+
+// ConnectionEvent describes a change to the connectivity of an
+// entity, as reported by the watcher returned by WatchConnections.
+// Each change reported holds the entity's tag and the event,
+// separated by a space, as in "machine-0 connected".
+type ConnectionEvent string
+
+const (
+	ConnectionConnected    ConnectionEvent = "connected"
+	ConnectionDisconnected ConnectionEvent = "disconnected"
+)
+
+// The Capability constants name optional features of the API that
+// clients may require the server to support when they log in; see
+// Creds.Capabilities.
+const (
+	// CapabilityAgentEvents is the ability to subscribe
+	// to agent events; see Creds.AgentEvents.
+	CapabilityAgentEvents = "agent-events"
+
+	// CapabilityImpersonation is the ability of controllers
+	// to act on behalf of agents; see Creds.EffectiveTag.
+	CapabilityImpersonation = "impersonation"
+
+	// CapabilityTracing is the ability to associate a connection's
+	// requests with a client-side trace; see Creds.TraceId.
+	CapabilityTracing = "tracing"
+)
+
+// The AuthMethod constants name the ways in which clients may
+// authenticate when they log in; see AuthMethodsResult.
+const (
+	// AuthMethodPassword is authentication with the password
+	// of the entity named by Creds.AuthTag.
+	AuthMethodPassword = "password"
+
+	// AuthMethodClientCert is authentication with a client
+	// certificate naming the entity, in place of a password.
+	AuthMethodClientCert = "client-certificate"
+)
@@ -3,6 +3,12 @@
 
 package params
 
+import (
+	"time"
+
+	"launchpad.net/juju-core/constraints"
+)
+
 // Entity identifies a single entity.
 type Entity struct {
 	Tag string
@@ -25,6 +31,18 @@ type BoolResults struct {
 	Results []BoolResult
 }
 
+// StringResult holds the result of a single operation returning
+// a string value or an error.
+type StringResult struct {
+	Result string
+	Error  *Error
+}
+
+// StringResults holds the string or error results of multiple entities.
+type StringResults struct {
+	Results []StringResult
+}
+
 // LifeResult holds the life status of a single entity, or an error
 // indicating why it is not available.
 type LifeResult struct {
@@ -127,12 +145,80 @@ type NotifyWatchResults struct {
 	Results []NotifyWatchResult
 }
 
+// ControllersWatchResult holds a NotifyWatcher id that fires when the
+// set of controller machines changes, and the ids of the controller
+// machines at the time the watcher was started.
+type ControllersWatchResult struct {
+	NotifyWatcherId string
+	MachineIds      []string
+	Error           *Error
+}
+
+// Block describes a block placed by an operator on operations of
+// the given type, such as "remove", with a message explaining why.
+type Block struct {
+	Type    string
+	Message string
+}
+
+// BlocksWatchResult holds a NotifyWatcher id that fires when blocks
+// are switched on or off, and the blocks in place at the time the
+// watcher was started.
+type BlocksWatchResult struct {
+	NotifyWatcherId string
+	Blocks          []Block
+	Error           *Error
+}
+
+// ConstraintsWatchResult holds a NotifyWatcher id that fires when
+// the environment constraints change, and the constraints at the
+// time the watcher was started.
+type ConstraintsWatchResult struct {
+	NotifyWatcherId string
+	Constraints     constraints.Value
+	Error           *Error
+}
+
+// EnvironLifeWatchResult holds a NotifyWatcher id that fires when the
+// environment changes, and the environment's life at the time the
+// watcher was started.
+type EnvironLifeWatchResult struct {
+	NotifyWatcherId string
+	Life            Life
+	Error           *Error
+}
+
+// WatcherRateLimit caps the rate at which a watcher delivers events:
+// at most Count events are delivered in any period of length
+// Interval, changes in between being coalesced into the next event.
+// A zero Count removes the cap.
+type WatcherRateLimit struct {
+	Count    int
+	Interval time.Duration
+}
+
 // StringsWatchResult holds a StringsWatcher id, changes and an error
 // (if any).
 type StringsWatchResult struct {
 	StringsWatcherId string
 	Changes          []string
 	Error            *Error
+
+	// Snapshot is true when Changes holds the full set of ids
+	// watched, as returned by the watcher's Snapshot method,
+	// rather than the ids that have changed.
+	Snapshot bool `json:",omitempty"`
+
+	// ResyncRequired is true, and Changes empty, when a call to
+	// Next found more changes than the server is configured to
+	// send at once. The client should then fetch the full set of
+	// ids watched, by calling the watcher's Snapshot method.
+	ResyncRequired bool `json:",omitempty"`
+
+	// MorePages is true when Changes holds a page of the ids of
+	// the watcher's initial event, and more of them remain to be
+	// returned by the following calls to Next.
+	MorePages bool `json:",omitempty"`
 }
 
 // StringsWatchResults holds the results for any API call which ends up
@@ -140,3 +226,244 @@ type StringsWatchResult struct {
 type StringsWatchResults struct {
 	Results []StringsWatchResult
 }
+
+// WatchContainer identifies the containers of a single type,
+// such as "lxc", on a machine.
+type WatchContainer struct {
+	MachineTag    string
+	ContainerType string
+}
+
+// WatchContainers holds the arguments of an
+// AgentWatchers.WatchContainers call.
+type WatchContainers struct {
+	Params []WatchContainer
+}
+
+// WatchWildcard holds the arguments of an
+// AgentWatchers.WatchWildcard call.
+type WatchWildcard struct {
+	Pattern string
+}
+
+// WatcherIds holds the arguments of a WatcherManager.Stop call.
+type WatcherIds struct {
+	Ids []string
+}
+
+// WatcherKind holds the arguments of a WatcherManager.StopKind call.
+type WatcherKind struct {
+	Kind string
+}
+
+// StoppedWatchers holds the result of a WatcherManager.StopKind
+// call: the number of watchers stopped.
+type StoppedWatchers struct {
+	Count int
+}
+
+// Assertion asserts that the entity with the given tag is still at
+// the given revision, as returned by Revisions.Get. The arguments of
+// a mutating call may hold assertions in a field named Assertions,
+// of type []Assertion; the call then fails with a CodeConflict error,
+// without being made, if any of the entities has changed.
+type Assertion struct {
+	Tag      string
+	Revision int64
+}
+
+// IdempotencyKeyField names the field, of type string, in which the
+// arguments of a mutating call may hold a key chosen by the client to
+// identify the call. A call with a key is made at most once: for some
+// time afterwards, a retry by the same entity with the same key, to
+// the same method, returns the result of the first call without the
+// call being made again. Calls that fail are not remembered. Reusing
+// a key with different arguments fails with CodeBadRequest.
+const IdempotencyKeyField = "IdempotencyKey"
+
+// RevisionResult holds the revision of an entity or an error.
+type RevisionResult struct {
+	Revision int64
+	Error    *Error
+}
+
+// RevisionResults holds the results of a Revisions.Get call.
+type RevisionResults struct {
+	Results []RevisionResult
+}
+
+// RetryStrategy holds the parameters with which an
+// agent should retry operations that fail transiently,
+// as for utils.AttemptStrategy.
+type RetryStrategy struct {
+	Total time.Duration
+	Delay time.Duration
+	Min   int
+}
+
+// RetryStrategyResult holds a RetryStrategy or an error.
+type RetryStrategyResult struct {
+	Result *RetryStrategy
+	Error  *Error
+}
+
+// ProxyConfig holds the proxy settings of an environment.
+type ProxyConfig struct {
+	HTTP    string
+	HTTPS   string
+	NoProxy string
+}
+
+// RetryStrategyResults holds the bulk operation result of an
+// API call that returns a RetryStrategy or an error.
+type RetryStrategyResults struct {
+	Results []RetryStrategyResult
+}
+
+// The WatchSpec kinds name the watchers that may be
+// started by a single AgentWatchers.Register call.
+const (
+	// WatchEntity watches the entity with the spec's tag.
+	WatchEntity = "entity"
+
+	// WatchEnvironConfig watches the environment configuration.
+	WatchEnvironConfig = "environ-config"
+
+	// WatchConfigSettings watches the service configuration
+	// settings of the unit with the spec's tag.
+	WatchConfigSettings = "config-settings"
+
+	// WatchRelations watches the lifecycles of the relations of
+	// the service of the unit with the spec's tag.
+	WatchRelations = "relations"
+
+	// WatchUnits watches the principal units of the
+	// machine with the spec's tag.
+	WatchUnits = "units"
+)
+
+// WatchSpec describes a watcher to be started.
+type WatchSpec struct {
+	Kind string
+	Tag  string
+
+	// StopAt, if set, gives the life at which a WatchEntity
+	// watcher is stopped: once the change that brings the entity
+	// to that life or beyond has been delivered, further calls to
+	// Next fail with CodeStopped, and once the change that removes
+	// the entity has been delivered, they fail with
+	// CodeEntityRemoved.
+	StopAt Life `json:",omitempty"`
+
+	// Lazy, if set, defers starting the watcher in state until
+	// Next is first called on it, so that watchers registered but
+	// never read cost the backend nothing. The first call to Next
+	// then returns at once, with the initial event of the watcher,
+	// and the result of registering a StringsWatcher holds no
+	// changes. A lazy watcher occupies its id, and counts as a
+	// watcher of the connection, from the start.
+	Lazy bool `json:",omitempty"`
+}
+
+// WatchSpecs holds the arguments of an AgentWatchers.Register call.
+type WatchSpecs struct {
+	Specs []WatchSpec
+}
+
+// WatchResult holds the id of a NotifyWatcher or of a StringsWatcher
+// and the changes held in its initial event, or an error.
+type WatchResult struct {
+	NotifyWatcherId  string
+	StringsWatcherId string
+	Changes          []string
+	Error            *Error
+
+	// MorePages is as for StringsWatchResult.MorePages.
+	MorePages bool `json:",omitempty"`
+}
+
+// WatchResults holds the results of an AgentWatchers.Register call,
+// one for each spec.
+type WatchResults struct {
+	Results []WatchResult
+}
+
+// UnitSettings holds the version of a unit's relation settings.
+type UnitSettings struct {
+	Version int64
+}
+
+// RelationUnitsChange holds notifications of units entering and
+// leaving the scope of a relation unit, and changes to the settings
+// of those units known to have entered.
+type RelationUnitsChange struct {
+	Joined   []string
+	Changed  map[string]UnitSettings
+	Departed []string
+}
+
+// RelationUnitsWatchResult holds a RelationUnitsWatcher id, changes
+// and an error (if any). Initial is set when Changes holds the
+// initial state of the relation rather than a change to it.
+type RelationUnitsWatchResult struct {
+	RelationUnitsWatcherId string
+	Changes                RelationUnitsChange
+	Initial                bool
+	Error                  *Error
+
+	// ResyncRequired is true, and Changes empty, when a call to
+	// Next found more changes than the server is configured to
+	// send at once. The client should then stop the watcher and
+	// start another, whose initial event describes the relation
+	// in full.
+	ResyncRequired bool `json:",omitempty"`
+}
+
+// RelationUnitsWatchResults holds the results for any API call which
+// ends up returning a list of RelationUnitsWatchers.
+type RelationUnitsWatchResults struct {
+	Results []RelationUnitsWatchResult
+}
+
+// ClaimLeadershipParams holds the parameters for a single claim of
+// leadership of a service by one of its units.
+type ClaimLeadershipParams struct {
+	ServiceTag      string
+	UnitTag         string
+	DurationSeconds float64
+}
+
+// ClaimLeadershipBulkParams holds the parameters for making a
+// LeadershipService.ClaimLeadership call.
+type ClaimLeadershipBulkParams struct {
+	Params []ClaimLeadershipParams
+}
+
+// Metric holds a single reading of a metric of a metered charm.
+type Metric struct {
+	Key   string
+	Value string
+	Time  time.Time
+}
+
+// MetricBatch holds a batch of metrics, identified by a UUID chosen by
+// the unit sending it, so that the batch may safely be sent again if
+// the unit cannot tell whether it was received.
+type MetricBatch struct {
+	UUID    string
+	Created time.Time
+	Metrics []Metric
+}
+
+// MetricBatchParam holds a batch of metrics sent by
+// the unit with the given tag.
+type MetricBatchParam struct {
+	Tag   string
+	Batch MetricBatch
+}
+
+// MetricBatchParams holds the arguments of a
+// MetricsAdder.AddMetricBatches call.
+type MetricBatchParams struct {
+	Batches []MetricBatchParam
+}
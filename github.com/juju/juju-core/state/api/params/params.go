@@ -10,6 +10,7 @@ import (
 	"launchpad.net/juju-core/charm"
 	"launchpad.net/juju-core/constraints"
 	"launchpad.net/juju-core/instance"
+	"time"
 )
 
 // ErrorResults holds the results of calling a bulk operation which
@@ -24,6 +25,10 @@ type ErrorResults struct {
 // The endpoints specified are unordered.
 type AddRelation struct {
 	Endpoints []string
+
+	// IdempotencyKey, if not empty, makes the
+	// call safe to retry; see IdempotencyKeyField.
+	IdempotencyKey string `json:",omitempty"`
 }
 
 // AddRelationResults holds the results of a AddRelation call. The Endpoints
@@ -47,6 +52,10 @@ type ServiceDeploy struct {
 	ConfigYAML    string // Takes precedence over config if both are present.
 	Constraints   constraints.Value
 	ToMachineSpec string
+
+	// IdempotencyKey, if not empty, makes the
+	// call safe to retry; see IdempotencyKeyField.
+	IdempotencyKey string `json:",omitempty"`
 }
 
 // ServiceSetCharm sets the charm for a given service.
@@ -66,6 +75,10 @@ type ServiceExpose struct {
 type ServiceSet struct {
 	ServiceName string
 	Options     map[string]string
+
+	// Assertions, if not empty, makes the call
+	// conditional; see Assertion.
+	Assertions []Assertion
 }
 
 // ServiceSetYAML holds the parameters for
@@ -118,6 +131,10 @@ type AddServiceUnits struct {
 	ServiceName   string
 	NumUnits      int
 	ToMachineSpec string
+
+	// IdempotencyKey, if not empty, makes the
+	// call safe to retry; see IdempotencyKeyField.
+	IdempotencyKey string `json:",omitempty"`
 }
 
 // DestroyServiceUnits holds parameters for the DestroyUnits call.
@@ -130,11 +147,190 @@ type ServiceDestroy struct {
 	ServiceName string
 }
 
+// Ping holds the parameters for making a Pinger.Ping call.
+type Ping struct {
+	// Nonce, if not empty, is echoed back in the result
+	// along with the server's time.
+	Nonce string
+}
+
+// PingResult holds the result of a Pinger.Ping call. It is empty
+// unless a nonce was given.
+type PingResult struct {
+	Nonce      string
+	ServerTime time.Time
+}
+
+// ConnectionHealth holds the result of a Pinger.ConnectionHealth call.
+type ConnectionHealth struct {
+	// DrainInProgress is set when the server is shutting down
+	// and accepts no further logins.
+	DrainInProgress bool
+
+	// LastPingAge holds the time since the server last
+	// heard a ping on the connection, or since login.
+	LastPingAge time.Duration
+
+	// SlowRequests holds the number of requests on the
+	// connection that have been slow to be served.
+	SlowRequests int
+
+	// BreakerOpen is set when the server's circuit breaker
+	// is refusing requests to the state backend.
+	BreakerOpen bool
+
+	// Degraded is set when the connection is still usable but
+	// the agent should consider moving to another server: the
+	// server is draining, its breaker is open, or a request
+	// was slow to be served within the last minute.
+	Degraded bool
+}
+
+// SessionInfo describes a logged in connection to an API server.
+type SessionInfo struct {
+	// ConnId identifies the connection amongst
+	// those made to the server.
+	ConnId string
+
+	Tag        string
+	LoginTime  time.Time
+	RemoteAddr string
+}
+
+// SessionsResult holds the sessions of an entity.
+type SessionsResult struct {
+	Sessions []SessionInfo
+}
+
+// LastConnectionResult holds the time at which an
+// entity last logged in to the API, or an error.
+type LastConnectionResult struct {
+	Time  time.Time
+	Error *Error
+}
+
+// LastConnectionResults holds the results of a
+// Client.LastConnections call, one for each entity.
+type LastConnectionResults struct {
+	Results []LastConnectionResult
+}
+
+// DeprecationNotice describes a deprecated facade method
+// that has been called on a connection.
+type DeprecationNotice struct {
+	Facade string
+	Method string
+
+	// Replacement, if not empty, names the method
+	// that should be called instead.
+	Replacement string
+
+	// RemovalVersion, if not empty, holds the
+	// version in which the method will be removed.
+	RemovalVersion string
+}
+
+// DeprecationNotices holds the result of a Deprecations.Notices call.
+type DeprecationNotices struct {
+	Notices []DeprecationNotice
+}
+
 // Creds holds credentials for identifying an entity.
 type Creds struct {
 	AuthTag  string
 	Password string
 	Nonce    string
+
+	// EffectiveTag, if set, holds the tag of an agent on whose
+	// behalf the authenticated entity wishes to act. Only
+	// controllers may log in this way.
+	EffectiveTag string `json:",omitempty"`
+
+	// TraceId, if set, identifies the client-side trace
+	// to which the connection's requests belong.
+	TraceId string `json:",omitempty"`
+
+	// AgentEvents, if set, subscribes an agent to the events
+	// stream served by the AgentEvents facade, so that changes
+	// are pushed to it rather than polled for.
+	AgentEvents bool `json:",omitempty"`
+
+	// Version holds the version of the API the client was
+	// written for; see APIVersion. Clients that predate
+	// versioning leave it zero.
+	Version int `json:",omitempty"`
+
+	// Capabilities, if set, holds the names of the capabilities,
+	// such as CapabilityAgentEvents, that the client requires.
+	// The login fails if the server does not support them all.
+	Capabilities []string `json:",omitempty"`
+
+	// ResumeToken, if set, holds the session token returned by the
+	// login of a previous connection, possibly to another API
+	// server, whose watchers the client wishes to resume.
+	ResumeToken string `json:",omitempty"`
+
+	// CallDeadline, if positive, bounds the time taken by every
+	// call made on the connection; a call that takes longer fails
+	// with CodeTimeout. Calls to Next on watchers are bounded only
+	// by WatcherDeadline, if that is positive.
+	CallDeadline    time.Duration `json:",omitempty"`
+	WatcherDeadline time.Duration `json:",omitempty"`
+}
+
+// AuthMethodsResult holds the result of an Admin.AuthMethods call.
+type AuthMethodsResult struct {
+	// Methods holds the names of the ways, such as
+	// AuthMethodPassword, in which the server accepts logins.
+	Methods []string
+
+	// ClientCertTagField names the subject field of a client
+	// certificate that must hold the tag of the entity it
+	// identifies, if the server accepts client certificates.
+	ClientCertTagField string `json:",omitempty"`
+}
+
+// LoginResult holds the result of a successful login.
+type LoginResult struct {
+	// SessionToken identifies the connection's session, so that a
+	// later connection may resume it; see Creds.ResumeToken.
+	SessionToken string
+
+	// Watchers holds, when a session was resumed, the outcome
+	// for each of its watchers.
+	Watchers []ResumedWatcher `json:",omitempty"`
+
+	// ResumeError holds the reason the session given in
+	// Creds.ResumeToken could not be resumed at all, if any.
+	ResumeError *Error `json:",omitempty"`
+}
+
+// ResumedWatcher describes the resumption of a watcher held by a
+// previous connection. If NewId is empty, the watcher could not be
+// resumed and the client must start it afresh; otherwise the
+// resumed watcher delivers an event straight away, as changes may
+// have been missed in between.
+type ResumedWatcher struct {
+	OldId string
+	NewId string `json:",omitempty"`
+	Tag   string
+}
+
+// APIVersion holds the current version of the API. It is raised
+// whenever the shape of a result changes in a way that the server
+// must hide from clients written for earlier versions.
+const APIVersion = 1
+
+// AgentEvent holds the changes relevant to an agent that have
+// happened since the previous event.
+type AgentEvent struct {
+	// EnvironConfigChanged is set when the environment
+	// configuration has changed.
+	EnvironConfigChanged bool
+
+	// AgentVersion holds the new agent tools version if it
+	// has changed, and is empty otherwise.
+	AgentVersion string `json:",omitempty"`
 }
 
 // GetAnnotationsResults holds annotations associated with an entity.
@@ -167,6 +363,10 @@ type GetServiceConstraintsResults struct {
 type SetServiceConstraints struct {
 	ServiceName string
 	Constraints constraints.Value
+
+	// Assertions, if not empty, makes the call
+	// conditional; see Assertion.
+	Assertions []Assertion
 }
 
 // CharmInfo stores parameters for a CharmInfo call.
@@ -184,6 +384,33 @@ type AllWatcherNextResults struct {
 	Deltas []Delta
 }
 
+// MachinesCursor holds the parameters for making a MachinesCursor call.
+type MachinesCursor struct {
+	// PageSize holds the largest number of machines
+	// returned by each call to MachinesCursor.Next.
+	PageSize int
+}
+
+// MachinesCursorId holds the id of a MachinesCursor.
+type MachinesCursorId struct {
+	MachinesCursorId string
+}
+
+// MachineDetails holds the details of a machine
+// returned by MachinesCursor.Next.
+type MachineDetails struct {
+	Id         string
+	InstanceId string
+	Life       Life
+}
+
+// MachinesPage holds a page of machines returned by calling
+// MachinesCursor.Next. Done is true for the last page.
+type MachinesPage struct {
+	Machines []MachineDetails
+	Done     bool
+}
+
 // Delta holds details of a change to the environment.
 type Delta struct {
 	// If Removed is true, the entity has been removed;
@@ -20,9 +20,40 @@ func (st *State) Login(tag, password, nonce string) error {
 		AuthTag:  tag,
 		Password: password,
 		Nonce:    nonce,
+		Version:  params.APIVersion,
 	}, nil)
 }
 
+// Resume is like Login, but also asks the server to resume the
+// watchers of the session with the given token, as returned by a
+// previous login to any API server, so that an agent that reconnects
+// after a failover need not start them all again. If the token is
+// empty, no session is resumed. The result holds the token of the new
+// session and the outcome for each watcher.
+func (st *State) Resume(tag, password, nonce, token string) (params.LoginResult, error) {
+	var result params.LoginResult
+	err := st.Call("Admin", "", "Login", &params.Creds{
+		AuthTag:     tag,
+		Password:    password,
+		Nonce:       nonce,
+		Version:     params.APIVersion,
+		ResumeToken: token,
+	}, &result)
+	return result, err
+}
+
+// ServeReverse serves requests made back to the agent by the
+// server on root, and registers the connection as a channel for such
+// requests, so that the agent can be reached even when it cannot
+// accept connections itself. See rpc.Conn.Serve for the methods
+// root must provide.
+func (st *State) ServeReverse(root interface{}) error {
+	if err := st.client.Serve(root, nil); err != nil {
+		return err
+	}
+	return st.Call("ReverseChannel", "", "Register", nil, nil)
+}
+
 // Client returns an object that can be used
 // to access client-specific functionality.
 func (st *State) Client() *Client {
@@ -102,6 +102,33 @@ func (s *watcherSuite) TestWatchMachine(c *gc.C) {
 	wc.AssertClosed()
 }
 
+func (s *watcherSuite) TestWatchRemovedMachine(c *gc.C) {
+	// Only watchers with a stop condition look for removal.
+	var results params.WatchResults
+	args := params.WatchSpecs{Specs: []params.WatchSpec{
+		{Kind: params.WatchEntity, Tag: s.rawMachine.Tag(), StopAt: params.Dead},
+	}}
+	err := s.stateAPI.Call("AgentWatchers", "", "Register", args, &results)
+	c.Assert(err, gc.IsNil)
+	c.Assert(results.Results, gc.HasLen, 1)
+	result := results.Results[0]
+	c.Assert(result.Error, gc.IsNil)
+
+	err = s.rawMachine.EnsureDead()
+	c.Assert(err, gc.IsNil)
+	err = s.rawMachine.Remove()
+	c.Assert(err, gc.IsNil)
+	s.State.StartSync()
+
+	// The removal is notified, after which the
+	// watcher reports that the machine has gone.
+	err = s.stateAPI.Call("NotifyWatcher", result.NotifyWatcherId, "Next", nil, nil)
+	c.Assert(err, gc.IsNil)
+	err = s.stateAPI.Call("NotifyWatcher", result.NotifyWatcherId, "Next", nil, nil)
+	c.Assert(err, gc.ErrorMatches, "watched entity has been removed")
+	c.Assert(params.ErrCode(err), gc.Equals, params.CodeEntityRemoved)
+}
+
 func (s *watcherSuite) TestNotifyWatcherStopsWithPendingSend(c *gc.C) {
 	var results params.NotifyWatchResults
 	args := params.Entities{Entities: []params.Entity{{Tag: s.rawMachine.Tag()}}}
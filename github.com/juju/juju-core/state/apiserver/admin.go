@@ -4,20 +4,28 @@
 package apiserver
 
 import (
+	"crypto/x509"
 	stderrors "errors"
 	"launchpad.net/juju-core/errors"
+	"launchpad.net/juju-core/log"
 	"launchpad.net/juju-core/rpc"
 	"launchpad.net/juju-core/state"
 	"launchpad.net/juju-core/state/api/params"
 	"launchpad.net/juju-core/state/apiserver/common"
 	"launchpad.net/juju-core/state/presence"
+	"launchpad.net/loggo"
 	"sync"
 )
 
-func newStateServer(srv *Server, rpcConn *rpc.Conn) *initialRoot {
+// auditLogger records logins that warrant later review.
+var auditLogger = loggo.GetLogger("juju.state.apiserver.audit")
+
+func newStateServer(srv *Server, rpcConn *rpc.Conn, connId uint64) *initialRoot {
 	r := &initialRoot{
 		srv:     srv,
 		rpcConn: rpcConn,
+		connId:  connId,
+		closed:  make(chan struct{}),
 	}
 	r.admin = &srvAdmin{
 		root: r,
@@ -32,9 +40,33 @@ type initialRoot struct {
 	srv     *Server
 	rpcConn *rpc.Conn
 
+	// connId uniquely identifies the connection
+	// amongst all those made to the server.
+	connId uint64
+
+	// clientCert holds the verified certificate presented
+	// by the client, or nil if it presented none.
+	clientCert *x509.Certificate
+
+	// remoteAddr holds the network address of the client.
+	remoteAddr string
+
+	// closed is closed to make the server close the
+	// connection; see disconnect.
+	closed    chan struct{}
+	closeOnce sync.Once
+
 	admin *srvAdmin
 }
 
+// disconnect makes the server close the connection.
+func (r *initialRoot) disconnect() {
+	if r.closed == nil {
+		return
+	}
+	r.closeOnce.Do(func() { close(r.closed) })
+}
+
 // Admin returns an object that provides API access
 // to methods that can be called even when not
 // authenticated.
@@ -55,40 +87,196 @@ type srvAdmin struct {
 	loggedIn bool
 }
 
-var errAlreadyLoggedIn = stderrors.New("already logged in")
+var (
+	errAlreadyLoggedIn = stderrors.New("already logged in")
+	errServerShutdown  = stderrors.New("server is shutting down")
+)
 
 // Login logs in with the provided credentials.
 // All subsequent requests on the connection will
-// act as the authenticated user.
-func (a *srvAdmin) Login(c params.Creds) error {
+// act as the authenticated user. If the credentials
+// hold a resume token, the watchers of the session
+// it identifies are resumed; see params.LoginResult.
+func (a *srvAdmin) Login(c params.Creds) (params.LoginResult, error) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 	if a.loggedIn {
 		// This can only happen if Login is called concurrently.
-		return errAlreadyLoggedIn
+		return params.LoginResult{}, errAlreadyLoggedIn
+	}
+	if err := checkCapabilities(c.Capabilities); err != nil {
+		return params.LoginResult{}, err
+	}
+	if err := a.root.srv.admitLogin(c.AuthTag); err != nil {
+		return params.LoginResult{}, err
+	}
+	entity, err := a.authenticate(c)
+	if err != nil {
+		return params.LoginResult{}, err
+	}
+	allowedMethods, err := a.allowedMethods(c)
+	if err != nil {
+		return params.LoginResult{}, err
+	}
+	if err := a.root.srv.reserveConn(entity); err != nil {
+		return params.LoginResult{}, err
+	}
+	// We have authenticated the user; now choose an appropriate API
+	// to serve to them.
+	var newRoot *srvRoot
+	if c.EffectiveTag != "" {
+		newRoot, err = a.apiRootForImpersonation(entity, c.EffectiveTag)
+	} else {
+		newRoot, err = a.apiRootForEntity(entity, c)
+	}
+	if err != nil {
+		a.root.srv.releaseConn()
+		return params.LoginResult{}, err
+	}
+	newRoot.traceId = c.TraceId
+	newRoot.version = c.Version
+	newRoot.callDeadline = c.CallDeadline
+	newRoot.watcherDeadline = c.WatcherDeadline
+	newRoot.priority = a.root.srv.requestPriority(newRoot.GetAuthTag())
+	newRoot.allowedMethods = allowedMethods
+	if newRoot.version > params.APIVersion {
+		// Newer clients are served the current API.
+		newRoot.version = params.APIVersion
+	}
+	fail := func(err error) (params.LoginResult, error) {
+		newRoot.Kill()
+		a.root.srv.releaseConn()
+		return params.LoginResult{}, err
+	}
+	if c.AgentEvents {
+		if err := newRoot.subscribeAgentEvents(); err != nil {
+			return fail(err)
+		}
+	}
+	if newRoot.sessionToken, err = newSessionToken(); err != nil {
+		return fail(err)
+	}
+	var result params.LoginResult
+	if c.ResumeToken != "" {
+		var err error
+		result.Watchers, err = newRoot.resumeSession(c.ResumeToken)
+		result.ResumeError = common.ServerError(err)
+	}
+	if err := a.root.srv.addRoot(newRoot); err != nil {
+		return fail(err)
+	}
+	if err := a.root.srv.state.SetLastConnection(newRoot.GetAuthTag(), newRoot.loginTime); err != nil {
+		log.Errorf("state/api: cannot record last connection of %q: %v", newRoot.GetAuthTag(), err)
+	}
+	if err := newRoot.saveSession(); err != nil {
+		// The session can still be saved when the client pings.
+		log.Errorf("state/api: cannot save session of %q: %v", newRoot.GetAuthTag(), err)
+	}
+	result.SessionToken = newRoot.sessionToken
+	if err := a.root.rpcConn.Serve(newRoot, serverError); err != nil {
+		newRoot.Kill()
+		return params.LoginResult{}, err
+	}
+	return result, nil
+}
+
+// AuthMethods returns the ways in which the server accepts logins,
+// so that a client can choose its credentials before logging in. It
+// may be called before Login.
+func (a *srvAdmin) AuthMethods() (params.AuthMethodsResult, error) {
+	return a.root.srv.AuthMethods(), nil
+}
+
+// supportedCapabilities holds the capabilities, as
+// required by clients at login, that the server supports.
+var supportedCapabilities = []string{
+	params.CapabilityAgentEvents,
+	params.CapabilityImpersonation,
+	params.CapabilityTracing,
+}
+
+// checkCapabilities returns a *common.UnsupportedCapabilityError
+// naming those of the required capabilities that the server does
+// not support, if any.
+func checkCapabilities(required []string) error {
+	var missing []string
+outer:
+	for _, name := range required {
+		for _, supported := range supportedCapabilities {
+			if name == supported {
+				continue outer
+			}
+		}
+		for _, m := range missing {
+			if name == m {
+				continue outer
+			}
+		}
+		missing = append(missing, name)
+	}
+	if missing != nil {
+		return &common.UnsupportedCapabilityError{Missing: missing}
+	}
+	return nil
+}
+
+// authenticate returns the entity identified by the given credentials.
+// A client that presented a certificate but no password is identified
+// by the certificate; otherwise the password is checked.
+func (a *srvAdmin) authenticate(c params.Creds) (state.TaggedAuthenticator, error) {
+	if c.Password == "" && a.root.clientCert != nil {
+		return a.authenticateCert(c)
 	}
 	entity, err := a.root.srv.state.Authenticator(c.AuthTag)
 	if err != nil && !errors.IsNotFoundError(err) {
-		return err
+		return nil, err
 	}
 	// We return the same error when an entity
 	// does not exist as for a bad password, so that
 	// we don't allow unauthenticated users to find information
 	// about existing entities.
 	if err != nil || !entity.PasswordValid(c.Password) {
-		return common.ErrBadCreds
+		return nil, common.ErrBadCreds
 	}
-	// We have authenticated the user; now choose an appropriate API
-	// to serve to them.
-	newRoot, err := a.apiRootForEntity(entity, c)
+	return entity, nil
+}
+
+// authenticateCert returns the entity whose tag is held in the
+// client's certificate. If the client also gave a tag, it must
+// match the certificate's.
+func (a *srvAdmin) authenticateCert(c params.Creds) (state.TaggedAuthenticator, error) {
+	tag, err := certTag(a.root.clientCert, a.root.srv.cfg.ClientCertTagField)
 	if err != nil {
-		return err
+		log.Debugf("state/api: rejecting client certificate: %v", err)
+		return nil, common.ErrBadCreds
+	}
+	if c.AuthTag != "" && c.AuthTag != tag {
+		return nil, common.ErrBadCreds
 	}
+	entity, err := a.root.srv.state.Authenticator(tag)
+	if err != nil {
+		// As with passwords, an unknown entity is
+		// indistinguishable from a bad certificate.
+		log.Debugf("state/api: rejecting client certificate for %q: %v", tag, err)
+		return nil, common.ErrBadCreds
+	}
+	return entity, nil
+}
 
-	if err := a.root.rpcConn.Serve(newRoot, serverError); err != nil {
-		return err
+// allowedMethods returns the only facade methods that the given
+// credentials allow, or nil if they do not restrict the methods
+// called. Only client certificates restrict them, and only when
+// the server is configured to look for the methods in them.
+func (a *srvAdmin) allowedMethods(c params.Creds) (map[methodKey]bool, error) {
+	if c.Password != "" || a.root.clientCert == nil {
+		return nil, nil
 	}
-	return nil
+	methods, err := certMethods(a.root.clientCert, a.root.srv.cfg.ClientCertMethodsField)
+	if err != nil {
+		log.Debugf("state/api: rejecting client certificate: %v", err)
+		return nil, common.ErrBadCreds
+	}
+	return methods, nil
 }
 
 // machinePinger wraps a presence.Pinger.
@@ -105,9 +293,9 @@ func (p *machinePinger) Stop() error {
 	return p.Pinger.Kill()
 }
 
-func (a *srvAdmin) apiRootForEntity(entity state.TaggedAuthenticator, c params.Creds) (interface{}, error) {
+func (a *srvAdmin) apiRootForEntity(entity state.TaggedAuthenticator, c params.Creds) (*srvRoot, error) {
 	// TODO(rog) choose appropriate object to serve.
-	newRoot := newSrvRoot(a.root.srv, entity)
+	newRoot := newSrvRoot(a.root, entity)
 
 	// If this is a machine agent connecting, we need to check the
 	// nonce matches, otherwise the wrong agent might be trying to
@@ -127,3 +315,34 @@ func (a *srvAdmin) apiRootForEntity(entity state.TaggedAuthenticator, c params.C
 	}
 	return newRoot, nil
 }
+
+// apiRootForImpersonation returns an API root that acts as the agent
+// with the given tag on behalf of the authenticated controller. The
+// controller may only act as an agent whose rights do not exceed its
+// own, so client users and machines running jobs the controller does
+// not run are refused.
+func (a *srvAdmin) apiRootForImpersonation(controller state.TaggedAuthenticator, tag string) (*srvRoot, error) {
+	if !isMachineWithJob(controller, state.JobManageState) {
+		return nil, common.ErrPerm
+	}
+	entity, err := a.root.srv.state.Authenticator(tag)
+	if err != nil {
+		return nil, err
+	}
+	if !isAgent(entity) {
+		return nil, common.ErrPerm
+	}
+	if machine, ok := entity.(*state.Machine); ok {
+		for _, job := range machine.Jobs() {
+			if !isMachineWithJob(controller, job) {
+				return nil, common.ErrPerm
+			}
+		}
+	}
+	auditLogger.Infof("%q logged in on behalf of %q", controller.Tag(), entity.Tag())
+	// Note that we neither check the nonce nor start a pinger:
+	// the agent itself has not connected.
+	newRoot := newSrvRoot(a.root, entity)
+	newRoot.impersonator = controller
+	return newRoot, nil
+}
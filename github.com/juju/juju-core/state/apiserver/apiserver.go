@@ -6,16 +6,26 @@ package apiserver
 import (
 	"code.google.com/p/go.net/websocket"
 	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"launchpad.net/juju-core/log"
 	"launchpad.net/juju-core/rpc"
 	"launchpad.net/juju-core/rpc/jsoncodec"
 	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/api/params"
 	"launchpad.net/juju-core/state/apiserver/common"
+	"launchpad.net/juju-core/state/apiserver/leadership"
+	"launchpad.net/juju-core/state/apiserver/metricsadder"
+	"launchpad.net/juju-core/state/apiserver/retrystrategy"
+	"launchpad.net/juju-core/utils"
 	"launchpad.net/loggo"
 	"launchpad.net/tomb"
 	"net"
 	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Server holds the server side of the API.
@@ -24,12 +34,283 @@ type Server struct {
 	wg    sync.WaitGroup
 	state *state.State
 	addr  net.Addr
+	cfg   ServerConfig
+
+	// lastConnId holds the id most recently given to a
+	// connection. It must be accessed atomically.
+	lastConnId uint64
+
+	// leadership holds the leadership leases of all services.
+	leadership *leadership.Manager
+
+	// latencies records the latency of requests
+	// to each facade method.
+	latencies *latencyStats
+
+	// watcherLags records the lag of events
+	// delivered by each type of watcher.
+	watcherLags *latencyStats
+
+	// costs records the state operations made by
+	// calls to each facade method.
+	costs *costStats
+
+	// idempotent remembers the calls made with idempotency
+	// keys, so that their retries are not made again.
+	idempotent *idempotentCalls
+
+	// breaker guards the state backend; it is nil
+	// if no circuit breaker has been configured.
+	breaker *breaker
+
+	// requestSlots limits the number of requests served at
+	// once; it is nil if cfg.MaxConcurrentRequests is not set.
+	requestSlots *requestSlots
+
+	// requestSampler chooses the requests logged; it is nil
+	// if cfg.RequestLogSampling is not set.
+	requestSampler *requestSampler
+
+	// degraded is non-zero while the server is under memory
+	// pressure; it is accessed atomically. See checkMemory.
+	degraded int32
+
+	// deprecated holds the notices for deprecated
+	// methods, keyed by facade and method.
+	deprecated map[methodKey]params.DeprecationNotice
+
+	// mu guards the fields below.
+	mu sync.Mutex
+
+	// roots holds the root of every logged in connection.
+	roots map[*srvRoot]bool
+
+	// reverse holds the root of each connection registered as
+	// a channel for requests back to its agent, keyed by the
+	// agent's tag.
+	reverse map[string]*srvRoot
+
+	// blobs holds the blobs offered to or accepted
+	// from clients, keyed by token.
+	blobs map[string]*blob
+
+	// conns holds the number of connection slots taken when
+	// cfg.MaxConnections is set, including those reserved by
+	// connections still logging in.
+	conns int
+
+	// shuttingDown is set when Shutdown has been called;
+	// no further logins are accepted once it is set.
+	shuttingDown bool
+
+	// connWatchers holds the watchers started by
+	// WatchConnections that have not yet stopped.
+	connWatchers map[*connectionsWatcher]bool
+}
+
+// ServerConfig holds optional parameters for an API server.
+// The zero value gives the default behaviour.
+type ServerConfig struct {
+	// Tracer, if not nil, is given a span for every request
+	// served on a logged in connection.
+	Tracer Tracer
+
+	// ClientCACert, if not empty, holds the CA certificate (in PEM
+	// format) used to verify client certificates. A client presenting
+	// a certificate signed by it may log in without a password as the
+	// entity whose tag is held in the certificate's subject.
+	ClientCACert []byte
+
+	// ClientCertTagField names the subject field of a client
+	// certificate that holds the entity tag; see the CertField
+	// constants. It defaults to CertFieldCommonName.
+	ClientCertTagField string
+
+	// ClientCertMethodsField, if set, names the subject field of a
+	// client certificate that may restrict the connections logged in
+	// with the certificate to a set of facade methods, whatever the
+	// entity identified would otherwise be allowed to call. Each value
+	// of the field names a method allowed, as "Facade.Method"; other
+	// methods fail with common.ErrPerm. Certificates with no value in
+	// the field are not restricted. Pinger methods are always allowed,
+	// so that clients can keep their connections alive.
+	ClientCertMethodsField string
+
+	// BreakerThreshold, if positive, enables a circuit breaker in
+	// front of the state backend: once that many consecutive
+	// requests have failed with state errors, requests fail fast
+	// with common.ErrTryAgain for BreakerCooldown (by default ten
+	// seconds) before a single request is let through to probe
+	// whether the backend has recovered.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// MaxRequestSize, if positive, limits the size in bytes of
+	// any message a client may send. A larger request is discarded
+	// without being read in full, and fails with
+	// common.ErrMessageTooLarge.
+	MaxRequestSize int64
+
+	// MaxResponseSize, if positive, limits the size in bytes of
+	// any result returned by a facade method. A request whose
+	// result is larger fails with common.ErrMessageTooLarge.
+	MaxResponseSize int64
+
+	// MaxConnections, if positive, limits the number of logged
+	// in connections; further logins fail with common.ErrAtCapacity
+	// until a connection is closed. ReservedConnections of those
+	// connections are kept for controllers and environment
+	// managers, so that a flood of other agents cannot shut
+	// them out.
+	MaxConnections      int
+	ReservedConnections int
+
+	// UnitLoginHeadroom holds the number of the unreserved
+	// connections that unit agents may not take either, so that
+	// when many agents log in at once, as they do when the server
+	// recovers from an outage, machine agents and clients are
+	// admitted before unit agents. Unit agents refused for this
+	// reason fail with common.ErrAtCapacity, which clients should
+	// take as a sign to back off and retry. Logins claiming a unit
+	// tag are refused before the password is checked, sparing the
+	// state backend.
+	UnitLoginHeadroom int
+
+	// MaxConcurrentRequests, if positive, limits the number of
+	// requests served at once across all connections. Requests
+	// beyond the limit wait for a slot, which is given to the
+	// waiting request of the connection with the highest priority.
+	// A connection's priority is given by the kind of entity that
+	// logged in, as named by the prefix of its tag: by default
+	// machine agents come before clients ("user"), which come before
+	// unit agents. RequestPriorities overrides the default for the
+	// kinds it holds; higher values are served first. Calls to Next
+	// on established watchers do not take a slot.
+	MaxConcurrentRequests int
+	RequestPriorities     map[string]int
+
+	// RequestLogSampling, if positive, enables the logging of the
+	// requests served: one request in RequestLogSampling is logged,
+	// with the id of its connection, its facade and method and the
+	// time taken to serve it, as is every request that fails.
+	// Requests made by a controller on behalf of an agent are
+	// logged whatever the sampling.
+	RequestLogSampling int
+
+	// MaxBlobSize, if positive, limits the size in bytes of any
+	// blob transferred outside the RPC connection; see
+	// srvRoot.OfferBlob and srvRoot.AcceptBlob.
+	MaxBlobSize int64
+
+	// WatcherSetupTimeout, if positive, bounds the time a watcher
+	// may take to produce its initial event when a client starts
+	// it. A watcher that takes longer is stopped, and starting it
+	// fails with common.ErrTimeout.
+	WatcherSetupTimeout time.Duration
+
+	// MaxWatcherLifetime, if positive, bounds the time for which a
+	// watcher is served. Watchers registered for longer are stopped,
+	// whether or not they are still being called, within a tenth of
+	// the lifetime, or at the next call to their Next, so that
+	// watchers are not kept indefinitely on long-lived connections.
+	// Calls on a watcher that has expired fail with
+	// common.ErrWatcherExpired; clients should start it again.
+	MaxWatcherLifetime time.Duration
+
+	// WatcherRetry holds the policy with which starting a watcher
+	// through AgentWatchers.Register is retried when it fails with
+	// a transient state error, rather than failing at once. Other
+	// errors, such as those for permission or unknown entities,
+	// are never retried. By default no retries are made.
+	WatcherRetry RetryPolicy
+
+	// MaxWatcherChanges limits, for each type of watcher named by
+	// common.ResourceKind, the number of changes Next may return at
+	// once. A call to Next that finds more returns none, marking its
+	// result ResyncRequired instead, so that the client fetches the
+	// state it watches afresh. Only StringsWatchers and
+	// RelationUnitsWatchers are limited, and the initial event of
+	// a RelationUnitsWatcher never is; types without a positive
+	// limit are not.
+	MaxWatcherChanges map[string]int
+
+	// SnapshotPageSize, if positive, limits the number of ids
+	// returned with the initial event of a StringsWatcher when it
+	// is started. The remaining ids are returned, a page at a time,
+	// by the following calls to the watcher's Next, each result
+	// being marked MorePages while more remain, before Next goes on
+	// to return the changes made since the watcher was started.
+	SnapshotPageSize int
+
+	// HeapWatermark, if positive, enables degraded watcher delivery
+	// under memory pressure: while the server's heap usage is at or
+	// above that many bytes, each call to a StringsWatcher's Next
+	// waits DegradedBatchWindow (by default a second) after the
+	// first change, coalescing the changes made meanwhile, and asks
+	// the client to resync, as for MaxWatcherChanges, if there are
+	// more than DegradedMaxChanges (by default 100). Normal delivery
+	// resumes once the heap usage falls a tenth below the watermark.
+	HeapWatermark       uint64
+	DegradedBatchWindow time.Duration
+	DegradedMaxChanges  int
+
+	// RetryStrategy holds the strategy with which agents are
+	// told to retry operations that fail transiently. It
+	// defaults to retrystrategy.DefaultStrategy.
+	RetryStrategy utils.AttemptStrategy
+
+	// MetricsSendInterval holds the interval at which the unit
+	// agents of metered charms are told to send their metrics. It
+	// defaults to metricsadder.DefaultSendInterval.
+	MetricsSendInterval time.Duration
+
+	// Deprecated describes the facade methods that are deprecated.
+	// Calls to them are served as usual, but the first call to each
+	// on a connection records its notice, which the client may read
+	// through the Deprecations facade.
+	Deprecated []params.DeprecationNotice
 }
 
 // Serve serves the given state by accepting requests on the given
 // listener, using the given certificate and key (in PEM format) for
 // authentication.
 func NewServer(s *state.State, addr string, cert, key []byte) (*Server, error) {
+	return NewServerWithConfig(s, addr, cert, key, ServerConfig{})
+}
+
+// NewServerWithConfig is like NewServer but allows the server's
+// optional parameters to be specified.
+func NewServerWithConfig(s *state.State, addr string, cert, key []byte, cfg ServerConfig) (*Server, error) {
+	if cfg.Tracer == nil {
+		cfg.Tracer = nopTracer{}
+	}
+	if cfg.RetryStrategy == (utils.AttemptStrategy{}) {
+		cfg.RetryStrategy = retrystrategy.DefaultStrategy
+	}
+	if cfg.MetricsSendInterval <= 0 {
+		cfg.MetricsSendInterval = metricsadder.DefaultSendInterval
+	}
+	if cfg.DegradedBatchWindow <= 0 {
+		cfg.DegradedBatchWindow = defaultDegradedBatchWindow
+	}
+	if cfg.DegradedMaxChanges <= 0 {
+		cfg.DegradedMaxChanges = defaultDegradedMaxChanges
+	}
+	if err := checkCertField(cfg.ClientCertTagField); err != nil {
+		return nil, err
+	}
+	if err := checkCertField(cfg.ClientCertMethodsField); err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{}
+	if len(cfg.ClientCACert) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(cfg.ClientCACert) {
+			return nil, fmt.Errorf("no certificates found in client CA certificate")
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
 	lis, err := net.Listen("tcp", addr)
 	if err != nil {
 		return nil, err
@@ -37,17 +318,38 @@ func NewServer(s *state.State, addr string, cert, key []byte) (*Server, error) {
 	log.Infof("state/api: listening on %q", lis.Addr())
 	tlsCert, err := tls.X509KeyPair(cert, key)
 	if err != nil {
+		lis.Close()
 		return nil, err
 	}
+	tlsConfig.Certificates = []tls.Certificate{tlsCert}
 	srv := &Server{
-		state: s,
-		addr:  lis.Addr(),
+		state:          s,
+		addr:           lis.Addr(),
+		cfg:            cfg,
+		leadership:     leadership.NewManager(),
+		latencies:      newLatencyStats(),
+		watcherLags:    newLatencyStats(),
+		costs:          newCostStats(),
+		idempotent:     newIdempotentCalls(),
+		breaker:        newBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown, time.Now),
+		requestSlots:   newRequestSlots(cfg.MaxConcurrentRequests),
+		requestSampler: newRequestSampler(cfg.RequestLogSampling),
+		deprecated:     newDeprecations(cfg.Deprecated),
+		roots:          make(map[*srvRoot]bool),
+		reverse:        make(map[string]*srvRoot),
+		blobs:          make(map[string]*blob),
 	}
 	// TODO(rog) check that *srvRoot is a valid type for using
 	// as an RPC server.
-	lis = tls.NewListener(lis, &tls.Config{
-		Certificates: []tls.Certificate{tlsCert},
-	})
+	lis = tls.NewListener(lis, tlsConfig)
+	if cfg.HeapWatermark > 0 {
+		srv.wg.Add(1)
+		go srv.monitorMemory()
+	}
+	if cfg.MaxWatcherLifetime > 0 {
+		srv.wg.Add(1)
+		go srv.sweepWatchers()
+	}
 	go srv.run(lis)
 	return srv, nil
 }
@@ -64,6 +366,144 @@ func (srv *Server) Stop() error {
 	return srv.tomb.Wait()
 }
 
+// Shutdown stops the server without waiting for connections to
+// finish of their own accord. It rejects any further logins, kills
+// every logged in connection (stopping all of its resources) and
+// returns when all connection goroutines have exited. It is safe to
+// call concurrently with running requests, and more than once.
+func (srv *Server) Shutdown() error {
+	srv.mu.Lock()
+	srv.shuttingDown = true
+	roots := make([]*srvRoot, 0, len(srv.roots))
+	for root := range srv.roots {
+		roots = append(roots, root)
+	}
+	srv.mu.Unlock()
+	for _, root := range roots {
+		root.Kill()
+	}
+	return srv.Stop()
+}
+
+// addRoot records the root of a newly logged in connection.
+// It fails if the server is shutting down.
+func (srv *Server) addRoot(root *srvRoot) error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.shuttingDown {
+		return errServerShutdown
+	}
+	srv.roots[root] = true
+	srv.notifyConnectionLocked(root.GetAuthTag(), params.ConnectionConnected)
+	return nil
+}
+
+// removeRoot forgets the root of a connection that has been killed,
+// releasing its connection slot.
+func (srv *Server) removeRoot(root *srvRoot) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.roots[root] {
+		delete(srv.roots, root)
+		srv.releaseConnLocked()
+		srv.notifyConnectionLocked(root.GetAuthTag(), params.ConnectionDisconnected)
+	}
+	if tag := root.GetAuthTag(); srv.reverse[tag] == root {
+		delete(srv.reverse, tag)
+	}
+}
+
+// loginPriority classifies logins by the connection
+// slots open to them when the server is near capacity.
+type loginPriority int
+
+const (
+	// priorityControl is given to controllers and environment
+	// managers, which may take any slot.
+	priorityControl loginPriority = iota
+
+	// priorityDefault is given to other machine agents and to
+	// clients, which may not take the reserved slots.
+	priorityDefault
+
+	// priorityUnit is given to unit agents, which may not
+	// take the unit login headroom either.
+	priorityUnit
+)
+
+// entityLoginPriority returns the priority of
+// a login by the given authenticated entity.
+func entityLoginPriority(entity state.TaggedAuthenticator) loginPriority {
+	switch {
+	case isMachineWithJob(entity, state.JobManageState),
+		isMachineWithJob(entity, state.JobManageEnviron):
+		return priorityControl
+	case strings.HasPrefix(entity.Tag(), "unit-"):
+		return priorityUnit
+	}
+	return priorityDefault
+}
+
+// connLimit returns the number of connection slots
+// that may be taken by logins of the given priority.
+func (srv *Server) connLimit(p loginPriority) int {
+	max := srv.cfg.MaxConnections
+	if p >= priorityDefault {
+		max -= srv.cfg.ReservedConnections
+	}
+	if p >= priorityUnit {
+		max -= srv.cfg.UnitLoginHeadroom
+	}
+	return max
+}
+
+// admitLogin fails with common.ErrAtCapacity if a login claiming the
+// given tag would certainly be refused a connection slot. It is
+// called before the login's credentials are checked, and so can
+// only tell whether the login is by a unit agent.
+func (srv *Server) admitLogin(tag string) error {
+	if srv.cfg.MaxConnections <= 0 || !strings.HasPrefix(tag, "unit-") {
+		return nil
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.conns >= srv.connLimit(priorityUnit) {
+		return common.ErrAtCapacity
+	}
+	return nil
+}
+
+// reserveConn takes a connection slot for the given entity, which is
+// logging in, failing with common.ErrAtCapacity if none is free. The
+// slot is released when the connection's root is removed, or by
+// releaseConn if the root is never added.
+func (srv *Server) reserveConn(entity state.TaggedAuthenticator) error {
+	if srv.cfg.MaxConnections <= 0 {
+		return nil
+	}
+	max := srv.connLimit(entityLoginPriority(entity))
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.conns >= max {
+		return common.ErrAtCapacity
+	}
+	srv.conns++
+	return nil
+}
+
+// releaseConn releases a slot taken by reserveConn.
+func (srv *Server) releaseConn() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.releaseConnLocked()
+}
+
+func (srv *Server) releaseConnLocked() {
+	if srv.cfg.MaxConnections > 0 {
+		srv.conns--
+	}
+}
+
 // Kill implements worker.Worker.Kill.
 func (srv *Server) Kill() {
 	srv.tomb.Kill(nil)
@@ -97,8 +537,12 @@ func (srv *Server) run(lis net.Listener) {
 			log.Errorf("state/api: error serving RPCs: %v", err)
 		}
 	})
+	mux := http.NewServeMux()
+	mux.Handle("/", handler)
+	mux.HandleFunc(blobPath, func(w http.ResponseWriter, req *http.Request) { srv.serveBlob(w, req) })
+	mux.HandleFunc(metricsPath, func(w http.ResponseWriter, req *http.Request) { srv.serveMetrics(w, req) })
 	// The error from http.Serve is not interesting.
-	http.Serve(lis, handler)
+	http.Serve(lis, mux)
 }
 
 // Addr returns the address that the server is listening on.
@@ -107,17 +551,44 @@ func (srv *Server) Addr() string {
 }
 
 func (srv *Server) serveConn(wsConn *websocket.Conn) error {
-	codec := jsoncodec.NewWebsocket(wsConn)
+	var codec *jsoncodec.Codec
+	var limited *limitedConn
+	if srv.cfg.MaxRequestSize > 0 {
+		limited = newLimitedConn(wsConn, srv.cfg.MaxRequestSize)
+		codec = jsoncodec.New(limited)
+	} else {
+		codec = jsoncodec.NewWebsocket(wsConn)
+	}
 	if loggo.GetLogger("").EffectiveLogLevel() >= loggo.DEBUG {
 		codec.SetLogging(true)
 	}
-	conn := rpc.NewConn(codec)
-	if err := conn.Serve(newStateServer(srv, conn), serverError); err != nil {
+	var conn *rpc.Conn
+	if limited != nil || srv.cfg.MaxResponseSize > 0 {
+		conn = rpc.NewConn(&limitedCodec{
+			Codec:       codec,
+			conn:        limited,
+			maxResponse: srv.cfg.MaxResponseSize,
+		})
+	} else {
+		conn = rpc.NewConn(codec)
+	}
+	connId := atomic.AddUint64(&srv.lastConnId, 1)
+	root := newStateServer(srv, conn, connId)
+	if req := wsConn.Request(); req != nil {
+		root.remoteAddr = req.RemoteAddr
+		if req.TLS != nil && len(req.TLS.PeerCertificates) > 0 {
+			// The certificate has been verified against
+			// cfg.ClientCACert during the TLS handshake.
+			root.clientCert = req.TLS.PeerCertificates[0]
+		}
+	}
+	if err := conn.Serve(root, serverError); err != nil {
 		return err
 	}
 	conn.Start()
 	select {
 	case <-conn.Dead():
+	case <-root.closed:
 	case <-srv.tomb.Dying():
 	}
 	return conn.Close()
@@ -0,0 +1,80 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package applicationscaler
+
+import (
+	"fmt"
+
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/api/params"
+	"launchpad.net/juju-core/state/apiserver/common"
+)
+
+// ApplicationScalerAPI provides access to the ApplicationScaler API
+// facade, used by the worker that keeps each service running at
+// least its minimum number of units.
+type ApplicationScalerAPI struct {
+	st         *state.State
+	resources  *common.Resources
+	authorizer common.Authorizer
+}
+
+// NewApplicationScalerAPI creates a new server-side ApplicationScaler
+// API facade.
+func NewApplicationScalerAPI(
+	st *state.State,
+	resources *common.Resources,
+	authorizer common.Authorizer,
+) (*ApplicationScalerAPI, error) {
+	if !authorizer.AuthEnvironManager() {
+		return nil, common.ErrPerm
+	}
+	return &ApplicationScalerAPI{
+		st:         st,
+		resources:  resources,
+		authorizer: authorizer,
+	}, nil
+}
+
+// Watch starts a StringsWatcher that reports the names of the
+// services that may need more units to reach their minimum: those
+// whose minimum has been raised, or some of whose units have been
+// destroyed. The initial event holds the names of all the services
+// with a minimum.
+func (api *ApplicationScalerAPI) Watch() (params.StringsWatchResult, error) {
+	watch := api.st.WatchMinUnits()
+	// Consume the initial event and forward it to the result.
+	changes, err := common.InitialStringsEvent(api.resources, watch)
+	if err != nil {
+		return params.StringsWatchResult{}, err
+	}
+	return params.StringsWatchResult{
+		StringsWatcherId: api.resources.Register(watch),
+		Changes:          changes,
+	}, nil
+}
+
+// Rescale adds units to each of the given services, identified by
+// tag, until it has at least its minimum number of alive units.
+func (api *ApplicationScalerAPI) Rescale(args params.Entities) (params.ErrorResults, error) {
+	result := params.ErrorResults{
+		Errors: make([]*params.Error, len(args.Entities)),
+	}
+	for i, entity := range args.Entities {
+		result.Errors[i] = common.ServerError(api.rescale(entity.Tag))
+	}
+	return result, nil
+}
+
+func (api *ApplicationScalerAPI) rescale(tag string) error {
+	entity, err := api.st.Lifer(tag)
+	if err != nil {
+		return err
+	}
+	service, ok := entity.(*state.Service)
+	if !ok {
+		return fmt.Errorf("%q is not a service", tag)
+	}
+	return service.EnsureMinUnits()
+}
@@ -0,0 +1,99 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package applicationscaler_test
+
+import (
+	. "launchpad.net/gocheck"
+
+	jujutesting "launchpad.net/juju-core/juju/testing"
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/api/params"
+	"launchpad.net/juju-core/state/apiserver/applicationscaler"
+	"launchpad.net/juju-core/state/apiserver/common"
+	apiservertesting "launchpad.net/juju-core/state/apiserver/testing"
+	statetesting "launchpad.net/juju-core/state/testing"
+)
+
+type applicationScalerSuite struct {
+	jujutesting.JujuConnSuite
+
+	service    *state.Service
+	api        *applicationscaler.ApplicationScalerAPI
+	resources  *common.Resources
+	authorizer apiservertesting.FakeAuthorizer
+}
+
+var _ = Suite(&applicationScalerSuite{})
+
+func (s *applicationScalerSuite) SetUpTest(c *C) {
+	s.JujuConnSuite.SetUpTest(c)
+	s.resources = common.NewResources()
+
+	var err error
+	s.service, err = s.State.AddService("wordpress", s.AddTestingCharm(c, "wordpress"))
+	c.Assert(err, IsNil)
+	err = s.service.SetMinUnits(2)
+	c.Assert(err, IsNil)
+	s.authorizer = apiservertesting.FakeAuthorizer{
+		Tag:          "machine-0",
+		LoggedIn:     true,
+		Manager:      true,
+		MachineAgent: true,
+	}
+	s.api, err = applicationscaler.NewApplicationScalerAPI(s.State, s.resources, s.authorizer)
+	c.Assert(err, IsNil)
+}
+
+func (s *applicationScalerSuite) TearDownTest(c *C) {
+	if s.resources != nil {
+		s.resources.StopAll()
+	}
+	s.JujuConnSuite.TearDownTest(c)
+}
+
+func (s *applicationScalerSuite) TestRefusesNonManager(c *C) {
+	anAuthorizer := s.authorizer
+	anAuthorizer.Manager = false
+	api, err := applicationscaler.NewApplicationScalerAPI(s.State, s.resources, anAuthorizer)
+	c.Assert(err, ErrorMatches, "permission denied")
+	c.Assert(api, IsNil)
+}
+
+func (s *applicationScalerSuite) TestWatch(c *C) {
+	result, err := s.api.Watch()
+	c.Assert(err, IsNil)
+	c.Assert(result.Changes, DeepEquals, []string{"wordpress"})
+
+	w, ok := s.resources.Get(result.StringsWatcherId).(state.StringsWatcher)
+	c.Assert(ok, Equals, true)
+	wc := statetesting.NewStringsWatcherC(c, s.State, w)
+	wc.AssertNoChange()
+
+	err = s.service.SetMinUnits(3)
+	c.Assert(err, IsNil)
+	wc.AssertChange("wordpress")
+	wc.AssertNoChange()
+	statetesting.AssertStop(c, w)
+	wc.AssertClosed()
+}
+
+func (s *applicationScalerSuite) TestRescale(c *C) {
+	args := params.Entities{Entities: []params.Entity{
+		{Tag: s.service.Tag()},
+		{Tag: "service-mysql"},
+		{Tag: "machine-42"},
+	}}
+	results, err := s.api.Rescale(args)
+	c.Assert(err, IsNil)
+	c.Assert(results, DeepEquals, params.ErrorResults{
+		Errors: []*params.Error{
+			nil,
+			{Code: params.CodeNotFound, Message: `service "mysql" not found`},
+			{Code: params.CodeNotFound, Message: `machine 42 not found`},
+		},
+	})
+	units, err := s.service.AllUnits()
+	c.Assert(err, IsNil)
+	c.Assert(units, HasLen, 2)
+}
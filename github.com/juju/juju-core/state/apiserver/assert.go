@@ -0,0 +1,76 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"reflect"
+
+	"launchpad.net/juju-core/errors"
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/api/params"
+	"launchpad.net/juju-core/state/apiserver/common"
+)
+
+var assertionsType = reflect.TypeOf([]params.Assertion(nil))
+
+// checkAssertions checks the assertions held in the Assertions field
+// of the given request arguments, if they have one, returning
+// common.ErrConflict if any asserted entity has changed or been
+// removed. The check is made before the request is served, so a
+// change made while it is being served is not detected.
+func checkAssertions(st *state.State, args interface{}) error {
+	v := reflect.ValueOf(args)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	field := v.FieldByName("Assertions")
+	if !field.IsValid() || field.Type() != assertionsType {
+		return nil
+	}
+	for _, a := range field.Interface().([]params.Assertion) {
+		rev, err := st.Revision(a.Tag)
+		if errors.IsNotFoundError(err) {
+			return common.ErrConflict
+		} else if err != nil {
+			return err
+		}
+		if rev != a.Revision {
+			return common.ErrConflict
+		}
+	}
+	return nil
+}
+
+// Revisions returns an object through which the client may read the
+// revisions of entities, to assert in conditional calls. The id
+// argument is reserved for future use and must be empty.
+func (r *srvRoot) Revisions(id string) (srvRevisions, error) {
+	if id != "" {
+		return srvRevisions{}, common.ErrBadId
+	}
+	return srvRevisions{r}, nil
+}
+
+type srvRevisions struct {
+	root *srvRoot
+}
+
+// Get returns the revisions of the given entities. An agent
+// may read only the revision of the entity it is logged in as.
+func (rv srvRevisions) Get(args params.Entities) params.RevisionResults {
+	result := params.RevisionResults{
+		Results: make([]params.RevisionResult, len(args.Entities)),
+	}
+	for i, entity := range args.Entities {
+		err := common.ErrPerm
+		if rv.root.AuthClient() || rv.root.AuthOwner(entity.Tag) {
+			result.Results[i].Revision, err = rv.root.srv.state.Revision(entity.Tag)
+		}
+		result.Results[i].Error = common.ServerError(err)
+	}
+	return result
+}
@@ -0,0 +1,161 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"reflect"
+
+	"launchpad.net/juju-core/state/apiserver/common"
+)
+
+// facadeRule says which authenticated entities may use a facade.
+type facadeRule struct {
+	// requirement describes the entities allowed,
+	// for those reviewing the policy.
+	requirement string
+
+	// allow reports whether the entity
+	// logged in to r is allowed.
+	allow func(r *srvRoot) bool
+}
+
+var (
+	anyEntity = facadeRule{
+		requirement: "any logged in entity",
+		allow:       func(*srvRoot) bool { return true },
+	}
+	clients = facadeRule{
+		requirement: "client users",
+		allow:       (*srvRoot).AuthClient,
+	}
+	agents = facadeRule{
+		requirement: "machine and unit agents",
+		allow: func(r *srvRoot) bool {
+			return isAgent(r.authEntity())
+		},
+	}
+	agentsAndClients = facadeRule{
+		requirement: "machine and unit agents, and client users",
+		allow: func(r *srvRoot) bool {
+			return isAgent(r.authEntity()) || r.AuthClient()
+		},
+	}
+	machineAgents = facadeRule{
+		requirement: "machine agents",
+		allow:       (*srvRoot).AuthMachineAgent,
+	}
+	unitAgents = facadeRule{
+		requirement: "unit agents",
+		allow:       (*srvRoot).AuthUnitAgent,
+	}
+	environManagers = facadeRule{
+		requirement: "machine agents running the ManageEnviron job",
+		allow:       (*srvRoot).AuthEnvironManager,
+	}
+	controllers = facadeRule{
+		requirement: "machine agents running the ManageState job",
+		allow:       (*srvRoot).AuthController,
+	}
+)
+
+// facadePolicy holds the rule for each facade served to logged in
+// connections, keyed by the name of the srvRoot method through which
+// it is obtained. It is consulted before any request is served, and
+// so before the facade is obtained; facades whose constructors check
+// the entity as well are given the same rule here, so that the
+// policy can be reviewed in one place. Facade methods given the tags
+// of entities may restrict further which of them may be acted upon.
+var facadePolicy = map[string]facadeRule{
+	"AgentEvents":          agents,
+	"AgentWatchers":        agents,
+	"AllWatcher":           clients,
+	"AuthEntity":           anyEntity,
+	"ApplicationScaler":    environManagers,
+	"Client":               clients,
+	"Deployer":             machineAgents,
+	"Deprecations":         anyEntity,
+	"LeadershipService":    unitAgents,
+	"MachineAgent":         machineAgents,
+	"MachineUndertaker":    environManagers,
+	"Machiner":             machineAgents,
+	"MachinesCursor":       clients,
+	"MetricsAdder":         unitAgents,
+	"NotifyWatcher":        agentsAndClients,
+	"Pinger":               anyEntity,
+	"ProxyUpdater":         agents,
+	"RelationUnitsWatcher": agents,
+	"Resolver":             unitAgents,
+	"RetryStrategy":        agents,
+	"ReverseChannel":       agents,
+	"Revisions":            anyEntity,
+	"SSHClient":            clients,
+	"StringsWatcher":       agents,
+	"Undertaker":           controllers,
+	"Upgrader":             machineAgents,
+	"WatcherManager":       anyEntity,
+}
+
+// authorizeFacade returns common.ErrPerm if the entity logged in to r
+// may not use the given facade. Facades without a rule are left to
+// the RPC layer, which reports them unknown.
+func (r *srvRoot) authorizeFacade(facade string) error {
+	if rule, ok := facadePolicy[facade]; ok && !rule.allow(r) {
+		return common.ErrPerm
+	}
+	return nil
+}
+
+// authorizeMethod returns common.ErrPerm if the credentials the
+// connection logged in with do not allow the given method of the
+// given facade. Pinger methods are always allowed.
+func (r *srvRoot) authorizeMethod(facade, method string) error {
+	if r.allowedMethods == nil || facade == "Pinger" || r.allowedMethods[methodKey{facade, method}] {
+		return nil
+	}
+	return common.ErrPerm
+}
+
+// AuthorizationRule gives the entities allowed
+// to call a facade method.
+type AuthorizationRule struct {
+	Facade      string
+	Method      string
+	Requirement string
+}
+
+// unrestricted is the requirement reported for
+// facades missing from facadePolicy.
+const unrestricted = "unrestricted: no rule in the policy"
+
+// AuthorizationPolicy returns the rule for every method of the
+// facades served to logged in connections, sorted by facade and
+// method, so that the authorization of the API can be reviewed in
+// one place. The facades are found as the RPC layer finds them, so
+// that any facade left out of the policy is reported as
+// unrestricted.
+func (srv *Server) AuthorizationPolicy() []AuthorizationRule {
+	var rules []AuthorizationRule
+	rootType := reflect.TypeOf((*srvRoot)(nil))
+	for i := 0; i < rootType.NumMethod(); i++ {
+		m := rootType.Method(i)
+		t := m.Type
+		if t.NumIn() != 2 || t.In(1).Kind() != reflect.String ||
+			t.NumOut() != 2 || t.Out(1) != errorType {
+			continue
+		}
+		requirement := unrestricted
+		if rule, ok := facadePolicy[m.Name]; ok {
+			requirement = rule.requirement
+		}
+		facadeType := t.Out(0)
+		for j := 0; j < facadeType.NumMethod(); j++ {
+			rules = append(rules, AuthorizationRule{
+				Facade:      m.Name,
+				Method:      facadeType.Method(j).Name,
+				Requirement: requirement,
+			})
+		}
+	}
+	return rules
+}
@@ -0,0 +1,166 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"launchpad.net/juju-core/log"
+	"launchpad.net/juju-core/state/apiserver/common"
+	"launchpad.net/juju-core/utils"
+	"launchpad.net/tomb"
+)
+
+// blobPath is the path under which blobs are transferred.
+const blobPath = "/blobs/"
+
+// blob is a single transfer of opaque data between a facade and a
+// client, made over HTTP on the API server's listener rather than
+// through the RPC connection, so that the data is never buffered or
+// encoded in full. The client refers to it by its token. A blob is a
+// resource of the connection that offered it: it may be transferred
+// once, and is discarded when the connection is killed.
+type blob struct {
+	srv       *Server
+	token     string
+	resources *common.Resources
+	id        string
+
+	// For a download, src holds the data and size its length.
+	src  io.ReadCloser
+	size int64
+
+	// For an upload, store is called with the uploaded
+	// data, which may be at most max bytes long.
+	store func(io.Reader) error
+	max   int64
+
+	mu   sync.Mutex
+	used bool
+}
+
+// Stop implements common.Resource.Stop.
+func (b *blob) Stop() error {
+	b.srv.removeBlob(b.token)
+	if b.src != nil {
+		return b.src.Close()
+	}
+	return nil
+}
+
+// take marks the blob as used, returning false if it has been used
+// already or cannot be transferred with the given HTTP method, which
+// must be GET or PUT.
+func (b *blob) take(method string) bool {
+	if method == "GET" && b.src == nil || method == "PUT" && b.store == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.used {
+		return false
+	}
+	b.used = true
+	return true
+}
+
+// OfferBlob makes the size bytes read from src available for the
+// client to download, returning the token by which the client refers
+// to them. The client downloads them with an HTTP GET of the token
+// under /blobs/ on the API server. src is closed once the download has
+// finished, or when the connection is killed.
+func (r *srvRoot) OfferBlob(src io.ReadCloser, size int64) (string, error) {
+	if max := r.srv.cfg.MaxBlobSize; max > 0 && size > max {
+		src.Close()
+		return "", fmt.Errorf("blob of %d bytes exceeds limit of %d", size, max)
+	}
+	return r.addBlob(&blob{src: src, size: size})
+}
+
+// AcceptBlob lets the client upload at most max bytes, which are
+// passed to store as they are read. It returns the token by which the
+// client refers to the upload; the client uploads the data with an
+// HTTP PUT of the token under /blobs/ on the API server. The limit is
+// reduced to the server's MaxBlobSize if that is smaller.
+func (r *srvRoot) AcceptBlob(max int64, store func(io.Reader) error) (string, error) {
+	if limit := r.srv.cfg.MaxBlobSize; limit > 0 && (max <= 0 || max > limit) {
+		max = limit
+	}
+	return r.addBlob(&blob{store: store, max: max})
+}
+
+// addBlob registers b with the server and in r.resources.
+func (r *srvRoot) addBlob(b *blob) (string, error) {
+	data, err := utils.RandomBytes(16)
+	if err != nil {
+		return "", err
+	}
+	b.srv = r.srv
+	b.token = hex.EncodeToString(data)
+	b.resources = r.resources
+	r.srv.mu.Lock()
+	r.srv.blobs[b.token] = b
+	r.srv.mu.Unlock()
+	b.id = r.resources.Register(b)
+	return b.token, nil
+}
+
+// removeBlob forgets the blob with the given token.
+func (srv *Server) removeBlob(token string) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	delete(srv.blobs, token)
+}
+
+// serveBlob transfers the blob named by the request's path,
+// discarding it once the transfer has finished. Blobs are downloaded
+// with GET and uploaded with PUT; other methods are refused without
+// the blob being looked up, so that it is left for the client to
+// transfer.
+func (srv *Server) serveBlob(w http.ResponseWriter, req *http.Request) {
+	srv.wg.Add(1)
+	defer srv.wg.Done()
+	if req.Method != "GET" && req.Method != "PUT" {
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if srv.tomb.Err() != tomb.ErrStillAlive {
+		http.NotFound(w, req)
+		return
+	}
+	token := req.URL.Path[len(blobPath):]
+	srv.mu.Lock()
+	b := srv.blobs[token]
+	srv.mu.Unlock()
+	if b == nil || !b.take(req.Method) {
+		http.NotFound(w, req)
+		return
+	}
+	defer b.resources.Stop(b.id)
+	if req.Method == "GET" {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.FormatInt(b.size, 10))
+		if _, err := io.CopyN(w, b.src, b.size); err != nil {
+			log.Errorf("state/api: cannot send blob: %v", err)
+		}
+	} else {
+		body := io.Reader(req.Body)
+		if b.max > 0 {
+			if req.ContentLength > b.max {
+				http.Error(w, "blob too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			body = http.MaxBytesReader(w, req.Body, b.max)
+		}
+		if err := b.store(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}
+}
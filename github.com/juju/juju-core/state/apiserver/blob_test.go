@@ -0,0 +1,166 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	jujutesting "launchpad.net/juju-core/juju/testing"
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/api"
+	"launchpad.net/juju-core/state/apiserver"
+	coretesting "launchpad.net/juju-core/testing"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+type blobSuite struct {
+	jujutesting.JujuConnSuite
+	srv  *apiserver.Server
+	root apiserver.BlobRoot
+	st   *api.State
+}
+
+var _ = Suite(&blobSuite{})
+
+func (s *blobSuite) SetUpTest(c *C) {
+	s.JujuConnSuite.SetUpTest(c)
+	var err error
+	s.srv, err = apiserver.NewServerWithConfig(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey), apiserver.ServerConfig{
+		MaxBlobSize: 100,
+	})
+	c.Assert(err, IsNil)
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	err = stm.SetProvisioned("foo", "fake_nonce", nil)
+	c.Assert(err, IsNil)
+	err = stm.SetPassword("password")
+	c.Assert(err, IsNil)
+	s.root = apiserver.NewBlobRoot(s.srv, stm)
+	s.st, err = api.Open(&api.Info{
+		Tag:      stm.Tag(),
+		Password: "password",
+		Nonce:    "fake_nonce",
+		Addrs:    []string{s.srv.Addr()},
+		CACert:   []byte(coretesting.CACert),
+	}, fastDialOpts)
+	c.Assert(err, IsNil)
+}
+
+func (s *blobSuite) TearDownTest(c *C) {
+	s.st.Close()
+	s.root.Kill()
+	s.srv.Stop()
+	s.JujuConnSuite.TearDownTest(c)
+}
+
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (r *closeRecorder) Close() error {
+	r.closed = true
+	return nil
+}
+
+func (s *blobSuite) TestDownload(c *C) {
+	src := &closeRecorder{Reader: strings.NewReader("hello world")}
+	token, err := s.root.OfferBlob(src, 11)
+	c.Assert(err, IsNil)
+
+	r, err := s.st.DownloadBlob(token)
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "hello world")
+	c.Assert(src.closed, Equals, true)
+
+	// A blob can be downloaded only once.
+	_, err = s.st.DownloadBlob(token)
+	c.Assert(err, ErrorMatches, "cannot download blob: 404 Not Found")
+}
+
+func (s *blobSuite) TestDownloadTooLarge(c *C) {
+	src := &closeRecorder{Reader: strings.NewReader("")}
+	_, err := s.root.OfferBlob(src, 101)
+	c.Assert(err, ErrorMatches, "blob of 101 bytes exceeds limit of 100")
+	c.Assert(src.closed, Equals, true)
+}
+
+func (s *blobSuite) TestUpload(c *C) {
+	var buf bytes.Buffer
+	store := func(r io.Reader) error {
+		_, err := io.Copy(&buf, r)
+		return err
+	}
+	token, err := s.root.AcceptBlob(5, store)
+	c.Assert(err, IsNil)
+	err = s.st.UploadBlob(token, strings.NewReader("hello"), 5)
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, "hello")
+
+	// Uploads are limited to the size given.
+	token, err = s.root.AcceptBlob(5, store)
+	c.Assert(err, IsNil)
+	err = s.st.UploadBlob(token, strings.NewReader("hello world"), 11)
+	c.Assert(err, ErrorMatches, "cannot upload blob: 413 Request Entity Too Large: blob too large")
+
+	// A blob cannot be uploaded with a download token.
+	token, err = s.root.OfferBlob(ioutil.NopCloser(strings.NewReader("x")), 1)
+	c.Assert(err, IsNil)
+	err = s.st.UploadBlob(token, strings.NewReader("hello"), 5)
+	c.Assert(err, ErrorMatches, "cannot upload blob: 404 Not Found: 404 page not found")
+}
+
+func (s *blobSuite) TestOtherMethodsRefused(c *C) {
+	src := &closeRecorder{Reader: strings.NewReader("hello world")}
+	download, err := s.root.OfferBlob(src, 11)
+	c.Assert(err, IsNil)
+	stored := false
+	upload, err := s.root.AcceptBlob(5, func(io.Reader) error {
+		stored = true
+		return nil
+	})
+	c.Assert(err, IsNil)
+
+	for _, token := range []string{download, upload} {
+		for _, method := range []string{"POST", "HEAD", "DELETE"} {
+			c.Logf("%s of %s", method, token)
+			req, err := http.NewRequest(method, "https://localhost/blobs/"+token, strings.NewReader("hello"))
+			c.Assert(err, IsNil)
+			rec := httptest.NewRecorder()
+			apiserver.ServeBlob(s.srv, rec, req)
+			c.Assert(rec.Code, Equals, http.StatusMethodNotAllowed)
+			c.Assert(rec.HeaderMap.Get("Allow"), Equals, "GET, PUT")
+		}
+	}
+	c.Assert(stored, Equals, false)
+	c.Assert(src.closed, Equals, false)
+
+	// The blobs can still be transferred.
+	r, err := s.st.DownloadBlob(download)
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "hello world")
+	err = s.st.UploadBlob(upload, strings.NewReader("hello"), 5)
+	c.Assert(err, IsNil)
+	c.Assert(stored, Equals, true)
+}
+
+func (s *blobSuite) TestKillDiscardsBlobs(c *C) {
+	src := &closeRecorder{Reader: strings.NewReader("hello world")}
+	token, err := s.root.OfferBlob(src, 11)
+	c.Assert(err, IsNil)
+	s.root.Kill()
+	c.Assert(src.closed, Equals, true)
+	_, err = s.st.DownloadBlob(token)
+	c.Assert(err, ErrorMatches, "cannot download blob: 404 Not Found")
+}
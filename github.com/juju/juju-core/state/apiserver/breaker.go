@@ -0,0 +1,146 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/txn"
+
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/apiserver/common"
+)
+
+// defaultBreakerCooldown is the time a tripped breaker stays
+// open when ServerConfig.BreakerCooldown is not set.
+const defaultBreakerCooldown = 10 * time.Second
+
+// breaker is a circuit breaker guarding the state backend. After
+// threshold consecutive requests have failed with a state error it
+// trips open, and requests are refused until cooldown has passed.
+// It then lets a single request through to probe whether the
+// backend has recovered: if that succeeds the breaker closes
+// again, otherwise it reopens for another cooldown.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	failures int
+
+	// openUntil holds the time the breaker stays open
+	// until, or the zero time if it is closed.
+	openUntil time.Time
+
+	// probing is set while the request probing
+	// a half-open breaker is outstanding.
+	probing bool
+}
+
+// newBreaker returns a breaker that trips after threshold
+// consecutive failures, or nil if threshold is not positive.
+// A nil breaker allows every request.
+func newBreaker(threshold int, cooldown time.Duration, now func() time.Time) *breaker {
+	if threshold <= 0 {
+		return nil
+	}
+	if cooldown <= 0 {
+		cooldown = defaultBreakerCooldown
+	}
+	return &breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       now,
+	}
+}
+
+// allow reports whether a request may be made. If it returns
+// true, the outcome of the request must be passed to record.
+func (b *breaker) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil.IsZero() {
+		return true
+	}
+	if b.probing || b.now().Before(b.openUntil) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// tripped reports whether the breaker is open or half open,
+// refusing requests other than a probe.
+func (b *breaker) tripped() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.openUntil.IsZero()
+}
+
+// record records the error returned by a request
+// allowed by the breaker.
+func (b *breaker) record(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !isStateError(err) {
+		b.failures = 0
+		b.openUntil = time.Time{}
+		b.probing = false
+		return
+	}
+	b.failures++
+	if b.probing || b.failures >= b.threshold {
+		b.openUntil = b.now().Add(b.cooldown)
+		b.probing = false
+	}
+}
+
+// driverErrors holds the messages with which the mongo driver
+// reports lost or unreachable servers. Its errors have no type
+// of their own, and the state package often wraps them.
+var driverErrors = []string{
+	"no reachable servers",
+	"Closed explicitly",
+}
+
+// isStateError reports whether err indicates trouble in the state
+// backend rather than a problem with the request itself: a failure
+// of the mongo driver or of its connection, a transaction aborted
+// by contention, or a call that timed out. Errors reported by the
+// facades, such as those for bad arguments or missing entities, say
+// nothing about the health of the backend.
+func isStateError(err error) bool {
+	switch err {
+	case nil, mgo.ErrNotFound:
+		return false
+	case common.ErrTimeout, txn.ErrAborted, state.ErrExcessiveContention, io.EOF:
+		return true
+	}
+	switch err.(type) {
+	case *mgo.QueryError, *mgo.LastError, net.Error:
+		return true
+	}
+	msg := err.Error()
+	for _, s := range driverErrors {
+		if strings.HasSuffix(msg, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver_test
+
+import (
+	stderrors "errors"
+	"fmt"
+	"time"
+
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/txn"
+	. "launchpad.net/gocheck"
+
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/apiserver"
+	"launchpad.net/juju-core/state/apiserver/common"
+	"launchpad.net/juju-core/testing"
+)
+
+type breakerSuite struct {
+	testing.LoggingSuite
+}
+
+var _ = Suite(&breakerSuite{})
+
+var errState = stderrors.New("no reachable servers")
+
+func (s *breakerSuite) TestDisabled(c *C) {
+	b := apiserver.NewBreaker(0, 0, time.Now)
+	for i := 0; i < 10; i++ {
+		c.Assert(b.Allow(), Equals, true)
+		b.Record(errState)
+	}
+}
+
+func (s *breakerSuite) TestTripAndRecover(c *C) {
+	now := time.Now()
+	clock := func() time.Time { return now }
+	b := apiserver.NewBreaker(3, time.Minute, clock)
+
+	// Errors with a known code do not count as failures.
+	for i := 0; i < 5; i++ {
+		c.Assert(b.Allow(), Equals, true)
+		b.Record(common.ErrPerm)
+	}
+	// A success resets the count of consecutive failures.
+	for i := 0; i < 2; i++ {
+		c.Assert(b.Allow(), Equals, true)
+		b.Record(errState)
+	}
+	c.Assert(b.Allow(), Equals, true)
+	b.Record(nil)
+	for i := 0; i < 3; i++ {
+		c.Assert(b.Allow(), Equals, true)
+		b.Record(errState)
+	}
+
+	// The breaker is now open until the cooldown has passed.
+	c.Assert(b.Allow(), Equals, false)
+	now = now.Add(59 * time.Second)
+	c.Assert(b.Allow(), Equals, false)
+
+	// Once half-open, only a single probe is allowed; when
+	// it fails the breaker opens again.
+	now = now.Add(time.Second)
+	c.Assert(b.Allow(), Equals, true)
+	c.Assert(b.Allow(), Equals, false)
+	b.Record(errState)
+	c.Assert(b.Allow(), Equals, false)
+
+	// A successful probe closes the breaker.
+	now = now.Add(time.Minute)
+	c.Assert(b.Allow(), Equals, true)
+	b.Record(nil)
+	c.Assert(b.Allow(), Equals, true)
+	c.Assert(b.Allow(), Equals, true)
+}
+
+func (s *breakerSuite) TestRequestErrorsDoNotTrip(c *C) {
+	b := apiserver.NewBreaker(3, time.Minute, time.Now)
+	for _, err := range []error{
+		fmt.Errorf("blob of 2048 bytes is larger than the limit of 1024"),
+		fmt.Errorf(`"service-wordpress" cannot be watched`),
+		&common.BadRequestError{Field: "Tag", Reason: "must be the tag of a machine or unit"},
+		common.ErrPerm,
+		mgo.ErrNotFound,
+	} {
+		for i := 0; i < 5; i++ {
+			c.Assert(b.Allow(), Equals, true, Commentf("%v", err))
+			b.Record(err)
+		}
+	}
+}
+
+func (s *breakerSuite) TestStateErrorsTrip(c *C) {
+	for _, err := range []error{
+		errState,
+		fmt.Errorf("cannot get machine 0: %v", errState),
+		txn.ErrAborted,
+		state.ErrExcessiveContention,
+		common.ErrTimeout,
+	} {
+		b := apiserver.NewBreaker(3, time.Minute, time.Now)
+		for i := 0; i < 3; i++ {
+			c.Assert(b.Allow(), Equals, true, Commentf("%v", err))
+			b.Record(err)
+		}
+		c.Assert(b.Allow(), Equals, false, Commentf("%v", err))
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"reflect"
+
+	"launchpad.net/juju-core/state/api/params"
+	"launchpad.net/juju-core/state/apiserver/common"
+)
+
+var paramsErrorType = reflect.TypeOf((*params.Error)(nil))
+
+// setEntryErrors records each of the given errors, keyed by the index
+// of the argument entry it concerns, as the error of the corresponding
+// entry in the results of a bulk call. The results must be a struct
+// whose only slice holds either *params.Error values or structs with
+// an Error field of that type; any other results are left untouched,
+// as are entries beyond the end of the slice. Any other error the
+// method reported for the entry is replaced, so that the client learns
+// what was wrong with its request while other entries keep their
+// results.
+func setEntryErrors(results interface{}, errs map[int]error) {
+	if len(errs) == 0 || results == nil {
+		return
+	}
+	v := reflect.ValueOf(results)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return
+	}
+	var entries reflect.Value
+	for i := 0; i < v.NumField(); i++ {
+		if f := v.Field(i); f.Kind() == reflect.Slice {
+			if entries.IsValid() {
+				return
+			}
+			entries = f
+		}
+	}
+	if !entries.IsValid() {
+		return
+	}
+	for i, err := range errs {
+		if i >= entries.Len() {
+			continue
+		}
+		// Slice elements are always settable, even when
+		// the slice is held in an unaddressable struct.
+		e := entries.Index(i)
+		if e.Kind() == reflect.Struct {
+			e = e.FieldByName("Error")
+		}
+		if !e.IsValid() || e.Type() != paramsErrorType {
+			return
+		}
+		e.Set(reflect.ValueOf(common.ServerError(err)))
+	}
+}
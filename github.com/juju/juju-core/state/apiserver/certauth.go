@@ -0,0 +1,104 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+
+	"launchpad.net/juju-core/state/api/params"
+)
+
+// Client certificate subject fields that may hold the tag
+// of the entity a certificate identifies.
+const (
+	CertFieldCommonName         = "CommonName"
+	CertFieldOrganizationalUnit = "OrganizationalUnit"
+	CertFieldSerialNumber       = "SerialNumber"
+)
+
+// checkCertField returns an error if field does not name
+// a client certificate subject field known to certTag.
+func checkCertField(field string) error {
+	switch field {
+	case "", CertFieldCommonName, CertFieldOrganizationalUnit, CertFieldSerialNumber:
+		return nil
+	}
+	return fmt.Errorf("unknown client certificate subject field %q", field)
+}
+
+// certValues returns the values of the given subject field
+// of a client certificate. An empty field means CommonName.
+func certValues(cert *x509.Certificate, field string) ([]string, error) {
+	switch field {
+	case "", CertFieldCommonName:
+		if cert.Subject.CommonName == "" {
+			return nil, nil
+		}
+		return []string{cert.Subject.CommonName}, nil
+	case CertFieldOrganizationalUnit:
+		return cert.Subject.OrganizationalUnit, nil
+	case CertFieldSerialNumber:
+		if cert.Subject.SerialNumber == "" {
+			return nil, nil
+		}
+		return []string{cert.Subject.SerialNumber}, nil
+	}
+	return nil, checkCertField(field)
+}
+
+// certTag returns the entity tag held in the given subject field
+// of a client certificate. An empty field means CommonName.
+func certTag(cert *x509.Certificate, field string) (string, error) {
+	values, err := certValues(cert, field)
+	if err != nil {
+		return "", err
+	}
+	if len(values) != 1 || values[0] == "" {
+		return "", fmt.Errorf("client certificate has no single tag in %s", field)
+	}
+	return values[0], nil
+}
+
+// certMethods returns the facade methods to which the given subject
+// field of a client certificate restricts the connections logged in
+// with it, or nil if the field is empty or holds no methods; see
+// ServerConfig.ClientCertMethodsField.
+func certMethods(cert *x509.Certificate, field string) (map[methodKey]bool, error) {
+	if field == "" {
+		return nil, nil
+	}
+	values, err := certValues(cert, field)
+	if err != nil || len(values) == 0 {
+		return nil, err
+	}
+	methods := make(map[methodKey]bool)
+	for _, v := range values {
+		i := strings.Index(v, ".")
+		if i <= 0 || i == len(v)-1 {
+			return nil, fmt.Errorf("client certificate has invalid method %q in %s", v, field)
+		}
+		methods[methodKey{v[:i], v[i+1:]}] = true
+	}
+	return methods, nil
+}
+
+// AuthMethods returns the ways in which the server accepts logins.
+// Client certificates are accepted only if the server has been given
+// a CA certificate with which to verify them; the certificate itself
+// is not revealed.
+func (srv *Server) AuthMethods() params.AuthMethodsResult {
+	result := params.AuthMethodsResult{
+		Methods: []string{params.AuthMethodPassword},
+	}
+	if len(srv.cfg.ClientCACert) > 0 {
+		result.Methods = append(result.Methods, params.AuthMethodClientCert)
+		result.ClientCertTagField = srv.cfg.ClientCertTagField
+		if result.ClientCertTagField == "" {
+			result.ClientCertTagField = CertFieldCommonName
+		}
+	}
+	return result
+}
@@ -0,0 +1,96 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver_test
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+
+	. "launchpad.net/gocheck"
+	"launchpad.net/juju-core/state/apiserver"
+	"launchpad.net/juju-core/testing"
+)
+
+type certAuthSuite struct {
+	testing.LoggingSuite
+}
+
+var _ = Suite(&certAuthSuite{})
+
+var certTagTests = []struct {
+	about   string
+	subject pkix.Name
+	field   string
+	tag     string
+	err     string
+}{{
+	about:   "common name by default",
+	subject: pkix.Name{CommonName: "machine-0"},
+	tag:     "machine-0",
+}, {
+	about:   "common name",
+	subject: pkix.Name{CommonName: "unit-wordpress-0"},
+	field:   apiserver.CertFieldCommonName,
+	tag:     "unit-wordpress-0",
+}, {
+	about:   "organizational unit",
+	subject: pkix.Name{CommonName: "foo", OrganizationalUnit: []string{"machine-1"}},
+	field:   apiserver.CertFieldOrganizationalUnit,
+	tag:     "machine-1",
+}, {
+	about:   "serial number",
+	subject: pkix.Name{SerialNumber: "user-admin"},
+	field:   apiserver.CertFieldSerialNumber,
+	tag:     "user-admin",
+}, {
+	about:   "empty field",
+	subject: pkix.Name{OrganizationalUnit: []string{"machine-1"}},
+	err:     "client certificate has no single tag in ",
+}, {
+	about:   "ambiguous field",
+	subject: pkix.Name{OrganizationalUnit: []string{"machine-1", "machine-2"}},
+	field:   apiserver.CertFieldOrganizationalUnit,
+	err:     "client certificate has no single tag in OrganizationalUnit",
+}, {
+	about:   "unknown field",
+	subject: pkix.Name{CommonName: "machine-0"},
+	field:   "Locality",
+	err:     `unknown client certificate subject field "Locality"`,
+}}
+
+func (s *certAuthSuite) TestCertTag(c *C) {
+	for i, test := range certTagTests {
+		c.Logf("test %d: %s", i, test.about)
+		tag, err := apiserver.CertTag(&x509.Certificate{Subject: test.subject}, test.field)
+		if test.err != "" {
+			c.Check(err, ErrorMatches, test.err)
+			continue
+		}
+		c.Check(err, IsNil)
+		c.Check(tag, Equals, test.tag)
+	}
+}
+
+func (s *certAuthSuite) TestCertMethods(c *C) {
+	cert := &x509.Certificate{Subject: pkix.Name{
+		CommonName:         "user-admin",
+		OrganizationalUnit: []string{"Client.Status", "AllWatcher.Next"},
+	}}
+	methods, err := apiserver.CertMethods(cert, apiserver.CertFieldOrganizationalUnit)
+	c.Assert(err, IsNil)
+	c.Assert(methods, HasLen, 2)
+
+	// Without a field, or with no values in it, methods are not restricted.
+	methods, err = apiserver.CertMethods(cert, "")
+	c.Assert(err, IsNil)
+	c.Assert(methods, IsNil)
+	methods, err = apiserver.CertMethods(cert, apiserver.CertFieldSerialNumber)
+	c.Assert(err, IsNil)
+	c.Assert(methods, IsNil)
+
+	// Values that name no method are refused.
+	cert.Subject.OrganizationalUnit = []string{"Client.Status", "Client"}
+	_, err = apiserver.CertMethods(cert, apiserver.CertFieldOrganizationalUnit)
+	c.Assert(err, ErrorMatches, `client certificate has invalid method "Client" in OrganizationalUnit`)
+}
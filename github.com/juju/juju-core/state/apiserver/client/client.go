@@ -12,6 +12,7 @@ import (
 	"launchpad.net/juju-core/state/api/params"
 	"launchpad.net/juju-core/state/apiserver/common"
 	"launchpad.net/juju-core/state/statecmd"
+	"time"
 )
 
 type API struct {
@@ -23,6 +24,7 @@ type API struct {
 
 // Client serves client-specific API methods.
 type Client struct {
+	*common.ControllersWatcher
 	api *API
 }
 
@@ -34,7 +36,8 @@ func NewAPI(st *state.State, resources *common.Resources, authorizer common.Auth
 		resources: resources,
 	}
 	r.client = &Client{
-		api: r,
+		ControllersWatcher: common.NewControllersWatcher(st, resources),
+		api:                r,
 	}
 	return r
 }
@@ -52,6 +55,42 @@ func (r *API) Client(id string) (*Client, error) {
 	return r.client, nil
 }
 
+// adminTag holds the tag of the environment's administrative user.
+const adminTag = "user-admin"
+
+// checkCanChange returns an error if changes
+// to the environment have been blocked.
+func (c *Client) checkCanChange() error {
+	return common.CheckBlocks(c.api.state, state.BlockChange)
+}
+
+// checkCanRemove returns an error if removals from
+// the environment, or all changes to it, have been
+// blocked.
+func (c *Client) checkCanRemove() error {
+	return common.CheckBlocks(c.api.state, state.BlockRemove, state.BlockChange)
+}
+
+// LastConnections returns the time at which each of the given
+// entities last logged in to the API, through any API server.
+// Only the administrative user may call it.
+func (c *Client) LastConnections(args params.Entities) (params.LastConnectionResults, error) {
+	if c.api.auth.GetAuthTag() != adminTag {
+		return params.LastConnectionResults{}, common.ErrPerm
+	}
+	results := params.LastConnectionResults{
+		Results: make([]params.LastConnectionResult, len(args.Entities)),
+	}
+	for i, entity := range args.Entities {
+		when, err := c.api.state.LastConnection(entity.Tag)
+		results.Results[i] = params.LastConnectionResult{
+			Time:  when,
+			Error: common.ServerError(err),
+		}
+	}
+	return results, nil
+}
+
 func (c *Client) Status() (api.Status, error) {
 	ms, err := c.api.state.AllMachines()
 	if err != nil {
@@ -79,8 +118,107 @@ func (c *Client) WatchAll() (params.AllWatcherId, error) {
 	}, nil
 }
 
+// WatchBlocks returns the blocks placed by operators on operations
+// in the environment, and the id of a NotifyWatcher that fires when
+// blocks are switched on or off. The blocks are read once the watcher
+// has started, so no change can be missed.
+func (c *Client) WatchBlocks() (params.BlocksWatchResult, error) {
+	var result params.BlocksWatchResult
+	id, err := common.NotifyWatchAndGet(c.api.resources, c.api.state.WatchBlocks(), func() error {
+		blocks, err := c.api.state.AllBlocks()
+		if err != nil {
+			return err
+		}
+		result.Blocks = make([]params.Block, len(blocks))
+		for i, b := range blocks {
+			result.Blocks[i] = params.Block{
+				Type:    string(b.Type()),
+				Message: b.Message(),
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return params.BlocksWatchResult{}, err
+	}
+	result.NotifyWatcherId = id
+	return result, nil
+}
+
+// agentPresenceWatcher is implemented by entities, such as machines
+// and units, whose agents' presence can be watched.
+type agentPresenceWatcher interface {
+	WatchAgentPresence() state.NotifyWatcher
+}
+
+// WatchAgentPresence starts a NotifyWatcher for each given machine or
+// unit, that fires when its agent is found to be alive, or lost, as
+// recorded by the agent's pinger in state, so that clients can show
+// the health of agents rather than of their connections.
+func (c *Client) WatchAgentPresence(args params.Entities) (params.NotifyWatchResults, error) {
+	result := params.NotifyWatchResults{
+		Results: make([]params.NotifyWatchResult, len(args.Entities)),
+	}
+	for i, entity := range args.Entities {
+		var err error
+		result.Results[i].NotifyWatcherId, err = c.watchAgentPresence(entity.Tag)
+		result.Results[i].Error = common.ServerError(err)
+	}
+	return result, nil
+}
+
+func (c *Client) watchAgentPresence(tag string) (string, error) {
+	entity, err := c.api.state.Tagger(tag)
+	if err != nil {
+		return "", err
+	}
+	agent, ok := entity.(agentPresenceWatcher)
+	if !ok {
+		return "", &common.BadRequestError{Field: "Tag", Reason: "must be the tag of a machine or unit"}
+	}
+	watch := agent.WatchAgentPresence()
+	if err := common.InitialNotifyEvent(c.api.resources, watch); err != nil {
+		return "", err
+	}
+	return c.api.resources.RegisterFor(watch, tag), nil
+}
+
+// machinesCursorTimeout holds the time after which
+// a MachinesCursor that is not read from is abandoned.
+var machinesCursorTimeout = 5 * time.Minute
+
+// MachinesCursor returns the id of a cursor from which the details
+// of all the machines in the environment can be read a page at a
+// time, so that a large environment need not be returned in a single
+// response. The cursor is stopped once its last page has been read.
+func (c *Client) MachinesCursor(args params.MachinesCursor) (params.MachinesCursorId, error) {
+	ms, err := c.api.state.AllMachines()
+	if err != nil {
+		return params.MachinesCursorId{}, err
+	}
+	details := make([]params.MachineDetails, len(ms))
+	for i, m := range ms {
+		instId, err := m.InstanceId()
+		if err != nil && !state.IsNotProvisionedError(err) {
+			return params.MachinesCursorId{}, err
+		}
+		details[i] = params.MachineDetails{
+			Id:         m.Id(),
+			InstanceId: string(instId),
+			Life:       params.Life(m.Life().String()),
+		}
+	}
+	id := common.RegisterCursor(c.api.resources, details, args.PageSize, machinesCursorTimeout)
+	return params.MachinesCursorId{
+		MachinesCursorId: id,
+	}, nil
+}
+
 // ServiceSet implements the server side of Client.ServerSet.
 func (c *Client) ServiceSet(p params.ServiceSet) error {
+	if err := c.checkCanChange(); err != nil {
+		return err
+	}
 	svc, err := c.api.state.Service(p.ServiceName)
 	if err != nil {
 		return err
@@ -98,6 +236,9 @@ func (c *Client) ServiceSet(p params.ServiceSet) error {
 
 // ServiceSetYAML implements the server side of Client.ServerSetYAML.
 func (c *Client) ServiceSetYAML(p params.ServiceSetYAML) error {
+	if err := c.checkCanChange(); err != nil {
+		return err
+	}
 	svc, err := c.api.state.Service(p.ServiceName)
 	if err != nil {
 		return err
@@ -120,6 +261,9 @@ func (c *Client) ServiceGet(args params.ServiceGet) (params.ServiceGetResults, e
 
 // Resolved implements the server side of Client.Resolved.
 func (c *Client) Resolved(p params.Resolved) error {
+	if err := c.checkCanChange(); err != nil {
+		return err
+	}
 	unit, err := c.api.state.Unit(p.UnitName)
 	if err != nil {
 		return err
@@ -130,12 +274,18 @@ func (c *Client) Resolved(p params.Resolved) error {
 // ServiceExpose changes the juju-managed firewall to expose any ports that
 // were also explicitly marked by units as open.
 func (c *Client) ServiceExpose(args params.ServiceExpose) error {
+	if err := c.checkCanChange(); err != nil {
+		return err
+	}
 	return statecmd.ServiceExpose(c.api.state, args)
 }
 
 // ServiceUnexpose changes the juju-managed firewall to unexpose any ports that
 // were also explicitly marked by units as open.
 func (c *Client) ServiceUnexpose(args params.ServiceUnexpose) error {
+	if err := c.checkCanChange(); err != nil {
+		return err
+	}
 	return statecmd.ServiceUnexpose(c.api.state, args)
 }
 
@@ -144,6 +294,9 @@ var CharmStore charm.Repository = charm.Store
 // ServiceDeploy fetches the charm from the charm store and deploys it. Local
 // charms are not supported.
 func (c *Client) ServiceDeploy(args params.ServiceDeploy) error {
+	if err := c.checkCanChange(); err != nil {
+		return err
+	}
 	curl, err := charm.ParseURL(args.CharmUrl)
 	if err != nil {
 		return err
@@ -184,6 +337,9 @@ func (c *Client) ServiceDeploy(args params.ServiceDeploy) error {
 
 // ServiceSetCharm sets the charm for a given service.
 func (c *Client) ServiceSetCharm(args params.ServiceSetCharm) error {
+	if err := c.checkCanChange(); err != nil {
+		return err
+	}
 	service, err := c.api.state.Service(args.ServiceName)
 	if err != nil {
 		return err
@@ -211,6 +367,9 @@ func (c *Client) ServiceSetCharm(args params.ServiceSetCharm) error {
 
 // AddServiceUnits adds a given number of units to a service.
 func (c *Client) AddServiceUnits(args params.AddServiceUnits) (params.AddServiceUnitsResults, error) {
+	if err := c.checkCanChange(); err != nil {
+		return params.AddServiceUnitsResults{}, err
+	}
 	units, err := statecmd.AddServiceUnits(c.api.state, args)
 	if err != nil {
 		return params.AddServiceUnitsResults{}, err
@@ -224,11 +383,17 @@ func (c *Client) AddServiceUnits(args params.AddServiceUnits) (params.AddService
 
 // DestroyServiceUnits removes a given set of service units.
 func (c *Client) DestroyServiceUnits(args params.DestroyServiceUnits) error {
+	if err := c.checkCanRemove(); err != nil {
+		return err
+	}
 	return statecmd.DestroyServiceUnits(c.api.state, args)
 }
 
 // ServiceDestroy destroys a given service.
 func (c *Client) ServiceDestroy(args params.ServiceDestroy) error {
+	if err := c.checkCanRemove(); err != nil {
+		return err
+	}
 	return statecmd.ServiceDestroy(c.api.state, args)
 }
 
@@ -239,16 +404,25 @@ func (c *Client) GetServiceConstraints(args params.GetServiceConstraints) (param
 
 // SetServiceConstraints sets the constraints for a given service.
 func (c *Client) SetServiceConstraints(args params.SetServiceConstraints) error {
+	if err := c.checkCanChange(); err != nil {
+		return err
+	}
 	return statecmd.SetServiceConstraints(c.api.state, args)
 }
 
 // AddRelation adds a relation between the specified endpoints and returns the relation info.
 func (c *Client) AddRelation(args params.AddRelation) (params.AddRelationResults, error) {
+	if err := c.checkCanChange(); err != nil {
+		return params.AddRelationResults{}, err
+	}
 	return statecmd.AddRelation(c.api.state, args)
 }
 
 // DestroyRelation removes the relation between the specified endpoints.
 func (c *Client) DestroyRelation(args params.DestroyRelation) error {
+	if err := c.checkCanRemove(); err != nil {
+		return err
+	}
 	return statecmd.DestroyRelation(c.api.state, args)
 }
 
@@ -12,9 +12,12 @@ import (
 	"launchpad.net/juju-core/state"
 	"launchpad.net/juju-core/state/api"
 	"launchpad.net/juju-core/state/api/params"
+	"launchpad.net/juju-core/state/api/watcher"
 	"launchpad.net/juju-core/state/apiserver/client"
+	statetesting "launchpad.net/juju-core/state/testing"
 	coretesting "launchpad.net/juju-core/testing"
 	"launchpad.net/juju-core/testing/checkers"
+	"time"
 )
 
 type clientSuite struct {
@@ -46,6 +49,41 @@ func (s *clientSuite) TestClientServerSet(c *C) {
 	})
 }
 
+func (s *clientSuite) TestClientServiceSetConditional(c *C) {
+	dummy, err := s.State.AddService("dummy", s.AddTestingCharm(c, "dummy"))
+	c.Assert(err, IsNil)
+	var revs params.RevisionResults
+	err = s.APIState.Call("Revisions", "", "Get", params.Entities{
+		Entities: []params.Entity{{Tag: dummy.Tag()}},
+	}, &revs)
+	c.Assert(err, IsNil)
+	c.Assert(revs.Results, HasLen, 1)
+	c.Assert(revs.Results[0].Error, IsNil)
+	assertions := []params.Assertion{{Tag: dummy.Tag(), Revision: revs.Results[0].Revision}}
+
+	// The call is made while the service is unchanged.
+	err = s.APIState.Call("Client", "", "ServiceSet", params.ServiceSet{
+		ServiceName: "dummy",
+		Options:     map[string]string{"title": "xxx"},
+		Assertions:  assertions,
+	}, nil)
+	c.Assert(err, IsNil)
+
+	// Once it has changed, the call fails without being made.
+	err = dummy.SetExposed()
+	c.Assert(err, IsNil)
+	err = s.APIState.Call("Client", "", "ServiceSet", params.ServiceSet{
+		ServiceName: "dummy",
+		Options:     map[string]string{"title": "yyy"},
+		Assertions:  assertions,
+	}, nil)
+	c.Assert(err, ErrorMatches, "entity has changed")
+	c.Assert(params.ErrCode(err), Equals, params.CodeConflict)
+	settings, err := dummy.ConfigSettings()
+	c.Assert(err, IsNil)
+	c.Assert(settings, DeepEquals, charm.Settings{"title": "xxx"})
+}
+
 func (s *clientSuite) TestClientServiceSetYAML(c *C) {
 	dummy, err := s.State.AddService("dummy", s.AddTestingCharm(c, "dummy"))
 	c.Assert(err, IsNil)
@@ -89,6 +127,65 @@ func (s *clientSuite) TestClientAddServiceUnits(c *C) {
 	}
 }
 
+func (s *clientSuite) TestClientAddServiceUnitsIdempotent(c *C) {
+	service, err := s.State.AddService("dummy", s.AddTestingCharm(c, "dummy"))
+	c.Assert(err, IsNil)
+	addUnits := func(n int, key string) ([]string, error) {
+		var result params.AddServiceUnitsResults
+		err := s.APIState.Call("Client", "", "AddServiceUnits", params.AddServiceUnits{
+			ServiceName:    "dummy",
+			NumUnits:       n,
+			IdempotencyKey: key,
+		}, &result)
+		return result.Units, err
+	}
+
+	// A retry returns the result of the first call
+	// without adding more units.
+	units, err := addUnits(1, "first")
+	c.Assert(err, IsNil)
+	c.Assert(units, DeepEquals, []string{"dummy/0"})
+	units, err = addUnits(1, "first")
+	c.Assert(err, IsNil)
+	c.Assert(units, DeepEquals, []string{"dummy/0"})
+
+	// The key may not be reused with other arguments.
+	_, err = addUnits(2, "first")
+	c.Assert(err, ErrorMatches, `invalid request: IdempotencyKey: already used for a different call`)
+	c.Assert(params.ErrCode(err), Equals, params.CodeBadRequest)
+
+	// Calls with other keys, or none, are made.
+	units, err = addUnits(1, "second")
+	c.Assert(err, IsNil)
+	c.Assert(units, DeepEquals, []string{"dummy/1"})
+	units, err = addUnits(1, "")
+	c.Assert(err, IsNil)
+	c.Assert(units, DeepEquals, []string{"dummy/2"})
+
+	// Failed calls are not remembered.
+	_, err = addUnits(0, "third")
+	c.Assert(err, NotNil)
+	units, err = addUnits(1, "third")
+	c.Assert(err, IsNil)
+	c.Assert(units, DeepEquals, []string{"dummy/3"})
+
+	allUnits, err := service.AllUnits()
+	c.Assert(err, IsNil)
+	c.Assert(allUnits, HasLen, 4)
+}
+
+func (s *clientSuite) TestClientLastConnections(c *C) {
+	// The connection made for the test was recorded.
+	when, err := s.APIState.Client().LastConnection("user-admin")
+	c.Assert(err, IsNil)
+	c.Assert(when.IsZero(), Equals, false)
+	c.Assert(when.After(time.Now()), Equals, false)
+
+	_, err = s.APIState.Client().LastConnection("machine-42")
+	c.Assert(err, ErrorMatches, `last connection of "machine-42" not found`)
+	c.Assert(params.ErrCode(err), Equals, params.CodeNotFound)
+}
+
 var clientCharmInfoTests = []struct {
 	about string
 	url   string
@@ -286,6 +383,40 @@ func (s *clientSuite) TestClientServiceDestroy(c *C) {
 	c.Assert(service.Life(), Not(Equals), state.Alive)
 }
 
+func (s *clientSuite) TestClientBlockedOperations(c *C) {
+	s.setUpScenario(c)
+	service, err := s.State.Service("wordpress")
+	c.Assert(err, IsNil)
+	client := s.APIState.Client()
+
+	// Removals are blocked by BlockRemove, but other changes are not.
+	err = s.State.SwitchBlockOn(state.BlockRemove, "no removals")
+	c.Assert(err, IsNil)
+	err = client.ServiceDestroy("wordpress")
+	c.Assert(err, ErrorMatches, "operation is blocked: no removals")
+	c.Assert(params.ErrCode(err), Equals, params.CodeOperationBlocked)
+	err = service.Refresh()
+	c.Assert(err, IsNil)
+	c.Assert(service.Life(), Equals, state.Alive)
+	err = client.ServiceExpose("wordpress")
+	c.Assert(err, IsNil)
+
+	// BlockChange blocks both.
+	err = s.State.SwitchBlockOff(state.BlockRemove)
+	c.Assert(err, IsNil)
+	err = s.State.SwitchBlockOn(state.BlockChange, "frozen")
+	c.Assert(err, IsNil)
+	err = client.ServiceUnexpose("wordpress")
+	c.Assert(err, ErrorMatches, "operation is blocked: frozen")
+	err = client.ServiceDestroy("wordpress")
+	c.Assert(err, ErrorMatches, "operation is blocked: frozen")
+
+	err = s.State.SwitchBlockOff(state.BlockChange)
+	c.Assert(err, IsNil)
+	err = client.ServiceDestroy("wordpress")
+	c.Assert(err, IsNil)
+}
+
 func (s *clientSuite) TestClientUnitResolved(c *C) {
 	// Setup:
 	s.setUpScenario(c)
@@ -580,3 +711,81 @@ func (s *clientSuite) TestClientWatchAll(c *C) {
 		}
 	}
 }
+
+func (s *clientSuite) TestClientWatchBlocks(c *C) {
+	err := s.State.SwitchBlockOn(state.BlockRemove, "no removals")
+	c.Assert(err, IsNil)
+	var result params.BlocksWatchResult
+	err = s.APIState.Call("Client", "", "WatchBlocks", nil, &result)
+	c.Assert(err, IsNil)
+	c.Assert(result.Blocks, DeepEquals, []params.Block{{Type: "remove", Message: "no removals"}})
+	w := watcher.NewNotifyWatcher(s.APIState, params.NotifyWatchResult{NotifyWatcherId: result.NotifyWatcherId})
+	wc := statetesting.NewNotifyWatcherC(c, s.State, w)
+	wc.AssertOneChange()
+
+	err = s.State.SwitchBlockOn(state.BlockChange, "frozen")
+	c.Assert(err, IsNil)
+	wc.AssertOneChange()
+	statetesting.AssertStop(c, w)
+	wc.AssertClosed()
+}
+
+func (s *clientSuite) TestClientWatchAgentPresence(c *C) {
+	m, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	args := params.Entities{Entities: []params.Entity{
+		{Tag: "user-admin"},
+		{Tag: "machine-99"},
+		{Tag: m.Tag()},
+	}}
+	var results params.NotifyWatchResults
+	err = s.APIState.Call("Client", "", "WatchAgentPresence", args, &results)
+	c.Assert(err, IsNil)
+	c.Assert(results.Results, HasLen, 3)
+	c.Assert(results.Results[0].Error, ErrorMatches, "invalid request: Tag: must be the tag of a machine or unit")
+	c.Assert(results.Results[1].Error, ErrorMatches, "machine 99 not found")
+	c.Assert(results.Results[2].Error, IsNil)
+
+	w := watcher.NewNotifyWatcher(s.APIState, results.Results[2])
+	wc := statetesting.NewNotifyWatcherC(c, s.State, w)
+	wc.AssertOneChange()
+
+	pinger, err := m.SetAgentAlive()
+	c.Assert(err, IsNil)
+	defer pinger.Stop()
+	wc.AssertOneChange()
+	statetesting.AssertStop(c, w)
+	wc.AssertClosed()
+}
+
+func (s *clientSuite) TestClientMachinesCursor(c *C) {
+	m0, err := s.State.AddMachine("series", state.JobManageEnviron)
+	c.Assert(err, IsNil)
+	err = m0.SetProvisioned("i-0", state.BootstrapNonce, nil)
+	c.Assert(err, IsNil)
+	m1, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	m2, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+
+	cursor, err := s.APIState.Client().MachinesCursor(2)
+	c.Assert(err, IsNil)
+	machines, done, err := cursor.Next()
+	c.Assert(err, IsNil)
+	c.Assert(done, Equals, false)
+	c.Assert(machines, DeepEquals, []params.MachineDetails{
+		{Id: m0.Id(), InstanceId: "i-0", Life: params.Alive},
+		{Id: m1.Id(), Life: params.Alive},
+	})
+	machines, done, err = cursor.Next()
+	c.Assert(err, IsNil)
+	c.Assert(done, Equals, true)
+	c.Assert(machines, DeepEquals, []params.MachineDetails{
+		{Id: m2.Id(), Life: params.Alive},
+	})
+
+	// The cursor is stopped once its last page is read.
+	_, _, err = cursor.Next()
+	c.Assert(err, ErrorMatches, "id not found")
+	c.Assert(params.ErrCode(err), Equals, params.CodeNotFound)
+}
@@ -100,6 +100,18 @@ var operationPermTests = []struct {
 	about: "Client.WatchAll",
 	op:    opClientWatchAll,
 	allow: []string{"user-admin", "user-other"},
+}, {
+	about: "Client.WatchBlocks",
+	op:    opClientWatchBlocks,
+	allow: []string{"user-admin", "user-other"},
+}, {
+	about: "Client.WatchAgentPresence",
+	op:    opClientWatchAgentPresence,
+	allow: []string{"user-admin", "user-other"},
+}, {
+	about: "Client.MachinesCursor",
+	op:    opClientMachinesCursor,
+	allow: []string{"user-admin", "user-other"},
 }, {
 	about: "Client.CharmInfo",
 	op:    opClientCharmInfo,
@@ -112,6 +124,10 @@ var operationPermTests = []struct {
 	about: "Client.DestroyRelation",
 	op:    opClientDestroyRelation,
 	allow: []string{"user-admin", "user-other"},
+}, {
+	about: "Client.LastConnections",
+	op:    opClientLastConnections,
+	allow: []string{"user-admin"},
 }}
 
 // allowed returns the set of allowed entities given an allow list and a
@@ -185,6 +201,11 @@ func opClientDestroyRelation(c *C, st *api.State, mst *state.State) (func(), err
 	return func() {}, err
 }
 
+func opClientLastConnections(c *C, st *api.State, mst *state.State) (func(), error) {
+	_, err := st.Client().LastConnection("user-admin")
+	return func() {}, err
+}
+
 func opClientStatus(c *C, st *api.State, mst *state.State) (func(), error) {
 	status, err := st.Client().Status()
 	if err != nil {
@@ -350,3 +371,31 @@ func opClientWatchAll(c *C, st *api.State, mst *state.State) (func(), error) {
 	}
 	return func() {}, err
 }
+
+func opClientWatchBlocks(c *C, st *api.State, mst *state.State) (func(), error) {
+	var result params.BlocksWatchResult
+	err := st.Call("Client", "", "WatchBlocks", nil, &result)
+	if err == nil {
+		st.Call("NotifyWatcher", result.NotifyWatcherId, "Stop", nil, nil)
+	}
+	return func() {}, err
+}
+
+func opClientWatchAgentPresence(c *C, st *api.State, mst *state.State) (func(), error) {
+	var results params.NotifyWatchResults
+	args := params.Entities{Entities: []params.Entity{{Tag: "machine-0"}}}
+	err := st.Call("Client", "", "WatchAgentPresence", args, &results)
+	if err == nil {
+		c.Assert(results.Results, HasLen, 1)
+		st.Call("NotifyWatcher", results.Results[0].NotifyWatcherId, "Stop", nil, nil)
+	}
+	return func() {}, err
+}
+
+func opClientMachinesCursor(c *C, st *api.State, mst *state.State) (func(), error) {
+	cursor, err := st.Client().MachinesCursor(10)
+	if err == nil {
+		cursor.Stop()
+	}
+	return func() {}, err
+}
@@ -0,0 +1,133 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package common
+
+import (
+	"sync"
+
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/watcher"
+	"launchpad.net/juju-core/utils/set"
+	"launchpad.net/tomb"
+)
+
+// NewAggregateWatcher returns a StringsWatcher that combines the
+// given NotifyWatchers, keyed by the tag of the entity each watches.
+// Each event holds the tags of the entities that have changed since
+// the previous event; the first event holds all the tags, once every
+// watcher has delivered its own initial event. The aggregate watcher
+// takes ownership of the watchers, stopping them when it stops, and
+// dies if any of them does. The watcher's goroutines are started with
+// spawn, so that they can be attributed to the connection that started
+// the watcher; if spawn is nil, they are started with a go statement.
+func NewAggregateWatcher(watchers map[string]state.NotifyWatcher, spawn func(func())) state.StringsWatcher {
+	if spawn == nil {
+		spawn = func(fn func()) { go fn() }
+	}
+	w := &aggregateWatcher{
+		watchers: watchers,
+		spawn:    spawn,
+		in:       make(chan string),
+		out:      make(chan []string),
+	}
+	spawn(func() {
+		defer w.tomb.Done()
+		defer close(w.out)
+		defer w.stopWatchers()
+		w.tomb.Kill(w.loop())
+	})
+	return w
+}
+
+type aggregateWatcher struct {
+	tomb     tomb.Tomb
+	watchers map[string]state.NotifyWatcher
+	spawn    func(func())
+	wg       sync.WaitGroup
+	in       chan string
+	out      chan []string
+}
+
+// Stop stops the watcher, and returns any error encountered while
+// running or shutting down.
+func (w *aggregateWatcher) Stop() error {
+	w.tomb.Kill(nil)
+	return w.tomb.Wait()
+}
+
+// Err returns any error encountered while running or shutting down,
+// or tomb.ErrStillAlive if the watcher is still running.
+func (w *aggregateWatcher) Err() error {
+	return w.tomb.Err()
+}
+
+// Changes returns the event channel for the watcher.
+func (w *aggregateWatcher) Changes() <-chan []string {
+	return w.out
+}
+
+func (w *aggregateWatcher) loop() error {
+	changed := set.NewStrings()
+	for tag, sw := range w.watchers {
+		select {
+		case <-w.tomb.Dying():
+			return tomb.ErrDying
+		case _, ok := <-sw.Changes():
+			if !ok {
+				return watcher.MustErr(sw)
+			}
+		}
+		changed.Add(tag)
+	}
+	for tag, sw := range w.watchers {
+		tag, sw := tag, sw
+		w.wg.Add(1)
+		w.spawn(func() { w.forward(tag, sw) })
+	}
+	out := w.out
+	for {
+		select {
+		case <-w.tomb.Dying():
+			return tomb.ErrDying
+		case tag := <-w.in:
+			changed.Add(tag)
+			out = w.out
+		case out <- changed.SortedValues():
+			changed = set.NewStrings()
+			out = nil
+		}
+	}
+	panic("unreachable")
+}
+
+// forward sends the tag of the entity watched by sw
+// to the main loop whenever sw delivers an event.
+func (w *aggregateWatcher) forward(tag string, sw state.NotifyWatcher) {
+	defer w.wg.Done()
+	for {
+		select {
+		case <-w.tomb.Dying():
+			return
+		case _, ok := <-sw.Changes():
+			if !ok {
+				// A watcher that dies takes the
+				// aggregate watcher down with it.
+				w.tomb.Kill(sw.Err())
+				return
+			}
+		}
+		select {
+		case <-w.tomb.Dying():
+			return
+		case w.in <- tag:
+		}
+	}
+}
+
+func (w *aggregateWatcher) stopWatchers() {
+	for _, sw := range w.watchers {
+		sw.Stop()
+	}
+	w.wg.Wait()
+}
@@ -0,0 +1,108 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package common_test
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	. "launchpad.net/gocheck"
+
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/apiserver/common"
+	coretesting "launchpad.net/juju-core/testing"
+)
+
+type aggregateSuite struct{}
+
+var _ = Suite(&aggregateSuite{})
+
+func nextChanges(c *C, w state.StringsWatcher) []string {
+	select {
+	case changes, ok := <-w.Changes():
+		c.Assert(ok, Equals, true)
+		return changes
+	case <-time.After(coretesting.LongWait):
+		c.Fatalf("timed out waiting for changes")
+	}
+	panic("unreachable")
+}
+
+func (*aggregateSuite) TestAggregateWatcher(c *C) {
+	w0, w1 := newFakeNotifyWatcher(), newFakeNotifyWatcher()
+	w0.changes <- struct{}{}
+	w1.changes <- struct{}{}
+	w := common.NewAggregateWatcher(map[string]state.NotifyWatcher{
+		"machine-0":        w0,
+		"unit-wordpress-0": w1,
+	}, nil)
+	c.Assert(nextChanges(c, w), DeepEquals, []string{"machine-0", "unit-wordpress-0"})
+
+	w1.changes <- struct{}{}
+	c.Assert(nextChanges(c, w), DeepEquals, []string{"unit-wordpress-0"})
+
+	select {
+	case changes := <-w.Changes():
+		c.Fatalf("unexpected changes %q", changes)
+	case <-time.After(coretesting.ShortWait):
+	}
+
+	err := w.Stop()
+	c.Assert(err, IsNil)
+	c.Assert(w0.stopped, Equals, true)
+	c.Assert(w1.stopped, Equals, true)
+	_, ok := <-w.Changes()
+	c.Assert(ok, Equals, false)
+}
+
+func (*aggregateSuite) TestAggregateWatcherDies(c *C) {
+	w0, w1 := newFakeNotifyWatcher(), newFakeNotifyWatcher()
+	w0.changes <- struct{}{}
+	w1.changes <- struct{}{}
+	w := common.NewAggregateWatcher(map[string]state.NotifyWatcher{
+		"machine-0": w0,
+		"machine-1": w1,
+	}, nil)
+	nextChanges(c, w)
+
+	w0.err = fmt.Errorf("watcher died")
+	close(w0.changes)
+	select {
+	case _, ok := <-w.Changes():
+		c.Assert(ok, Equals, false)
+	case <-time.After(coretesting.LongWait):
+		c.Fatalf("timed out waiting for the watcher to die")
+	}
+	c.Assert(w.Err(), ErrorMatches, "watcher died")
+	c.Assert(w1.stopped, Equals, true)
+}
+
+func (*aggregateSuite) TestAggregateWatcherSpawn(c *C) {
+	var wg sync.WaitGroup
+	spawned := 0
+	spawn := func(fn func()) {
+		spawned++
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn()
+		}()
+	}
+	w0, w1 := newFakeNotifyWatcher(), newFakeNotifyWatcher()
+	w0.changes <- struct{}{}
+	w1.changes <- struct{}{}
+	w := common.NewAggregateWatcher(map[string]state.NotifyWatcher{
+		"machine-0": w0,
+		"machine-1": w1,
+	}, spawn)
+	nextChanges(c, w)
+
+	// One goroutine runs the main loop, and one
+	// forwards the events of each watcher.
+	err := w.Stop()
+	c.Assert(err, IsNil)
+	wg.Wait()
+	c.Assert(spawned, Equals, 3)
+}
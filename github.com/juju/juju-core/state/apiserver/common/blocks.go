@@ -0,0 +1,27 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package common
+
+import (
+	"launchpad.net/juju-core/state"
+)
+
+// CheckBlocks returns an *OperationBlockedError carrying the message of
+// the first of the given types of block, in order, that is switched
+// on, or nil if none is. Facade methods call it before performing
+// the operations the blocks prevent.
+func CheckBlocks(st *state.State, types ...state.BlockType) error {
+	blocks, err := st.AllBlocks()
+	if err != nil {
+		return err
+	}
+	for _, t := range types {
+		for _, b := range blocks {
+			if b.Type() == t {
+				return &OperationBlockedError{Message: b.Message()}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package common
+
+import (
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/api/params"
+)
+
+// ControllersWatcher implements a common WatchControllerInfo method
+// for use by the facades of both agents and clients.
+type ControllersWatcher struct {
+	st        *state.State
+	resources *Resources
+}
+
+// NewControllersWatcher returns a new ControllersWatcher. Watchers
+// it starts are registered in resources.
+func NewControllersWatcher(st *state.State, resources *Resources) *ControllersWatcher {
+	return &ControllersWatcher{
+		st:        st,
+		resources: resources,
+	}
+}
+
+// WatchControllerInfo returns the ids of the controller machines,
+// those running the ManageState job, and the id of a NotifyWatcher
+// that fires when the set of controllers changes. The ids are read
+// once the watcher has started, so no change made after they were
+// read can be missed.
+func (cw *ControllersWatcher) WatchControllerInfo() (params.ControllersWatchResult, error) {
+	var result params.ControllersWatchResult
+	id, err := NotifyWatchAndGet(cw.resources, cw.st.WatchStateServers(), func() (err error) {
+		result.MachineIds, err = cw.st.StateServerMachines()
+		return err
+	})
+	if err != nil {
+		return params.ControllersWatchResult{}, err
+	}
+	result.NotifyWatcherId = id
+	return result, nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package common
+
+import (
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Cursor holds the result of a query so that the client can read it
+// a page at a time, instead of receiving it in a single response.
+type Cursor struct {
+	resources *Resources
+	id        string
+	timeout   time.Duration
+
+	mu       sync.Mutex
+	items    reflect.Value
+	pos      int
+	pageSize int
+	lastRead time.Time
+	timer    *time.Timer
+	stopped  bool
+}
+
+// RegisterCursor registers a cursor over items, which must be a
+// slice, in resources, returning its id. Each page read from the
+// cursor holds at most pageSize items. A cursor that has not been
+// read from for the given time is abandoned: it is retired from
+// resources with ErrCursorExpired.
+func RegisterCursor(resources *Resources, items interface{}, pageSize int, timeout time.Duration) string {
+	if pageSize <= 0 {
+		pageSize = 1
+	}
+	c := &Cursor{
+		resources: resources,
+		timeout:   timeout,
+		items:     reflect.ValueOf(items),
+		pageSize:  pageSize,
+		lastRead:  now(),
+	}
+	// Hold the lock so that the timer cannot
+	// fire before the cursor is complete.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.id = resources.Register(c)
+	c.timer = time.AfterFunc(timeout, func() { c.expire() })
+	return c.id
+}
+
+// expire retires the cursor if it has not been read from
+// within its timeout, and otherwise waits to check again.
+func (c *Cursor) expire() {
+	c.mu.Lock()
+	if c.stopped {
+		c.mu.Unlock()
+		return
+	}
+	idle := now().Sub(c.lastRead)
+	if idle < c.timeout {
+		c.timer = time.AfterFunc(c.timeout-idle, func() { c.expire() })
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+	c.resources.Retire(c.id, ErrCursorExpired)
+}
+
+// Next returns the next page of items, as a slice of the type given
+// to RegisterCursor, and whether it is the last page.
+func (c *Cursor) Next() (page interface{}, last bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastRead = now()
+	end := c.pos + c.pageSize
+	if n := c.items.Len(); end >= n {
+		end = n
+		last = true
+	}
+	page = c.items.Slice(c.pos, end).Interface()
+	c.pos = end
+	return page, last
+}
+
+// Stop implements Resource.Stop.
+func (c *Cursor) Stop() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stopped = true
+	c.timer.Stop()
+	return nil
+}
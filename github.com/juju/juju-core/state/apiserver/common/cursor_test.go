@@ -0,0 +1,69 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package common_test
+
+import (
+	. "launchpad.net/gocheck"
+	"launchpad.net/juju-core/state/apiserver/common"
+	"launchpad.net/juju-core/utils"
+	"time"
+)
+
+type cursorSuite struct{}
+
+var _ = Suite(cursorSuite{})
+
+func (cursorSuite) TestNextPages(c *C) {
+	rs := common.NewResources()
+	id := common.RegisterCursor(rs, []string{"a", "b", "c", "d", "e"}, 2, time.Minute)
+	cursor, ok := rs.Get(id).(*common.Cursor)
+	c.Assert(ok, Equals, true)
+
+	page, last := cursor.Next()
+	c.Assert(page, DeepEquals, []string{"a", "b"})
+	c.Assert(last, Equals, false)
+	page, last = cursor.Next()
+	c.Assert(page, DeepEquals, []string{"c", "d"})
+	c.Assert(last, Equals, false)
+	page, last = cursor.Next()
+	c.Assert(page, DeepEquals, []string{"e"})
+	c.Assert(last, Equals, true)
+
+	rs.StopAll()
+	c.Assert(rs.Count(), Equals, 0)
+}
+
+func (cursorSuite) TestEmpty(c *C) {
+	rs := common.NewResources()
+	id := common.RegisterCursor(rs, []int{}, 10, time.Minute)
+	page, last := rs.Get(id).(*common.Cursor).Next()
+	c.Assert(page, DeepEquals, []int{})
+	c.Assert(last, Equals, true)
+	rs.StopAll()
+}
+
+func (cursorSuite) TestExpires(c *C) {
+	rs := common.NewResources()
+	id := common.RegisterCursor(rs, []int{1, 2, 3}, 1, 10*time.Millisecond)
+	attempt := utils.AttemptStrategy{
+		Total: time.Second,
+		Delay: 10 * time.Millisecond,
+	}
+	for a := attempt.Start(); a.Next(); {
+		if rs.Get(id) == nil {
+			break
+		}
+	}
+	c.Assert(rs.Get(id), IsNil)
+	c.Assert(rs.Retired(id), Equals, common.ErrCursorExpired)
+}
+
+func (cursorSuite) TestStopDoesNotExpire(c *C) {
+	rs := common.NewResources()
+	id := common.RegisterCursor(rs, []int{1, 2, 3}, 1, 10*time.Millisecond)
+	err := rs.Stop(id)
+	c.Assert(err, IsNil)
+	time.Sleep(50 * time.Millisecond)
+	c.Assert(rs.Retired(id), IsNil)
+}
@@ -5,9 +5,11 @@ package common
 
 import (
 	stderrors "errors"
+	"fmt"
 	"launchpad.net/juju-core/errors"
 	"launchpad.net/juju-core/state"
 	"launchpad.net/juju-core/state/api/params"
+	"strings"
 )
 
 var (
@@ -20,8 +22,64 @@ var (
 	ErrStoppedWatcher = stderrors.New("watcher has been stopped")
 	ErrBadRequest     = stderrors.New("invalid request")
 	ErrNotProvisioned = stderrors.New("not provisioned")
+
+	ErrLeadershipClaimDenied = stderrors.New("leadership claim denied")
+	ErrTryAgain              = stderrors.New("state is overloaded, try again later")
+	ErrMessageTooLarge       = stderrors.New("message too large")
+	ErrAtCapacity            = stderrors.New("server at capacity")
+	ErrEntityRemoved         = stderrors.New("watched entity has been removed")
+	ErrNotSubscribed         = stderrors.New("not subscribed to agent events")
+	ErrCursorExpired         = stderrors.New("cursor has expired")
+	ErrTimeout               = stderrors.New("timed out")
+	ErrConflict              = stderrors.New("entity has changed")
+	ErrUnsupported           = stderrors.New("unsupported capability")
+	ErrBlocked               = stderrors.New("operation is blocked")
+	ErrConcurrentNext        = stderrors.New("watcher already has a call to Next in progress")
+	ErrWatcherExpired        = stderrors.New("watcher has outlived its maximum lifetime")
 )
 
+// BadRequestError describes an invalid field in the arguments of
+// a request. It is reported with the same code as ErrBadRequest.
+type BadRequestError struct {
+	// Field names the field, such as "Entities[0].Tag".
+	Field string
+
+	// Reason says what is wrong with it.
+	Reason string
+}
+
+func (e *BadRequestError) Error() string {
+	return fmt.Sprintf("%v: %s: %s", ErrBadRequest, e.Field, e.Reason)
+}
+
+// UnsupportedCapabilityError is returned by Login when the server does
+// not support all the capabilities required by the client. It is
+// reported with the same code as ErrUnsupported.
+type UnsupportedCapabilityError struct {
+	// Missing holds the names of the
+	// unsupported capabilities.
+	Missing []string
+}
+
+func (e *UnsupportedCapabilityError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrUnsupported, strings.Join(e.Missing, ", "))
+}
+
+// OperationBlockedError is returned when an operation is prevented by
+// a block placed by an operator. It is reported with the same code
+// as ErrBlocked.
+type OperationBlockedError struct {
+	// Message holds the operator's explanation of the block.
+	Message string
+}
+
+func (e *OperationBlockedError) Error() string {
+	if e.Message == "" {
+		return ErrBlocked.Error()
+	}
+	return fmt.Sprintf("%v: %s", ErrBlocked, e.Message)
+}
+
 var singletonErrorCodes = map[error]string{
 	state.ErrCannotEnterScopeYet: params.CodeCannotEnterScopeYet,
 	state.ErrCannotEnterScope:    params.CodeCannotEnterScope,
@@ -34,6 +92,20 @@ var singletonErrorCodes = map[error]string{
 	ErrUnknownWatcher:            params.CodeNotFound,
 	ErrStoppedWatcher:            params.CodeStopped,
 	ErrNotProvisioned:            params.CodeNotProvisioned,
+	ErrLeadershipClaimDenied:     params.CodeLeadershipClaimDenied,
+	ErrTryAgain:                  params.CodeTryAgain,
+	ErrMessageTooLarge:           params.CodeMessageTooLarge,
+	ErrAtCapacity:                params.CodeTryAgain,
+	ErrEntityRemoved:             params.CodeEntityRemoved,
+	ErrNotSubscribed:             params.CodeNotFound,
+	ErrCursorExpired:             params.CodeNotFound,
+	ErrTimeout:                   params.CodeTimeout,
+	ErrConflict:                  params.CodeConflict,
+	ErrBadRequest:                params.CodeBadRequest,
+	ErrUnsupported:               params.CodeUnsupportedCapability,
+	ErrBlocked:                   params.CodeOperationBlocked,
+	ErrConcurrentNext:            params.CodeConcurrentNext,
+	ErrWatcherExpired:            params.CodeWatcherExpired,
 }
 
 // ServerError returns an error suitable for returning to an API
@@ -54,6 +126,12 @@ func ServerError(err error) *params.Error {
 		code = params.CodeNotAssigned
 	case state.IsHasAssignedUnitsError(err):
 		code = params.CodeHasAssignedUnits
+	case isBadRequestError(err):
+		code = params.CodeBadRequest
+	case isUnsupportedCapability(err):
+		code = params.CodeUnsupportedCapability
+	case isOperationBlocked(err):
+		code = params.CodeOperationBlocked
 	default:
 		code = params.ErrCode(err)
 	}
@@ -62,3 +140,18 @@ func ServerError(err error) *params.Error {
 		Code:    code,
 	}
 }
+
+func isBadRequestError(err error) bool {
+	_, ok := err.(*BadRequestError)
+	return ok
+}
+
+func isUnsupportedCapability(err error) bool {
+	_, ok := err.(*UnsupportedCapabilityError)
+	return ok
+}
+
+func isOperationBlocked(err error) bool {
+	_, ok := err.(*OperationBlockedError)
+	return ok
+}
@@ -0,0 +1,29 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package common
+
+import (
+	"time"
+)
+
+// PatchMaxRetired sets the number of retired resources whose
+// reasons are remembered, returning a function that restores
+// the original.
+func PatchMaxRetired(n int) (restore func()) {
+	old := maxRetired
+	maxRetired = n
+	return func() {
+		maxRetired = old
+	}
+}
+
+// PatchNow sets the clock used to timestamp resources,
+// returning a function that restores the original.
+func PatchNow(f func() time.Time) (restore func()) {
+	old := now
+	now = f
+	return func() {
+		now = old
+	}
+}
@@ -3,6 +3,10 @@
 
 package common
 
+import (
+	"io"
+)
+
 // AuthFunc returns whether the given entity is available to some operation.
 type AuthFunc func(tag string) bool
 
@@ -18,6 +22,10 @@ type Authorizer interface {
 	// machine agent.
 	AuthMachineAgent() bool
 
+	// AuthUnitAgent returns whether the authenticated entity is a
+	// unit agent.
+	AuthUnitAgent() bool
+
 	// AuthOwner returns whether the authenticated entity is the same
 	// as the given entity.
 	AuthOwner(tag string) bool
@@ -26,6 +34,10 @@ type Authorizer interface {
 	// a machine running the environment manager job.
 	AuthEnvironManager() bool
 
+	// AuthController returns whether the authenticated entity is
+	// a machine running the state server (controller) job.
+	AuthController() bool
+
 	// AuthClient returns whether the authenticated entity
 	// is a client user.
 	AuthClient() bool
@@ -33,3 +45,26 @@ type Authorizer interface {
 	// GetAuthTag returns the tag of the authenticated entity.
 	GetAuthTag() string
 }
+
+// MethodAuthorizer is implemented by facades whose methods check the
+// entities they are given other than with Authorizer.AuthOwner.
+type MethodAuthorizer interface {
+	// MethodAuthFunc returns the AuthFunc the named method
+	// uses to check the entities it is given.
+	MethodAuthFunc(method string) (AuthFunc, error)
+}
+
+// BlobTransferer is implemented by an API server, alongside
+// Authorizer, to let an API implementation transfer large opaque
+// data to and from the client without passing it through the RPC
+// connection. Each method returns a token that the API
+// implementation hands to the client to make the transfer.
+type BlobTransferer interface {
+	// OfferBlob makes the size bytes read from src
+	// available for the client to download.
+	OfferBlob(src io.ReadCloser, size int64) (token string, err error)
+
+	// AcceptBlob lets the client upload at most max bytes,
+	// which are passed to store as they are read.
+	AcceptBlob(max int64, store func(io.Reader) error) (token string, err error)
+}
@@ -0,0 +1,188 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package common
+
+import (
+	"sync"
+
+	"launchpad.net/juju-core/errors"
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/watcher"
+	"launchpad.net/juju-core/utils/set"
+	"launchpad.net/tomb"
+)
+
+// NewMembershipWatcher returns a StringsWatcher that tracks a set of
+// entities whose membership is reported by the given StringsWatcher,
+// as it might be for the units of a service. The ids it reports are
+// turned into entity tags with toTag, and each member is watched with
+// the NotifyWatcher returned by watch. Each event holds the tags of
+// the members that have joined, left or changed since the previous
+// event; the first holds the tags of all the members. Members for
+// which watch returns a not found error are taken to have been
+// removed. The membership watcher takes ownership of members,
+// stopping it when it stops. Its goroutines are started with spawn,
+// as for NewAggregateWatcher.
+func NewMembershipWatcher(
+	members state.StringsWatcher,
+	toTag func(id string) string,
+	watch func(tag string) (state.NotifyWatcher, error),
+	spawn func(func()),
+) state.StringsWatcher {
+	if spawn == nil {
+		spawn = func(fn func()) { go fn() }
+	}
+	w := &membershipWatcher{
+		members:  members,
+		toTag:    toTag,
+		watch:    watch,
+		spawn:    spawn,
+		watchers: make(map[string]*memberWatcher),
+		in:       make(chan string),
+		out:      make(chan []string),
+	}
+	spawn(func() {
+		defer w.tomb.Done()
+		defer close(w.out)
+		defer w.stopWatchers()
+		w.tomb.Kill(w.loop())
+	})
+	return w
+}
+
+type membershipWatcher struct {
+	tomb     tomb.Tomb
+	members  state.StringsWatcher
+	toTag    func(id string) string
+	watch    func(tag string) (state.NotifyWatcher, error)
+	spawn    func(func())
+	watchers map[string]*memberWatcher
+	wg       sync.WaitGroup
+	in       chan string
+	out      chan []string
+}
+
+// memberWatcher watches a single member.
+type memberWatcher struct {
+	w    state.NotifyWatcher
+	stop chan struct{}
+}
+
+// Stop stops the watcher, and returns any error encountered while
+// running or shutting down.
+func (w *membershipWatcher) Stop() error {
+	w.tomb.Kill(nil)
+	return w.tomb.Wait()
+}
+
+// Err returns any error encountered while running or shutting down,
+// or tomb.ErrStillAlive if the watcher is still running.
+func (w *membershipWatcher) Err() error {
+	return w.tomb.Err()
+}
+
+// Changes returns the event channel for the watcher.
+func (w *membershipWatcher) Changes() <-chan []string {
+	return w.out
+}
+
+func (w *membershipWatcher) loop() error {
+	changed := set.NewStrings()
+	var out chan []string
+	for {
+		select {
+		case <-w.tomb.Dying():
+			return tomb.ErrDying
+		case ids, ok := <-w.members.Changes():
+			if !ok {
+				return watcher.MustErr(w.members)
+			}
+			for _, id := range ids {
+				tag := w.toTag(id)
+				if err := w.rewatch(tag); err != nil {
+					return err
+				}
+				changed.Add(tag)
+			}
+			out = w.out
+		case tag := <-w.in:
+			changed.Add(tag)
+			out = w.out
+		case out <- changed.SortedValues():
+			changed = set.NewStrings()
+			out = nil
+		}
+	}
+	panic("unreachable")
+}
+
+// rewatch replaces any watcher of the member with the given tag,
+// whose membership has changed, with a new one, or forgets the
+// member if it has been removed.
+func (w *membershipWatcher) rewatch(tag string) error {
+	if m := w.watchers[tag]; m != nil {
+		delete(w.watchers, tag)
+		close(m.stop)
+		m.w.Stop()
+	}
+	nw, err := w.watch(tag)
+	if errors.IsNotFoundError(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	m := &memberWatcher{w: nw, stop: make(chan struct{})}
+	w.watchers[tag] = m
+	w.wg.Add(1)
+	w.spawn(func() { w.forward(tag, m) })
+	return nil
+}
+
+// forward sends the tag of the member watched by m to the main loop
+// whenever m delivers an event after its initial one, which is
+// covered by the membership change that started it.
+func (w *membershipWatcher) forward(tag string, m *memberWatcher) {
+	defer w.wg.Done()
+	initial := true
+	for {
+		select {
+		case <-w.tomb.Dying():
+			return
+		case <-m.stop:
+			return
+		case _, ok := <-m.w.Changes():
+			if !ok {
+				// A member watcher that dies, unless it has
+				// been stopped, takes the membership watcher
+				// down with it.
+				select {
+				case <-m.stop:
+				default:
+					w.tomb.Kill(m.w.Err())
+				}
+				return
+			}
+		}
+		if initial {
+			initial = false
+			continue
+		}
+		select {
+		case <-w.tomb.Dying():
+			return
+		case <-m.stop:
+			return
+		case w.in <- tag:
+		}
+	}
+}
+
+func (w *membershipWatcher) stopWatchers() {
+	for _, m := range w.watchers {
+		close(m.stop)
+		m.w.Stop()
+	}
+	w.members.Stop()
+	w.wg.Wait()
+}
@@ -0,0 +1,86 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package common_test
+
+import (
+	"sync"
+	"time"
+
+	. "launchpad.net/gocheck"
+	"launchpad.net/tomb"
+
+	"launchpad.net/juju-core/errors"
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/apiserver/common"
+	coretesting "launchpad.net/juju-core/testing"
+)
+
+type membershipSuite struct{}
+
+var _ = Suite(&membershipSuite{})
+
+type fakeStringsWatcher struct {
+	changes chan []string
+	stopped bool
+}
+
+func (w *fakeStringsWatcher) Stop() error {
+	w.stopped = true
+	return nil
+}
+
+func (w *fakeStringsWatcher) Err() error {
+	return tomb.ErrStillAlive
+}
+
+func (w *fakeStringsWatcher) Changes() <-chan []string {
+	return w.changes
+}
+
+func (*membershipSuite) TestMembershipWatcher(c *C) {
+	members := &fakeStringsWatcher{changes: make(chan []string, 1)}
+	var mu sync.Mutex
+	watchers := make(map[string]*fakeNotifyWatcher)
+	watch := func(tag string) (state.NotifyWatcher, error) {
+		if tag == "unit-foo-2" {
+			return nil, errors.NotFoundf("unit foo/2")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		w := newFakeNotifyWatcher()
+		w.changes <- struct{}{}
+		watchers[tag] = w
+		return w, nil
+	}
+	w := common.NewMembershipWatcher(members, state.UnitTag, watch, nil)
+
+	// The initial event holds all the members.
+	members.changes <- []string{"foo/0", "foo/1"}
+	c.Assert(nextChanges(c, w), DeepEquals, []string{"unit-foo-0", "unit-foo-1"})
+
+	// A change to a member is reported.
+	mu.Lock()
+	w0 := watchers["unit-foo-0"]
+	mu.Unlock()
+	w0.changes <- struct{}{}
+	c.Assert(nextChanges(c, w), DeepEquals, []string{"unit-foo-0"})
+
+	// So is a change to the membership, including
+	// that of a member that has been removed.
+	members.changes <- []string{"foo/1", "foo/2"}
+	c.Assert(nextChanges(c, w), DeepEquals, []string{"unit-foo-1", "unit-foo-2"})
+
+	select {
+	case changes := <-w.Changes():
+		c.Fatalf("unexpected changes %q", changes)
+	case <-time.After(coretesting.ShortWait):
+	}
+
+	err := w.Stop()
+	c.Assert(err, IsNil)
+	c.Assert(members.stopped, Equals, true)
+	for tag, mw := range watchers {
+		c.Check(mw.stopped, Equals, true, Commentf("%s", tag))
+	}
+}
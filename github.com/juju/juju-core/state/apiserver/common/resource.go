@@ -5,10 +5,21 @@ package common
 
 import (
 	"launchpad.net/juju-core/log"
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/multiwatcher"
+	"sort"
 	"strconv"
 	"sync"
+	"time"
 )
 
+// now is the clock used to timestamp resource registration.
+var now = time.Now
+
+// maxRetired bounds the number of retired resources whose reasons
+// are remembered; the reasons for the oldest are forgotten first.
+var maxRetired = 100
+
 // Resource represents any resource that should be cleaned up when an
 // API connection terminates. The Stop method will be called when
 // that happens.
@@ -22,35 +33,164 @@ type Resource interface {
 type Resources struct {
 	mu        sync.Mutex
 	maxId     uint64
-	resources map[string]Resource
+	resources map[string]*resourceEntry
+
+	// retired holds the reason each retired
+	// resource went away, keyed by its id, for
+	// the ids held in retiredIds, oldest first.
+	retired    map[string]error
+	retiredIds []string
+
+	// setupTimeout bounds the time watchers may take to
+	// produce their initial events; see InitialNotifyEvent.
+	setupTimeout time.Duration
+}
+
+// resourceEntry holds a registered resource along with
+// information about its registration.
+type resourceEntry struct {
+	resource   Resource
+	registered time.Time
+
+	// tag holds the tag of the entity the resource
+	// is concerned with, if any.
+	tag string
+
+	// kind holds the kind of the resource,
+	// as returned by ResourceKind.
+	kind string
+}
+
+// ResourceKind returns the type of watcher by which r is
+// served to clients, such as "NotifyWatcher", or "" if r
+// is not a watcher.
+func ResourceKind(r Resource) string {
+	switch r.(type) {
+	case state.NotifyWatcher:
+		return "NotifyWatcher"
+	case state.StringsWatcher:
+		return "StringsWatcher"
+	case *RelationUnitsWatcher:
+		return "RelationUnitsWatcher"
+	case *multiwatcher.Watcher:
+		return "AllWatcher"
+	}
+	return ""
 }
 
 func NewResources() *Resources {
 	return &Resources{
-		resources: make(map[string]Resource),
+		resources: make(map[string]*resourceEntry),
+		retired:   make(map[string]error),
 	}
 }
 
+// SetSetupTimeout sets the time watchers may take to produce their
+// initial events before they are registered in rs. A zero timeout,
+// the default, lets them take as long as they need.
+func (rs *Resources) SetSetupTimeout(timeout time.Duration) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.setupTimeout = timeout
+}
+
+// setupDeadline returns a channel that receives a value when the
+// setup timeout has passed, or nil if there is no timeout.
+func (rs *Resources) setupDeadline() <-chan time.Time {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.setupTimeout <= 0 {
+		return nil
+	}
+	return time.After(rs.setupTimeout)
+}
+
 // Get returns the resource for the given id, or
 // nil if there is no such resource.
 func (rs *Resources) Get(id string) Resource {
 	rs.mu.Lock()
 	defer rs.mu.Unlock()
-	return rs.resources[id]
+	if e := rs.resources[id]; e != nil {
+		return e.resource
+	}
+	return nil
 }
 
 // Register registers the given resource. It returns a unique
 // identifier for the resource which can then be used in
 // subsequent API requests to refer to the resource.
 func (rs *Resources) Register(r Resource) string {
+	return rs.RegisterFor(r, "")
+}
+
+// RegisterFor is like Register but also records the tag of the
+// entity the resource is concerned with, such as the entity
+// watched by a watcher, so that it can be found with Find.
+func (rs *Resources) RegisterFor(r Resource, tag string) string {
 	rs.mu.Lock()
 	defer rs.mu.Unlock()
 	rs.maxId++
 	id := strconv.FormatUint(rs.maxId, 10)
-	rs.resources[id] = r
+	rs.resources[id] = &resourceEntry{
+		resource:   r,
+		registered: now(),
+		tag:        tag,
+		kind:       ResourceKind(r),
+	}
 	return id
 }
 
+// Tag returns the tag recorded when the resource with the given id
+// was registered, or the empty string if there is no such resource or
+// it was registered without a tag.
+func (rs *Resources) Tag(id string) string {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if e := rs.resources[id]; e != nil {
+		return e.tag
+	}
+	return ""
+}
+
+// Registered returns the time at which the resource with the given
+// id was registered, or the zero time if there is no such resource.
+func (rs *Resources) Registered(id string) time.Time {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if e := rs.resources[id]; e != nil {
+		return e.registered
+	}
+	return time.Time{}
+}
+
+// Find returns the ids, in order of registration, of all the
+// resources registered for the entity with the given tag.
+func (rs *Resources) Find(tag string) []string {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	var ids resourceIds
+	for id, e := range rs.resources {
+		if tag != "" && e.tag == tag {
+			ids = append(ids, id)
+		}
+	}
+	sort.Sort(ids)
+	return ids
+}
+
+// resourceIds sorts resource ids numerically,
+// and hence in order of registration.
+type resourceIds []string
+
+func (ids resourceIds) Len() int      { return len(ids) }
+func (ids resourceIds) Swap(i, j int) { ids[i], ids[j] = ids[j], ids[i] }
+func (ids resourceIds) Less(i, j int) bool {
+	if len(ids[i]) != len(ids[j]) {
+		return len(ids[i]) < len(ids[j])
+	}
+	return ids[i] < ids[j]
+}
+
 // Stop stops the resource with the given id and unregisters it.
 // It returns any error from the underlying Stop call.
 // It does not return an error if the resource has already
@@ -73,16 +213,126 @@ func (rs *Resources) Stop(id string) error {
 	return err
 }
 
+// Detach unregisters the resource with the given id without stopping
+// it, so that it may be registered anew, and returns it along with
+// the tag it was registered for. It returns a nil resource if there
+// is no resource with the given id.
+func (rs *Resources) Detach(id string) (Resource, string) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	e := rs.resources[id]
+	if e == nil {
+		return nil, ""
+	}
+	delete(rs.resources, id)
+	return e.resource, e.tag
+}
+
+// StopKind stops and unregisters all the resources of the given
+// kind, as returned by ResourceKind, and returns their ids, in order
+// of registration. Any errors from their Stop calls are logged.
+// Unknown kinds match no resources.
+func (rs *Resources) StopKind(kind string) []string {
+	if kind == "" {
+		return nil
+	}
+	rs.mu.Lock()
+	var ids resourceIds
+	for id, e := range rs.resources {
+		if e.kind == kind {
+			ids = append(ids, id)
+		}
+	}
+	rs.mu.Unlock()
+	sort.Sort(ids)
+	for _, id := range ids {
+		r := rs.Get(id)
+		if err := rs.Stop(id); err != nil {
+			log.Errorf("state/api: error stopping %T resource: %v", r, err)
+		}
+	}
+	return ids
+}
+
+// Retire is like Stop, but also records err as the reason the
+// resource went away, to be returned by Retired. Only the reasons
+// for the most recently retired resources are remembered.
+func (rs *Resources) Retire(id string, err error) error {
+	stopErr := rs.Stop(id)
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if _, ok := rs.retired[id]; !ok {
+		if len(rs.retiredIds) >= maxRetired {
+			delete(rs.retired, rs.retiredIds[0])
+			rs.retiredIds = rs.retiredIds[1:]
+		}
+		rs.retiredIds = append(rs.retiredIds, id)
+	}
+	rs.retired[id] = err
+	return stopErr
+}
+
+// Retired returns the reason given when the resource with the given
+// id was retired, or nil if it has not been.
+func (rs *Resources) Retired(id string) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.retired[id]
+}
+
 // StopAll stops all the resources.
 func (rs *Resources) StopAll() {
 	rs.mu.Lock()
 	defer rs.mu.Unlock()
-	for _, r := range rs.resources {
-		if err := r.Stop(); err != nil {
-			log.Errorf("state/api: error stopping %T resource: %v", r, err)
+	for _, e := range rs.resources {
+		if err := e.resource.Stop(); err != nil {
+			log.Errorf("state/api: error stopping %T resource: %v", e.resource, err)
+		}
+	}
+	rs.resources = make(map[string]*resourceEntry)
+	rs.retired = make(map[string]error)
+	rs.retiredIds = nil
+}
+
+// StopAllWithTimeout stops all the resources, like StopAll, but
+// stops them concurrently and waits at most the given time for them
+// to do so. Any resource that has not stopped in time is logged and
+// left to finish stopping in the background, so that a single stuck
+// resource cannot hold up the teardown of a connection.
+func (rs *Resources) StopAllWithTimeout(timeout time.Duration) {
+	rs.mu.Lock()
+	resources := rs.resources
+	rs.resources = make(map[string]*resourceEntry)
+	rs.retired = make(map[string]error)
+	rs.retiredIds = nil
+	rs.mu.Unlock()
+
+	type stopped struct {
+		id  string
+		err error
+	}
+	done := make(chan stopped, len(resources))
+	for id, e := range resources {
+		go func(id string, r Resource) {
+			done <- stopped{id, r.Stop()}
+		}(id, e.resource)
+	}
+	deadline := time.After(timeout)
+	for remaining := len(resources); remaining > 0; remaining-- {
+		select {
+		case s := <-done:
+			if s.err != nil {
+				r := resources[s.id].resource
+				log.Errorf("state/api: error stopping %T resource: %v", r, s.err)
+			}
+			delete(resources, s.id)
+		case <-deadline:
+			for id, e := range resources {
+				log.Errorf("state/api: %T resource %s did not stop within %v", e.resource, id, timeout)
+			}
+			return
 		}
 	}
-	rs.resources = make(map[string]Resource)
 }
 
 // Count returns the number of resources currently held.
@@ -91,3 +341,83 @@ func (rs *Resources) Count() int {
 	defer rs.mu.Unlock()
 	return len(rs.resources)
 }
+
+// All returns the registered resources, in
+// no particular order.
+func (rs *Resources) All() []Resource {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	all := make([]Resource, 0, len(rs.resources))
+	for _, e := range rs.resources {
+		all = append(all, e.resource)
+	}
+	return all
+}
+
+// ResourceInfo describes a registered resource.
+type ResourceInfo struct {
+	Id       string
+	Resource Resource
+
+	// Tag holds the tag recorded by RegisterFor, if any.
+	Tag string
+
+	// Kind holds the kind of the resource,
+	// as returned by ResourceKind.
+	Kind string
+}
+
+// Entries describes the registered resources,
+// in order of registration.
+func (rs *Resources) Entries() []ResourceInfo {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	ids := make(resourceIds, 0, len(rs.resources))
+	for id := range rs.resources {
+		ids = append(ids, id)
+	}
+	sort.Sort(ids)
+	entries := make([]ResourceInfo, len(ids))
+	for i, id := range ids {
+		e := rs.resources[id]
+		entries[i] = ResourceInfo{
+			Id:       id,
+			Resource: e.resource,
+			Tag:      e.tag,
+			Kind:     e.kind,
+		}
+	}
+	return entries
+}
+
+// AgeBucket counts the resources that have been registered for less
+// than MaxAge, but no less than the MaxAge of the previous bucket, if
+// any. A zero MaxAge counts all resources older than the previous
+// bucket's.
+type AgeBucket struct {
+	MaxAge time.Duration
+	Count  int
+}
+
+// AgeDistribution returns the number of resources registered for
+// each range of time delimited by the given bounds, which must be in
+// increasing order. The final bucket counts the resources older than
+// the last bound.
+func (rs *Resources) AgeDistribution(bounds ...time.Duration) []AgeBucket {
+	buckets := make([]AgeBucket, len(bounds)+1)
+	for i, bound := range bounds {
+		buckets[i].MaxAge = bound
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	t := now()
+	for _, e := range rs.resources {
+		age := t.Sub(e.registered)
+		i := 0
+		for i < len(bounds) && age >= bounds[i] {
+			i++
+		}
+		buckets[i].Count++
+	}
+	return buckets
+}
@@ -1,9 +1,12 @@
 package common_test
 
 import (
+	"errors"
+	"fmt"
 	. "launchpad.net/gocheck"
 	"launchpad.net/juju-core/state/apiserver/common"
 	"sync"
+	"time"
 )
 
 type resourceSuite struct{}
@@ -95,3 +98,174 @@ func (resourceSuite) TestStopAll(c *C) {
 
 	c.Assert(rs.Count(), Equals, 0)
 }
+
+func (resourceSuite) TestAgeDistribution(c *C) {
+	t := time.Now()
+	restore := common.PatchNow(func() time.Time { return t })
+	defer restore()
+	rs := common.NewResources()
+	rs.Register(&fakeResource{})
+	t = t.Add(2 * time.Minute)
+	rs.Register(&fakeResource{})
+	rs.Register(&fakeResource{})
+	t = t.Add(2 * time.Hour)
+	rs.Register(&fakeResource{})
+	t = t.Add(30 * time.Second)
+
+	buckets := rs.AgeDistribution(time.Minute, 5*time.Minute, time.Hour)
+	c.Assert(buckets, DeepEquals, []common.AgeBucket{
+		{MaxAge: time.Minute, Count: 1},
+		{MaxAge: 5 * time.Minute, Count: 0},
+		{MaxAge: time.Hour, Count: 0},
+		{MaxAge: 0, Count: 3},
+	})
+}
+
+func (resourceSuite) TestFind(c *C) {
+	rs := common.NewResources()
+	var ids []string
+	for i := 0; i < 12; i++ {
+		ids = append(ids, rs.RegisterFor(&fakeResource{}, "machine-0"))
+		rs.RegisterFor(&fakeResource{}, "machine-1")
+	}
+	rs.Register(&fakeResource{})
+	c.Assert(rs.Find("machine-0"), DeepEquals, ids)
+	c.Assert(rs.Find("machine-2"), HasLen, 0)
+	c.Assert(rs.Find(""), HasLen, 0)
+
+	err := rs.Stop(ids[1])
+	c.Assert(err, IsNil)
+	remaining := append([]string{ids[0]}, ids[2:]...)
+	c.Assert(rs.Find("machine-0"), DeepEquals, remaining)
+}
+
+func (resourceSuite) TestDetach(c *C) {
+	rs := common.NewResources()
+	r := &fakeResource{}
+	id := rs.RegisterFor(r, "machine-0")
+
+	detached, tag := rs.Detach(id)
+	c.Assert(detached, Equals, r)
+	c.Assert(tag, Equals, "machine-0")
+	c.Assert(r.stopped, Equals, false)
+	c.Assert(rs.Get(id), IsNil)
+	c.Assert(rs.Count(), Equals, 0)
+
+	detached, tag = rs.Detach(id)
+	c.Assert(detached, IsNil)
+	c.Assert(tag, Equals, "")
+}
+
+func (resourceSuite) TestRetire(c *C) {
+	rs := common.NewResources()
+	r := &fakeResource{}
+	id := rs.Register(r)
+	c.Assert(rs.Retired(id), IsNil)
+
+	reason := errors.New("gone")
+	err := rs.Retire(id, reason)
+	c.Assert(err, IsNil)
+	c.Assert(r.stopped, Equals, true)
+	c.Assert(rs.Get(id), IsNil)
+	c.Assert(rs.Retired(id), Equals, reason)
+
+	rs.StopAll()
+	c.Assert(rs.Retired(id), IsNil)
+}
+
+func (resourceSuite) TestRetiredBounded(c *C) {
+	defer common.PatchMaxRetired(2)()
+	rs := common.NewResources()
+	reason := errors.New("gone")
+	var ids []string
+	for i := 0; i < 3; i++ {
+		id := rs.Register(&fakeResource{})
+		err := rs.Retire(id, reason)
+		c.Assert(err, IsNil)
+		ids = append(ids, id)
+	}
+
+	// The reason for the oldest is forgotten.
+	c.Assert(rs.Retired(ids[0]), IsNil)
+	c.Assert(rs.Retired(ids[1]), Equals, reason)
+	c.Assert(rs.Retired(ids[2]), Equals, reason)
+}
+
+func (resourceSuite) TestStopKind(c *C) {
+	rs := common.NewResources()
+	w1 := newFakeNotifyWatcher()
+	w2 := newFakeNotifyWatcher()
+	r := &fakeResource{}
+	rs.Register(w1)
+	rs.Register(r)
+	rs.Register(w2)
+	c.Assert(common.ResourceKind(w1), Equals, "NotifyWatcher")
+	c.Assert(common.ResourceKind(r), Equals, "")
+
+	c.Assert(rs.StopKind("StringsWatcher"), HasLen, 0)
+	c.Assert(rs.StopKind("NoSuchWatcher"), HasLen, 0)
+	c.Assert(rs.StopKind(""), HasLen, 0)
+	c.Assert(rs.Count(), Equals, 3)
+
+	c.Assert(rs.StopKind("NotifyWatcher"), DeepEquals, []string{"1", "3"})
+	c.Assert(w1.stopped, Equals, true)
+	c.Assert(w2.stopped, Equals, true)
+	c.Assert(r.stopped, Equals, false)
+	c.Assert(rs.All(), DeepEquals, []common.Resource{r})
+}
+
+func (resourceSuite) TestEntries(c *C) {
+	rs := common.NewResources()
+	c.Assert(rs.Entries(), HasLen, 0)
+	var ws []*fakeNotifyWatcher
+	for i := 0; i < 11; i++ {
+		w := newFakeNotifyWatcher()
+		ws = append(ws, w)
+		rs.RegisterFor(w, "machine-0")
+	}
+	r := &fakeResource{}
+	rs.Register(r)
+
+	entries := rs.Entries()
+	c.Assert(entries, HasLen, 12)
+	for i, w := range ws {
+		c.Assert(entries[i], DeepEquals, common.ResourceInfo{
+			Id:       fmt.Sprint(i + 1),
+			Resource: w,
+			Tag:      "machine-0",
+			Kind:     "NotifyWatcher",
+		})
+	}
+	c.Assert(entries[11], DeepEquals, common.ResourceInfo{Id: "12", Resource: r})
+}
+
+type blockingResource struct {
+	unblock chan struct{}
+}
+
+func (r *blockingResource) Stop() error {
+	<-r.unblock
+	return nil
+}
+
+func (resourceSuite) TestStopAllWithTimeout(c *C) {
+	rs := common.NewResources()
+	r1 := &fakeResource{}
+	rs.Register(r1)
+	r2 := &blockingResource{make(chan struct{})}
+	defer close(r2.unblock)
+	rs.Register(r2)
+
+	done := make(chan struct{})
+	go func() {
+		rs.StopAllWithTimeout(100 * time.Millisecond)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		c.Fatalf("StopAllWithTimeout did not return")
+	}
+	c.Assert(r1.stopped, Equals, true)
+	c.Assert(rs.Count(), Equals, 0)
+}
@@ -0,0 +1,90 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package common
+
+import (
+	"sync"
+
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/watcher"
+)
+
+// NotifyWatchAndGet consumes the initial event from the given watcher
+// and only then calls get to read whatever state the watcher is
+// tracking. Because the watcher is already established when get is
+// called, any change made after the read is guaranteed to produce a
+// further event, so no change can be lost between the read and the
+// watch. On success the watcher is registered in resources and its id
+// is returned; otherwise the watcher is stopped.
+func NotifyWatchAndGet(resources *Resources, w state.NotifyWatcher, get func() error) (string, error) {
+	if err := InitialNotifyEvent(resources, w); err != nil {
+		return "", err
+	}
+	if err := get(); err != nil {
+		w.Stop()
+		return "", err
+	}
+	return resources.Register(w), nil
+}
+
+// InitialNotifyEvent consumes the initial event from the given
+// watcher, which is about to be registered in resources. If the
+// watcher does not produce the event within the setup timeout of
+// resources, it is stopped and ErrTimeout is returned.
+func InitialNotifyEvent(resources *Resources, w state.NotifyWatcher) error {
+	select {
+	case _, ok := <-w.Changes():
+		if !ok {
+			return watcher.MustErr(w)
+		}
+		return nil
+	case <-resources.setupDeadline():
+		w.Stop()
+		return ErrTimeout
+	}
+	panic("unreachable")
+}
+
+// InitialStringsEvent is like InitialNotifyEvent, but returns
+// the changes held in the initial event from a StringsWatcher.
+func InitialStringsEvent(resources *Resources, w state.StringsWatcher) ([]string, error) {
+	select {
+	case changes, ok := <-w.Changes():
+		if !ok {
+			return nil, watcher.MustErr(w)
+		}
+		return changes, nil
+	case <-resources.setupDeadline():
+		w.Stop()
+		return nil, ErrTimeout
+	}
+	panic("unreachable")
+}
+
+// RelationUnitsWatcher wraps a state.RelationUnitsWatcher so that the
+// event holding the initial state of the relation can be told apart
+// from later changes. Facades register it in place of the state
+// watcher, leaving the initial event to be returned by the first call
+// to Next on the watcher.
+type RelationUnitsWatcher struct {
+	*state.RelationUnitsWatcher
+
+	mu        sync.Mutex
+	seenFirst bool
+}
+
+// NewRelationUnitsWatcher returns a RelationUnitsWatcher wrapping w.
+func NewRelationUnitsWatcher(w *state.RelationUnitsWatcher) *RelationUnitsWatcher {
+	return &RelationUnitsWatcher{RelationUnitsWatcher: w}
+}
+
+// TakeInitial reports whether the initial event has yet to be
+// received from the watcher, marking it as received.
+func (w *RelationUnitsWatcher) TakeInitial() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	initial := !w.seenFirst
+	w.seenFirst = true
+	return initial
+}
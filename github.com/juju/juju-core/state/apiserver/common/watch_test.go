@@ -0,0 +1,136 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package common_test
+
+import (
+	"fmt"
+	"time"
+
+	. "launchpad.net/gocheck"
+	"launchpad.net/tomb"
+
+	"launchpad.net/juju-core/state/apiserver/common"
+	coretesting "launchpad.net/juju-core/testing"
+)
+
+type watchSuite struct{}
+
+var _ = Suite(&watchSuite{})
+
+func (*watchSuite) TestNotifyWatchAndGet(c *C) {
+	rs := common.NewResources()
+	w := newFakeNotifyWatcher()
+	w.changes <- struct{}{}
+
+	value := "initial"
+	var snapshot string
+	id, err := common.NotifyWatchAndGet(rs, w, func() error {
+		// The initial event must have been consumed before
+		// the state is read.
+		c.Check(w.changes, HasLen, 0)
+		snapshot = value
+		// Mutate the state between the read and the return of
+		// the watcher id; the watcher must see the change.
+		value = "changed"
+		w.changes <- struct{}{}
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(snapshot, Equals, "initial")
+	c.Assert(rs.Get(id), Equals, w)
+	c.Assert(w.changes, HasLen, 1)
+	c.Assert(w.stopped, Equals, false)
+}
+
+func (*watchSuite) TestNotifyWatchAndGetError(c *C) {
+	rs := common.NewResources()
+	w := newFakeNotifyWatcher()
+	w.changes <- struct{}{}
+	id, err := common.NotifyWatchAndGet(rs, w, func() error {
+		return fmt.Errorf("splat")
+	})
+	c.Assert(err, ErrorMatches, "splat")
+	c.Assert(id, Equals, "")
+	c.Assert(w.stopped, Equals, true)
+	c.Assert(rs.Count(), Equals, 0)
+}
+
+func (*watchSuite) TestNotifyWatchAndGetWatcherDied(c *C) {
+	rs := common.NewResources()
+	w := newFakeNotifyWatcher()
+	w.err = fmt.Errorf("watcher died")
+	close(w.changes)
+	called := false
+	_, err := common.NotifyWatchAndGet(rs, w, func() error {
+		called = true
+		return nil
+	})
+	c.Assert(err, ErrorMatches, "watcher died")
+	c.Assert(called, Equals, false)
+	c.Assert(rs.Count(), Equals, 0)
+}
+
+func (*watchSuite) TestInitialNotifyEvent(c *C) {
+	rs := common.NewResources()
+	rs.SetSetupTimeout(coretesting.LongWait)
+	w := newFakeNotifyWatcher()
+	w.changes <- struct{}{}
+	err := common.InitialNotifyEvent(rs, w)
+	c.Assert(err, IsNil)
+	c.Assert(w.changes, HasLen, 0)
+	c.Assert(w.stopped, Equals, false)
+}
+
+func (*watchSuite) TestInitialNotifyEventTimeout(c *C) {
+	rs := common.NewResources()
+	rs.SetSetupTimeout(10 * time.Millisecond)
+	w := newFakeNotifyWatcher()
+	err := common.InitialNotifyEvent(rs, w)
+	c.Assert(err, Equals, common.ErrTimeout)
+	c.Assert(w.stopped, Equals, true)
+
+	// The timeout applies to NotifyWatchAndGet too.
+	w = newFakeNotifyWatcher()
+	id, err := common.NotifyWatchAndGet(rs, w, func() error {
+		c.Fatalf("get called")
+		return nil
+	})
+	c.Assert(err, Equals, common.ErrTimeout)
+	c.Assert(id, Equals, "")
+	c.Assert(w.stopped, Equals, true)
+	c.Assert(rs.Count(), Equals, 0)
+}
+
+func (*watchSuite) TestRelationUnitsWatcherTakeInitial(c *C) {
+	w := common.NewRelationUnitsWatcher(nil)
+	c.Assert(w.TakeInitial(), Equals, true)
+	c.Assert(w.TakeInitial(), Equals, false)
+	c.Assert(w.TakeInitial(), Equals, false)
+}
+
+type fakeNotifyWatcher struct {
+	changes chan struct{}
+	stopped bool
+	err     error
+}
+
+func newFakeNotifyWatcher() *fakeNotifyWatcher {
+	return &fakeNotifyWatcher{
+		changes: make(chan struct{}, 2),
+		err:     tomb.ErrStillAlive,
+	}
+}
+
+func (w *fakeNotifyWatcher) Stop() error {
+	w.stopped = true
+	return nil
+}
+
+func (w *fakeNotifyWatcher) Err() error {
+	return w.err
+}
+
+func (w *fakeNotifyWatcher) Changes() <-chan struct{} {
+	return w.changes
+}
@@ -0,0 +1,77 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"launchpad.net/juju-core/state"
+)
+
+// changeKeyFunc returns the key of a change reported by a
+// StringsWatcher, such as the tag of the entity it concerns, and
+// whether the change is an update that is superseded by any later
+// update with the same key. Other changes, such as the addition or
+// removal of an entity, are never dropped.
+type changeKeyFunc func(change string) (key string, update bool)
+
+// registerCompacted registers the given watcher in r.resources, like
+// Register, and arranges for the changes returned by each call to
+// its Next to be compacted with the given key function: an update is
+// dropped if a later update with the same key follows it in the same
+// call, with no other change with that key in between, so that the
+// client does not process updates already superseded. If the watcher
+// is served to clients with a transform, the key function sees the
+// changes before they are transformed.
+func (r *srvRoot) registerCompacted(w state.StringsWatcher, key changeKeyFunc) string {
+	id := r.resources.Register(w)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.compactions == nil {
+		r.compactions = make(map[string]changeKeyFunc)
+	}
+	r.compactions[id] = key
+	return id
+}
+
+// compaction returns the key function with which the changes of the
+// watcher with the given resource id are compacted, or nil if they
+// are not.
+func (r *srvRoot) compaction(id string) changeKeyFunc {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.compactions[id]
+}
+
+// compactChanges returns the given changes with the superseded
+// updates dropped, as described in registerCompacted, and the
+// remaining changes in their original order.
+func compactChanges(changes []string, key changeKeyFunc) []string {
+	keep := make([]bool, len(changes))
+	// superseded holds the keys of the updates kept so far,
+	// working backwards, with no other change since.
+	superseded := make(map[string]bool)
+	n := 0
+	for i := len(changes) - 1; i >= 0; i-- {
+		k, update := key(changes[i])
+		switch {
+		case !update:
+			delete(superseded, k)
+		case superseded[k]:
+			continue
+		default:
+			superseded[k] = true
+		}
+		keep[i] = true
+		n++
+	}
+	if n == len(changes) {
+		return changes
+	}
+	compacted := make([]string, 0, n)
+	for i, change := range changes {
+		if keep[i] {
+			compacted = append(compacted, change)
+		}
+	}
+	return compacted
+}
@@ -0,0 +1,278 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"launchpad.net/juju-core/state/api/params"
+	"launchpad.net/juju-core/state/apiserver/common"
+	"launchpad.net/tomb"
+)
+
+// ConnectionInfo describes a logged in connection.
+type ConnectionInfo struct {
+	ConnId  uint64
+	AuthTag string
+
+	// Resources holds the number of resources
+	// registered on the connection.
+	Resources int
+
+	// Goroutines holds the number of goroutines started on
+	// behalf of the connection that have not yet exited. It
+	// does not count the goroutines serving its requests.
+	Goroutines int
+}
+
+type connectionInfoSlice []ConnectionInfo
+
+func (s connectionInfoSlice) Len() int           { return len(s) }
+func (s connectionInfoSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s connectionInfoSlice) Less(i, j int) bool { return s[i].ConnId < s[j].ConnId }
+
+// Connections returns a snapshot of the server's logged
+// in connections, ordered by connection id.
+func (srv *Server) Connections() []ConnectionInfo {
+	srv.mu.Lock()
+	roots := make([]*srvRoot, 0, len(srv.roots))
+	for root := range srv.roots {
+		roots = append(roots, root)
+	}
+	srv.mu.Unlock()
+	conns := make(connectionInfoSlice, len(roots))
+	for i, root := range roots {
+		conns[i] = ConnectionInfo{
+			ConnId:     root.connId,
+			AuthTag:    root.GetAuthTag(),
+			Resources:  root.resources.Count(),
+			Goroutines: int(atomic.LoadInt32(&root.goroutines)),
+		}
+	}
+	sort.Sort(conns)
+	return conns
+}
+
+// spawn starts fn in a new goroutine, counting it
+// among the goroutines of the connection until it
+// returns.
+func (r *srvRoot) spawn(fn func()) {
+	atomic.AddInt32(&r.goroutines, 1)
+	go func() {
+		defer atomic.AddInt32(&r.goroutines, -1)
+		fn()
+	}()
+}
+
+// adminTag holds the tag of the environment's administrative user.
+const adminTag = "user-admin"
+
+// WatchConnections starts a StringsWatcher that reports the entities
+// connecting to and disconnecting from the server, each change
+// holding an entity's tag and a params.ConnectionEvent. The initial
+// event reports all the entities connected when the watcher starts.
+// Only the administrative user may watch connections.
+func (r *srvRoot) WatchConnections() (params.StringsWatchResult, error) {
+	if !r.AuthClient() || r.GetAuthTag() != adminTag {
+		return params.StringsWatchResult{}, common.ErrPerm
+	}
+	w := newConnectionsWatcher(r.srv, func(fn func()) { r.spawn(fn) })
+	changes, err := common.InitialStringsEvent(r.resources, w)
+	if err != nil {
+		return params.StringsWatchResult{}, err
+	}
+	id := r.resources.Register(w)
+	page, more := r.pageSnapshot(id, changes)
+	return params.StringsWatchResult{
+		StringsWatcherId: id,
+		Changes:          page,
+		MorePages:        more,
+	}, nil
+}
+
+// Sessions returns the logged in connections of the entity with the
+// given tag, ordered by connection id, so that a suspicious session
+// can be told apart from the entity's others. Only the administrative
+// user may list sessions.
+func (r *srvRoot) Sessions(tag string) (params.SessionsResult, error) {
+	if !r.AuthClient() || r.GetAuthTag() != adminTag {
+		return params.SessionsResult{}, common.ErrPerm
+	}
+	var roots connectionRoots
+	r.srv.mu.Lock()
+	for root := range r.srv.roots {
+		if root.GetAuthTag() == tag {
+			roots = append(roots, root)
+		}
+	}
+	r.srv.mu.Unlock()
+	sort.Sort(roots)
+	result := params.SessionsResult{
+		Sessions: make([]params.SessionInfo, len(roots)),
+	}
+	for i, root := range roots {
+		result.Sessions[i] = params.SessionInfo{
+			ConnId:     strconv.FormatUint(root.connId, 10),
+			Tag:        tag,
+			LoginTime:  root.loginTime,
+			RemoteAddr: root.remoteAddr,
+		}
+	}
+	return result, nil
+}
+
+// RevokeSession kills the logged in connection with the given id, as
+// returned by Sessions, stopping its resources, and closes it, leaving
+// the entity's other connections alone. Only the administrative user
+// may revoke sessions.
+func (r *srvRoot) RevokeSession(connId string) error {
+	if !r.AuthClient() || r.GetAuthTag() != adminTag {
+		return common.ErrPerm
+	}
+	id, err := strconv.ParseUint(connId, 10, 64)
+	if err != nil {
+		return common.ErrBadId
+	}
+	var target *srvRoot
+	r.srv.mu.Lock()
+	for root := range r.srv.roots {
+		if root.connId == id {
+			target = root
+			break
+		}
+	}
+	r.srv.mu.Unlock()
+	if target == nil {
+		return common.ErrBadId
+	}
+	auditLogger.Infof("%q revoked session %s of %q", r.GetAuthTag(), connId, target.GetAuthTag())
+	target.Kill()
+	target.disconnect()
+	return nil
+}
+
+// connectionRoots sorts roots by connection id.
+type connectionRoots []*srvRoot
+
+func (s connectionRoots) Len() int           { return len(s) }
+func (s connectionRoots) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s connectionRoots) Less(i, j int) bool { return s[i].connId < s[j].connId }
+
+// connectionEvent returns the change reported for the
+// given event on the connection of the entity with tag.
+func connectionEvent(tag string, event params.ConnectionEvent) string {
+	return tag + " " + string(event)
+}
+
+// notifyConnectionLocked reports the given event on the connection
+// of the entity with tag to every connections watcher. It must be
+// called with srv.mu held.
+func (srv *Server) notifyConnectionLocked(tag string, event params.ConnectionEvent) {
+	for w := range srv.connWatchers {
+		w.notify(connectionEvent(tag, event))
+	}
+}
+
+// connectionsWatcher implements state.StringsWatcher,
+// reporting changes to the server's logged in connections.
+type connectionsWatcher struct {
+	tomb tomb.Tomb
+	srv  *Server
+	wake chan struct{}
+	out  chan []string
+
+	// mu guards pending, which holds the changes
+	// not yet taken by the main loop.
+	mu      sync.Mutex
+	pending []string
+}
+
+func newConnectionsWatcher(srv *Server, spawn func(func())) *connectionsWatcher {
+	w := &connectionsWatcher{
+		srv:  srv,
+		wake: make(chan struct{}, 1),
+		out:  make(chan []string),
+	}
+	srv.mu.Lock()
+	var initial []string
+	for root := range srv.roots {
+		initial = append(initial, connectionEvent(root.GetAuthTag(), params.ConnectionConnected))
+	}
+	if srv.connWatchers == nil {
+		srv.connWatchers = make(map[*connectionsWatcher]bool)
+	}
+	srv.connWatchers[w] = true
+	srv.mu.Unlock()
+	sort.Strings(initial)
+	spawn(func() {
+		defer w.tomb.Done()
+		defer close(w.out)
+		defer w.unregister()
+		w.tomb.Kill(w.loop(initial))
+	})
+	return w
+}
+
+// notify queues the given change without blocking.
+func (w *connectionsWatcher) notify(change string) {
+	w.mu.Lock()
+	w.pending = append(w.pending, change)
+	w.mu.Unlock()
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (w *connectionsWatcher) unregister() {
+	w.srv.mu.Lock()
+	defer w.srv.mu.Unlock()
+	delete(w.srv.connWatchers, w)
+}
+
+// Stop stops the watcher, and returns any error encountered while
+// running or shutting down.
+func (w *connectionsWatcher) Stop() error {
+	w.tomb.Kill(nil)
+	return w.tomb.Wait()
+}
+
+// Err returns any error encountered while running or shutting down,
+// or tomb.ErrStillAlive if the watcher is still running.
+func (w *connectionsWatcher) Err() error {
+	return w.tomb.Err()
+}
+
+// Changes returns the event channel for the watcher. Changes are
+// reported in the order they happened, so an entity may appear
+// more than once in an event.
+func (w *connectionsWatcher) Changes() <-chan []string {
+	return w.out
+}
+
+func (w *connectionsWatcher) loop(changes []string) error {
+	// The initial event is sent even if no entity is connected.
+	out := w.out
+	for {
+		select {
+		case <-w.tomb.Dying():
+			return tomb.ErrDying
+		case <-w.wake:
+			w.mu.Lock()
+			changes = append(changes, w.pending...)
+			w.pending = nil
+			w.mu.Unlock()
+			if len(changes) > 0 {
+				out = w.out
+			}
+		case out <- changes:
+			changes = nil
+			out = nil
+		}
+	}
+	panic("unreachable")
+}
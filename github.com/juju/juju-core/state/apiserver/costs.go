@@ -0,0 +1,93 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"sort"
+	"sync"
+
+	"launchpad.net/juju-core/state"
+)
+
+// MethodCost holds the state operations made by
+// the calls to a single facade method.
+type MethodCost struct {
+	Facade string
+	Method string
+
+	// Calls holds the number of calls counted.
+	Calls uint64
+
+	// Ops holds the total operations made by those calls.
+	Ops state.OpCounts
+}
+
+// SetOpCounting enables or disables the counting of the state
+// operations made by each facade call; see MethodCosts. It is
+// disabled by default.
+func (srv *Server) SetOpCounting(enabled bool) {
+	srv.state.SetOpCounting(enabled)
+}
+
+// MethodCosts returns the state operations made by the calls to
+// every facade method that has been called while operation counting
+// was enabled, sorted by facade and method. A call is charged with
+// all the operations made through the server's state while it ran,
+// so when calls overlap each is also charged with some of the
+// operations of the others; the costs are most telling when a
+// method is called on an otherwise quiet server.
+func (srv *Server) MethodCosts() []MethodCost {
+	return srv.costs.snapshot()
+}
+
+// costStats holds the costs of the calls to each facade method.
+type costStats struct {
+	mu      sync.Mutex
+	methods map[methodKey]*MethodCost
+}
+
+func newCostStats() *costStats {
+	return &costStats{
+		methods: make(map[methodKey]*MethodCost),
+	}
+}
+
+// record records a call to the given facade
+// method that made the given operations.
+func (s *costStats) record(facade, method string, ops state.OpCounts) {
+	key := methodKey{facade, method}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cost := s.methods[key]
+	if cost == nil {
+		cost = &MethodCost{Facade: facade, Method: method}
+		s.methods[key] = cost
+	}
+	cost.Calls++
+	cost.Ops.Reads += ops.Reads
+	cost.Ops.Transactions += ops.Transactions
+	cost.Ops.Writes += ops.Writes
+}
+
+func (s *costStats) snapshot() []MethodCost {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	results := make(methodCosts, 0, len(s.methods))
+	for _, cost := range s.methods {
+		results = append(results, *cost)
+	}
+	sort.Sort(results)
+	return results
+}
+
+type methodCosts []MethodCost
+
+func (cs methodCosts) Len() int      { return len(cs) }
+func (cs methodCosts) Swap(i, j int) { cs[i], cs[j] = cs[j], cs[i] }
+func (cs methodCosts) Less(i, j int) bool {
+	if cs[i].Facade != cs[j].Facade {
+		return cs[i].Facade < cs[j].Facade
+	}
+	return cs[i].Method < cs[j].Method
+}
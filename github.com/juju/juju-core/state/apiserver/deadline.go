@@ -0,0 +1,110 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	"launchpad.net/juju-core/log"
+	"launchpad.net/juju-core/rpc"
+	"launchpad.net/juju-core/state/apiserver/common"
+)
+
+// invokeWithDeadline invokes the request, failing with
+// common.ErrTimeout if it takes longer than the deadline the client
+// requested at login, and calls release once the invocation has
+// finished. Calls to Next on established watchers are bounded by the
+// watcher deadline instead, and not at all if there is none.
+//
+// State calls cannot be interrupted, so a call that overruns is
+// abandoned, not cancelled: it carries on in the background, holding
+// its request slot until it finishes, and only its results are
+// undone. A watcher whose Next overran is stopped, as the event it
+// would take would otherwise be lost, and the watchers in the result
+// of any other call are stopped when it completes. Identical calls
+// coalesced with an abandoned call fail with it, but later ones are
+// not coalesced with it, and are made afresh.
+func (r *srvRoot) invokeWithDeadline(req rpc.Request, invoke func() (interface{}, error), release func()) (interface{}, error) {
+	deadline := r.callDeadline
+	next := req.Action == "Next" && r.resources.Get(req.Id) != nil
+	if next {
+		deadline = r.watcherDeadline
+	}
+	if deadline <= 0 {
+		defer release()
+		return invoke()
+	}
+	type outcome struct {
+		result interface{}
+		err    error
+	}
+	done := make(chan outcome)
+	abandoned := make(chan struct{})
+	r.spawn(func() {
+		defer release()
+		result, err := invoke()
+		select {
+		case done <- outcome{result, err}:
+		case <-abandoned:
+			if err == nil {
+				r.stopWatchersIn(result)
+			}
+		}
+	})
+	select {
+	case o := <-done:
+		return o.result, o.err
+	case <-time.After(deadline):
+	}
+	close(abandoned)
+	log.Debugf("state/api: %s.%s on %q exceeded its deadline of %v", req.Type, req.Action, r.GetAuthTag(), deadline)
+	if next {
+		r.forgetDelivery(req.Id)
+		if err := r.resources.Retire(req.Id, common.ErrTimeout); err != nil {
+			log.Errorf("state/api: error stopping watcher %s: %v", req.Id, err)
+		}
+	}
+	return nil, common.ErrTimeout
+}
+
+// stopWatchersIn stops the watchers whose ids are held in the given
+// result of a call: in its fields whose names end in "WatcherId", or
+// in those fields of the entries of its slices.
+func (r *srvRoot) stopWatchersIn(result interface{}) {
+	for _, id := range watcherIds(reflect.ValueOf(result)) {
+		if err := r.resources.Stop(id); err != nil {
+			log.Errorf("state/api: error stopping watcher %s: %v", id, err)
+		}
+	}
+}
+
+func watcherIds(v reflect.Value) []string {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	var ids []string
+	t := v.Type()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		switch {
+		case f.Kind() == reflect.String && strings.HasSuffix(t.Field(i).Name, "WatcherId"):
+			if id := f.String(); id != "" {
+				ids = append(ids, id)
+			}
+		case f.Kind() == reflect.Slice:
+			for j := 0; j < f.Len(); j++ {
+				ids = append(ids, watcherIds(f.Index(j))...)
+			}
+		}
+	}
+	return ids
+}
@@ -10,7 +10,6 @@ import (
 	"launchpad.net/juju-core/state"
 	"launchpad.net/juju-core/state/api/params"
 	"launchpad.net/juju-core/state/apiserver/common"
-	"launchpad.net/juju-core/state/watcher"
 	"launchpad.net/juju-core/utils/set"
 )
 
@@ -20,9 +19,10 @@ type DeployerAPI struct {
 	*common.PasswordChanger
 	*common.LifeGetter
 
-	st         *state.State
-	resources  *common.Resources
-	authorizer common.Authorizer
+	st          *state.State
+	resources   *common.Resources
+	authorizer  common.Authorizer
+	getAuthFunc common.GetAuthFunc
 }
 
 // getAllUnits returns a list of all principal and subordinate units
@@ -74,6 +74,20 @@ func NewDeployerAPI(
 		st:              st,
 		resources:       resources,
 		authorizer:      authorizer,
+		getAuthFunc:     getAuthFunc,
+	}, nil
+}
+
+// MethodAuthFunc implements common.MethodAuthorizer. The methods
+// acting on units allow any unit deployed to the machine, while
+// those acting on machines allow only the machine itself.
+func (d *DeployerAPI) MethodAuthFunc(method string) (common.AuthFunc, error) {
+	switch method {
+	case "Remove", "SetPasswords", "Life":
+		return d.getAuthFunc()
+	}
+	return func(tag string) bool {
+		return d.authorizer.AuthOwner(tag)
 	}, nil
 }
 
@@ -92,11 +106,10 @@ func (d *DeployerAPI) WatchUnits(args params.Entities) (params.StringsWatchResul
 			if err == nil {
 				watch := machine.WatchUnits()
 				// Consume the initial event and forward it to the result.
-				if changes, ok := <-watch.Changes(); ok {
-					result.Results[i].StringsWatcherId = d.resources.Register(watch)
+				var changes []string
+				if changes, err = common.InitialStringsEvent(d.resources, watch); err == nil {
+					result.Results[i].StringsWatcherId = d.resources.RegisterFor(watch, entity.Tag)
 					result.Results[i].Changes = changes
-				} else {
-					err = watcher.MustErr(watch)
 				}
 			}
 		}
@@ -0,0 +1,67 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"launchpad.net/juju-core/log"
+	"launchpad.net/juju-core/rpc"
+	"launchpad.net/juju-core/state/api/params"
+	"launchpad.net/juju-core/state/apiserver/common"
+)
+
+// newDeprecations returns the registry of deprecated methods
+// described by the given notices, keyed by facade and method.
+func newDeprecations(notices []params.DeprecationNotice) map[methodKey]params.DeprecationNotice {
+	deprecated := make(map[methodKey]params.DeprecationNotice)
+	for _, notice := range notices {
+		deprecated[methodKey{notice.Facade, notice.Method}] = notice
+	}
+	return deprecated
+}
+
+// noteDeprecation records a notice for the client if req calls a
+// deprecated method. Each method's notice is recorded only the first
+// time it is called on the connection.
+func (r *srvRoot) noteDeprecation(req rpc.Request) {
+	key := methodKey{req.Type, req.Action}
+	notice, ok := r.srv.deprecated[key]
+	if !ok {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.deprecationsSeen[key] {
+		return
+	}
+	if r.deprecationsSeen == nil {
+		r.deprecationsSeen = make(map[methodKey]bool)
+	}
+	r.deprecationsSeen[key] = true
+	r.deprecations = append(r.deprecations, notice)
+	log.Warningf("state/api: %q called deprecated method %s.%s", r.GetAuthTag(), req.Type, req.Action)
+}
+
+// Deprecations returns an object through which the client may read
+// the notices recorded for the deprecated methods it has called. The
+// id argument is reserved for future use and must be empty.
+func (r *srvRoot) Deprecations(id string) (srvDeprecations, error) {
+	if id != "" {
+		return srvDeprecations{}, common.ErrBadId
+	}
+	return srvDeprecations{r}, nil
+}
+
+type srvDeprecations struct {
+	root *srvRoot
+}
+
+// Notices returns the notices recorded since it was last called,
+// in the order the deprecated methods were first called.
+func (d srvDeprecations) Notices() params.DeprecationNotices {
+	d.root.mu.Lock()
+	defer d.root.mu.Unlock()
+	notices := d.root.deprecations
+	d.root.deprecations = nil
+	return params.DeprecationNotices{Notices: notices}
+}
@@ -0,0 +1,146 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"time"
+
+	"launchpad.net/juju-core/rpc"
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/apiserver/common"
+)
+
+// Intercept implements rpc.Interceptor. It is called to serve every
+// request made on the connection once it has logged in.
+func (r *srvRoot) Intercept(req rpc.Request, invoke func() (interface{}, error)) (interface{}, error) {
+	span := r.srv.cfg.Tracer.StartSpan(SpanInfo{
+		TraceId: r.traceId,
+		ConnId:  r.connId,
+		Facade:  req.Type,
+		Id:      req.Id,
+		Method:  req.Action,
+	})
+	start := time.Now()
+	counting := r.srv.state.OpCounting()
+	var startOps state.OpCounts
+	if counting {
+		startOps = r.srv.state.OpCounts()
+	}
+	var result interface{}
+	var entryErrs map[int]error
+	err := common.ErrNotLoggedIn
+	if r.loggedIn() {
+		err = r.authorizeFacade(req.Type)
+	}
+	if err == nil {
+		err = r.authorizeMethod(req.Type, req.Action)
+	}
+	if err == nil {
+		entryErrs, err = validateArgs(r.srv.state, methodKey{req.Type, req.Action}, req.Params)
+	}
+	if err == nil {
+		r.noteDeprecation(req)
+		call := func() (interface{}, error) {
+			release, err := r.acquireSlot(req)
+			if err != nil {
+				return nil, err
+			}
+			// Once invoked, the request holds its slot until the
+			// invocation finishes, which may be after the call has
+			// been abandoned at its deadline; see invokeWithDeadline.
+			invoked := false
+			defer func() {
+				if !invoked {
+					release()
+				}
+			}()
+			if req.Action == "Next" && common.ResourceKind(r.resources.Get(req.Id)) != "" {
+				if err := r.expireWatcher(req.Id); err != nil {
+					return nil, err
+				}
+				if !r.beginNext(req.Id) {
+					return nil, common.ErrConcurrentNext
+				}
+				defer r.endNext(req.Id)
+			}
+			// Assertions are checked by the call made, so
+			// that the retries of a call made with an
+			// idempotency key are not refused because of
+			// the changes it made.
+			if err := checkAssertions(r.srv.state, req.Params); err != nil {
+				return nil, err
+			}
+			// The breaker sees the calls that overran their
+			// deadline, as those indicate a struggling backend.
+			result, err := r.invokeGuarded(req, func() (interface{}, error) {
+				invoked = true
+				return r.invokeWithDeadline(req, invoke, release)
+			})
+			if err == nil {
+				setEntryErrors(result, entryErrs)
+			}
+			return result, err
+		}
+		if key, args, ok := idempotencyKeyOf(r.GetAuthTag(), req); ok {
+			// Retries share the result of the first call.
+			result, err = r.srv.idempotent.do(key, args, call)
+		} else if key, ok := coalesceKey(req); ok {
+			// Coalesced calls share their results, so the
+			// entry errors are set once, by the call made.
+			result, err = r.flights.do(key, call)
+		} else {
+			result, err = call()
+		}
+	}
+	duration := time.Since(start)
+	r.srv.latencies.record(req.Type, req.Action, duration)
+	if counting {
+		r.srv.costs.record(req.Type, req.Action, r.srv.state.OpCounts().Sub(startOps))
+	}
+	r.health.served(duration)
+	r.logRequest(req, duration, err)
+	span.End(duration, err)
+	return result, err
+}
+
+// beginNext records that a call to Next is in progress on the watcher
+// with the given resource id, returning false if one already is.
+// Concurrent calls could otherwise take each other's events, so the
+// second is refused rather than queued behind the first, which may
+// wait indefinitely.
+func (r *srvRoot) beginNext(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.nexts[id] {
+		return false
+	}
+	if r.nexts == nil {
+		r.nexts = make(map[string]bool)
+	}
+	r.nexts[id] = true
+	return true
+}
+
+// endNext records that the call to Next on the watcher
+// with the given resource id has finished.
+func (r *srvRoot) endNext(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.nexts, id)
+}
+
+// invokeGuarded invokes the request through the server's circuit
+// breaker. Calls to Next on established watchers bypass the breaker,
+// so that clients keep receiving events while it is open.
+func (r *srvRoot) invokeGuarded(req rpc.Request, invoke func() (interface{}, error)) (interface{}, error) {
+	if req.Action == "Next" && r.resources.Get(req.Id) != nil {
+		return invoke()
+	}
+	if !r.srv.breaker.allow() {
+		return nil, common.ErrTryAgain
+	}
+	result, err := invoke()
+	r.srv.breaker.record(err)
+	return result, err
+}
@@ -67,6 +67,57 @@ var errorTransformTests = []struct {
 }, {
 	err:  &state.HasAssignedUnitsError{"42", []string{"a"}},
 	code: params.CodeHasAssignedUnits,
+}, {
+	err:  common.ErrLeadershipClaimDenied,
+	code: params.CodeLeadershipClaimDenied,
+}, {
+	err:  common.ErrTryAgain,
+	code: params.CodeTryAgain,
+}, {
+	err:  common.ErrMessageTooLarge,
+	code: params.CodeMessageTooLarge,
+}, {
+	err:  common.ErrAtCapacity,
+	code: params.CodeTryAgain,
+}, {
+	err:  common.ErrEntityRemoved,
+	code: params.CodeEntityRemoved,
+}, {
+	err:  common.ErrNotSubscribed,
+	code: params.CodeNotFound,
+}, {
+	err:  common.ErrCursorExpired,
+	code: params.CodeNotFound,
+}, {
+	err:  common.ErrTimeout,
+	code: params.CodeTimeout,
+}, {
+	err:  common.ErrConcurrentNext,
+	code: params.CodeConcurrentNext,
+}, {
+	err:  common.ErrWatcherExpired,
+	code: params.CodeWatcherExpired,
+}, {
+	err:  common.ErrConflict,
+	code: params.CodeConflict,
+}, {
+	err:  common.ErrBadRequest,
+	code: params.CodeBadRequest,
+}, {
+	err:  &common.BadRequestError{"Entities[0].Tag", "missing"},
+	code: params.CodeBadRequest,
+}, {
+	err:  common.ErrUnsupported,
+	code: params.CodeUnsupportedCapability,
+}, {
+	err:  &common.UnsupportedCapabilityError{[]string{"foo", "bar"}},
+	code: params.CodeUnsupportedCapability,
+}, {
+	err:  common.ErrBlocked,
+	code: params.CodeOperationBlocked,
+}, {
+	err:  &common.OperationBlockedError{"frozen for the holidays"},
+	code: params.CodeOperationBlocked,
 }, {
 	err:  stderrors.New("an error"),
 	code: "",
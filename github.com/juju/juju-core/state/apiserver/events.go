@@ -0,0 +1,96 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"sync"
+
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/api/params"
+	"launchpad.net/juju-core/state/apiserver/common"
+	"launchpad.net/juju-core/version"
+)
+
+// agentEvents holds the events stream an agent subscribed
+// to at login.
+type agentEvents struct {
+	st      *state.State
+	watcher state.NotifyWatcher
+
+	// mu guards agentVersion, which holds the agent
+	// version most recently sent to the agent.
+	mu           sync.Mutex
+	agentVersion version.Number
+}
+
+// subscribeAgentEvents starts the events stream for the root, which
+// must belong to an agent. The stream is registered in r.resources,
+// so it is stopped when the connection is killed.
+func (r *srvRoot) subscribeAgentEvents() error {
+	if err := r.requireAgent(); err != nil {
+		return err
+	}
+	w := r.srv.state.WatchForEnvironConfigChanges()
+	// Consume the initial event; the agent reads the current
+	// state for itself, and is sent only changes to it.
+	if err := common.InitialNotifyEvent(r.resources, w); err != nil {
+		return err
+	}
+	cfg, err := r.srv.state.EnvironConfig()
+	if err != nil {
+		w.Stop()
+		return err
+	}
+	r.agentEvents = &agentEvents{
+		st:      r.srv.state,
+		watcher: w,
+	}
+	r.agentEvents.agentVersion, _ = cfg.AgentVersion()
+	r.resources.Register(w)
+	return nil
+}
+
+// AgentEvents returns an object that provides access to the events
+// stream the agent subscribed to at login. The id argument is
+// reserved for future use and must be empty.
+func (r *srvRoot) AgentEvents(id string) (*srvAgentEvents, error) {
+	if id != "" {
+		return nil, common.ErrBadId
+	}
+	if r.agentEvents == nil {
+		return nil, common.ErrNotSubscribed
+	}
+	return &srvAgentEvents{r.agentEvents}, nil
+}
+
+// srvAgentEvents serves the events stream of an agent.
+type srvAgentEvents struct {
+	events *agentEvents
+}
+
+// Next returns when a change relevant to the agent has happened
+// since the most recent call to Next, or since login.
+func (e *srvAgentEvents) Next() (params.AgentEvent, error) {
+	w := e.events.watcher
+	if _, ok := <-w.Changes(); !ok {
+		err := w.Err()
+		if err == nil {
+			err = common.ErrStoppedWatcher
+		}
+		return params.AgentEvent{}, err
+	}
+	cfg, err := e.events.st.EnvironConfig()
+	if err != nil {
+		return params.AgentEvent{}, err
+	}
+	event := params.AgentEvent{EnvironConfigChanged: true}
+	v, _ := cfg.AgentVersion()
+	e.events.mu.Lock()
+	defer e.events.mu.Unlock()
+	if v != e.events.agentVersion {
+		event.AgentVersion = v.String()
+		e.events.agentVersion = v
+	}
+	return event, nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver_test
+
+import (
+	"time"
+
+	. "launchpad.net/gocheck"
+	jujutesting "launchpad.net/juju-core/juju/testing"
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/api"
+	"launchpad.net/juju-core/state/api/params"
+	"launchpad.net/juju-core/state/apiserver"
+	coretesting "launchpad.net/juju-core/testing"
+)
+
+type agentEventsSuite struct {
+	jujutesting.JujuConnSuite
+}
+
+var _ = Suite(&agentEventsSuite{})
+
+func (s *agentEventsSuite) login(c *C, srv *apiserver.Server, subscribe bool) *api.State {
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	err = stm.SetProvisioned("foo", "fake_nonce", nil)
+	c.Assert(err, IsNil)
+	err = stm.SetPassword("password")
+	c.Assert(err, IsNil)
+	st, err := api.Open(&api.Info{
+		Addrs:  []string{srv.Addr()},
+		CACert: []byte(coretesting.CACert),
+	}, fastDialOpts)
+	c.Assert(err, IsNil)
+	err = st.Call("Admin", "", "Login", &params.Creds{
+		AuthTag:     stm.Tag(),
+		Password:    "password",
+		Nonce:       "fake_nonce",
+		AgentEvents: subscribe,
+	}, nil)
+	c.Assert(err, IsNil)
+	return st
+}
+
+func (s *agentEventsSuite) TestAgentEvents(c *C) {
+	srv, err := apiserver.NewServer(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey))
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+	st := s.login(c, srv, true)
+	defer st.Close()
+
+	events := make(chan params.AgentEvent)
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			var event params.AgentEvent
+			if err := st.Call("AgentEvents", "", "Next", nil, &event); err != nil {
+				errs <- err
+				return
+			}
+			events <- event
+		}
+	}()
+	select {
+	case event := <-events:
+		c.Fatalf("unexpected event %#v", event)
+	case <-time.After(coretesting.ShortWait):
+	}
+
+	cfg, err := s.State.EnvironConfig()
+	c.Assert(err, IsNil)
+	cfg, err = cfg.Apply(map[string]interface{}{"agent-version": "9.8.7"})
+	c.Assert(err, IsNil)
+	err = s.State.SetEnvironConfig(cfg)
+	c.Assert(err, IsNil)
+	s.State.StartSync()
+	select {
+	case event := <-events:
+		c.Assert(event, DeepEquals, params.AgentEvent{
+			EnvironConfigChanged: true,
+			AgentVersion:         "9.8.7",
+		})
+	case err := <-errs:
+		c.Fatalf("unexpected error: %v", err)
+	case <-time.After(coretesting.LongWait):
+		c.Fatalf("timed out waiting for event")
+	}
+}
+
+func (s *agentEventsSuite) TestNotSubscribed(c *C) {
+	srv, err := apiserver.NewServer(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey))
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+	st := s.login(c, srv, false)
+	defer st.Close()
+
+	var event params.AgentEvent
+	err = st.Call("AgentEvents", "", "Next", nil, &event)
+	c.Assert(err, ErrorMatches, "not subscribed to agent events")
+	c.Assert(params.ErrCode(err), Equals, params.CodeNotFound)
+}
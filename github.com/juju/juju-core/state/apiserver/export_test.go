@@ -0,0 +1,280 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"crypto/x509"
+	"net/http"
+	"time"
+
+	"launchpad.net/juju-core/rpc"
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/api/params"
+	"launchpad.net/juju-core/state/apiserver/common"
+)
+
+var (
+	CertTag          = certTag
+	CertMethods      = certMethods
+	BoundedTagCounts = boundedTagCounts
+)
+
+const MaxMetricTags = maxMetricTags
+
+// ServeBlob serves a request for one of srv's blobs.
+func ServeBlob(srv *Server, w http.ResponseWriter, req *http.Request) {
+	srv.serveBlob(w, req)
+}
+
+// ServeMetrics serves a request for srv's metrics.
+func ServeMetrics(srv *Server, w http.ResponseWriter, req *http.Request) {
+	srv.serveMetrics(w, req)
+}
+
+// Breaker exposes a circuit breaker for testing.
+type Breaker interface {
+	Allow() bool
+	Record(err error)
+}
+
+type exportedBreaker struct {
+	b *breaker
+}
+
+func (b exportedBreaker) Allow() bool      { return b.b.allow() }
+func (b exportedBreaker) Record(err error) { b.b.record(err) }
+
+func NewBreaker(threshold int, cooldown time.Duration, now func() time.Time) Breaker {
+	return exportedBreaker{newBreaker(threshold, cooldown, now)}
+}
+
+// RequestSlots exposes a request slot limiter for testing.
+type RequestSlots interface {
+	Acquire(priority int, abort <-chan struct{}) bool
+	Release()
+	Waiting() int
+}
+
+type exportedRequestSlots struct {
+	s *requestSlots
+}
+
+func (s exportedRequestSlots) Acquire(priority int, abort <-chan struct{}) bool {
+	return s.s.acquire(priority, abort)
+}
+
+func (s exportedRequestSlots) Release() { s.s.release() }
+
+func (s exportedRequestSlots) Waiting() int {
+	s.s.mu.Lock()
+	defer s.s.mu.Unlock()
+	return len(s.s.waiters)
+}
+
+func NewRequestSlots(max int) RequestSlots {
+	return exportedRequestSlots{newRequestSlots(max)}
+}
+
+// RequestPriority returns the priority srv gives to
+// the requests of the entity with the given tag.
+func RequestPriority(srv *Server, tag string) int {
+	return srv.requestPriority(tag)
+}
+
+// LatencyQuantiles records the given request latencies in a latency
+// histogram and returns its estimates of the given quantiles.
+func LatencyQuantiles(samples []time.Duration, qs ...float64) (uint64, []time.Duration) {
+	var h latencyHistogram
+	for _, d := range samples {
+		h.add(d)
+	}
+	return h.quantiles(qs...)
+}
+
+// KillRoot calls Kill on a root logged in to srv
+// as the given entity, after registering each of
+// the given functions with OnKill.
+func KillRoot(srv *Server, entity state.TaggedAuthenticator, onKill ...func()) {
+	r := newSrvRoot(&initialRoot{srv: srv}, entity)
+	for _, fn := range onKill {
+		r.OnKill(fn)
+	}
+	r.Kill()
+}
+
+// BlobRoot is the root of a connection that can transfer blobs.
+type BlobRoot interface {
+	common.BlobTransferer
+	Kill()
+}
+
+// NewBlobRoot returns the root of a connection
+// logged in to srv as the given entity.
+func NewBlobRoot(srv *Server, entity state.TaggedAuthenticator) BlobRoot {
+	return newSrvRoot(&initialRoot{srv: srv}, entity)
+}
+
+type exportedRoot struct {
+	*srvRoot
+}
+
+func (r exportedRoot) Resources() *common.Resources { return r.resources }
+
+// WatchingRoot is the root of a connection
+// that can watch groups of entities.
+type WatchingRoot interface {
+	WatchEntities(tags []string, authFor func(tag string) bool) (params.StringsWatchResult, error)
+	WatchWildcard(pattern string, authFor func(tag string) bool) (params.StringsWatchResult, error)
+	StringsWatcher(id string) (*srvStringsWatcher, error)
+	Snapshot(id string) (params.StringsWatchResult, error)
+	WatchConnections() (params.StringsWatchResult, error)
+	Sessions(tag string) (params.SessionsResult, error)
+	RevokeSession(connId string) error
+	FindWatchers(tag string) []string
+	ResourceAges() []common.AgeBucket
+	StopWatchersByType(kind string) int
+	StopWatchers(ids []string) []error
+	DetachWatcher(id string) (*WatcherHandle, error)
+	AttachWatcher(h *WatcherHandle) (string, error)
+	DiscardWatcher(h *WatcherHandle) error
+	ExpiringWatchers(fraction float64) []ExpiringWatcher
+	Resources() *common.Resources
+	Kill()
+}
+
+// AddWatchingRoot adds to srv the root of a connection
+// logged in as the given entity, and returns it.
+func AddWatchingRoot(srv *Server, entity state.TaggedAuthenticator) (WatchingRoot, error) {
+	r := newSrvRoot(&initialRoot{srv: srv}, entity)
+	if err := srv.addRoot(r); err != nil {
+		return nil, err
+	}
+	return exportedRoot{r}, nil
+}
+
+// AddWatchingRootAtVersion is like AddWatchingRoot, but the root
+// behaves as if its client had logged in at the given API version.
+func AddWatchingRootAtVersion(srv *Server, entity state.TaggedAuthenticator, version int) (WatchingRoot, error) {
+	r := newSrvRoot(&initialRoot{srv: srv}, entity)
+	r.version = version
+	if err := srv.addRoot(r); err != nil {
+		return nil, err
+	}
+	return exportedRoot{r}, nil
+}
+
+// SetStringsTransform sets the transform applied to StringsWatcher
+// changes sent to clients of the given API version, and returns
+// a function that restores the previous transform.
+func SetStringsTransform(version int, transform func([]string) []string) (restore func()) {
+	old, ok := stringsTransforms[version]
+	stringsTransforms[version] = transform
+	return func() {
+		if ok {
+			stringsTransforms[version] = old
+		} else {
+			delete(stringsTransforms, version)
+		}
+	}
+}
+
+// RetryTransient calls fn as retried by a root logged in
+// to srv as the given entity under the given policy.
+func RetryTransient(srv *Server, entity state.TaggedAuthenticator, policy RetryPolicy, fn func() error) error {
+	r := newSrvRoot(&initialRoot{srv: srv}, entity)
+	defer r.resources.StopAll()
+	return r.retryTransient(policy, fn)
+}
+
+// PermanentError marks err as one that is not to be retried.
+func PermanentError(err error) error {
+	return permanentError{err}
+}
+
+// CheckPermission calls CheckPermission on a root
+// logged in to srv as the given entity.
+func CheckPermission(srv *Server, entity state.TaggedAuthenticator, facade, method, targetTag string) error {
+	r := newSrvRoot(&initialRoot{srv: srv}, entity)
+	defer r.resources.StopAll()
+	return r.CheckPermission(facade, method, targetTag)
+}
+
+// ValidateTag calls ValidateTag on a root
+// logged in to srv as the given entity.
+func ValidateTag(srv *Server, entity state.TaggedAuthenticator, tag string) error {
+	r := newSrvRoot(&initialRoot{srv: srv}, entity)
+	defer r.resources.StopAll()
+	return r.ValidateTag(tag)
+}
+
+// CoalesceKey returns the key under which the given call is coalesced
+// with identical concurrent calls, and whether it may be coalesced.
+func CoalesceKey(facade, id, method string, args interface{}) (interface{}, bool) {
+	key, ok := coalesceKey(rpc.Request{Type: facade, Id: id, Action: method, Params: args})
+	if !ok {
+		return nil, false
+	}
+	return key, true
+}
+
+// FlightGroup coalesces identical concurrent calls.
+type FlightGroup interface {
+	Do(key interface{}, fn func() (interface{}, error)) (interface{}, error)
+}
+
+type exportedFlightGroup struct {
+	g *flightGroup
+}
+
+func (g exportedFlightGroup) Do(key interface{}, fn func() (interface{}, error)) (interface{}, error) {
+	return g.g.do(key.(flightKey), fn)
+}
+
+func NewFlightGroup() FlightGroup {
+	return exportedFlightGroup{&flightGroup{}}
+}
+
+// CheckMemory makes srv check the given heap usage
+// against its watermark, as it does at intervals.
+func CheckMemory(srv *Server, heap uint64) {
+	srv.checkMemory(heap)
+}
+
+// HasWatcherSettings reports whether root holds any of the settings
+// made for the watcher with the given id, such as its compaction.
+func HasWatcherSettings(root WatchingRoot, id string) bool {
+	r := root.(exportedRoot).srvRoot
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, delivered := r.deliveries[id]
+	_, limited := r.rateLimits[id]
+	_, stopAt := r.stopAt[id]
+	_, compacted := r.compactions[id]
+	_, paged := r.snapshotPages[id]
+	return delivered || limited || stopAt || compacted || paged
+}
+
+// RegisterCompacted registers w in the resources of root, compacting
+// the changes it returns with the given key function.
+func RegisterCompacted(root WatchingRoot, w state.StringsWatcher, key func(string) (string, bool)) string {
+	return root.(exportedRoot).registerCompacted(w, key)
+}
+
+// RestrictToCertMethods restricts root to the facade methods
+// allowed by the given subject field of a client certificate,
+// as if root had logged in with the certificate.
+func RestrictToCertMethods(root WatchingRoot, cert *x509.Certificate, field string) error {
+	methods, err := certMethods(cert, field)
+	if err != nil {
+		return err
+	}
+	root.(exportedRoot).allowedMethods = methods
+	return nil
+}
+
+// AuthorizeMethod returns common.ErrPerm if root
+// may not call the given method of the given facade.
+func AuthorizeMethod(root WatchingRoot, facade, method string) error {
+	return root.(exportedRoot).authorizeMethod(facade, method)
+}
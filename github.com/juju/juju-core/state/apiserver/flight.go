@@ -0,0 +1,112 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+
+	"launchpad.net/juju-core/rpc"
+)
+
+// coalescedMethods holds the facade methods whose concurrent
+// identical calls on a connection share a single result. Only
+// methods that read state without changing it, and without
+// creating resources, may be listed: watchers, for example, must
+// not be shared, as each caller is given a watcher of its own.
+var coalescedMethods = map[methodKey]bool{
+	{"Client", "Status"}:                true,
+	{"Client", "ServiceGet"}:            true,
+	{"Client", "GetServiceConstraints"}: true,
+	{"Client", "CharmInfo"}:             true,
+	{"Client", "EnvironmentInfo"}:       true,
+	{"Client", "GetAnnotations"}:        true,
+	{"Machiner", "Life"}:                true,
+	{"MachineAgent", "GetMachines"}:     true,
+	{"Deployer", "Life"}:                true,
+	{"Deployer", "CanDeploy"}:           true,
+	{"Upgrader", "Tools"}:               true,
+	{"SSHClient", "PublicAddress"}:      true,
+}
+
+// flightKey identifies identical calls.
+type flightKey struct {
+	facade string
+	id     string
+	method string
+
+	// args holds a hash of the JSON encoding of the arguments.
+	args string
+}
+
+// coalesceKey returns the key identifying calls identical to req,
+// and whether req may share its result with them.
+func coalesceKey(req rpc.Request) (flightKey, bool) {
+	if !coalescedMethods[methodKey{req.Type, req.Action}] {
+		return flightKey{}, false
+	}
+	args, ok := argsHash(req.Params)
+	if !ok {
+		return flightKey{}, false
+	}
+	return flightKey{
+		facade: req.Type,
+		id:     req.Id,
+		method: req.Action,
+		args:   args,
+	}, true
+}
+
+// argsHash returns a hash of the JSON encoding of the given
+// request arguments, and whether they could be encoded.
+func argsHash(args interface{}) (string, bool) {
+	data, err := json.Marshal(args)
+	if err != nil {
+		return "", false
+	}
+	h := sha256.New()
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// flight is a call in progress, whose result is
+// shared by every caller waiting on done.
+type flight struct {
+	done   chan struct{}
+	result interface{}
+	err    error
+}
+
+// flightGroup coalesces identical concurrent calls.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[flightKey]*flight
+}
+
+// do calls fn and returns its result, unless a call with the same key
+// is already in progress, in which case it waits for that call and
+// returns its result instead.
+func (g *flightGroup) do(key flightKey, fn func() (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	if f, ok := g.flights[key]; ok {
+		g.mu.Unlock()
+		<-f.done
+		return f.result, f.err
+	}
+	if g.flights == nil {
+		g.flights = make(map[flightKey]*flight)
+	}
+	f := &flight{done: make(chan struct{})}
+	g.flights[key] = f
+	g.mu.Unlock()
+
+	f.result, f.err = fn()
+	g.mu.Lock()
+	delete(g.flights, key)
+	g.mu.Unlock()
+	close(f.done)
+	return f.result, f.err
+}
@@ -0,0 +1,124 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver_test
+
+import (
+	"sync"
+	"time"
+
+	. "launchpad.net/gocheck"
+	"launchpad.net/juju-core/state/api/params"
+	"launchpad.net/juju-core/state/apiserver"
+	"launchpad.net/juju-core/testing"
+)
+
+type flightSuite struct {
+	testing.LoggingSuite
+}
+
+var _ = Suite(&flightSuite{})
+
+var someEntities = params.Entities{Entities: []params.Entity{{Tag: "machine-0"}}}
+
+var coalesceTests = []struct {
+	facade, method string
+	coalesced      bool
+}{
+	{"Client", "Status", true},
+	{"Client", "ServiceGet", true},
+	{"Machiner", "Life", true},
+	{"Upgrader", "Tools", true},
+	{"SSHClient", "PublicAddress", true},
+	{"Client", "ServiceDeploy", false},
+	{"Client", "SetAnnotations", false},
+	{"Client", "WatchAll", false},
+	{"Machiner", "SetStatus", false},
+	{"Machiner", "Watch", false},
+	{"Upgrader", "SetTools", false},
+	{"NotifyWatcher", "Next", false},
+	{"Pinger", "Ping", false},
+}
+
+func (s *flightSuite) TestCoalescedMethods(c *C) {
+	for i, test := range coalesceTests {
+		c.Logf("test %d: %s.%s", i, test.facade, test.method)
+		_, ok := apiserver.CoalesceKey(test.facade, "", test.method, someEntities)
+		c.Check(ok, Equals, test.coalesced)
+	}
+}
+
+func (s *flightSuite) TestCoalesceKey(c *C) {
+	key, ok := apiserver.CoalesceKey("Machiner", "", "Life", someEntities)
+	c.Assert(ok, Equals, true)
+
+	same, _ := apiserver.CoalesceKey("Machiner", "", "Life", params.Entities{
+		Entities: []params.Entity{{Tag: "machine-0"}},
+	})
+	c.Assert(same, Equals, key)
+
+	other, _ := apiserver.CoalesceKey("Machiner", "", "Life", params.Entities{
+		Entities: []params.Entity{{Tag: "machine-1"}},
+	})
+	c.Assert(other, Not(Equals), key)
+
+	other, _ = apiserver.CoalesceKey("Machiner", "1", "Life", someEntities)
+	c.Assert(other, Not(Equals), key)
+
+	other, _ = apiserver.CoalesceKey("Deployer", "", "Life", someEntities)
+	c.Assert(other, Not(Equals), key)
+}
+
+func (s *flightSuite) TestDoSharesResult(c *C) {
+	g := apiserver.NewFlightGroup()
+	key, _ := apiserver.CoalesceKey("Client", "", "Status", nil)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var mu sync.Mutex
+	calls := 0
+	fn := func() (interface{}, error) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		close(started)
+		<-release
+		return "status", nil
+	}
+	results := make(chan interface{}, 3)
+	go func() {
+		result, err := g.Do(key, fn)
+		c.Check(err, IsNil)
+		results <- result
+	}()
+	<-started
+	for i := 0; i < 2; i++ {
+		go func() {
+			result, err := g.Do(key, func() (interface{}, error) {
+				c.Errorf("call was not coalesced")
+				return nil, nil
+			})
+			c.Check(err, IsNil)
+			results <- result
+		}()
+	}
+	// Give the waiting calls a chance to join the flight.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	for i := 0; i < 3; i++ {
+		select {
+		case result := <-results:
+			c.Assert(result, Equals, "status")
+		case <-time.After(testing.LongWait):
+			c.Fatalf("timed out waiting for result")
+		}
+	}
+	c.Assert(calls, Equals, 1)
+
+	// Once the flight has landed, a new call invokes fn again.
+	result, err := g.Do(key, func() (interface{}, error) {
+		return "fresh", nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(result, Equals, "fresh")
+}
@@ -0,0 +1,89 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	stderrors "errors"
+	"sync"
+
+	"launchpad.net/juju-core/state/apiserver/common"
+)
+
+var errHandleUsed = stderrors.New("watcher handle already used")
+
+// WatcherHandle holds a watcher detached from a connection by
+// DetachWatcher, until it is attached to a connection again by
+// AttachWatcher or stopped by DiscardWatcher. A handle may be used
+// only once. It has no exported methods, so that DetachWatcher is
+// not served as a facade to clients.
+type WatcherHandle struct {
+	mu       sync.Mutex
+	resource common.Resource
+	tag      string
+}
+
+// take returns the handle's watcher and the tag it was registered
+// for, invalidating the handle, or fails if it has been used already.
+func (h *WatcherHandle) take() (common.Resource, string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.resource == nil {
+		return nil, "", errHandleUsed
+	}
+	resource := h.resource
+	h.resource = nil
+	return resource, h.tag, nil
+}
+
+// DetachWatcher unregisters the connection's watcher with the given
+// id without stopping it, returning a handle with which the watcher
+// can be attached to another connection, or to this one under a new
+// id, so that a worker can be handed over without missing any event.
+// The settings made on the connection for the watcher, such as its
+// rate limit, are not carried over. It fails with
+// common.ErrUnknownWatcher if the id names no watcher.
+func (r *srvRoot) DetachWatcher(id string) (*WatcherHandle, error) {
+	if common.ResourceKind(r.resources.Get(id)) == "" {
+		return nil, common.ErrUnknownWatcher
+	}
+	resource, tag := r.resources.Detach(id)
+	if resource == nil {
+		// The watcher was stopped meanwhile.
+		return nil, common.ErrUnknownWatcher
+	}
+	r.forgetDelivery(id)
+	return &WatcherHandle{
+		resource: resource,
+		tag:      tag,
+	}, nil
+}
+
+// AttachWatcher registers the watcher held by the given handle in
+// r.resources, returning its new id, and invalidates the handle. A
+// watcher of an entity may be attached only to a connection allowed
+// to watch it; the handle is left valid if it is refused.
+func (r *srvRoot) AttachWatcher(h *WatcherHandle) (string, error) {
+	h.mu.Lock()
+	tag := h.tag
+	h.mu.Unlock()
+	if tag != "" && !r.AuthOwner(tag) && !r.AuthEnvironManager() {
+		return "", common.ErrPerm
+	}
+	resource, tag, err := h.take()
+	if err != nil {
+		return "", err
+	}
+	return r.resources.RegisterFor(resource, tag), nil
+}
+
+// DiscardWatcher stops the watcher held by the given handle, for
+// when it is not to be attached after all, and invalidates the
+// handle.
+func (r *srvRoot) DiscardWatcher(h *WatcherHandle) error {
+	resource, _, err := h.take()
+	if err != nil {
+		return err
+	}
+	return resource.Stop()
+}
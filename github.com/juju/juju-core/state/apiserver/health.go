@@ -0,0 +1,70 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"sync"
+	"time"
+
+	"launchpad.net/juju-core/state/api/params"
+)
+
+// A request taking longer than slowRequestThreshold to serve is
+// counted as slow, and marks its connection as degraded for
+// slowRequestWindow.
+const (
+	slowRequestThreshold = 5 * time.Second
+	slowRequestWindow    = time.Minute
+)
+
+// connHealth records what a connection's health is judged on.
+type connHealth struct {
+	mu           sync.Mutex
+	lastPing     time.Time
+	slowRequests int
+	lastSlow     time.Time
+}
+
+// ping records a ping from the client.
+func (h *connHealth) ping() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastPing = time.Now()
+}
+
+// served records that a request took the given time to serve.
+func (h *connHealth) served(d time.Duration) {
+	if d < slowRequestThreshold {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.slowRequests++
+	h.lastSlow = time.Now()
+}
+
+// ConnectionHealth returns a summary of the connection's health, so
+// that an agent can move to another server before the connection
+// fails outright.
+func (r *srvRoot) ConnectionHealth() params.ConnectionHealth {
+	r.health.mu.Lock()
+	now := time.Now()
+	health := params.ConnectionHealth{
+		DrainInProgress: r.srv.draining(),
+		LastPingAge:     now.Sub(r.health.lastPing),
+		SlowRequests:    r.health.slowRequests,
+		BreakerOpen:     r.srv.breaker.tripped(),
+	}
+	recentlySlow := r.health.slowRequests > 0 && now.Sub(r.health.lastSlow) < slowRequestWindow
+	r.health.mu.Unlock()
+	health.Degraded = health.DrainInProgress || health.BreakerOpen || recentlySlow
+	return health
+}
+
+// draining reports whether the server is shutting down.
+func (srv *Server) draining() bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.shuttingDown
+}
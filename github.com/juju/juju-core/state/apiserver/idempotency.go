@@ -0,0 +1,170 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"launchpad.net/juju-core/rpc"
+	"launchpad.net/juju-core/state/api/params"
+	"launchpad.net/juju-core/state/apiserver/common"
+)
+
+// idempotencyRetention is how long the result of a call
+// made with an idempotency key is kept for its retries.
+const idempotencyRetention = 10 * time.Minute
+
+// maxIdempotentCalls bounds the number of calls remembered for each
+// entity; once it is reached, the oldest call is forgotten to make
+// room for a new one.
+const maxIdempotentCalls = 100
+
+// idempotencyKey identifies the calls that are retries of one another.
+type idempotencyKey struct {
+	tag    string
+	facade string
+	method string
+	key    string
+}
+
+// idempotencyKeyOf returns the key identifying the retries of req,
+// made by the entity with the given tag, and a hash of its arguments,
+// if its arguments hold an idempotency key; see
+// params.IdempotencyKeyField.
+func idempotencyKeyOf(tag string, req rpc.Request) (idempotencyKey, string, bool) {
+	v := reflect.ValueOf(req.Params)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return idempotencyKey{}, "", false
+	}
+	field := v.FieldByName(params.IdempotencyKeyField)
+	if !field.IsValid() || field.Kind() != reflect.String || field.String() == "" {
+		return idempotencyKey{}, "", false
+	}
+	args, ok := argsHash(req.Params)
+	if !ok {
+		return idempotencyKey{}, "", false
+	}
+	return idempotencyKey{
+		tag:    tag,
+		facade: req.Type,
+		method: req.Action,
+		key:    field.String(),
+	}, args, true
+}
+
+// idempotentCall is a call made with an idempotency key. Its result
+// is set, and done closed, when the call has completed.
+type idempotentCall struct {
+	args   string
+	done   chan struct{}
+	result interface{}
+	err    error
+
+	// expires holds the time after which the call is
+	// forgotten; it is zero while the call is in progress.
+	expires time.Time
+}
+
+// idempotentCalls remembers the calls made with idempotency keys,
+// so that their retries are not made again.
+type idempotentCalls struct {
+	mu    sync.Mutex
+	calls map[idempotencyKey]*idempotentCall
+
+	// order holds the keys of the calls remembered
+	// for each entity, oldest first.
+	order map[string][]idempotencyKey
+}
+
+func newIdempotentCalls() *idempotentCalls {
+	return &idempotentCalls{
+		calls: make(map[idempotencyKey]*idempotentCall),
+		order: make(map[string][]idempotencyKey),
+	}
+}
+
+// do calls fn and returns its result, unless a call with the same key
+// has already been made and is remembered, in which case it waits for
+// that call to complete, if need be, and returns its result instead.
+// Reusing a key with different arguments is refused.
+func (c *idempotentCalls) do(key idempotencyKey, args string, fn func() (interface{}, error)) (interface{}, error) {
+	c.mu.Lock()
+	c.expire(key.tag, time.Now())
+	if call := c.calls[key]; call != nil {
+		c.mu.Unlock()
+		if call.args != args {
+			return nil, &common.BadRequestError{
+				Field:  params.IdempotencyKeyField,
+				Reason: "already used for a different call",
+			}
+		}
+		<-call.done
+		return call.result, call.err
+	}
+	if order := c.order[key.tag]; len(order) >= maxIdempotentCalls {
+		delete(c.calls, order[0])
+		c.order[key.tag] = order[1:]
+	}
+	call := &idempotentCall{
+		args: args,
+		done: make(chan struct{}),
+	}
+	c.calls[key] = call
+	c.order[key.tag] = append(c.order[key.tag], key)
+	c.mu.Unlock()
+
+	call.result, call.err = fn()
+	c.mu.Lock()
+	if call.err != nil {
+		// Failed calls may be retried.
+		c.forget(key, call)
+	} else {
+		call.expires = time.Now().Add(idempotencyRetention)
+	}
+	c.mu.Unlock()
+	close(call.done)
+	return call.result, call.err
+}
+
+// expire forgets the calls of the entity with the given tag that
+// have expired by the given time. It must be called with c.mu held.
+func (c *idempotentCalls) expire(tag string, now time.Time) {
+	order := c.order[tag]
+	for len(order) > 0 {
+		call := c.calls[order[0]]
+		if call != nil && (call.expires.IsZero() || now.Before(call.expires)) {
+			break
+		}
+		delete(c.calls, order[0])
+		order = order[1:]
+	}
+	if len(order) == 0 {
+		delete(c.order, tag)
+	} else {
+		c.order[tag] = order
+	}
+}
+
+// forget forgets the given call, if it is still remembered
+// with the given key. It must be called with c.mu held.
+func (c *idempotentCalls) forget(key idempotencyKey, call *idempotentCall) {
+	if c.calls[key] != call {
+		return
+	}
+	delete(c.calls, key)
+	order := c.order[key.tag]
+	for i, k := range order {
+		if k == key {
+			rest := make([]idempotencyKey, 0, len(order)-1)
+			rest = append(rest, order[:i]...)
+			c.order[key.tag] = append(rest, order[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MethodLatency holds estimated latency percentiles for
+// requests made to a single facade method.
+type MethodLatency struct {
+	Facade string
+	Method string
+
+	// Count holds the number of requests made.
+	Count uint64
+
+	P50 time.Duration
+	P95 time.Duration
+	P99 time.Duration
+}
+
+// MethodLatencies returns the estimated latency percentiles of every
+// facade method that has been called on the server, sorted by facade
+// and method. Watcher operations appear as methods on the watcher
+// facades, so NotifyWatcher.Next, for example, measures the time
+// spent waiting for events.
+func (srv *Server) MethodLatencies() []MethodLatency {
+	return srv.latencies.snapshot()
+}
+
+// minLatencyBound is the upper bound of the first latency bucket;
+// each subsequent bucket's bound is double the previous one's.
+const minLatencyBound = 100 * time.Microsecond
+
+// numLatencyBuckets holds the number of buckets in a latency
+// histogram. The last bucket counts all requests taking longer
+// than the bound of the one before it, about 52 seconds.
+const numLatencyBuckets = 21
+
+// latencyBound returns the upper bound of the
+// latency bucket with the given index.
+func latencyBound(i int) time.Duration {
+	return minLatencyBound << uint(i)
+}
+
+// latencyHistogram counts requests in buckets of exponentially
+// increasing latency. It uses a fixed amount of memory however many
+// requests are recorded, at the cost of estimating percentiles to
+// within a bucket. It is safe to call its methods concurrently.
+type latencyHistogram struct {
+	counts [numLatencyBuckets]uint64
+}
+
+func (h *latencyHistogram) add(d time.Duration) {
+	i := 0
+	for i < numLatencyBuckets-1 && d >= latencyBound(i) {
+		i++
+	}
+	atomic.AddUint64(&h.counts[i], 1)
+}
+
+// quantiles returns the total number of requests recorded,
+// and estimates of the given quantiles of their latencies.
+func (h *latencyHistogram) quantiles(qs ...float64) (uint64, []time.Duration) {
+	var counts [numLatencyBuckets]uint64
+	var total uint64
+	for i := range counts {
+		counts[i] = atomic.LoadUint64(&h.counts[i])
+		total += counts[i]
+	}
+	results := make([]time.Duration, len(qs))
+	if total == 0 {
+		return 0, results
+	}
+	for j, q := range qs {
+		rank := q * float64(total)
+		var seen uint64
+		for i, n := range counts {
+			if n == 0 || float64(seen+n) < rank {
+				seen += n
+				continue
+			}
+			var lower time.Duration
+			if i > 0 {
+				lower = latencyBound(i - 1)
+			}
+			if i == numLatencyBuckets-1 {
+				// We know nothing of the spread of
+				// requests in the overflow bucket.
+				results[j] = lower
+				break
+			}
+			// Assume the requests are spread evenly
+			// through the bucket.
+			frac := (rank - float64(seen)) / float64(n)
+			results[j] = lower + time.Duration(frac*float64(latencyBound(i)-lower))
+			break
+		}
+	}
+	return total, results
+}
+
+type methodKey struct {
+	facade string
+	method string
+}
+
+// latencyStats holds a latency histogram for each facade method.
+type latencyStats struct {
+	mu      sync.RWMutex
+	methods map[methodKey]*latencyHistogram
+}
+
+func newLatencyStats() *latencyStats {
+	return &latencyStats{
+		methods: make(map[methodKey]*latencyHistogram),
+	}
+}
+
+// record records a request to the given facade method
+// that took the given time.
+func (s *latencyStats) record(facade, method string, d time.Duration) {
+	key := methodKey{facade, method}
+	s.mu.RLock()
+	h := s.methods[key]
+	s.mu.RUnlock()
+	if h == nil {
+		s.mu.Lock()
+		if h = s.methods[key]; h == nil {
+			h = &latencyHistogram{}
+			s.methods[key] = h
+		}
+		s.mu.Unlock()
+	}
+	h.add(d)
+}
+
+func (s *latencyStats) snapshot() []MethodLatency {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	results := make(methodLatencies, 0, len(s.methods))
+	for key, h := range s.methods {
+		count, qs := h.quantiles(0.5, 0.95, 0.99)
+		results = append(results, MethodLatency{
+			Facade: key.facade,
+			Method: key.method,
+			Count:  count,
+			P50:    qs[0],
+			P95:    qs[1],
+			P99:    qs[2],
+		})
+	}
+	sort.Sort(results)
+	return results
+}
+
+type methodLatencies []MethodLatency
+
+func (ls methodLatencies) Len() int      { return len(ls) }
+func (ls methodLatencies) Swap(i, j int) { ls[i], ls[j] = ls[j], ls[i] }
+func (ls methodLatencies) Less(i, j int) bool {
+	if ls[i].Facade != ls[j].Facade {
+		return ls[i].Facade < ls[j].Facade
+	}
+	return ls[i].Method < ls[j].Method
+}
@@ -0,0 +1,49 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver_test
+
+import (
+	"time"
+
+	. "launchpad.net/gocheck"
+	"launchpad.net/juju-core/state/apiserver"
+	"launchpad.net/juju-core/testing"
+)
+
+type latencySuite struct {
+	testing.LoggingSuite
+}
+
+var _ = Suite(&latencySuite{})
+
+func (s *latencySuite) TestNoSamples(c *C) {
+	count, qs := apiserver.LatencyQuantiles(nil, 0.5, 0.99)
+	c.Assert(count, Equals, uint64(0))
+	c.Assert(qs, DeepEquals, []time.Duration{0, 0})
+}
+
+func (s *latencySuite) TestQuantiles(c *C) {
+	// 90 fast requests, 9 slower ones and a single
+	// very slow one.
+	var samples []time.Duration
+	for i := 0; i < 90; i++ {
+		samples = append(samples, 150*time.Microsecond)
+	}
+	for i := 0; i < 9; i++ {
+		samples = append(samples, 30*time.Millisecond)
+	}
+	samples = append(samples, 10*time.Minute)
+
+	count, qs := apiserver.LatencyQuantiles(samples, 0.5, 0.95, 0.99, 1)
+	c.Assert(count, Equals, uint64(100))
+
+	// Each estimate lies within the bucket holding the sample.
+	c.Assert(qs[0] >= 100*time.Microsecond && qs[0] < 200*time.Microsecond, Equals, true, Commentf("p50 %v", qs[0]))
+	c.Assert(qs[1] >= 25600*time.Microsecond && qs[1] <= 51200*time.Microsecond, Equals, true, Commentf("p95 %v", qs[1]))
+	c.Assert(qs[2] >= 25600*time.Microsecond && qs[2] <= 51200*time.Microsecond, Equals, true, Commentf("p99 %v", qs[2]))
+
+	// Requests beyond the last bucket are reported
+	// at its lower bound.
+	c.Assert(qs[3], Equals, 100*time.Microsecond<<19)
+}
@@ -0,0 +1,124 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"sync"
+
+	"launchpad.net/juju-core/state"
+)
+
+// upstreamWatcher holds the methods common to the
+// watchers started lazily by a lazyWatcher.
+type upstreamWatcher interface {
+	Stop() error
+	Err() error
+}
+
+// lazyWatcher defers starting a watcher until its changes are first
+// asked for; see params.WatchSpec.Lazy.
+type lazyWatcher struct {
+	mu    sync.Mutex
+	start func() (upstreamWatcher, error)
+
+	// w holds the started watcher, and err the error
+	// from starting it, once the watcher has been started.
+	w   upstreamWatcher
+	err error
+
+	// done is set once the watcher has been started,
+	// or stopped before it was.
+	done bool
+}
+
+// activate starts the watcher if it has not been started already,
+// and returns it, or nil if it was stopped first or could not be
+// started.
+func (l *lazyWatcher) activate() upstreamWatcher {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.done {
+		l.w, l.err = l.start()
+		l.done = true
+	}
+	return l.w
+}
+
+// Stop implements state.Watcher.Stop.
+func (l *lazyWatcher) Stop() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.done = true
+	if l.w == nil {
+		return nil
+	}
+	return l.w.Stop()
+}
+
+// Err implements state.Watcher.Err.
+func (l *lazyWatcher) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.w == nil {
+		return l.err
+	}
+	return l.w.Err()
+}
+
+// lazyNotifyWatcher is a NotifyWatcher started lazily.
+type lazyNotifyWatcher struct {
+	lazyWatcher
+}
+
+func newLazyNotifyWatcher(start func() (state.NotifyWatcher, error)) *lazyNotifyWatcher {
+	w := &lazyNotifyWatcher{}
+	w.start = func() (upstreamWatcher, error) {
+		nw, err := start()
+		if err != nil {
+			return nil, err
+		}
+		return nw, nil
+	}
+	return w
+}
+
+// Changes implements state.NotifyWatcher.Changes. It starts the
+// watcher if need be; if that fails, or the watcher has been stopped
+// before being started, the returned channel is closed.
+func (w *lazyNotifyWatcher) Changes() <-chan struct{} {
+	if nw := w.activate(); nw != nil {
+		return nw.(state.NotifyWatcher).Changes()
+	}
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}
+
+// lazyStringsWatcher is a StringsWatcher started lazily.
+type lazyStringsWatcher struct {
+	lazyWatcher
+}
+
+func newLazyStringsWatcher(start func() (state.StringsWatcher, error)) *lazyStringsWatcher {
+	w := &lazyStringsWatcher{}
+	w.start = func() (upstreamWatcher, error) {
+		sw, err := start()
+		if err != nil {
+			return nil, err
+		}
+		return sw, nil
+	}
+	return w
+}
+
+// Changes implements state.StringsWatcher.Changes,
+// as for lazyNotifyWatcher.Changes.
+func (w *lazyStringsWatcher) Changes() <-chan []string {
+	if sw := w.activate(); sw != nil {
+		return sw.(state.StringsWatcher).Changes()
+	}
+	ch := make(chan []string)
+	close(ch)
+	return ch
+}
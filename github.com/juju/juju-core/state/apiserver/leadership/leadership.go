@@ -0,0 +1,106 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// The leadership package implements the API used by unit agents
+// to elect a leader amongst the units of a service.
+package leadership
+
+import (
+	"strings"
+	"time"
+
+	"launchpad.net/juju-core/state/api/params"
+	"launchpad.net/juju-core/state/apiserver/common"
+)
+
+var errClaimDenied = common.ErrLeadershipClaimDenied
+
+// LeadershipServiceAPI provides access to the leadership API facade.
+type LeadershipServiceAPI struct {
+	manager     *Manager
+	resources   *common.Resources
+	authorizer  common.Authorizer
+	serviceName string
+}
+
+// NewLeadershipServiceAPI creates a new server-side leadership API
+// facade for the unit agent represented by the given authorizer.
+func NewLeadershipServiceAPI(
+	manager *Manager,
+	resources *common.Resources,
+	authorizer common.Authorizer,
+) (*LeadershipServiceAPI, error) {
+	if !authorizer.AuthUnitAgent() {
+		return nil, common.ErrPerm
+	}
+	return &LeadershipServiceAPI{
+		manager:     manager,
+		resources:   resources,
+		authorizer:  authorizer,
+		serviceName: serviceNameFromUnitTag(authorizer.GetAuthTag()),
+	}, nil
+}
+
+// serviceNameFromUnitTag returns the name of the service
+// of the unit with the given tag.
+func serviceNameFromUnitTag(tag string) string {
+	name := tag[len("unit-"):]
+	if i := strings.LastIndex(name, "-"); i != -1 {
+		name = name[:i]
+	}
+	return name
+}
+
+// authService returns whether the given tag is that of
+// the authenticated unit's service.
+func (api *LeadershipServiceAPI) authService(tag string) bool {
+	return tag == "service-"+api.serviceName
+}
+
+// MethodAuthFunc implements common.MethodAuthorizer. Every
+// method acts only on the authenticated unit's service.
+func (api *LeadershipServiceAPI) MethodAuthFunc(method string) (common.AuthFunc, error) {
+	return func(tag string) bool {
+		return api.authService(tag)
+	}, nil
+}
+
+// ClaimLeadership makes each given unit leader of the given service
+// for the requested duration, if no other unit currently leads it.
+// Units may only claim the leadership of their own service.
+func (api *LeadershipServiceAPI) ClaimLeadership(args params.ClaimLeadershipBulkParams) (params.ErrorResults, error) {
+	result := params.ErrorResults{
+		Errors: make([]*params.Error, len(args.Params)),
+	}
+	for i, p := range args.Params {
+		err := common.ErrPerm
+		if api.authorizer.AuthOwner(p.UnitTag) && api.authService(p.ServiceTag) {
+			duration := time.Duration(p.DurationSeconds * float64(time.Second))
+			err = api.manager.ClaimLeadership(api.serviceName, p.UnitTag, duration)
+		}
+		result.Errors[i] = common.ServerError(err)
+	}
+	return result, nil
+}
+
+// BlockUntilLeadershipReleased starts a NotifyWatcher for each given
+// service, which notifies when the service has no leader. Units may
+// only watch the leadership of their own service.
+func (api *LeadershipServiceAPI) BlockUntilLeadershipReleased(args params.Entities) (params.NotifyWatchResults, error) {
+	result := params.NotifyWatchResults{
+		Results: make([]params.NotifyWatchResult, len(args.Entities)),
+	}
+	for i, entity := range args.Entities {
+		err := common.ErrPerm
+		if api.authService(entity.Tag) {
+			watch := api.manager.WatchLeadershipReleased(api.serviceName)
+			// Consume the initial event; the client's first
+			// call to Next returns when leadership is released.
+			if err = common.InitialNotifyEvent(api.resources, watch); err == nil {
+				result.Results[i].NotifyWatcherId = api.resources.RegisterFor(watch, entity.Tag)
+			}
+		}
+		result.Results[i].Error = common.ServerError(err)
+	}
+	return result, nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package leadership_test
+
+import (
+	stdtesting "testing"
+	"time"
+
+	. "launchpad.net/gocheck"
+
+	"launchpad.net/juju-core/state/api/params"
+	"launchpad.net/juju-core/state/apiserver/common"
+	"launchpad.net/juju-core/state/apiserver/leadership"
+	apiservertesting "launchpad.net/juju-core/state/apiserver/testing"
+	coretesting "launchpad.net/juju-core/testing"
+)
+
+func TestAll(t *stdtesting.T) {
+	TestingT(t)
+}
+
+type leadershipSuite struct {
+	manager    *leadership.Manager
+	resources  *common.Resources
+	authorizer apiservertesting.FakeAuthorizer
+	api        *leadership.LeadershipServiceAPI
+}
+
+var _ = Suite(&leadershipSuite{})
+
+func (s *leadershipSuite) SetUpTest(c *C) {
+	s.manager = leadership.NewManager()
+	s.resources = common.NewResources()
+	s.authorizer = apiservertesting.FakeAuthorizer{
+		Tag:       "unit-my-service-0",
+		LoggedIn:  true,
+		UnitAgent: true,
+	}
+	var err error
+	s.api, err = leadership.NewLeadershipServiceAPI(s.manager, s.resources, s.authorizer)
+	c.Assert(err, IsNil)
+}
+
+func (s *leadershipSuite) TearDownTest(c *C) {
+	s.resources.StopAll()
+}
+
+func (s *leadershipSuite) TestRequiresUnitAgent(c *C) {
+	anAuthorizer := s.authorizer
+	anAuthorizer.UnitAgent = false
+	api, err := leadership.NewLeadershipServiceAPI(s.manager, s.resources, anAuthorizer)
+	c.Assert(err, ErrorMatches, "permission denied")
+	c.Assert(api, IsNil)
+}
+
+func (s *leadershipSuite) TestClaimLeadership(c *C) {
+	err := s.manager.ClaimLeadership("my-service", "unit-my-service-1", time.Minute)
+	c.Assert(err, IsNil)
+	err = s.manager.ClaimLeadership("other", "unit-other-0", time.Minute)
+	c.Assert(err, IsNil)
+
+	result, err := s.api.ClaimLeadership(params.ClaimLeadershipBulkParams{
+		Params: []params.ClaimLeadershipParams{
+			{ServiceTag: "service-my-service", UnitTag: "unit-my-service-0", DurationSeconds: 60},
+			{ServiceTag: "service-my-service", UnitTag: "unit-my-service-1", DurationSeconds: 60},
+			{ServiceTag: "service-other", UnitTag: "unit-my-service-0", DurationSeconds: 60},
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, params.ErrorResults{
+		Errors: []*params.Error{
+			{Message: "leadership claim denied", Code: params.CodeLeadershipClaimDenied},
+			apiservertesting.ErrUnauthorized,
+			apiservertesting.ErrUnauthorized,
+		},
+	})
+}
+
+func (s *leadershipSuite) TestClaimLeadershipExtendsLease(c *C) {
+	err := s.manager.ClaimLeadership("my-service", "unit-my-service-0", time.Minute)
+	c.Assert(err, IsNil)
+	err = s.manager.ClaimLeadership("my-service", "unit-my-service-0", time.Minute)
+	c.Assert(err, IsNil)
+	err = s.manager.ClaimLeadership("my-service", "unit-my-service-1", time.Minute)
+	c.Assert(err, Equals, common.ErrLeadershipClaimDenied)
+}
+
+func (s *leadershipSuite) TestBlockUntilLeadershipReleased(c *C) {
+	err := s.manager.ClaimLeadership("my-service", "unit-my-service-1", 50*time.Millisecond)
+	c.Assert(err, IsNil)
+
+	result, err := s.api.BlockUntilLeadershipReleased(params.Entities{
+		Entities: []params.Entity{
+			{Tag: "service-my-service"},
+			{Tag: "service-other"},
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, params.NotifyWatchResults{
+		Results: []params.NotifyWatchResult{
+			{NotifyWatcherId: "1"},
+			{Error: apiservertesting.ErrUnauthorized},
+		},
+	})
+	c.Assert(s.resources.Count(), Equals, 1)
+	w := s.resources.Get("1").(interface {
+		Changes() <-chan struct{}
+	})
+	select {
+	case _, ok := <-w.Changes():
+		c.Assert(ok, Equals, true)
+	case <-time.After(coretesting.LongWait):
+		c.Fatalf("leadership release not notified")
+	}
+	// Now the lease has lapsed another unit may claim leadership.
+	err = s.manager.ClaimLeadership("my-service", "unit-my-service-0", time.Minute)
+	c.Assert(err, IsNil)
+}
@@ -0,0 +1,146 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package leadership
+
+import (
+	"sync"
+	"time"
+
+	"launchpad.net/tomb"
+
+	"launchpad.net/juju-core/state"
+)
+
+// Manager holds the leadership leases of all services. A single
+// Manager is shared by every connection to an API server.
+type Manager struct {
+	mu     sync.Mutex
+	leases map[string]lease
+}
+
+// lease records the tag of the unit leading a service, and until when.
+type lease struct {
+	holder string
+	expiry time.Time
+}
+
+// NewManager returns a new Manager with no leases held.
+func NewManager() *Manager {
+	return &Manager{
+		leases: make(map[string]lease),
+	}
+}
+
+// ClaimLeadership makes the unit with the given tag leader of the
+// named service for the given duration. A unit that already leads the
+// service has its lease extended. It returns common.ErrLeadershipClaimDenied if
+// another unit holds an unexpired lease.
+func (m *Manager) ClaimLeadership(serviceName, unitTag string, duration time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if l, ok := m.leases[serviceName]; ok && l.holder != unitTag && now.Before(l.expiry) {
+		return errClaimDenied
+	}
+	m.leases[serviceName] = lease{
+		holder: unitTag,
+		expiry: now.Add(duration),
+	}
+	return nil
+}
+
+// leaseExpiry returns the time at which the current lease on the
+// leadership of the named service expires, and whether there is
+// such a lease.
+func (m *Manager) leaseExpiry(serviceName string) (time.Time, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l, ok := m.leases[serviceName]
+	if !ok {
+		return time.Time{}, false
+	}
+	if !time.Now().Before(l.expiry) {
+		delete(m.leases, serviceName)
+		return time.Time{}, false
+	}
+	return l.expiry, true
+}
+
+// WatchLeadershipReleased returns a NotifyWatcher that sends an
+// initial event, followed by a single further event as soon as no
+// unit leads the named service.
+func (m *Manager) WatchLeadershipReleased(serviceName string) state.NotifyWatcher {
+	w := &releaseWatcher{
+		manager:     m,
+		serviceName: serviceName,
+		out:         make(chan struct{}),
+	}
+	go func() {
+		defer w.tomb.Done()
+		defer close(w.out)
+		w.tomb.Kill(w.loop())
+	}()
+	return w
+}
+
+// releaseWatcher implements the watcher returned by
+// Manager.WatchLeadershipReleased.
+type releaseWatcher struct {
+	tomb        tomb.Tomb
+	manager     *Manager
+	serviceName string
+	out         chan struct{}
+}
+
+// Stop stops the watcher, and returns any error encountered while
+// running or shutting down.
+func (w *releaseWatcher) Stop() error {
+	w.tomb.Kill(nil)
+	return w.tomb.Wait()
+}
+
+// Err returns any error encountered while running or shutting down,
+// or tomb.ErrStillAlive if the watcher is still running.
+func (w *releaseWatcher) Err() error {
+	return w.tomb.Err()
+}
+
+// Changes returns the event channel for the watcher.
+func (w *releaseWatcher) Changes() <-chan struct{} {
+	return w.out
+}
+
+func (w *releaseWatcher) send() error {
+	select {
+	case <-w.tomb.Dying():
+		return tomb.ErrDying
+	case w.out <- struct{}{}:
+	}
+	return nil
+}
+
+func (w *releaseWatcher) loop() error {
+	if err := w.send(); err != nil {
+		return err
+	}
+	for {
+		expiry, held := w.manager.leaseExpiry(w.serviceName)
+		if !held {
+			break
+		}
+		// The lease may have been extended by the time this
+		// fires, so we check again rather than assume it
+		// has lapsed.
+		select {
+		case <-w.tomb.Dying():
+			return tomb.ErrDying
+		case <-time.After(expiry.Sub(time.Now())):
+		}
+	}
+	if err := w.send(); err != nil {
+		return err
+	}
+	<-w.tomb.Dying()
+	return tomb.ErrDying
+}
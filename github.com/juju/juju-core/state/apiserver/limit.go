@@ -0,0 +1,222 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"bytes"
+	"code.google.com/p/go.net/websocket"
+	"encoding/json"
+	"io"
+
+	"launchpad.net/juju-core/rpc"
+	"launchpad.net/juju-core/rpc/jsoncodec"
+	"launchpad.net/juju-core/state/apiserver/common"
+)
+
+// maxHeaderPrefix holds the number of bytes kept of the start of
+// each message, in which the header of an oversized request is
+// looked for.
+const maxHeaderPrefix = 1024
+
+// limitedConn implements jsoncodec.JSONConn for a websocket
+// connection, refusing to receive any message larger than max
+// bytes. Messages are decoded as they are read, so an oversized
+// message is rejected without being buffered in full: the rest
+// of it is discarded, and only its header is received, so that
+// limitedCodec can answer the request with
+// common.ErrMessageTooLarge and the connection remains usable.
+type limitedConn struct {
+	conn *websocket.Conn
+	r    limitedReader
+	dec  *json.Decoder
+	max  int64
+
+	// tooLarge records whether the last message
+	// received was rejected.
+	tooLarge bool
+}
+
+func newLimitedConn(conn *websocket.Conn, max int64) *limitedConn {
+	c := &limitedConn{
+		conn: conn,
+		max:  max,
+	}
+	c.r.r = conn
+	c.dec = json.NewDecoder(&c.r)
+	return c
+}
+
+func (c *limitedConn) Send(msg interface{}) error {
+	return websocket.JSON.Send(c.conn, msg)
+}
+
+func (c *limitedConn) Receive(msg interface{}) error {
+	// Clients send each message in a websocket frame of its own,
+	// so the decoder does not read beyond the message it is
+	// decoding and each message may be read afresh.
+	c.tooLarge = false
+	c.r.reset(c.max)
+	err := c.dec.Decode(msg)
+	if err != common.ErrMessageTooLarge {
+		return err
+	}
+	if err := c.r.discard(); err != nil {
+		return err
+	}
+	// The decoder keeps the error it has returned.
+	c.dec = json.NewDecoder(&c.r)
+	hdr, ok := c.r.header()
+	if !ok {
+		return err
+	}
+	c.tooLarge = true
+	return json.Unmarshal(hdr, msg)
+}
+
+func (c *limitedConn) Close() error {
+	return c.conn.Close()
+}
+
+// limitedReader reads a message from r, failing with
+// common.ErrMessageTooLarge once more than n bytes have been
+// read. It keeps the start of the message and follows its
+// nesting, so that the rest of an oversized message can be
+// discarded and its header recovered.
+type limitedReader struct {
+	r      io.Reader
+	n      int64
+	prefix []byte
+	scan   valueScanner
+}
+
+// reset prepares l to read a new message of at most n bytes.
+func (l *limitedReader) reset(n int64) {
+	l.n = n
+	l.prefix = l.prefix[:0]
+	l.scan = valueScanner{}
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		return 0, common.ErrMessageTooLarge
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if room := maxHeaderPrefix - len(l.prefix); room > 0 {
+		if room > n {
+			room = n
+		}
+		l.prefix = append(l.prefix, p[:room]...)
+	}
+	l.scan.scan(p[:n])
+	return n, err
+}
+
+// discard reads and discards the rest of the message being read.
+func (l *limitedReader) discard() error {
+	buf := make([]byte, 4096)
+	for !l.scan.done {
+		n, err := l.r.Read(buf)
+		l.scan.scan(buf[:n])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// header returns the message being read without its parameters,
+// if its header was found at its start. Clients encode the header
+// fields before the parameters.
+func (l *limitedReader) header() ([]byte, bool) {
+	i := bytes.Index(l.prefix, []byte(`,"Params":`))
+	if i < 0 {
+		return nil, false
+	}
+	hdr := make([]byte, 0, i+1)
+	hdr = append(hdr, l.prefix[:i]...)
+	return append(hdr, '}'), true
+}
+
+// valueScanner follows the nesting of a JSON object or array
+// as it is read, to find where it ends.
+type valueScanner struct {
+	depth    int
+	inString bool
+	escaped  bool
+	done     bool
+}
+
+func (s *valueScanner) scan(data []byte) {
+	for _, b := range data {
+		if s.done {
+			return
+		}
+		switch {
+		case s.escaped:
+			s.escaped = false
+		case s.inString:
+			switch b {
+			case '\\':
+				s.escaped = true
+			case '"':
+				s.inString = false
+			}
+		case b == '"':
+			s.inString = true
+		case b == '{' || b == '[':
+			s.depth++
+		case b == '}' || b == ']':
+			s.depth--
+			s.done = s.depth <= 0
+		}
+	}
+}
+
+// limitedCodec wraps the codec of a connection to enforce
+// ServerConfig.MaxRequestSize, with conn, if it is not nil,
+// and ServerConfig.MaxResponseSize, with maxResponse, if it
+// is positive.
+type limitedCodec struct {
+	*jsoncodec.Codec
+	conn        *limitedConn
+	maxResponse int64
+}
+
+// ReadBody implements rpc.Codec.ReadBody, failing with
+// common.ErrMessageTooLarge for a request that was too large
+// to be received, so that the request is answered with the error.
+func (c *limitedCodec) ReadBody(body interface{}, isRequest bool) error {
+	if c.conn != nil && c.conn.tooLarge {
+		return common.ErrMessageTooLarge
+	}
+	return c.Codec.ReadBody(body, isRequest)
+}
+
+// WriteMessage implements rpc.Codec.WriteMessage, answering with
+// common.ErrMessageTooLarge instead a response whose result encodes
+// to more than maxResponse bytes. The result is encoded once, here,
+// and the codec sends that encoding.
+func (c *limitedCodec) WriteMessage(hdr *rpc.Header, body interface{}) error {
+	if c.maxResponse <= 0 || hdr.IsRequest() || hdr.Error != "" {
+		return c.Codec.WriteMessage(hdr, body)
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) > c.maxResponse {
+		err := common.ServerError(common.ErrMessageTooLarge)
+		return c.Codec.WriteMessage(&rpc.Header{
+			RequestId: hdr.RequestId,
+			Error:     err.Message,
+			ErrorCode: err.Code,
+		}, struct{}{})
+	}
+	raw := json.RawMessage(data)
+	return c.Codec.WriteMessage(hdr, &raw)
+}
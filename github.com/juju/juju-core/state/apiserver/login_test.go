@@ -4,12 +4,15 @@
 package apiserver_test
 
 import (
+	"fmt"
 	. "launchpad.net/gocheck"
 	jujutesting "launchpad.net/juju-core/juju/testing"
+	"launchpad.net/juju-core/state"
 	"launchpad.net/juju-core/state/api"
 	"launchpad.net/juju-core/state/api/params"
 	"launchpad.net/juju-core/state/apiserver"
 	coretesting "launchpad.net/juju-core/testing"
+	"time"
 )
 
 type loginSuite struct {
@@ -87,3 +90,314 @@ func (s *loginSuite) TestBadLogin(c *C) {
 		}()
 	}
 }
+
+func (s *loginSuite) TestLoginOnBehalfOf(c *C) {
+	controller, err := s.State.AddMachine("series", state.JobManageEnviron, state.JobManageState)
+	c.Assert(err, IsNil)
+	err = controller.SetProvisioned("foo", "fake_nonce", nil)
+	c.Assert(err, IsNil)
+	err = controller.SetPassword("controller-password")
+	c.Assert(err, IsNil)
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	err = stm.SetPassword("machine-password")
+	c.Assert(err, IsNil)
+
+	_, info, err := s.APIConn.Environ.StateInfo()
+	c.Assert(err, IsNil)
+	info.Tag = ""
+	info.Password = ""
+
+	for i, t := range []struct {
+		tag          string
+		password     string
+		effectiveTag string
+		err          string
+	}{{
+		// Only controllers may log in on behalf of another entity.
+		tag:          stm.Tag(),
+		password:     "machine-password",
+		effectiveTag: controller.Tag(),
+		err:          "permission denied",
+	}, {
+		// Controllers may not act as client users.
+		tag:          controller.Tag(),
+		password:     "controller-password",
+		effectiveTag: "user-admin",
+		err:          "permission denied",
+	}, {
+		tag:          controller.Tag(),
+		password:     "controller-password",
+		effectiveTag: stm.Tag(),
+	}} {
+		c.Logf("test %d; %q on behalf of %q", i, t.tag, t.effectiveTag)
+		func() {
+			st, err := api.Open(info, fastDialOpts)
+			c.Assert(err, IsNil)
+			defer st.Close()
+
+			err = st.Call("Admin", "", "Login", &params.Creds{
+				AuthTag:      t.tag,
+				Password:     t.password,
+				Nonce:        "fake_nonce",
+				EffectiveTag: t.effectiveTag,
+			}, nil)
+			if t.err != "" {
+				c.Assert(err, ErrorMatches, t.err)
+				return
+			}
+			c.Assert(err, IsNil)
+			// The connection acts as the impersonated machine, so
+			// the Machiner API allows access to that machine only.
+			_, err = st.Machiner().Machine(stm.Tag())
+			c.Assert(err, IsNil)
+			_, err = st.Machiner().Machine(controller.Tag())
+			c.Assert(err, ErrorMatches, "permission denied")
+			// Every call names the controller that made it.
+			logged := fmt.Sprintf(`(?s).*state/api: connection \d+: Machiner\.Life by %q on behalf of %q took .*`, controller.Tag(), stm.Tag())
+			c.Assert(c.GetTestLog(), Matches, logged)
+		}()
+	}
+}
+
+func (s *loginSuite) TestLoginCapabilities(c *C) {
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	err = stm.SetProvisioned("foo", "fake_nonce", nil)
+	c.Assert(err, IsNil)
+	err = stm.SetPassword("machine-password")
+	c.Assert(err, IsNil)
+
+	_, info, err := s.APIConn.Environ.StateInfo()
+	c.Assert(err, IsNil)
+	info.Tag = ""
+	info.Password = ""
+
+	for i, t := range []struct {
+		capabilities []string
+		err          string
+	}{{
+		capabilities: []string{"compression", params.CapabilityAgentEvents, "bulk-watch", "compression"},
+		err:          "unsupported capability: compression, bulk-watch",
+	}, {
+		capabilities: []string{params.CapabilityAgentEvents, params.CapabilityTracing},
+	}, {
+		capabilities: nil,
+	}} {
+		c.Logf("test %d; capabilities %q", i, t.capabilities)
+		func() {
+			st, err := api.Open(info, fastDialOpts)
+			c.Assert(err, IsNil)
+			defer st.Close()
+
+			err = st.Call("Admin", "", "Login", &params.Creds{
+				AuthTag:      stm.Tag(),
+				Password:     "machine-password",
+				Nonce:        "fake_nonce",
+				Capabilities: t.capabilities,
+			}, nil)
+			if t.err != "" {
+				c.Assert(err, ErrorMatches, t.err)
+				c.Assert(params.ErrCode(err), Equals, params.CodeUnsupportedCapability)
+				// The connection remains logged out.
+				_, err = st.Machiner().Machine(stm.Tag())
+				c.Assert(err, ErrorMatches, `unknown object type "Machiner"`)
+				return
+			}
+			c.Assert(err, IsNil)
+			_, err = st.Machiner().Machine(stm.Tag())
+			c.Assert(err, IsNil)
+		}()
+	}
+}
+
+func (s *loginSuite) TestResumeSession(c *C) {
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	err = stm.SetProvisioned("foo", "fake_nonce", nil)
+	c.Assert(err, IsNil)
+	err = stm.SetPassword("machine-password")
+	c.Assert(err, IsNil)
+	newServer := func() *apiserver.Server {
+		srv, err := apiserver.NewServer(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey))
+		c.Assert(err, IsNil)
+		return srv
+	}
+	open := func(srv *apiserver.Server) *api.State {
+		st, err := api.Open(&api.Info{
+			Addrs:  []string{srv.Addr()},
+			CACert: []byte(coretesting.CACert),
+		}, fastDialOpts)
+		c.Assert(err, IsNil)
+		return st
+	}
+
+	// Log in to the first server and start a watcher, which the
+	// session records when the client pings.
+	srv1 := newServer()
+	defer srv1.Stop()
+	st1 := open(srv1)
+	defer st1.Close()
+	result, err := st1.Resume(stm.Tag(), "machine-password", "fake_nonce", "")
+	c.Assert(err, IsNil)
+	c.Assert(result.SessionToken, Not(Equals), "")
+	c.Assert(result.Watchers, HasLen, 0)
+	c.Assert(result.ResumeError, IsNil)
+	var watchResults params.NotifyWatchResults
+	err = st1.Call("Machiner", "", "Watch", params.Entities{
+		Entities: []params.Entity{{Tag: stm.Tag()}},
+	}, &watchResults)
+	c.Assert(err, IsNil)
+	c.Assert(watchResults.Results[0].Error, IsNil)
+	oldId := watchResults.Results[0].NotifyWatcherId
+	err = st1.Call("Pinger", "", "Ping", nil, nil)
+	c.Assert(err, IsNil)
+	session, err := s.State.APISession(result.SessionToken)
+	c.Assert(err, IsNil)
+	c.Assert(session.Watchers(), HasLen, 1)
+
+	// Add a watcher that cannot be resumed.
+	watchers := append(session.Watchers(), state.APISessionWatcher{
+		Id:   "99",
+		Tag:  "machine-99",
+		Type: session.Watchers()[0].Type,
+	})
+	err = s.State.SaveAPISession(result.SessionToken, stm.Tag(), watchers)
+	c.Assert(err, IsNil)
+
+	// The first server fails over; shutting it down
+	// leaves the session to be resumed.
+	err = srv1.Shutdown()
+	c.Assert(err, IsNil)
+
+	srv2 := newServer()
+	defer srv2.Stop()
+	st2 := open(srv2)
+	defer st2.Close()
+	resumed, err := st2.Resume(stm.Tag(), "machine-password", "fake_nonce", result.SessionToken)
+	c.Assert(err, IsNil)
+	c.Assert(resumed.ResumeError, IsNil)
+	c.Assert(resumed.SessionToken, Not(Equals), result.SessionToken)
+	c.Assert(resumed.Watchers, HasLen, 2)
+	c.Assert(resumed.Watchers[0].OldId, Equals, oldId)
+	c.Assert(resumed.Watchers[0].Tag, Equals, stm.Tag())
+	c.Assert(resumed.Watchers[0].NewId, Not(Equals), "")
+	c.Assert(resumed.Watchers[1], DeepEquals, params.ResumedWatcher{OldId: "99", Tag: "machine-99"})
+
+	// The resumed watcher delivers an event straight away.
+	err = st2.Call("NotifyWatcher", resumed.Watchers[0].NewId, "Next", nil, nil)
+	c.Assert(err, IsNil)
+
+	// A session can be resumed only once.
+	st3 := open(srv2)
+	defer st3.Close()
+	again, err := st3.Resume(stm.Tag(), "machine-password", "fake_nonce", result.SessionToken)
+	c.Assert(err, IsNil)
+	c.Assert(again.ResumeError, ErrorMatches, "session not found")
+	c.Assert(again.Watchers, HasLen, 0)
+}
+
+func (s *loginSuite) TestCallDeadlines(c *C) {
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	err = stm.SetProvisioned("foo", "fake_nonce", nil)
+	c.Assert(err, IsNil)
+	err = stm.SetPassword("machine-password")
+	c.Assert(err, IsNil)
+	srv, err := apiserver.NewServer(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey))
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+	info := &api.Info{
+		Addrs:  []string{srv.Addr()},
+		CACert: []byte(coretesting.CACert),
+	}
+	login := func(creds params.Creds) *api.State {
+		st, err := api.Open(info, fastDialOpts)
+		c.Assert(err, IsNil)
+		creds.AuthTag = stm.Tag()
+		creds.Password = "machine-password"
+		creds.Nonce = "fake_nonce"
+		err = st.Call("Admin", "", "Login", &creds, nil)
+		c.Assert(err, IsNil)
+		return st
+	}
+	watch := func(st *api.State) string {
+		var results params.NotifyWatchResults
+		err := st.Call("Machiner", "", "Watch", params.Entities{
+			Entities: []params.Entity{{Tag: stm.Tag()}},
+		}, &results)
+		c.Assert(err, IsNil)
+		c.Assert(results.Results[0].Error, IsNil)
+		return results.Results[0].NotifyWatcherId
+	}
+
+	// The call deadline does not apply to Next.
+	st := login(params.Creds{CallDeadline: 10 * time.Second})
+	defer st.Close()
+	id := watch(st)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		err := stm.SetPassword("another-password")
+		c.Check(err, IsNil)
+	}()
+	err = st.Call("NotifyWatcher", id, "Next", nil, nil)
+	c.Assert(err, IsNil)
+
+	// A Next that overruns the watcher deadline fails,
+	// stopping the watcher, whose next event would
+	// otherwise be lost.
+	st = login(params.Creds{WatcherDeadline: 50 * time.Millisecond})
+	defer st.Close()
+	id = watch(st)
+	err = st.Call("NotifyWatcher", id, "Next", nil, nil)
+	c.Assert(err, ErrorMatches, "timed out")
+	c.Assert(params.ErrCode(err), Equals, params.CodeTimeout)
+	err = st.Call("NotifyWatcher", id, "Next", nil, nil)
+	c.Assert(err, ErrorMatches, "timed out")
+}
+
+func (s *loginSuite) TestAuthMethods(c *C) {
+	for i, test := range []struct {
+		cfg    apiserver.ServerConfig
+		result params.AuthMethodsResult
+	}{{
+		result: params.AuthMethodsResult{
+			Methods: []string{params.AuthMethodPassword},
+		},
+	}, {
+		cfg: apiserver.ServerConfig{
+			ClientCACert: []byte(coretesting.CACert),
+		},
+		result: params.AuthMethodsResult{
+			Methods:            []string{params.AuthMethodPassword, params.AuthMethodClientCert},
+			ClientCertTagField: apiserver.CertFieldCommonName,
+		},
+	}, {
+		cfg: apiserver.ServerConfig{
+			ClientCACert:       []byte(coretesting.CACert),
+			ClientCertTagField: apiserver.CertFieldSerialNumber,
+		},
+		result: params.AuthMethodsResult{
+			Methods:            []string{params.AuthMethodPassword, params.AuthMethodClientCert},
+			ClientCertTagField: apiserver.CertFieldSerialNumber,
+		},
+	}} {
+		c.Logf("test %d", i)
+		srv, err := apiserver.NewServerWithConfig(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey), test.cfg)
+		c.Assert(err, IsNil)
+		c.Assert(srv.AuthMethods(), DeepEquals, test.result)
+
+		// The methods may be fetched before logging in.
+		st, err := api.Open(&api.Info{
+			Addrs:  []string{srv.Addr()},
+			CACert: []byte(coretesting.CACert),
+		}, fastDialOpts)
+		c.Assert(err, IsNil)
+		var result params.AuthMethodsResult
+		err = st.Call("Admin", "", "AuthMethods", nil, &result)
+		c.Check(err, IsNil)
+		c.Check(result, DeepEquals, test.result)
+		st.Close()
+		c.Assert(srv.Stop(), IsNil)
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"launchpad.net/juju-core/state"
 	"launchpad.net/juju-core/state/api/params"
 	"launchpad.net/juju-core/state/apiserver/common"
-	"launchpad.net/juju-core/state/watcher"
 )
 
 // MachinerAPI implements the API used by the machiner worker.
@@ -78,10 +77,36 @@ func (m *MachinerAPI) Watch(args params.Entities) (params.NotifyWatchResults, er
 				// calls to Watch 'transmit' the initial event
 				// in the Watch response. But NotifyWatchers
 				// have no state to transmit.
-				if _, ok := <-watch.Changes(); ok {
-					result.Results[i].NotifyWatcherId = m.resources.Register(watch)
-				} else {
-					err = watcher.MustErr(watch)
+				if err = common.InitialNotifyEvent(m.resources, watch); err == nil {
+					result.Results[i].NotifyWatcherId = m.resources.RegisterFor(watch, entity.Tag)
+				}
+			}
+		}
+		result.Results[i].Error = common.ServerError(err)
+	}
+	return result, nil
+}
+
+// WatchAddresses starts a NotifyWatcher for the addresses of each
+// given machine, so that its agent can act on the machine's new
+// network configuration.
+func (m *MachinerAPI) WatchAddresses(args params.Entities) (params.NotifyWatchResults, error) {
+	result := params.NotifyWatchResults{
+		Results: make([]params.NotifyWatchResult, len(args.Entities)),
+	}
+	if len(args.Entities) == 0 {
+		return result, nil
+	}
+	for i, entity := range args.Entities {
+		err := common.ErrPerm
+		if m.auth.AuthOwner(entity.Tag) {
+			var machine *state.Machine
+			machine, err = m.st.Machine(state.MachineIdFromTag(entity.Tag))
+			if err == nil {
+				watch := machine.WatchAddresses()
+				// Consume the initial event, as for Watch.
+				if err = common.InitialNotifyEvent(m.resources, watch); err == nil {
+					result.Results[i].NotifyWatcherId = m.resources.RegisterFor(watch, entity.Tag)
 				}
 			}
 		}
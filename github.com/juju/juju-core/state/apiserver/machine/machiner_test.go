@@ -177,3 +177,36 @@ func (s *machinerSuite) TestWatch(c *C) {
 	wc := statetesting.NewNotifyWatcherC(c, s.State, resource.(state.NotifyWatcher))
 	wc.AssertNoChange()
 }
+
+func (s *machinerSuite) TestWatchAddresses(c *C) {
+	c.Assert(s.resources.Count(), Equals, 0)
+
+	args := params.Entities{Entities: []params.Entity{
+		{Tag: "machine-1"},
+		{Tag: "machine-0"},
+		{Tag: "machine-42"},
+	}}
+	result, err := s.machiner.WatchAddresses(args)
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, params.NotifyWatchResults{
+		Results: []params.NotifyWatchResult{
+			{NotifyWatcherId: "1"},
+			{Error: apiservertesting.ErrUnauthorized},
+			{Error: apiservertesting.ErrUnauthorized},
+		},
+	})
+
+	// Verify the resource was registered and stop when done
+	c.Assert(s.resources.Count(), Equals, 1)
+	c.Assert(s.resources.Tag("1"), Equals, "machine-1")
+	resource := s.resources.Get("1")
+	defer statetesting.AssertStop(c, resource)
+
+	// The initial event has been consumed; a change
+	// to the addresses is reported.
+	wc := statetesting.NewNotifyWatcherC(c, s.State, resource.(state.NotifyWatcher))
+	wc.AssertNoChange()
+	err = s.machine1.SetAddresses([]state.Address{{Value: "10.0.0.1", Type: state.Ipv4Address}})
+	c.Assert(err, IsNil)
+	wc.AssertOneChange()
+}
@@ -0,0 +1,82 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package machineundertaker
+
+import (
+	"fmt"
+
+	"launchpad.net/juju-core/errors"
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/api/params"
+	"launchpad.net/juju-core/state/apiserver/common"
+)
+
+// MachineUndertakerAPI provides access to the MachineUndertaker API
+// facade, used by the worker that removes dead machines, along
+// with their addresses, instance data and other records, from state.
+type MachineUndertakerAPI struct {
+	st         *state.State
+	resources  *common.Resources
+	authorizer common.Authorizer
+}
+
+// NewMachineUndertakerAPI creates a new server-side MachineUndertaker
+// API facade.
+func NewMachineUndertakerAPI(
+	st *state.State,
+	resources *common.Resources,
+	authorizer common.Authorizer,
+) (*MachineUndertakerAPI, error) {
+	if !authorizer.AuthEnvironManager() {
+		return nil, common.ErrPerm
+	}
+	return &MachineUndertakerAPI{
+		st:         st,
+		resources:  resources,
+		authorizer: authorizer,
+	}, nil
+}
+
+// WatchMachineRemovals starts a StringsWatcher that reports the ids
+// of the machines waiting to be removed: those that are dead. The
+// initial event holds the ids of all the dead machines.
+func (api *MachineUndertakerAPI) WatchMachineRemovals() (params.StringsWatchResult, error) {
+	watch := api.st.WatchMachineRemovals()
+	// Consume the initial event and forward it to the result.
+	changes, err := common.InitialStringsEvent(api.resources, watch)
+	if err != nil {
+		return params.StringsWatchResult{}, err
+	}
+	return params.StringsWatchResult{
+		StringsWatcherId: api.resources.Register(watch),
+		Changes:          changes,
+	}, nil
+}
+
+// CompleteMachineRemovals removes each of the given machines,
+// identified by tag, from state. Each machine must be dead.
+// Machines already removed are not reported as errors.
+func (api *MachineUndertakerAPI) CompleteMachineRemovals(args params.Entities) (params.ErrorResults, error) {
+	result := params.ErrorResults{
+		Errors: make([]*params.Error, len(args.Entities)),
+	}
+	for i, entity := range args.Entities {
+		result.Errors[i] = common.ServerError(api.completeRemoval(entity.Tag))
+	}
+	return result, nil
+}
+
+func (api *MachineUndertakerAPI) completeRemoval(tag string) error {
+	id := state.MachineIdFromTag(tag)
+	if id == "" {
+		return fmt.Errorf("%q is not a machine tag", tag)
+	}
+	machine, err := api.st.Machine(id)
+	if errors.IsNotFoundError(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return machine.Remove()
+}
@@ -0,0 +1,111 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package machineundertaker_test
+
+import (
+	"fmt"
+
+	. "launchpad.net/gocheck"
+
+	"launchpad.net/juju-core/errors"
+	jujutesting "launchpad.net/juju-core/juju/testing"
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/api/params"
+	"launchpad.net/juju-core/state/apiserver/common"
+	"launchpad.net/juju-core/state/apiserver/machineundertaker"
+	apiservertesting "launchpad.net/juju-core/state/apiserver/testing"
+	statetesting "launchpad.net/juju-core/state/testing"
+	"launchpad.net/juju-core/testing/checkers"
+)
+
+type machineUndertakerSuite struct {
+	jujutesting.JujuConnSuite
+
+	machine    *state.Machine
+	api        *machineundertaker.MachineUndertakerAPI
+	resources  *common.Resources
+	authorizer apiservertesting.FakeAuthorizer
+}
+
+var _ = Suite(&machineUndertakerSuite{})
+
+func (s *machineUndertakerSuite) SetUpTest(c *C) {
+	s.JujuConnSuite.SetUpTest(c)
+	s.resources = common.NewResources()
+
+	var err error
+	s.machine, err = s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	err = s.machine.EnsureDead()
+	c.Assert(err, IsNil)
+	s.authorizer = apiservertesting.FakeAuthorizer{
+		Tag:          "machine-0",
+		LoggedIn:     true,
+		Manager:      true,
+		MachineAgent: true,
+	}
+	s.api, err = machineundertaker.NewMachineUndertakerAPI(s.State, s.resources, s.authorizer)
+	c.Assert(err, IsNil)
+}
+
+func (s *machineUndertakerSuite) TearDownTest(c *C) {
+	if s.resources != nil {
+		s.resources.StopAll()
+	}
+	s.JujuConnSuite.TearDownTest(c)
+}
+
+func (s *machineUndertakerSuite) TestRefusesNonManager(c *C) {
+	anAuthorizer := s.authorizer
+	anAuthorizer.Manager = false
+	api, err := machineundertaker.NewMachineUndertakerAPI(s.State, s.resources, anAuthorizer)
+	c.Assert(err, ErrorMatches, "permission denied")
+	c.Assert(api, IsNil)
+}
+
+func (s *machineUndertakerSuite) TestWatchMachineRemovals(c *C) {
+	result, err := s.api.WatchMachineRemovals()
+	c.Assert(err, IsNil)
+	c.Assert(result.Changes, DeepEquals, []string{s.machine.Id()})
+
+	w, ok := s.resources.Get(result.StringsWatcherId).(state.StringsWatcher)
+	c.Assert(ok, Equals, true)
+	wc := statetesting.NewStringsWatcherC(c, s.State, w)
+	wc.AssertNoChange()
+
+	other, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	wc.AssertNoChange()
+	err = other.EnsureDead()
+	c.Assert(err, IsNil)
+	wc.AssertChange(other.Id())
+	wc.AssertNoChange()
+	statetesting.AssertStop(c, w)
+	wc.AssertClosed()
+}
+
+func (s *machineUndertakerSuite) TestCompleteMachineRemovals(c *C) {
+	alive, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	args := params.Entities{Entities: []params.Entity{
+		{Tag: s.machine.Tag()},
+		{Tag: alive.Tag()},
+		{Tag: "machine-42"},
+		{Tag: "service-wordpress"},
+	}}
+	results, err := s.api.CompleteMachineRemovals(args)
+	c.Assert(err, IsNil)
+	c.Assert(results, DeepEquals, params.ErrorResults{
+		Errors: []*params.Error{
+			nil,
+			{Message: fmt.Sprintf("cannot remove machine %s: machine is not dead", alive.Id())},
+			nil,
+			{Message: `"service-wordpress" is not a machine tag`},
+		},
+	})
+	err = s.machine.Refresh()
+	c.Assert(err, checkers.Satisfies, errors.IsNotFoundError)
+	err = alive.Refresh()
+	c.Assert(err, IsNil)
+}
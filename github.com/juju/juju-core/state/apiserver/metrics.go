@@ -0,0 +1,248 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"launchpad.net/juju-core/log"
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/apiserver/common"
+	"launchpad.net/tomb"
+)
+
+// metricsPath is the path under which metrics are served.
+const metricsPath = "/metrics"
+
+// maxMetricTags bounds the number of entity tags used as metric
+// labels. The connections holding the most resources are labelled
+// with their tags; the rest are counted together under otherTag.
+const maxMetricTags = 20
+
+// otherTag labels the metrics of connections not labelled by tag.
+const otherTag = "other"
+
+// latencyQuantiles holds the quantiles reported for latency summaries.
+var latencyQuantiles = []string{"0.5", "0.95", "0.99"}
+
+// WriteMetrics writes the server's metrics to w in the Prometheus
+// text exposition format: the requests served and their latencies
+// for each facade method, the state operations made by them while
+// operation counting is enabled, the lag of watcher events, the
+// watchers held by each type, and the resources and goroutines held
+// by connections.
+func (srv *Server) WriteMetrics(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	writeLatencies(bw, "juju_api_request", "Requests served, by facade and method.", srv.MethodLatencies(), func(l MethodLatency) []string {
+		return []string{"facade", l.Facade, "method", l.Method}
+	})
+	writeCosts(bw, srv.MethodCosts())
+	writeLatencies(bw, "juju_api_watcher_lag", "Time events waited to be read by clients, by watcher type.", srv.WatcherLags(), func(l MethodLatency) []string {
+		return []string{"watcher", l.Facade}
+	})
+
+	conns := srv.Connections()
+	writeHeader(bw, "juju_api_connections", "gauge", "Logged in connections.")
+	fmt.Fprintf(bw, "juju_api_connections %d\n", len(conns))
+
+	srv.mu.Lock()
+	roots := make([]*srvRoot, 0, len(srv.roots))
+	for root := range srv.roots {
+		roots = append(roots, root)
+	}
+	srv.mu.Unlock()
+	watchers := make(map[string]int)
+	for _, root := range roots {
+		for _, r := range root.resources.All() {
+			if kind := common.ResourceKind(r); kind != "" {
+				watchers[kind]++
+			}
+		}
+	}
+	kinds := make([]string, 0, len(watchers))
+	for kind := range watchers {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	writeHeader(bw, "juju_api_watchers", "gauge", "Active watchers, by type.")
+	for _, kind := range kinds {
+		fmt.Fprintf(bw, "juju_api_watchers%s %d\n", labels("watcher", kind), watchers[kind])
+	}
+
+	tagCounts := boundedTagCounts(conns)
+	writeHeader(bw, "juju_api_resources", "gauge", "Resources held by connections, by entity tag.")
+	for _, c := range tagCounts {
+		fmt.Fprintf(bw, "juju_api_resources%s %d\n", labels("tag", c.AuthTag), c.Resources)
+	}
+	writeHeader(bw, "juju_api_goroutines", "gauge", "Goroutines started for connections, by entity tag.")
+	for _, c := range tagCounts {
+		fmt.Fprintf(bw, "juju_api_goroutines%s %d\n", labels("tag", c.AuthTag), c.Goroutines)
+	}
+	return bw.Flush()
+}
+
+// writeLatencies writes the counter and summary metrics for the
+// given latencies, labelled by the label names and values, which
+// alternate, returned by labelsOf.
+func writeLatencies(w io.Writer, name, help string, ls []MethodLatency, labelsOf func(MethodLatency) []string) {
+	writeHeader(w, name+"s_total", "counter", help)
+	for _, l := range ls {
+		fmt.Fprintf(w, "%ss_total%s %d\n", name, labels(labelsOf(l)...), l.Count)
+	}
+	writeHeader(w, name+"_duration_seconds", "summary", help)
+	for _, l := range ls {
+		for i, d := range []time.Duration{l.P50, l.P95, l.P99} {
+			lbs := append(labelsOf(l), "quantile", latencyQuantiles[i])
+			fmt.Fprintf(w, "%s_duration_seconds%s %s\n", name, labels(lbs...), seconds(d))
+		}
+		fmt.Fprintf(w, "%s_duration_seconds_count%s %d\n", name, labels(labelsOf(l)...), l.Count)
+	}
+}
+
+// writeCosts writes the counter metrics for the given method costs.
+func writeCosts(w io.Writer, costs []MethodCost) {
+	for _, m := range []struct {
+		name  string
+		help  string
+		count func(state.OpCounts) uint64
+	}{{
+		"juju_api_request_state_reads_total",
+		"State queries made by requests, by facade and method.",
+		func(ops state.OpCounts) uint64 { return ops.Reads },
+	}, {
+		"juju_api_request_state_transactions_total",
+		"State transactions run by requests, by facade and method.",
+		func(ops state.OpCounts) uint64 { return ops.Transactions },
+	}, {
+		"juju_api_request_state_writes_total",
+		"State document operations run by requests, by facade and method.",
+		func(ops state.OpCounts) uint64 { return ops.Writes },
+	}} {
+		writeHeader(w, m.name, "counter", m.help)
+		for _, cost := range costs {
+			fmt.Fprintf(w, "%s%s %d\n", m.name, labels("facade", cost.Facade, "method", cost.Method), m.count(cost.Ops))
+		}
+	}
+}
+
+func writeHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// labels formats the given label names and values,
+// which alternate, as a Prometheus label set.
+func labels(nameValues ...string) string {
+	parts := make([]string, 0, len(nameValues)/2)
+	for i := 0; i+1 < len(nameValues); i += 2 {
+		parts = append(parts, nameValues[i]+`="`+labelEscaper.Replace(nameValues[i+1])+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
+
+type byResources []ConnectionInfo
+
+func (s byResources) Len() int      { return len(s) }
+func (s byResources) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byResources) Less(i, j int) bool {
+	if s[i].Resources != s[j].Resources {
+		return s[i].Resources > s[j].Resources
+	}
+	return s[i].AuthTag < s[j].AuthTag
+}
+
+// boundedTagCounts sums the resources and goroutines of the given
+// connections by entity tag. Only the maxMetricTags tags holding the
+// most resources are kept; the counts of the others are summed under
+// otherTag, so that the number of labels does not grow with the
+// number of agents. The results are sorted by tag.
+func boundedTagCounts(conns []ConnectionInfo) []ConnectionInfo {
+	sums := make(map[string]*ConnectionInfo)
+	for _, c := range conns {
+		sum := sums[c.AuthTag]
+		if sum == nil {
+			sum = &ConnectionInfo{AuthTag: c.AuthTag}
+			sums[c.AuthTag] = sum
+		}
+		sum.Resources += c.Resources
+		sum.Goroutines += c.Goroutines
+	}
+	counts := make(byResources, 0, len(sums))
+	for _, sum := range sums {
+		counts = append(counts, *sum)
+	}
+	sort.Sort(counts)
+	if len(counts) > maxMetricTags {
+		other := ConnectionInfo{AuthTag: otherTag}
+		for _, c := range counts[maxMetricTags:] {
+			other.Resources += c.Resources
+			other.Goroutines += c.Goroutines
+		}
+		counts = append(counts[:maxMetricTags], other)
+	}
+	sort.Sort(byTag(counts))
+	return counts
+}
+
+type byTag []ConnectionInfo
+
+func (s byTag) Len() int           { return len(s) }
+func (s byTag) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byTag) Less(i, j int) bool { return s[i].AuthTag < s[j].AuthTag }
+
+// serveMetrics serves the server's metrics to the administrative
+// user, who must authenticate with HTTP basic authentication.
+func (srv *Server) serveMetrics(w http.ResponseWriter, req *http.Request) {
+	srv.wg.Add(1)
+	defer srv.wg.Done()
+	if srv.tomb.Err() != tomb.ErrStillAlive {
+		http.NotFound(w, req)
+		return
+	}
+	if !srv.metricsAuthorized(req) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="juju"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := srv.WriteMetrics(w); err != nil {
+		log.Errorf("state/api: cannot write metrics: %v", err)
+	}
+}
+
+// metricsAuthorized reports whether req carries the
+// credentials of the administrative user.
+func (srv *Server) metricsAuthorized(req *http.Request) bool {
+	auth := req.Header.Get("Authorization")
+	const prefix = "Basic "
+	if !strings.HasPrefix(auth, prefix) {
+		return false
+	}
+	data, err := base64.StdEncoding.DecodeString(auth[len(prefix):])
+	if err != nil {
+		return false
+	}
+	creds := strings.SplitN(string(data), ":", 2)
+	if len(creds) != 2 || creds[0] != adminTag {
+		return false
+	}
+	entity, err := srv.state.Authenticator(creds[0])
+	if err != nil {
+		return false
+	}
+	return entity.PasswordValid(creds[1])
+}
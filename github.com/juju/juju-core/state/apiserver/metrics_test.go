@@ -0,0 +1,116 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "launchpad.net/gocheck"
+
+	jujutesting "launchpad.net/juju-core/juju/testing"
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/apiserver"
+	coretesting "launchpad.net/juju-core/testing"
+)
+
+type metricsSuite struct {
+	jujutesting.JujuConnSuite
+}
+
+var _ = Suite(&metricsSuite{})
+
+func (s *metricsSuite) TestWriteMetrics(c *C) {
+	srv, err := apiserver.NewServer(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey))
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	root, err := apiserver.AddWatchingRoot(srv, stm)
+	c.Assert(err, IsNil)
+	defer root.Kill()
+	allow := func(string) bool { return true }
+	_, err = root.WatchEntities([]string{stm.Tag()}, allow)
+	c.Assert(err, IsNil)
+
+	var buf bytes.Buffer
+	err = srv.WriteMetrics(&buf)
+	c.Assert(err, IsNil)
+	metrics := buf.String()
+	for _, line := range []string{
+		"# TYPE juju_api_requests_total counter",
+		"# TYPE juju_api_request_duration_seconds summary",
+		"# TYPE juju_api_request_state_reads_total counter",
+		"# TYPE juju_api_watcher_lags_total counter",
+		"juju_api_connections 1",
+		`juju_api_watchers{watcher="StringsWatcher"} 1`,
+		fmt.Sprintf(`juju_api_resources{tag=%q} 1`, stm.Tag()),
+		fmt.Sprintf(`juju_api_goroutines{tag=%q} 2`, stm.Tag()),
+	} {
+		c.Check(strings.Contains(metrics, line+"\n"), Equals, true, Commentf("missing %q", line))
+	}
+}
+
+func (s *metricsSuite) TestBoundedTagCounts(c *C) {
+	var conns []apiserver.ConnectionInfo
+	for i := 0; i < apiserver.MaxMetricTags+2; i++ {
+		conns = append(conns, apiserver.ConnectionInfo{
+			AuthTag:   fmt.Sprintf("unit-foo-%d", i),
+			Resources: i + 1,
+		})
+	}
+	// Connections of the same entity are summed.
+	conns = append(conns, apiserver.ConnectionInfo{AuthTag: "unit-foo-1", Resources: 100, Goroutines: 3})
+
+	counts := apiserver.BoundedTagCounts(conns)
+	c.Assert(counts, HasLen, apiserver.MaxMetricTags+1)
+	byTag := make(map[string]apiserver.ConnectionInfo)
+	for _, count := range counts {
+		byTag[count.AuthTag] = count
+	}
+	c.Assert(byTag["unit-foo-1"], DeepEquals, apiserver.ConnectionInfo{AuthTag: "unit-foo-1", Resources: 102, Goroutines: 3})
+	// The two entities holding the fewest resources,
+	// other than unit-foo-1, are counted together.
+	c.Assert(byTag["other"], DeepEquals, apiserver.ConnectionInfo{AuthTag: "other", Resources: 1 + 3})
+	_, ok := byTag["unit-foo-0"]
+	c.Assert(ok, Equals, false)
+	_, ok = byTag["unit-foo-2"]
+	c.Assert(ok, Equals, false)
+}
+
+func (s *metricsSuite) TestServeMetricsRequiresAdmin(c *C) {
+	srv, err := apiserver.NewServer(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey))
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	err = stm.SetPassword("password")
+	c.Assert(err, IsNil)
+
+	for i, t := range []struct {
+		tag, password string
+		status        int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"user-admin", "wrong", http.StatusUnauthorized},
+		{stm.Tag(), "password", http.StatusUnauthorized},
+		{"user-admin", "dummy-secret", http.StatusOK},
+	} {
+		c.Logf("test %d: %q", i, t.tag)
+		req, err := http.NewRequest("GET", "https://localhost/metrics", nil)
+		c.Assert(err, IsNil)
+		if t.tag != "" {
+			req.SetBasicAuth(t.tag, t.password)
+		}
+		rec := httptest.NewRecorder()
+		apiserver.ServeMetrics(srv, rec, req)
+		c.Assert(rec.Code, Equals, t.status)
+		if t.status == http.StatusOK {
+			c.Assert(rec.Body.String(), Matches, `(?s).*juju_api_connections 0\n.*`)
+		}
+	}
+}
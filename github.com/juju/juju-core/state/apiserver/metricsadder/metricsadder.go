@@ -0,0 +1,155 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// The metricsadder package implements the API used by the unit agents
+// of metered charms to send their metrics to the state server.
+package metricsadder
+
+import (
+	"time"
+
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/api/params"
+	"launchpad.net/juju-core/state/apiserver/common"
+	"launchpad.net/tomb"
+)
+
+// DefaultSendInterval holds the interval at which unit
+// agents are told to send their metrics by default.
+const DefaultSendInterval = 5 * time.Minute
+
+// MetricsAdderAPI provides access to the MetricsAdder API facade.
+type MetricsAdderAPI struct {
+	st           *state.State
+	resources    *common.Resources
+	authorizer   common.Authorizer
+	sendInterval time.Duration
+}
+
+// NewMetricsAdderAPI creates a new server-side MetricsAdder API
+// facade, which tells unit agents to send their metrics at the given
+// interval.
+func NewMetricsAdderAPI(
+	st *state.State,
+	resources *common.Resources,
+	authorizer common.Authorizer,
+	sendInterval time.Duration,
+) (*MetricsAdderAPI, error) {
+	if !authorizer.AuthUnitAgent() {
+		return nil, common.ErrPerm
+	}
+	return &MetricsAdderAPI{
+		st:           st,
+		resources:    resources,
+		authorizer:   authorizer,
+		sendInterval: sendInterval,
+	}, nil
+}
+
+// AddMetricBatches records each of the given batches of metrics,
+// each of which must be sent by the authenticated unit. A batch
+// whose UUID has already been added by the unit is not added again,
+// so that a batch may safely be resent.
+func (api *MetricsAdderAPI) AddMetricBatches(args params.MetricBatchParams) (params.ErrorResults, error) {
+	result := params.ErrorResults{
+		Errors: make([]*params.Error, len(args.Batches)),
+	}
+	for i, arg := range args.Batches {
+		err := common.ErrPerm
+		if api.authorizer.AuthOwner(arg.Tag) {
+			err = api.addMetricBatch(arg.Tag, arg.Batch)
+		}
+		result.Errors[i] = common.ServerError(err)
+	}
+	return result, nil
+}
+
+func (api *MetricsAdderAPI) addMetricBatch(tag string, batch params.MetricBatch) error {
+	unit, err := api.st.Unit(state.UnitNameFromTag(tag))
+	if err != nil {
+		return err
+	}
+	metrics := make([]state.Metric, len(batch.Metrics))
+	for i, m := range batch.Metrics {
+		metrics[i] = state.Metric{
+			Key:   m.Key,
+			Value: m.Value,
+			Time:  m.Time,
+		}
+	}
+	_, err = unit.AddMetrics(batch.UUID, batch.Created, metrics)
+	return err
+}
+
+// WatchSendInterval starts a NotifyWatcher that fires each time the
+// authenticated unit should send the metrics it has collected.
+func (api *MetricsAdderAPI) WatchSendInterval() (params.NotifyWatchResult, error) {
+	watch := newIntervalWatcher(api.sendInterval)
+	// Consume the initial event; the client's first call
+	// to Next returns when the first interval has passed.
+	if err := common.InitialNotifyEvent(api.resources, watch); err != nil {
+		return params.NotifyWatchResult{}, err
+	}
+	return params.NotifyWatchResult{
+		NotifyWatcherId: api.resources.Register(watch),
+	}, nil
+}
+
+// intervalWatcher implements a NotifyWatcher that sends an
+// initial event, followed by an event each time the given
+// interval passes.
+type intervalWatcher struct {
+	tomb     tomb.Tomb
+	interval time.Duration
+	out      chan struct{}
+}
+
+func newIntervalWatcher(interval time.Duration) *intervalWatcher {
+	w := &intervalWatcher{
+		interval: interval,
+		out:      make(chan struct{}),
+	}
+	go func() {
+		defer w.tomb.Done()
+		defer close(w.out)
+		w.tomb.Kill(w.loop())
+	}()
+	return w
+}
+
+// Stop stops the watcher, and returns any error encountered while
+// running or shutting down.
+func (w *intervalWatcher) Stop() error {
+	w.tomb.Kill(nil)
+	return w.tomb.Wait()
+}
+
+// Err returns any error encountered while running or shutting down,
+// or tomb.ErrStillAlive if the watcher is still running.
+func (w *intervalWatcher) Err() error {
+	return w.tomb.Err()
+}
+
+// Changes returns the event channel for the watcher.
+func (w *intervalWatcher) Changes() <-chan struct{} {
+	return w.out
+}
+
+func (w *intervalWatcher) loop() error {
+	for {
+		select {
+		case <-w.tomb.Dying():
+			return tomb.ErrDying
+		case w.out <- struct{}{}:
+		}
+		// Intervals are counted from the delivery of each
+		// event, so that events do not queue up behind a
+		// client that is slow to ask for them.
+		select {
+		case <-w.tomb.Dying():
+			return tomb.ErrDying
+		case <-time.After(w.interval):
+		}
+	}
+	panic("unreachable")
+}
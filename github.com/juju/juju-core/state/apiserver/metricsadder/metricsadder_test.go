@@ -0,0 +1,123 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package metricsadder_test
+
+import (
+	"time"
+
+	. "launchpad.net/gocheck"
+
+	jujutesting "launchpad.net/juju-core/juju/testing"
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/api/params"
+	"launchpad.net/juju-core/state/apiserver/common"
+	"launchpad.net/juju-core/state/apiserver/metricsadder"
+	apiservertesting "launchpad.net/juju-core/state/apiserver/testing"
+	statetesting "launchpad.net/juju-core/state/testing"
+	coretesting "launchpad.net/juju-core/testing"
+)
+
+type metricsAdderSuite struct {
+	jujutesting.JujuConnSuite
+
+	unit       *state.Unit
+	api        *metricsadder.MetricsAdderAPI
+	resources  *common.Resources
+	authorizer apiservertesting.FakeAuthorizer
+}
+
+var _ = Suite(&metricsAdderSuite{})
+
+const batchUUID = "1b4a2c5d-6e7f-4a8b-9c0d-1e2f3a4b5c6d"
+
+func (s *metricsAdderSuite) SetUpTest(c *C) {
+	s.JujuConnSuite.SetUpTest(c)
+	s.resources = common.NewResources()
+
+	svc, err := s.State.AddService("wordpress", s.AddTestingCharm(c, "wordpress"))
+	c.Assert(err, IsNil)
+	s.unit, err = svc.AddUnit()
+	c.Assert(err, IsNil)
+	s.authorizer = apiservertesting.FakeAuthorizer{
+		Tag:       s.unit.Tag(),
+		LoggedIn:  true,
+		UnitAgent: true,
+	}
+	s.api, err = metricsadder.NewMetricsAdderAPI(s.State, s.resources, s.authorizer, time.Hour)
+	c.Assert(err, IsNil)
+}
+
+func (s *metricsAdderSuite) TearDownTest(c *C) {
+	if s.resources != nil {
+		s.resources.StopAll()
+	}
+	s.JujuConnSuite.TearDownTest(c)
+}
+
+func (s *metricsAdderSuite) TestRequiresUnitAgent(c *C) {
+	anAuthorizer := s.authorizer
+	anAuthorizer.UnitAgent = false
+	anAuthorizer.MachineAgent = true
+	api, err := metricsadder.NewMetricsAdderAPI(s.State, s.resources, anAuthorizer, time.Hour)
+	c.Assert(err, ErrorMatches, "permission denied")
+	c.Assert(api, IsNil)
+}
+
+func (s *metricsAdderSuite) TestAddMetricBatches(c *C) {
+	created := time.Date(2013, 10, 1, 12, 0, 0, 0, time.UTC)
+	batch := params.MetricBatch{
+		UUID:    batchUUID,
+		Created: created,
+		Metrics: []params.Metric{{Key: "pings", Value: "5", Time: created}},
+	}
+	args := params.MetricBatchParams{Batches: []params.MetricBatchParam{
+		{Tag: s.unit.Tag(), Batch: batch},
+		// Resending a batch is harmless.
+		{Tag: s.unit.Tag(), Batch: batch},
+		{Tag: s.unit.Tag(), Batch: params.MetricBatch{UUID: "bad", Metrics: batch.Metrics}},
+		{Tag: "unit-wordpress-1", Batch: batch},
+	}}
+	result, err := s.api.AddMetricBatches(args)
+	c.Assert(err, IsNil)
+	c.Assert(result.Errors, HasLen, 4)
+	c.Assert(result.Errors[0], IsNil)
+	c.Assert(result.Errors[1], IsNil)
+	c.Assert(result.Errors[2], ErrorMatches, `cannot add metrics: invalid batch UUID "bad"`)
+	c.Assert(result.Errors[3], DeepEquals, apiservertesting.ErrUnauthorized)
+
+	added, err := s.State.MetricBatch(batchUUID)
+	c.Assert(err, IsNil)
+	c.Assert(added.Unit(), Equals, s.unit.Name())
+	c.Assert(added.Metrics(), HasLen, 1)
+	c.Assert(added.Metrics()[0].Value, Equals, "5")
+}
+
+func (s *metricsAdderSuite) TestWatchSendInterval(c *C) {
+	result, err := s.api.WatchSendInterval()
+	c.Assert(err, IsNil)
+	c.Assert(result.Error, IsNil)
+	w, ok := s.resources.Get(result.NotifyWatcherId).(state.NotifyWatcher)
+	c.Assert(ok, Equals, true)
+	wc := statetesting.NewNotifyWatcherC(c, s.State, w)
+	// The initial event has been consumed, and
+	// the first interval has not yet passed.
+	wc.AssertNoChange()
+	statetesting.AssertStop(c, w)
+	wc.AssertClosed()
+
+	api, err := metricsadder.NewMetricsAdderAPI(s.State, s.resources, s.authorizer, time.Millisecond)
+	c.Assert(err, IsNil)
+	result, err = api.WatchSendInterval()
+	c.Assert(err, IsNil)
+	w = s.resources.Get(result.NotifyWatcherId).(state.NotifyWatcher)
+	defer statetesting.AssertStop(c, w)
+	for i := 0; i < 2; i++ {
+		select {
+		case _, ok := <-w.Changes():
+			c.Assert(ok, Equals, true)
+		case <-time.After(coretesting.LongWait):
+			c.Fatalf("timed out waiting for event %d", i)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package metricsadder_test
+
+import (
+	coretesting "launchpad.net/juju-core/testing"
+	stdtesting "testing"
+)
+
+func TestAll(t *stdtesting.T) {
+	coretesting.MgoTestPackage(t)
+}
@@ -0,0 +1,86 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"reflect"
+
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/apiserver/common"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// CheckPermission returns nil if the authenticated entity would be
+// allowed to call the given facade method on the entity with the
+// given tag, and common.ErrPerm if not, without making the call. It
+// runs the same checks as a real call: those made when the facade is
+// obtained, then the facade's check of the entities it is given. An
+// empty targetTag checks access to the facade method alone. Unknown
+// facades and methods yield common.ErrBadRequest.
+func (r *srvRoot) CheckPermission(facade, method, targetTag string) error {
+	api, err := r.obtainFacade(facade)
+	if err != nil {
+		return err
+	}
+	if _, ok := reflect.TypeOf(api).MethodByName(method); !ok {
+		return common.ErrBadRequest
+	}
+	if targetTag == "" {
+		return nil
+	}
+	authFunc := common.AuthFunc(func(tag string) bool {
+		return r.AuthOwner(tag)
+	})
+	if a, ok := api.(common.MethodAuthorizer); ok {
+		if authFunc, err = a.MethodAuthFunc(method); err != nil {
+			return err
+		}
+	}
+	if !authFunc(targetTag) {
+		return common.ErrPerm
+	}
+	return nil
+}
+
+// obtainFacade returns the facade with the given name, obtained
+// as the RPC layer would through the srvRoot method of that name.
+func (r *srvRoot) obtainFacade(facade string) (interface{}, error) {
+	m := reflect.ValueOf(r).MethodByName(facade)
+	if !m.IsValid() {
+		return nil, common.ErrBadRequest
+	}
+	t := m.Type()
+	if t.NumIn() != 1 || t.In(0).Kind() != reflect.String ||
+		t.NumOut() != 2 || t.Out(1) != errorType {
+		return nil, common.ErrBadRequest
+	}
+	if err := r.authorizeFacade(facade); err != nil {
+		return nil, err
+	}
+	out := m.Call([]reflect.Value{reflect.ValueOf("")})
+	if err, _ := out[1].Interface().(error); err != nil {
+		return nil, err
+	}
+	return out[0].Interface(), nil
+}
+
+// ValidateTag checks the given tag as a real call would, without
+// making one. A tag that is not well formed yields a
+// common.BadRequestError. Clients and environment managers may then
+// learn whether the entity exists, an error satisfying
+// errors.IsNotFoundError being returned if it does not; other
+// entities may see only themselves, and are given common.ErrPerm for
+// any other tag, whether or not its entity exists, so that they
+// cannot probe for entities they are not allowed to see.
+func (r *srvRoot) ValidateTag(tag string) error {
+	if err := state.CheckTag(tag); err != nil {
+		return &common.BadRequestError{Field: "Tag", Reason: err.Error()}
+	}
+	if !r.AuthClient() && !r.AuthEnvironManager() && !r.AuthOwner(tag) {
+		return common.ErrPerm
+	}
+	_, err := r.srv.state.Tagger(tag)
+	return err
+}
@@ -0,0 +1,92 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver_test
+
+import (
+	. "launchpad.net/gocheck"
+	"launchpad.net/juju-core/errors"
+	jujutesting "launchpad.net/juju-core/juju/testing"
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/apiserver"
+	"launchpad.net/juju-core/state/apiserver/common"
+	coretesting "launchpad.net/juju-core/testing"
+)
+
+type permissionSuite struct {
+	jujutesting.JujuConnSuite
+}
+
+var _ = Suite(&permissionSuite{})
+
+func (s *permissionSuite) TestCheckPermission(c *C) {
+	srv, err := apiserver.NewServer(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey))
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+
+	m0, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	m1, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	svc, err := s.State.AddService("wordpress", s.AddTestingCharm(c, "wordpress"))
+	c.Assert(err, IsNil)
+	u0, err := svc.AddUnit()
+	c.Assert(err, IsNil)
+	err = u0.AssignToMachine(m0)
+	c.Assert(err, IsNil)
+	u1, err := svc.AddUnit()
+	c.Assert(err, IsNil)
+
+	for i, test := range []struct {
+		facade string
+		method string
+		target string
+		err    error
+	}{
+		{"Machiner", "Life", m0.Tag(), nil},
+		{"Machiner", "Life", "", nil},
+		{"Machiner", "Life", m1.Tag(), common.ErrPerm},
+		{"Machiner", "NoSuchMethod", m0.Tag(), common.ErrBadRequest},
+		{"NoSuchFacade", "Life", m0.Tag(), common.ErrBadRequest},
+		{"Deployer", "WatchUnits", m0.Tag(), nil},
+		{"Deployer", "Life", u0.Tag(), nil},
+		{"Deployer", "Life", u1.Tag(), common.ErrPerm},
+		{"Client", "Status", "", common.ErrPerm},
+		{"LeadershipService", "ClaimLeadership", svc.Tag(), common.ErrPerm},
+	} {
+		c.Logf("test %d: %s.%s on %q", i, test.facade, test.method, test.target)
+		err := apiserver.CheckPermission(srv, m0, test.facade, test.method, test.target)
+		c.Check(err, Equals, test.err)
+	}
+}
+
+func (s *permissionSuite) TestValidateTag(c *C) {
+	srv, err := apiserver.NewServer(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey))
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+
+	m0, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	admin, err := s.State.User("admin")
+	c.Assert(err, IsNil)
+
+	// Malformed tags are reported to anyone.
+	for _, tag := range []string{"", "machine", "machine-x", "unit-wordpress", "foo-1"} {
+		for _, entity := range []state.TaggedAuthenticator{m0, admin} {
+			err := apiserver.ValidateTag(srv, entity, tag)
+			c.Check(err, ErrorMatches, `invalid request: Tag: invalid entity tag ".*"`)
+		}
+	}
+
+	// Clients learn whether entities exist.
+	c.Assert(apiserver.ValidateTag(srv, admin, m0.Tag()), IsNil)
+	err = apiserver.ValidateTag(srv, admin, "machine-42")
+	c.Assert(err, ErrorMatches, `machine 42 not found`)
+	c.Assert(errors.IsNotFoundError(err), Equals, true)
+
+	// Agents may see only themselves, and cannot tell
+	// other entities that exist from those that do not.
+	c.Assert(apiserver.ValidateTag(srv, m0, m0.Tag()), IsNil)
+	c.Assert(apiserver.ValidateTag(srv, m0, admin.Tag()), Equals, common.ErrPerm)
+	c.Assert(apiserver.ValidateTag(srv, m0, "machine-42"), Equals, common.ErrPerm)
+}
@@ -8,6 +8,7 @@ import (
 	"launchpad.net/juju-core/juju/testing"
 	"launchpad.net/juju-core/state"
 	"launchpad.net/juju-core/state/api"
+	"launchpad.net/juju-core/state/api/params"
 	"time"
 )
 
@@ -54,3 +55,42 @@ func (s *stateSuite) TestConnectionBrokenDetection(c *C) {
 		return
 	}
 }
+
+func (s *stateSuite) TestPingEchoesNonce(c *C) {
+	var result params.PingResult
+	err := s.APIState.Call("Pinger", "", "Ping", params.Ping{Nonce: "nonce"}, &result)
+	c.Assert(err, IsNil)
+	c.Assert(result.Nonce, Equals, "nonce")
+	c.Assert(result.ServerTime.IsZero(), Equals, false)
+
+	// Without a nonce, nothing is returned.
+	result = params.PingResult{}
+	err = s.APIState.Call("Pinger", "", "Ping", nil, &result)
+	c.Assert(err, IsNil)
+	c.Assert(result.Nonce, Equals, "")
+	c.Assert(result.ServerTime.IsZero(), Equals, true)
+}
+
+func (s *stateSuite) TestMeasureLatency(c *C) {
+	rtt, skew, err := s.APIState.MeasureLatency()
+	c.Assert(err, IsNil)
+	c.Assert(rtt > 0, Equals, true)
+	// Client and server share a clock.
+	if skew < 0 {
+		skew = -skew
+	}
+	c.Assert(skew <= rtt, Equals, true)
+}
+
+func (s *stateSuite) TestConnectionHealth(c *C) {
+	err := s.APIState.Call("Pinger", "", "Ping", nil, nil)
+	c.Assert(err, IsNil)
+	var health params.ConnectionHealth
+	err = s.APIState.Call("Pinger", "", "ConnectionHealth", nil, &health)
+	c.Assert(err, IsNil)
+	c.Assert(health.Degraded, Equals, false)
+	c.Assert(health.DrainInProgress, Equals, false)
+	c.Assert(health.BreakerOpen, Equals, false)
+	c.Assert(health.SlowRequests, Equals, 0)
+	c.Assert(health.LastPingAge < time.Minute, Equals, true)
+}
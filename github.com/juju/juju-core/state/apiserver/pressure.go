@@ -0,0 +1,103 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"launchpad.net/juju-core/log"
+)
+
+// memoryCheckInterval holds the interval at which the server
+// checks its heap usage against ServerConfig.HeapWatermark.
+var memoryCheckInterval = 5 * time.Second
+
+// heapInUse returns the number of bytes of heap in use.
+var heapInUse = func() uint64 {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
+
+// The defaults for the coarser delivery of StringsWatcher
+// events while the server is under memory pressure.
+const (
+	defaultDegradedBatchWindow = time.Second
+	defaultDegradedMaxChanges  = 100
+)
+
+// monitorMemory checks the server's heap usage at intervals until
+// the server is stopped, switching watchers in and out of degraded
+// delivery as the usage crosses the watermark.
+func (srv *Server) monitorMemory() {
+	defer srv.wg.Done()
+	for {
+		select {
+		case <-srv.tomb.Dying():
+			return
+		case <-time.After(memoryCheckInterval):
+		}
+		srv.checkMemory(heapInUse())
+	}
+	panic("unreachable")
+}
+
+// checkMemory enters degraded delivery if the given heap usage is
+// at or above the watermark, and leaves it once the usage has fallen
+// a tenth below the watermark, so that usage hovering around the
+// watermark does not switch the mode at every check.
+func (srv *Server) checkMemory(heap uint64) {
+	watermark := srv.cfg.HeapWatermark
+	switch {
+	case !srv.Degraded() && heap >= watermark:
+		atomic.StoreInt32(&srv.degraded, 1)
+		log.Warningf("state/api: heap usage %d at or above watermark %d; degrading watcher delivery", heap, watermark)
+	case srv.Degraded() && heap < watermark-watermark/10:
+		atomic.StoreInt32(&srv.degraded, 0)
+		log.Infof("state/api: heap usage %d below watermark %d; restoring watcher delivery", heap, watermark)
+	}
+}
+
+// Degraded reports whether the server is under memory pressure, and
+// so delivering StringsWatcher events in coarser batches; see
+// ServerConfig.HeapWatermark.
+func (srv *Server) Degraded() bool {
+	return atomic.LoadInt32(&srv.degraded) != 0
+}
+
+// coalesceStrings returns the given changes, just received from ch,
+// as they are unless the server is degraded; otherwise it waits for
+// the degraded batch window, or until ch is closed or the connection
+// is killed, merging in the changes received meanwhile.
+func (r *srvRoot) coalesceStrings(ch <-chan []string, changes []string) []string {
+	if !r.srv.Degraded() {
+		return changes
+	}
+	seen := make(map[string]bool)
+	for _, change := range changes {
+		seen[change] = true
+	}
+	window := time.After(r.srv.cfg.DegradedBatchWindow)
+	for {
+		select {
+		case more, ok := <-ch:
+			if !ok {
+				return changes
+			}
+			for _, change := range more {
+				if !seen[change] {
+					seen[change] = true
+					changes = append(changes, change)
+				}
+			}
+		case <-window:
+			return changes
+		case <-r.dying:
+			return changes
+		}
+	}
+	panic("unreachable")
+}
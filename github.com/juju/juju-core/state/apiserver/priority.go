@@ -0,0 +1,141 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"strings"
+	"sync"
+
+	"launchpad.net/juju-core/rpc"
+	"launchpad.net/juju-core/state/apiserver/common"
+)
+
+// defaultRequestPriorities holds the priority given to the requests
+// of each kind of entity, as named by the prefix of its tag, when
+// ServerConfig.RequestPriorities does not say otherwise. Machine
+// agents, on which the environment depends, come before clients,
+// which come before the unit agents that are most numerous.
+var defaultRequestPriorities = map[string]int{
+	"machine": 2,
+	"user":    1,
+	"unit":    0,
+}
+
+// requestPriority returns the priority with which the requests of
+// the entity with the given tag are served when the server is busy.
+func (srv *Server) requestPriority(tag string) int {
+	kind := tag
+	if i := strings.Index(tag, "-"); i >= 0 {
+		kind = tag[:i]
+	}
+	if p, ok := srv.cfg.RequestPriorities[kind]; ok {
+		return p
+	}
+	return defaultRequestPriorities[kind]
+}
+
+// requestSlots limits the number of requests served at once. When
+// all slots are taken, a freed slot goes to the waiting request of
+// highest priority, and to the longest waiting of those of equal
+// priority.
+type requestSlots struct {
+	mu      sync.Mutex
+	free    int
+	waiters []*slotWaiter
+}
+
+// slotWaiter is a request waiting for a slot; ready
+// is closed when the slot has been given to it.
+type slotWaiter struct {
+	priority int
+	ready    chan struct{}
+}
+
+// newRequestSlots returns a requestSlots with max slots,
+// or nil, which never limits requests, if max is not positive.
+func newRequestSlots(max int) *requestSlots {
+	if max <= 0 {
+		return nil
+	}
+	return &requestSlots{free: max}
+}
+
+// acquire waits for a slot for a request of the given priority,
+// and reports whether it got one before abort was closed. A slot
+// acquired must be released with release.
+func (s *requestSlots) acquire(priority int, abort <-chan struct{}) bool {
+	if s == nil {
+		return true
+	}
+	s.mu.Lock()
+	if s.free > 0 && len(s.waiters) == 0 {
+		s.free--
+		s.mu.Unlock()
+		return true
+	}
+	w := &slotWaiter{
+		priority: priority,
+		ready:    make(chan struct{}),
+	}
+	s.waiters = append(s.waiters, w)
+	s.mu.Unlock()
+	select {
+	case <-w.ready:
+		return true
+	case <-abort:
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, other := range s.waiters {
+		if other == w {
+			s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+			return false
+		}
+	}
+	// The slot was given to us as we gave up; pass it on.
+	s.releaseLocked()
+	return false
+}
+
+// release frees a slot acquired with acquire.
+func (s *requestSlots) release() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.releaseLocked()
+}
+
+func (s *requestSlots) releaseLocked() {
+	if len(s.waiters) == 0 {
+		s.free++
+		return
+	}
+	best := 0
+	for i, w := range s.waiters {
+		if w.priority > s.waiters[best].priority {
+			best = i
+		}
+	}
+	w := s.waiters[best]
+	s.waiters = append(s.waiters[:best], s.waiters[best+1:]...)
+	close(w.ready)
+}
+
+// acquireSlot waits for a request slot for the given request, failing
+// with common.ErrTryAgain if the connection is killed meanwhile.
+// Calls to Next on established watchers, which may block for as long
+// as the watcher has no events, do not take a slot; the returned
+// function releases the slot, if one was taken.
+func (r *srvRoot) acquireSlot(req rpc.Request) (func(), error) {
+	slots := r.srv.requestSlots
+	if slots == nil || req.Action == "Next" && r.resources.Get(req.Id) != nil {
+		return func() {}, nil
+	}
+	if !slots.acquire(r.priority, r.dying) {
+		return nil, common.ErrTryAgain
+	}
+	return func() { slots.release() }, nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver_test
+
+import (
+	"time"
+
+	. "launchpad.net/gocheck"
+	"launchpad.net/juju-core/state/apiserver"
+	"launchpad.net/juju-core/testing"
+	"launchpad.net/juju-core/utils"
+)
+
+type requestSlotsSuite struct {
+	testing.LoggingSuite
+}
+
+var _ = Suite(&requestSlotsSuite{})
+
+func (s *requestSlotsSuite) TestUnlimited(c *C) {
+	slots := apiserver.NewRequestSlots(0)
+	for i := 0; i < 10; i++ {
+		c.Assert(slots.Acquire(0, nil), Equals, true)
+	}
+}
+
+// waitForWaiting waits until n requests are waiting for a slot.
+func waitForWaiting(c *C, slots apiserver.RequestSlots, n int) {
+	attempt := utils.AttemptStrategy{
+		Total: testing.LongWait,
+		Delay: 10 * time.Millisecond,
+	}
+	for a := attempt.Start(); a.Next(); {
+		if slots.Waiting() == n {
+			return
+		}
+	}
+	c.Fatalf("expected %d waiting requests, got %d", n, slots.Waiting())
+}
+
+func (s *requestSlotsSuite) TestHighestPriorityFirst(c *C) {
+	slots := apiserver.NewRequestSlots(1)
+	c.Assert(slots.Acquire(0, nil), Equals, true)
+
+	served := make(chan int, 3)
+	for i, p := range []int{0, 2, 1} {
+		go func(p int) {
+			if slots.Acquire(p, nil) {
+				served <- p
+			}
+		}(p)
+		waitForWaiting(c, slots, i+1)
+	}
+	for _, expect := range []int{2, 1, 0} {
+		slots.Release()
+		select {
+		case p := <-served:
+			c.Assert(p, Equals, expect)
+		case <-time.After(testing.LongWait):
+			c.Fatalf("no request given the slot")
+		}
+	}
+}
+
+func (s *requestSlotsSuite) TestFirstComeFirstServedWithinPriority(c *C) {
+	slots := apiserver.NewRequestSlots(1)
+	c.Assert(slots.Acquire(0, nil), Equals, true)
+
+	served := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func(i int) {
+			if slots.Acquire(1, nil) {
+				served <- i
+			}
+		}(i)
+		waitForWaiting(c, slots, i+1)
+	}
+	for expect := 0; expect < 2; expect++ {
+		slots.Release()
+		select {
+		case i := <-served:
+			c.Assert(i, Equals, expect)
+		case <-time.After(testing.LongWait):
+			c.Fatalf("no request given the slot")
+		}
+	}
+}
+
+func (s *requestSlotsSuite) TestAbort(c *C) {
+	slots := apiserver.NewRequestSlots(1)
+	c.Assert(slots.Acquire(0, nil), Equals, true)
+
+	abort := make(chan struct{})
+	done := make(chan bool)
+	go func() {
+		done <- slots.Acquire(2, abort)
+	}()
+	waitForWaiting(c, slots, 1)
+	close(abort)
+	select {
+	case ok := <-done:
+		c.Assert(ok, Equals, false)
+	case <-time.After(testing.LongWait):
+		c.Fatalf("acquire not aborted")
+	}
+	c.Assert(slots.Waiting(), Equals, 0)
+
+	// The aborted request did not take the slot when it was freed.
+	slots.Release()
+	c.Assert(slots.Acquire(0, nil), Equals, true)
+}
@@ -0,0 +1,63 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package proxyupdater
+
+import (
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/api/params"
+	"launchpad.net/juju-core/state/apiserver/common"
+)
+
+// ProxyUpdaterAPI provides access to the ProxyUpdater API facade,
+// through which agents learn the proxy settings of their environment.
+type ProxyUpdaterAPI struct {
+	st         *state.State
+	resources  *common.Resources
+	authorizer common.Authorizer
+}
+
+// NewProxyUpdaterAPI creates a new server-side ProxyUpdater API facade.
+func NewProxyUpdaterAPI(
+	st *state.State,
+	resources *common.Resources,
+	authorizer common.Authorizer,
+) (*ProxyUpdaterAPI, error) {
+	if !authorizer.AuthMachineAgent() && !authorizer.AuthUnitAgent() {
+		return nil, common.ErrPerm
+	}
+	return &ProxyUpdaterAPI{
+		st:         st,
+		resources:  resources,
+		authorizer: authorizer,
+	}, nil
+}
+
+// ProxyConfig returns the proxy settings of the environment.
+func (api *ProxyUpdaterAPI) ProxyConfig() (params.ProxyConfig, error) {
+	cfg, err := api.st.EnvironConfig()
+	if err != nil {
+		return params.ProxyConfig{}, err
+	}
+	return params.ProxyConfig{
+		HTTP:    cfg.HTTPProxy(),
+		HTTPS:   cfg.HTTPSProxy(),
+		NoProxy: cfg.NoProxy(),
+	}, nil
+}
+
+// WatchForProxyConfigChanges starts a NotifyWatcher that fires when
+// the proxy settings returned by ProxyConfig may have changed. It
+// fires whenever the environment configuration changes, so the
+// settings may turn out to be unchanged.
+func (api *ProxyUpdaterAPI) WatchForProxyConfigChanges() (params.NotifyWatchResult, error) {
+	watch := api.st.WatchForEnvironConfigChanges()
+	// Consume the initial event; NotifyWatchers
+	// have no state to transmit.
+	if err := common.InitialNotifyEvent(api.resources, watch); err != nil {
+		return params.NotifyWatchResult{}, err
+	}
+	return params.NotifyWatchResult{
+		NotifyWatcherId: api.resources.Register(watch),
+	}, nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package proxyupdater_test
+
+import (
+	. "launchpad.net/gocheck"
+
+	jujutesting "launchpad.net/juju-core/juju/testing"
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/api/params"
+	"launchpad.net/juju-core/state/apiserver/common"
+	"launchpad.net/juju-core/state/apiserver/proxyupdater"
+	apiservertesting "launchpad.net/juju-core/state/apiserver/testing"
+	statetesting "launchpad.net/juju-core/state/testing"
+)
+
+type proxyUpdaterSuite struct {
+	jujutesting.JujuConnSuite
+
+	api        *proxyupdater.ProxyUpdaterAPI
+	resources  *common.Resources
+	authorizer apiservertesting.FakeAuthorizer
+}
+
+var _ = Suite(&proxyUpdaterSuite{})
+
+func (s *proxyUpdaterSuite) SetUpTest(c *C) {
+	s.JujuConnSuite.SetUpTest(c)
+	s.resources = common.NewResources()
+
+	machine, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	s.authorizer = apiservertesting.FakeAuthorizer{
+		Tag:          machine.Tag(),
+		LoggedIn:     true,
+		MachineAgent: true,
+	}
+	s.api, err = proxyupdater.NewProxyUpdaterAPI(s.State, s.resources, s.authorizer)
+	c.Assert(err, IsNil)
+}
+
+func (s *proxyUpdaterSuite) TearDownTest(c *C) {
+	if s.resources != nil {
+		s.resources.StopAll()
+	}
+	s.JujuConnSuite.TearDownTest(c)
+}
+
+func (s *proxyUpdaterSuite) setProxies(c *C, attrs map[string]interface{}) {
+	cfg, err := s.State.EnvironConfig()
+	c.Assert(err, IsNil)
+	cfg, err = cfg.Apply(attrs)
+	c.Assert(err, IsNil)
+	err = s.State.SetEnvironConfig(cfg)
+	c.Assert(err, IsNil)
+}
+
+func (s *proxyUpdaterSuite) TestRefusesClient(c *C) {
+	anAuthorizer := s.authorizer
+	anAuthorizer.MachineAgent = false
+	anAuthorizer.Client = true
+	api, err := proxyupdater.NewProxyUpdaterAPI(s.State, s.resources, anAuthorizer)
+	c.Assert(err, ErrorMatches, "permission denied")
+	c.Assert(api, IsNil)
+}
+
+func (s *proxyUpdaterSuite) TestProxyConfig(c *C) {
+	result, err := s.api.ProxyConfig()
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, params.ProxyConfig{})
+
+	s.setProxies(c, map[string]interface{}{
+		"http-proxy":  "http://proxy.example.com:3128",
+		"https-proxy": "https://proxy.example.com:3129",
+		"no-proxy":    "localhost",
+	})
+	result, err = s.api.ProxyConfig()
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, params.ProxyConfig{
+		HTTP:    "http://proxy.example.com:3128",
+		HTTPS:   "https://proxy.example.com:3129",
+		NoProxy: "localhost",
+	})
+}
+
+func (s *proxyUpdaterSuite) TestWatchForProxyConfigChanges(c *C) {
+	result, err := s.api.WatchForProxyConfigChanges()
+	c.Assert(err, IsNil)
+	c.Assert(result.Error, IsNil)
+
+	w, ok := s.resources.Get(result.NotifyWatcherId).(state.NotifyWatcher)
+	c.Assert(ok, Equals, true)
+	wc := statetesting.NewNotifyWatcherC(c, s.State, w)
+	wc.AssertNoChange()
+
+	s.setProxies(c, map[string]interface{}{"http-proxy": "http://proxy.example.com:3128"})
+	wc.AssertOneChange()
+	statetesting.AssertStop(c, w)
+	wc.AssertClosed()
+}
@@ -0,0 +1,101 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"time"
+
+	"launchpad.net/juju-core/state/api/params"
+	"launchpad.net/juju-core/state/apiserver/common"
+)
+
+// maxRateLimitInterval bounds the interval of a watcher rate limit,
+// and hence the time Next may be held back by one.
+const maxRateLimitInterval = 10 * time.Minute
+
+// rateLimiter caps the rate of a watcher's deliveries.
+type rateLimiter struct {
+	count    int
+	interval time.Duration
+
+	// sent holds the times of the latest deliveries,
+	// at most count of them, oldest first.
+	sent []time.Time
+}
+
+// delay returns how long after now the next delivery must wait.
+func (l *rateLimiter) delay(now time.Time) time.Duration {
+	if len(l.sent) < l.count {
+		return 0
+	}
+	if d := l.sent[0].Add(l.interval).Sub(now); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// note records a delivery at the given time.
+func (l *rateLimiter) note(now time.Time) {
+	if len(l.sent) == l.count {
+		l.sent = append(l.sent[:0], l.sent[1:]...)
+	}
+	l.sent = append(l.sent, now)
+}
+
+// setRateLimit caps the rate of deliveries of the watcher with the
+// given resource id. A zero count removes any cap.
+func (r *srvRoot) setRateLimit(id string, limit params.WatcherRateLimit) error {
+	switch {
+	case limit.Count < 0:
+		return &common.BadRequestError{Field: "Count", Reason: "must not be negative"}
+	case limit.Count > 0 && limit.Interval <= 0:
+		return &common.BadRequestError{Field: "Interval", Reason: "must be positive"}
+	case limit.Interval > maxRateLimitInterval:
+		return &common.BadRequestError{Field: "Interval", Reason: "must be at most " + maxRateLimitInterval.String()}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if limit.Count == 0 {
+		delete(r.rateLimits, id)
+		return nil
+	}
+	if r.rateLimits == nil {
+		r.rateLimits = make(map[string]*rateLimiter)
+	}
+	r.rateLimits[id] = &rateLimiter{
+		count:    limit.Count,
+		interval: limit.Interval,
+	}
+	return nil
+}
+
+// awaitRate waits until the watcher with the given resource id may
+// deliver another event under its rate limit, if it has one, or
+// until the connection is killed. The events held back meanwhile are
+// coalesced by the watcher.
+func (r *srvRoot) awaitRate(id string) {
+	r.mu.Lock()
+	var d time.Duration
+	if l := r.rateLimits[id]; l != nil {
+		d = l.delay(time.Now())
+	}
+	r.mu.Unlock()
+	if d <= 0 {
+		return
+	}
+	select {
+	case <-time.After(d):
+	case <-r.dying:
+	}
+}
+
+// noteRate records a delivery by the watcher
+// with the given resource id.
+func (r *srvRoot) noteRate(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if l := r.rateLimits[id]; l != nil {
+		l.note(time.Now())
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"launchpad.net/juju-core/log"
+	"launchpad.net/juju-core/rpc"
+)
+
+// requestSampler chooses the requests to be logged;
+// see ServerConfig.RequestLogSampling.
+type requestSampler struct {
+	// count holds the number of requests served so far.
+	// It must be accessed atomically, and is the first
+	// field so that it is aligned on 32-bit platforms.
+	count uint64
+	n     uint64
+}
+
+// newRequestSampler returns a requestSampler logging one
+// in n requests, or nil, which logs none, if n is not positive.
+func newRequestSampler(n int) *requestSampler {
+	if n <= 0 {
+		return nil
+	}
+	return &requestSampler{n: uint64(n)}
+}
+
+// sample reports whether the request just served, which
+// failed if err is not nil, should be logged.
+func (s *requestSampler) sample(err error) bool {
+	if s == nil {
+		return false
+	}
+	count := atomic.AddUint64(&s.count, 1)
+	return err != nil || count%s.n == 0
+}
+
+// logRequest logs the given request, served on the connection in the
+// given duration, if the server's sampler chooses it. Every request
+// made by a controller on behalf of an agent is logged, with the tag
+// of the controller, so that what it did as the agent can be traced.
+func (r *srvRoot) logRequest(req rpc.Request, duration time.Duration, err error) {
+	if !r.srv.requestSampler.sample(err) && r.impersonator == nil {
+		return
+	}
+	call := fmt.Sprintf("%s.%s", req.Type, req.Action)
+	if r.impersonator != nil {
+		call += fmt.Sprintf(" by %q on behalf of %q", r.impersonator.Tag(), r.GetAuthTag())
+	}
+	if err != nil {
+		log.Infof("state/api: connection %d: %s took %v: %v", r.connId, call, duration, err)
+		return
+	}
+	log.Infof("state/api: connection %d: %s took %v", r.connId, call, duration)
+}
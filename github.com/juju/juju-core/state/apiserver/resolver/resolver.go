@@ -0,0 +1,82 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// The resolver package implements the API used by unit agents to
+// learn when an operator has marked a failed hook as resolved.
+package resolver
+
+import (
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/api/params"
+	"launchpad.net/juju-core/state/apiserver/common"
+)
+
+// ResolverAPI provides access to the Resolver API facade.
+type ResolverAPI struct {
+	st         *state.State
+	resources  *common.Resources
+	authorizer common.Authorizer
+}
+
+// NewResolverAPI creates a new server-side Resolver API facade.
+func NewResolverAPI(
+	st *state.State,
+	resources *common.Resources,
+	authorizer common.Authorizer,
+) (*ResolverAPI, error) {
+	if !authorizer.AuthUnitAgent() {
+		return nil, common.ErrPerm
+	}
+	return &ResolverAPI{
+		st:         st,
+		resources:  resources,
+		authorizer: authorizer,
+	}, nil
+}
+
+// unit returns the unit with the given tag,
+// if the authenticated unit may access it.
+func (api *ResolverAPI) unit(tag string) (*state.Unit, error) {
+	if !api.authorizer.AuthOwner(tag) {
+		return nil, common.ErrPerm
+	}
+	return api.st.Unit(state.UnitNameFromTag(tag))
+}
+
+// Resolved returns the resolved mode of each of the given units,
+// such as "retry-hooks", or the empty string if none is set.
+func (api *ResolverAPI) Resolved(args params.Entities) (params.StringResults, error) {
+	result := params.StringResults{
+		Results: make([]params.StringResult, len(args.Entities)),
+	}
+	for i, entity := range args.Entities {
+		unit, err := api.unit(entity.Tag)
+		if err == nil {
+			result.Results[i].Result = string(unit.Resolved())
+		}
+		result.Results[i].Error = common.ServerError(err)
+	}
+	return result, nil
+}
+
+// WatchResolved starts a NotifyWatcher for each of the given units
+// that fires when the unit's resolved mode changes, so that the unit
+// agent can retry a failed hook as soon as an operator asks it to.
+func (api *ResolverAPI) WatchResolved(args params.Entities) (params.NotifyWatchResults, error) {
+	result := params.NotifyWatchResults{
+		Results: make([]params.NotifyWatchResult, len(args.Entities)),
+	}
+	for i, entity := range args.Entities {
+		unit, err := api.unit(entity.Tag)
+		if err == nil {
+			watch := unit.WatchResolved()
+			// Consume the initial event; NotifyWatchers
+			// have no state to transmit.
+			if err = common.InitialNotifyEvent(api.resources, watch); err == nil {
+				result.Results[i].NotifyWatcherId = api.resources.RegisterFor(watch, entity.Tag)
+			}
+		}
+		result.Results[i].Error = common.ServerError(err)
+	}
+	return result, nil
+}
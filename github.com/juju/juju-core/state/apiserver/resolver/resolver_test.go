@@ -0,0 +1,108 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package resolver_test
+
+import (
+	. "launchpad.net/gocheck"
+
+	jujutesting "launchpad.net/juju-core/juju/testing"
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/api/params"
+	"launchpad.net/juju-core/state/apiserver/common"
+	"launchpad.net/juju-core/state/apiserver/resolver"
+	apiservertesting "launchpad.net/juju-core/state/apiserver/testing"
+	statetesting "launchpad.net/juju-core/state/testing"
+)
+
+type resolverSuite struct {
+	jujutesting.JujuConnSuite
+
+	unit       *state.Unit
+	api        *resolver.ResolverAPI
+	resources  *common.Resources
+	authorizer apiservertesting.FakeAuthorizer
+}
+
+var _ = Suite(&resolverSuite{})
+
+func (s *resolverSuite) SetUpTest(c *C) {
+	s.JujuConnSuite.SetUpTest(c)
+	s.resources = common.NewResources()
+
+	svc, err := s.State.AddService("wordpress", s.AddTestingCharm(c, "wordpress"))
+	c.Assert(err, IsNil)
+	s.unit, err = svc.AddUnit()
+	c.Assert(err, IsNil)
+	s.authorizer = apiservertesting.FakeAuthorizer{
+		Tag:       s.unit.Tag(),
+		LoggedIn:  true,
+		UnitAgent: true,
+	}
+	s.api, err = resolver.NewResolverAPI(s.State, s.resources, s.authorizer)
+	c.Assert(err, IsNil)
+}
+
+func (s *resolverSuite) TearDownTest(c *C) {
+	if s.resources != nil {
+		s.resources.StopAll()
+	}
+	s.JujuConnSuite.TearDownTest(c)
+}
+
+func (s *resolverSuite) TestRequiresUnitAgent(c *C) {
+	anAuthorizer := s.authorizer
+	anAuthorizer.UnitAgent = false
+	anAuthorizer.MachineAgent = true
+	api, err := resolver.NewResolverAPI(s.State, s.resources, anAuthorizer)
+	c.Assert(err, ErrorMatches, "permission denied")
+	c.Assert(api, IsNil)
+}
+
+func (s *resolverSuite) TestResolved(c *C) {
+	args := params.Entities{Entities: []params.Entity{
+		{Tag: s.unit.Tag()},
+		{Tag: "unit-wordpress-1"},
+	}}
+	results, err := s.api.Resolved(args)
+	c.Assert(err, IsNil)
+	c.Assert(results, DeepEquals, params.StringResults{
+		Results: []params.StringResult{
+			{Result: ""},
+			{Error: apiservertesting.ErrUnauthorized},
+		},
+	})
+
+	err = s.unit.SetResolved(state.ResolvedRetryHooks)
+	c.Assert(err, IsNil)
+	results, err = s.api.Resolved(args)
+	c.Assert(err, IsNil)
+	c.Assert(results.Results[0], DeepEquals, params.StringResult{Result: "retry-hooks"})
+}
+
+func (s *resolverSuite) TestWatchResolved(c *C) {
+	args := params.Entities{Entities: []params.Entity{
+		{Tag: s.unit.Tag()},
+		{Tag: "unit-wordpress-1"},
+	}}
+	results, err := s.api.WatchResolved(args)
+	c.Assert(err, IsNil)
+	c.Assert(results.Results, HasLen, 2)
+	c.Assert(results.Results[0].Error, IsNil)
+	c.Assert(results.Results[1], DeepEquals, params.NotifyWatchResult{
+		Error: apiservertesting.ErrUnauthorized,
+	})
+
+	w, ok := s.resources.Get(results.Results[0].NotifyWatcherId).(state.NotifyWatcher)
+	c.Assert(ok, Equals, true)
+	wc := statetesting.NewNotifyWatcherC(c, s.State, w)
+	wc.AssertNoChange()
+
+	err = s.unit.SetResolved(state.ResolvedNoHooks)
+	c.Assert(err, IsNil)
+	wc.AssertOneChange()
+
+	// Stopping the connection's resources stops the watcher.
+	s.resources.StopAll()
+	wc.AssertClosed()
+}
@@ -0,0 +1,144 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"time"
+
+	"launchpad.net/juju-core/errors"
+	"launchpad.net/juju-core/log"
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/api/params"
+	"launchpad.net/juju-core/utils"
+)
+
+// sessionExpiry bounds the age of the sessions that can be resumed.
+// Sessions are saved whenever the client pings, so a session that
+// has not been saved for longer is that of a client long gone.
+var sessionExpiry = 10 * time.Minute
+
+// newSessionToken returns a new random session token.
+func newSessionToken() (string, error) {
+	data, err := utils.RandomBytes(16)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(data), nil
+}
+
+// sessionWatchers returns the connection's watchers that can be
+// resumed on another connection: the NotifyWatchers registered for
+// an entity, which can be started afresh by watching the entity.
+func (r *srvRoot) sessionWatchers() []state.APISessionWatcher {
+	var watchers []state.APISessionWatcher
+	for _, e := range r.resources.Entries() {
+		if e.Kind != "NotifyWatcher" || e.Tag == "" {
+			continue
+		}
+		watchers = append(watchers, state.APISessionWatcher{
+			Id:   e.Id,
+			Tag:  e.Tag,
+			Type: fmt.Sprintf("%T", e.Resource),
+		})
+	}
+	return watchers
+}
+
+// saveSession records the connection's resumable watchers in the
+// state, shared by all API servers, if they have changed since they
+// were last saved.
+func (r *srvRoot) saveSession() error {
+	if r.sessionToken == "" {
+		return nil
+	}
+	watchers := r.sessionWatchers()
+	r.mu.Lock()
+	unchanged := r.sessionSaved && reflect.DeepEqual(watchers, r.savedWatchers)
+	r.mu.Unlock()
+	if unchanged {
+		return nil
+	}
+	if err := r.srv.state.SaveAPISession(r.sessionToken, r.GetAuthTag(), watchers); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.sessionSaved = true
+	r.savedWatchers = watchers
+	r.mu.Unlock()
+	return nil
+}
+
+// resumeSession starts, on behalf of a client that has reconnected,
+// watchers equivalent to those held by its connection with the given
+// session token, and removes that session so that it is resumed only
+// once. Watchers are resumed on a best effort basis: those that
+// cannot be are reported without a new id, and the client must start
+// them itself.
+func (r *srvRoot) resumeSession(token string) ([]params.ResumedWatcher, error) {
+	session, err := r.srv.state.APISession(token)
+	if errors.IsNotFoundError(err) || err == nil && session.Tag() != r.GetAuthTag() {
+		// A session of another entity is
+		// indistinguishable from one that is gone.
+		return nil, errors.NotFoundf("session")
+	} else if err != nil {
+		return nil, err
+	}
+	if time.Since(session.Saved()) > sessionExpiry {
+		return nil, fmt.Errorf("session has expired")
+	}
+	if err := r.srv.state.RemoveAPISession(token); err != nil {
+		return nil, err
+	}
+	results := make([]params.ResumedWatcher, len(session.Watchers()))
+	for i, w := range session.Watchers() {
+		results[i] = params.ResumedWatcher{
+			OldId: w.Id,
+			Tag:   w.Tag,
+		}
+		watch, err := r.watchEntity(w.Tag)
+		if err != nil {
+			log.Debugf("state/api: cannot resume watcher %s of %q: %v", w.Id, w.Tag, err)
+			continue
+		}
+		if fmt.Sprintf("%T", watch) != w.Type {
+			// The entity is not watched by the same
+			// kind of watcher as before.
+			watch.Stop()
+			continue
+		}
+		// The initial event is left for the client to read,
+		// as changes may have been missed since the previous
+		// connection's watcher last delivered an event.
+		results[i].NewId = r.resources.RegisterFor(watch, w.Tag)
+	}
+	return results, nil
+}
+
+// watchEntity returns a watcher of the entity with the given tag.
+func (r *srvRoot) watchEntity(tag string) (state.NotifyWatcher, error) {
+	entity, err := r.srv.state.Lifer(tag)
+	if err != nil {
+		return nil, err
+	}
+	e, ok := entity.(entityWatcher)
+	if !ok {
+		return nil, fmt.Errorf("entity %q cannot be watched", tag)
+	}
+	return e.Watch(), nil
+}
+
+// forgetSession removes the connection's saved session, unless the
+// server is shutting down, in which case the client may resume the
+// session on another server.
+func (r *srvRoot) forgetSession() {
+	if r.sessionToken == "" || r.srv.draining() {
+		return
+	}
+	if err := r.srv.state.RemoveAPISession(r.sessionToken); err != nil {
+		log.Errorf("state/api: cannot remove session of %q: %v", r.GetAuthTag(), err)
+	}
+}
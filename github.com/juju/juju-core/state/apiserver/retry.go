@@ -0,0 +1,56 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"time"
+)
+
+// RetryPolicy describes how an operation that fails with a transient
+// state error is retried. Each retry waits twice as long as the one
+// before, starting with Delay, but never longer than MaxDelay, if
+// that is positive.
+type RetryPolicy struct {
+	// Attempts holds the maximum number of attempts made,
+	// including the first; a policy with fewer than two
+	// attempts makes no retries.
+	Attempts int
+
+	Delay    time.Duration
+	MaxDelay time.Duration
+}
+
+// permanentError marks an error that retrying cannot cure,
+// although it carries no error code.
+type permanentError struct {
+	error
+}
+
+// retryTransient calls fn until it succeeds or fails with an error
+// other than a state error, as the breaker counts them, or one marked
+// permanent, or until the given policy allows no more attempts,
+// and returns its last error. It stops waiting to retry if the
+// connection is killed.
+func (r *srvRoot) retryTransient(policy RetryPolicy, fn func() error) error {
+	delay := policy.Delay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if p, ok := err.(permanentError); ok {
+			return p.error
+		}
+		if !isStateError(err) || attempt >= policy.Attempts {
+			return err
+		}
+		select {
+		case <-time.After(delay):
+		case <-r.dying:
+			return err
+		}
+		delay *= 2
+		if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+			delay = policy.MaxDelay
+		}
+	}
+	panic("unreachable")
+}
@@ -0,0 +1,91 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package retrystrategy
+
+import (
+	"time"
+
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/api/params"
+	"launchpad.net/juju-core/state/apiserver/common"
+	"launchpad.net/juju-core/utils"
+)
+
+// DefaultStrategy holds the retry strategy recommended to agents
+// when the API server has not been given one.
+var DefaultStrategy = utils.AttemptStrategy{
+	Total: 5 * time.Minute,
+	Delay: 3 * time.Second,
+}
+
+// RetryStrategyAPI provides access to the RetryStrategy API facade.
+type RetryStrategyAPI struct {
+	st         *state.State
+	resources  *common.Resources
+	authorizer common.Authorizer
+	strategy   utils.AttemptStrategy
+}
+
+// NewRetryStrategyAPI creates a new server-side RetryStrategy API
+// facade, recommending the given strategy to agents.
+func NewRetryStrategyAPI(
+	st *state.State,
+	resources *common.Resources,
+	authorizer common.Authorizer,
+	strategy utils.AttemptStrategy,
+) (*RetryStrategyAPI, error) {
+	if !authorizer.AuthMachineAgent() && !authorizer.AuthUnitAgent() {
+		return nil, common.ErrPerm
+	}
+	return &RetryStrategyAPI{
+		st:         st,
+		resources:  resources,
+		authorizer: authorizer,
+		strategy:   strategy,
+	}, nil
+}
+
+// RetryStrategy returns the strategy with which each of the given
+// agents should retry operations that fail transiently.
+func (api *RetryStrategyAPI) RetryStrategy(args params.Entities) (params.RetryStrategyResults, error) {
+	result := params.RetryStrategyResults{
+		Results: make([]params.RetryStrategyResult, len(args.Entities)),
+	}
+	for i, entity := range args.Entities {
+		if !api.authorizer.AuthOwner(entity.Tag) {
+			result.Results[i].Error = common.ServerError(common.ErrPerm)
+			continue
+		}
+		result.Results[i].Result = &params.RetryStrategy{
+			Total: api.strategy.Total,
+			Delay: api.strategy.Delay,
+			Min:   api.strategy.Min,
+		}
+	}
+	return result, nil
+}
+
+// WatchRetryStrategy starts a NotifyWatcher for each of the given
+// agents that fires when the strategy returned by RetryStrategy may
+// have changed. The strategy does not yet depend on anything held in
+// state, so the watcher fires whenever the environment configuration
+// changes, and the agent should then read the strategy again.
+func (api *RetryStrategyAPI) WatchRetryStrategy(args params.Entities) (params.NotifyWatchResults, error) {
+	result := params.NotifyWatchResults{
+		Results: make([]params.NotifyWatchResult, len(args.Entities)),
+	}
+	for i, entity := range args.Entities {
+		err := common.ErrPerm
+		if api.authorizer.AuthOwner(entity.Tag) {
+			watch := api.st.WatchForEnvironConfigChanges()
+			// Consume the initial event; NotifyWatchers
+			// have no state to transmit.
+			if err = common.InitialNotifyEvent(api.resources, watch); err == nil {
+				result.Results[i].NotifyWatcherId = api.resources.Register(watch)
+			}
+		}
+		result.Results[i].Error = common.ServerError(err)
+	}
+	return result, nil
+}
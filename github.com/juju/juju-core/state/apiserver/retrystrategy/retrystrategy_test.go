@@ -0,0 +1,109 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package retrystrategy_test
+
+import (
+	"time"
+
+	. "launchpad.net/gocheck"
+
+	jujutesting "launchpad.net/juju-core/juju/testing"
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/api/params"
+	"launchpad.net/juju-core/state/apiserver/common"
+	"launchpad.net/juju-core/state/apiserver/retrystrategy"
+	apiservertesting "launchpad.net/juju-core/state/apiserver/testing"
+	statetesting "launchpad.net/juju-core/state/testing"
+	"launchpad.net/juju-core/utils"
+	"launchpad.net/juju-core/version"
+)
+
+type retryStrategySuite struct {
+	jujutesting.JujuConnSuite
+
+	machine    *state.Machine
+	api        *retrystrategy.RetryStrategyAPI
+	resources  *common.Resources
+	authorizer apiservertesting.FakeAuthorizer
+}
+
+var _ = Suite(&retryStrategySuite{})
+
+var testStrategy = utils.AttemptStrategy{
+	Total: time.Minute,
+	Delay: time.Second,
+	Min:   3,
+}
+
+func (s *retryStrategySuite) SetUpTest(c *C) {
+	s.JujuConnSuite.SetUpTest(c)
+	s.resources = common.NewResources()
+
+	var err error
+	s.machine, err = s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	s.authorizer = apiservertesting.FakeAuthorizer{
+		Tag:          s.machine.Tag(),
+		LoggedIn:     true,
+		MachineAgent: true,
+	}
+	s.api, err = retrystrategy.NewRetryStrategyAPI(s.State, s.resources, s.authorizer, testStrategy)
+	c.Assert(err, IsNil)
+}
+
+func (s *retryStrategySuite) TearDownTest(c *C) {
+	if s.resources != nil {
+		s.resources.StopAll()
+	}
+	s.JujuConnSuite.TearDownTest(c)
+}
+
+func (s *retryStrategySuite) TestRefusesClient(c *C) {
+	anAuthorizer := s.authorizer
+	anAuthorizer.MachineAgent = false
+	anAuthorizer.Client = true
+	api, err := retrystrategy.NewRetryStrategyAPI(s.State, s.resources, anAuthorizer, testStrategy)
+	c.Assert(err, ErrorMatches, "permission denied")
+	c.Assert(api, IsNil)
+}
+
+func (s *retryStrategySuite) TestRetryStrategy(c *C) {
+	args := params.Entities{Entities: []params.Entity{
+		{Tag: s.machine.Tag()},
+		{Tag: "machine-42"},
+	}}
+	results, err := s.api.RetryStrategy(args)
+	c.Assert(err, IsNil)
+	c.Assert(results, DeepEquals, params.RetryStrategyResults{
+		Results: []params.RetryStrategyResult{
+			{Result: &params.RetryStrategy{Total: time.Minute, Delay: time.Second, Min: 3}},
+			{Error: apiservertesting.ErrUnauthorized},
+		},
+	})
+}
+
+func (s *retryStrategySuite) TestWatchRetryStrategy(c *C) {
+	args := params.Entities{Entities: []params.Entity{
+		{Tag: s.machine.Tag()},
+		{Tag: "machine-42"},
+	}}
+	results, err := s.api.WatchRetryStrategy(args)
+	c.Assert(err, IsNil)
+	c.Assert(results.Results, HasLen, 2)
+	c.Assert(results.Results[0].Error, IsNil)
+	c.Assert(results.Results[1], DeepEquals, params.NotifyWatchResult{
+		Error: apiservertesting.ErrUnauthorized,
+	})
+
+	w, ok := s.resources.Get(results.Results[0].NotifyWatcherId).(state.NotifyWatcher)
+	c.Assert(ok, Equals, true)
+	wc := statetesting.NewNotifyWatcherC(c, s.State, w)
+	wc.AssertNoChange()
+
+	err = statetesting.SetAgentVersion(s.State, version.MustParse("3.4.5"))
+	c.Assert(err, IsNil)
+	wc.AssertOneChange()
+	statetesting.AssertStop(c, w)
+	wc.AssertClosed()
+}
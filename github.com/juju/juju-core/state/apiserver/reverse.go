@@ -0,0 +1,63 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	stderrors "errors"
+
+	"launchpad.net/juju-core/errors"
+	"launchpad.net/juju-core/state/apiserver/common"
+)
+
+var errConnClosed = stderrors.New("connection has been closed")
+
+// ReverseChannel returns an object through which an agent may offer
+// its connection as a channel for requests made back to it by the
+// server. The id argument is reserved for future use and must be
+// empty.
+func (r *srvRoot) ReverseChannel(id string) (*srvReverseChannel, error) {
+	if id != "" {
+		return nil, common.ErrBadId
+	}
+	return &srvReverseChannel{r}, nil
+}
+
+type srvReverseChannel struct {
+	root *srvRoot
+}
+
+// Register registers the connection as the channel for requests
+// made back to the agent, replacing any channel registered
+// previously. The agent must already be serving requests on its
+// end of the connection. The channel lasts as long as the
+// connection does.
+func (c *srvReverseChannel) Register() error {
+	return c.root.srv.addReverse(c.root)
+}
+
+// addReverse registers the connection of the given root
+// as the reverse channel to its agent.
+func (srv *Server) addReverse(root *srvRoot) error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if !srv.roots[root] {
+		// The connection has already been killed.
+		return errConnClosed
+	}
+	srv.reverse[root.GetAuthTag()] = root
+	return nil
+}
+
+// CallAgent makes a request back to the agent with the given tag over
+// the channel registered by the agent, as with rpc.Conn.Call. It
+// returns a not found error if the agent has no channel registered.
+func (srv *Server) CallAgent(tag, objType, id, action string, args, response interface{}) error {
+	srv.mu.Lock()
+	root := srv.reverse[tag]
+	srv.mu.Unlock()
+	if root == nil {
+		return errors.NotFoundf("reverse channel to %q", tag)
+	}
+	return root.rpcConn.Call(objType, id, action, args, response)
+}
@@ -4,11 +4,30 @@
 package apiserver
 
 import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"launchpad.net/juju-core/errors"
+	"launchpad.net/juju-core/instance"
+	"launchpad.net/juju-core/log"
+	"launchpad.net/juju-core/rpc"
 	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/api/params"
+	"launchpad.net/juju-core/state/apiserver/applicationscaler"
 	"launchpad.net/juju-core/state/apiserver/client"
 	"launchpad.net/juju-core/state/apiserver/common"
 	"launchpad.net/juju-core/state/apiserver/deployer"
+	"launchpad.net/juju-core/state/apiserver/leadership"
 	"launchpad.net/juju-core/state/apiserver/machine"
+	"launchpad.net/juju-core/state/apiserver/machineundertaker"
+	"launchpad.net/juju-core/state/apiserver/metricsadder"
+	"launchpad.net/juju-core/state/apiserver/proxyupdater"
+	"launchpad.net/juju-core/state/apiserver/resolver"
+	"launchpad.net/juju-core/state/apiserver/retrystrategy"
+	"launchpad.net/juju-core/state/apiserver/sshclient"
+	"launchpad.net/juju-core/state/apiserver/undertaker"
 	"launchpad.net/juju-core/state/apiserver/upgrader"
 	"launchpad.net/juju-core/state/multiwatcher"
 )
@@ -20,42 +39,243 @@ type clientAPI struct{ *client.API }
 type srvRoot struct {
 	clientAPI
 	srv       *Server
+	rpcConn   *rpc.Conn
+	connId    uint64
 	resources *common.Resources
 
-	entity state.TaggedAuthenticator
+	// remoteAddr holds the network address of the client,
+	// and loginTime the time at which it logged in.
+	remoteAddr string
+	loginTime  time.Time
+
+	// disconnect makes the server close the connection.
+	disconnect func()
+
+	// traceId holds the trace id given by the client at login,
+	// linking the connection's spans to the client's own.
+	traceId string
+
+	// version holds the API version negotiated at login.
+	version int
+
+	// callDeadline and watcherDeadline hold the deadlines
+	// requested at login; see invokeWithDeadline.
+	callDeadline    time.Duration
+	watcherDeadline time.Duration
+
+	// priority holds the priority with which the connection's
+	// requests are given slots; see ServerConfig.RequestPriorities.
+	priority int
+
+	// allowedMethods, if not nil, holds the only facade methods
+	// the connection may call, as restricted by the credentials
+	// it logged in with; see ServerConfig.ClientCertMethodsField.
+	allowedMethods map[methodKey]bool
+
+	// entityMu guards entity and entityRemoved.
+	entityMu sync.RWMutex
+	entity   state.TaggedAuthenticator
+
+	// entityRemoved is set when RefreshEntity
+	// finds that the entity no longer exists.
+	entityRemoved bool
+
+	// impersonator holds the controller that logged in on
+	// behalf of entity, or nil if entity logged in itself.
+	impersonator state.TaggedAuthenticator
+
+	// agentEvents holds the events stream the agent
+	// subscribed to at login, if any.
+	agentEvents *agentEvents
+
+	// sessionToken identifies the connection's session,
+	// so that a later connection can resume it.
+	sessionToken string
+
+	// health records the connection's pings and slow requests.
+	health connHealth
+
+	// flights coalesces identical concurrent read-only calls.
+	flights flightGroup
+
+	// dying is closed when the connection is killed.
+	dying chan struct{}
+
+	// goroutines holds the number of goroutines started
+	// with spawn that are still running. It must be
+	// accessed atomically.
+	goroutines int32
+
+	// mu guards the fields below.
+	mu sync.Mutex
+
+	// onKill holds the functions registered with OnKill.
+	onKill []func()
+
+	// deprecations holds the notices not yet read by the client
+	// for the deprecated methods it has called, and
+	// deprecationsSeen the methods for which notices have been
+	// recorded.
+	deprecations     []params.DeprecationNotice
+	deprecationsSeen map[methodKey]bool
+
+	// deliveries holds the time at which Next last returned an
+	// event, keyed by the resource id of the watcher.
+	deliveries map[string]time.Time
+
+	// nexts holds the resource ids of the watchers
+	// on which a call to Next is in progress.
+	nexts map[string]bool
+
+	// rateLimits holds the rate limits set on the connection's
+	// watchers, keyed by resource id.
+	rateLimits map[string]*rateLimiter
+
+	// stopAt holds the life at which each watcher registered
+	// with a stop condition is stopped, keyed by resource id.
+	stopAt map[string]state.Life
+
+	// compactions holds the key functions with which the
+	// changes of compacted watchers are compacted, keyed by
+	// resource id; see registerCompacted.
+	compactions map[string]changeKeyFunc
+
+	// snapshotPages holds the ids of the initial events of
+	// StringsWatchers yet to be returned by Next, keyed by
+	// resource id; see pageSnapshot.
+	snapshotPages map[string][]string
+
+	// savedWatchers holds the watchers last recorded in the
+	// connection's saved session, if sessionSaved is true.
+	sessionSaved  bool
+	savedWatchers []state.APISessionWatcher
 }
 
-func newSrvRoot(srv *Server, entity state.TaggedAuthenticator) *srvRoot {
+func newSrvRoot(root *initialRoot, entity state.TaggedAuthenticator) *srvRoot {
 	r := &srvRoot{
-		srv:       srv,
-		resources: common.NewResources(),
-		entity:    entity,
+		srv:        root.srv,
+		rpcConn:    root.rpcConn,
+		connId:     root.connId,
+		resources:  common.NewResources(),
+		entity:     entity,
+		dying:      make(chan struct{}),
+		remoteAddr: root.remoteAddr,
+		loginTime:  time.Now(),
+		disconnect: func() { root.disconnect() },
 	}
-	r.clientAPI.API = client.NewAPI(srv.state, r.resources, r)
+	r.health.lastPing = time.Now()
+	r.resources.SetSetupTimeout(r.srv.cfg.WatcherSetupTimeout)
+	r.clientAPI.API = client.NewAPI(r.srv.state, r.resources, r)
 	return r
 }
 
+// resourceStopTimeout bounds the time Kill waits for
+// the connection's resources to stop.
+const resourceStopTimeout = 10 * time.Second
+
 // Kill implements rpc.Killer.  It cleans up any resources that need
 // cleaning up to ensure that all outstanding requests return.
 func (r *srvRoot) Kill() {
-	r.resources.StopAll()
+	r.resources.StopAllWithTimeout(resourceStopTimeout)
+	r.mu.Lock()
+	onKill := r.onKill
+	r.onKill = nil
+	select {
+	case <-r.dying:
+	default:
+		close(r.dying)
+	}
+	r.mu.Unlock()
+	for _, fn := range onKill {
+		runOnKill(fn)
+	}
+	r.forgetSession()
+	r.srv.removeRoot(r)
 }
 
-// requireAgent checks whether the current client is an agent and hence
-// may access the agent APIs.  We filter out non-agents when calling one
-// of the accessor functions (Machine, Unit, etc) which avoids us making
-// the check in every single request method.
-func (r *srvRoot) requireAgent() error {
-	if !isAgent(r.entity) {
-		return common.ErrPerm
+// OnKill registers fn to be called when the connection is killed,
+// after its resources have been stopped, so that facades can release
+// anything they hold outside r.resources. The functions are called in
+// the order they were registered.
+func (r *srvRoot) OnKill(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onKill = append(r.onKill, fn)
+}
+
+// runOnKill calls fn, logging rather than propagating any
+// panic so that the remaining functions are still called.
+func runOnKill(fn func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Errorf("state/api: panic in connection cleanup: %v", err)
+		}
+	}()
+	fn()
+}
+
+// authEntity returns the authenticated entity.
+func (r *srvRoot) authEntity() state.TaggedAuthenticator {
+	r.entityMu.RLock()
+	defer r.entityMu.RUnlock()
+	return r.entity
+}
+
+// RefreshEntity reads the authenticated entity from the state again,
+// so that changes made to it since login, such as to a machine's
+// jobs, are reflected in subsequent permission checks. If the entity
+// no longer exists, it returns common.ErrNotLoggedIn, as do all
+// subsequent requests on the connection.
+func (r *srvRoot) RefreshEntity() error {
+	entity, err := r.srv.state.Authenticator(r.GetAuthTag())
+	if errors.IsNotFoundError(err) {
+		r.entityMu.Lock()
+		r.entityRemoved = true
+		r.entityMu.Unlock()
+		return common.ErrNotLoggedIn
+	}
+	if err != nil {
+		return err
 	}
+	r.entityMu.Lock()
+	defer r.entityMu.Unlock()
+	r.entity = entity
 	return nil
 }
 
-// requireClient returns an error unless the current
-// client is a juju client user.
-func (r *srvRoot) requireClient() error {
-	if isAgent(r.entity) {
+// AuthEntity returns an object through which the connection's
+// authenticated entity may be refreshed. The id argument is reserved
+// for future use and must be empty.
+func (r *srvRoot) AuthEntity(id string) (srvAuthEntity, error) {
+	if id != "" {
+		return srvAuthEntity{}, common.ErrBadId
+	}
+	return srvAuthEntity{r}, nil
+}
+
+type srvAuthEntity struct {
+	root *srvRoot
+}
+
+// Refresh reads the authenticated entity from the state again;
+// see srvRoot.RefreshEntity.
+func (e srvAuthEntity) Refresh() error {
+	return e.root.RefreshEntity()
+}
+
+// loggedIn reports whether the authenticated entity
+// has not been found removed by RefreshEntity.
+func (r *srvRoot) loggedIn() bool {
+	r.entityMu.RLock()
+	defer r.entityMu.RUnlock()
+	return !r.entityRemoved
+}
+
+// requireAgent checks whether the current client is an agent and hence
+// may use the agent methods of the root that are not served through
+// facades; the facades themselves are governed by facadePolicy.
+func (r *srvRoot) requireAgent() error {
+	if !isAgent(r.authEntity()) {
 		return common.ErrPerm
 	}
 	return nil
@@ -102,22 +322,131 @@ func (r *srvRoot) Upgrader(id string) (*upgrader.UpgraderAPI, error) {
 	return upgrader.NewUpgraderAPI(r.srv.state, r.resources, r)
 }
 
+// LeadershipService returns an object that provides access to the
+// leadership API facade, used by unit agents to elect a leader of
+// their service. The id argument is reserved for future use and must
+// be empty.
+func (r *srvRoot) LeadershipService(id string) (*leadership.LeadershipServiceAPI, error) {
+	if id != "" {
+		return nil, common.ErrBadId
+	}
+	return leadership.NewLeadershipServiceAPI(r.srv.leadership, r.resources, r)
+}
+
+// SSHClient returns an object that provides access to the SSHClient
+// API facade, used by clients to find the addresses to ssh to. The id
+// argument is reserved for future use and must be empty.
+func (r *srvRoot) SSHClient(id string) (*sshclient.SSHClientAPI, error) {
+	if id != "" {
+		return nil, common.ErrBadId
+	}
+	return sshclient.NewSSHClientAPI(r.srv.state, r)
+}
+
+// RetryStrategy returns an object that provides access to the
+// RetryStrategy API facade, through which agents learn how to retry
+// operations that fail transiently. The id argument is reserved for
+// future use and must be empty.
+func (r *srvRoot) RetryStrategy(id string) (*retrystrategy.RetryStrategyAPI, error) {
+	if id != "" {
+		return nil, common.ErrBadId
+	}
+	return retrystrategy.NewRetryStrategyAPI(r.srv.state, r.resources, r, r.srv.cfg.RetryStrategy)
+}
+
+// ProxyUpdater returns an object that provides access to the
+// ProxyUpdater API facade, through which agents learn the proxy
+// settings of their environment. The id argument is reserved for
+// future use and must be empty.
+func (r *srvRoot) ProxyUpdater(id string) (*proxyupdater.ProxyUpdaterAPI, error) {
+	if id != "" {
+		return nil, common.ErrBadId
+	}
+	return proxyupdater.NewProxyUpdaterAPI(r.srv.state, r.resources, r)
+}
+
+// ApplicationScaler returns an object that provides access to the
+// ApplicationScaler API facade, used by the environment manager to
+// keep services running their minimum number of units. The id
+// argument is reserved for future use and must be empty.
+func (r *srvRoot) ApplicationScaler(id string) (*applicationscaler.ApplicationScalerAPI, error) {
+	if id != "" {
+		return nil, common.ErrBadId
+	}
+	return applicationscaler.NewApplicationScalerAPI(r.srv.state, r.resources, r)
+}
+
+// MachineUndertaker returns an object that provides access to the
+// MachineUndertaker API facade, used by the environment manager to
+// remove dead machines from state. The id argument is reserved for
+// future use and must be empty.
+func (r *srvRoot) MachineUndertaker(id string) (*machineundertaker.MachineUndertakerAPI, error) {
+	if id != "" {
+		return nil, common.ErrBadId
+	}
+	return machineundertaker.NewMachineUndertakerAPI(r.srv.state, r.resources, r)
+}
+
+// Undertaker returns an object that provides access to the Undertaker
+// API facade, used by the controller to tear down an environment that
+// is being destroyed. The id argument is reserved for future use and
+// must be empty.
+func (r *srvRoot) Undertaker(id string) (*undertaker.UndertakerAPI, error) {
+	if id != "" {
+		return nil, common.ErrBadId
+	}
+	return undertaker.NewUndertakerAPI(r.srv.state, r.resources, r)
+}
+
+// MetricsAdder returns an object that provides access to the
+// MetricsAdder API facade, through which the unit agents of metered
+// charms send their metrics. The id argument is reserved for future
+// use and must be empty.
+func (r *srvRoot) MetricsAdder(id string) (*metricsadder.MetricsAdderAPI, error) {
+	if id != "" {
+		return nil, common.ErrBadId
+	}
+	return metricsadder.NewMetricsAdderAPI(r.srv.state, r.resources, r, r.srv.cfg.MetricsSendInterval)
+}
+
+// Resolver returns an object that provides access to the Resolver API
+// facade, through which unit agents learn when a failed hook has been
+// marked as resolved. Its watchers are registered in r.resources, and
+// so are stopped when the connection is killed. The id argument is
+// reserved for future use and must be empty.
+func (r *srvRoot) Resolver(id string) (*resolver.ResolverAPI, error) {
+	if id != "" {
+		return nil, common.ErrBadId
+	}
+	return resolver.NewResolverAPI(r.srv.state, r.resources, r)
+}
+
+// unknownWatcher returns the error for a call on the watcher with the
+// given id, which is not registered: the reason it was retired, such
+// as common.ErrWatcherExpired, if it was, or common.ErrUnknownWatcher.
+func (r *srvRoot) unknownWatcher(id string) error {
+	if err := r.resources.Retired(id); err != nil {
+		return err
+	}
+	return common.ErrUnknownWatcher
+}
+
 // NotifyWatcher returns an object that provides
 // API access to methods on a state.NotifyWatcher.
 // Each client has its own current set of watchers, stored
 // in r.resources.
 func (r *srvRoot) NotifyWatcher(id string) (*srvNotifyWatcher, error) {
-	if err := r.requireAgent(); err != nil {
-		return nil, err
-	}
 	watcher, ok := r.resources.Get(id).(state.NotifyWatcher)
 	if !ok {
-		return nil, common.ErrUnknownWatcher
+		return nil, r.unknownWatcher(id)
 	}
 	return &srvNotifyWatcher{
 		watcher:   watcher,
 		id:        id,
 		resources: r.resources,
+		root:      r,
+		st:        r.srv.state,
+		tag:       r.resources.Tag(id),
 	}, nil
 }
 
@@ -125,17 +454,61 @@ func (r *srvRoot) NotifyWatcher(id string) (*srvNotifyWatcher, error) {
 // methods on a state.StringsWatcher.  Each client has its own
 // current set of watchers, stored in r.resources.
 func (r *srvRoot) StringsWatcher(id string) (*srvStringsWatcher, error) {
+	watcher, ok := r.resources.Get(id).(state.StringsWatcher)
+	if !ok {
+		return nil, r.unknownWatcher(id)
+	}
+	return &srvStringsWatcher{
+		watcher:   watcher,
+		id:        id,
+		resources: r.resources,
+		root:      r,
+		transform: r.stringsTransform(),
+	}, nil
+}
+
+// Snapshot returns the full set of ids watched, as of now, by the
+// StringsWatcher with the given id, marked as a snapshot, so that a
+// client that has missed events can resynchronise without restarting
+// the watcher, which goes on reporting changes as before. Only
+// watchers implementing state.Snapshotter can take snapshots.
+func (r *srvRoot) Snapshot(id string) (params.StringsWatchResult, error) {
 	if err := r.requireAgent(); err != nil {
-		return nil, err
+		return params.StringsWatchResult{}, err
 	}
 	watcher, ok := r.resources.Get(id).(state.StringsWatcher)
 	if !ok {
-		return nil, common.ErrUnknownWatcher
+		return params.StringsWatchResult{}, r.unknownWatcher(id)
 	}
-	return &srvStringsWatcher{
+	snapshotter, ok := watcher.(state.Snapshotter)
+	if !ok {
+		return params.StringsWatchResult{}, fmt.Errorf("watcher %q cannot take snapshots", id)
+	}
+	changes, err := snapshotter.Snapshot()
+	if err != nil {
+		return params.StringsWatchResult{}, err
+	}
+	return params.StringsWatchResult{
+		StringsWatcherId: id,
+		Changes:          r.stringsTransform()(changes),
+		Snapshot:         true,
+	}, nil
+}
+
+// RelationUnitsWatcher returns an object that provides API access to
+// methods on a state.RelationUnitsWatcher, as registered by facades
+// through common.RelationUnitsWatcher. Each client has its own
+// current set of watchers, stored in r.resources.
+func (r *srvRoot) RelationUnitsWatcher(id string) (*srvRelationUnitsWatcher, error) {
+	watcher, ok := r.resources.Get(id).(*common.RelationUnitsWatcher)
+	if !ok {
+		return nil, r.unknownWatcher(id)
+	}
+	return &srvRelationUnitsWatcher{
 		watcher:   watcher,
 		id:        id,
 		resources: r.resources,
+		root:      r,
 	}, nil
 }
 
@@ -144,12 +517,9 @@ func (r *srvRoot) StringsWatcher(id string) (*srvStringsWatcher, error) {
 // state. Each client has its own current set of watchers, stored in
 // r.resources.
 func (r *srvRoot) AllWatcher(id string) (*srvClientAllWatcher, error) {
-	if err := r.requireClient(); err != nil {
-		return nil, err
-	}
 	watcher, ok := r.resources.Get(id).(*multiwatcher.Watcher)
 	if !ok {
-		return nil, common.ErrUnknownWatcher
+		return nil, r.unknownWatcher(id)
 	}
 	return &srvClientAllWatcher{
 		watcher:   watcher,
@@ -158,41 +528,507 @@ func (r *srvRoot) AllWatcher(id string) (*srvClientAllWatcher, error) {
 	}, nil
 }
 
-// Pinger returns object with a single "Ping" method that does nothing.
+// MachinesCursor returns an object that provides API access to the
+// cursor started by a Client.MachinesCursor call. Each client has its
+// own current set of cursors, stored in r.resources.
+func (r *srvRoot) MachinesCursor(id string) (*srvMachinesCursor, error) {
+	cursor, ok := r.resources.Get(id).(*common.Cursor)
+	if !ok {
+		if err := r.resources.Retired(id); err != nil {
+			return nil, err
+		}
+		return nil, common.ErrBadId
+	}
+	return &srvMachinesCursor{
+		cursor:    cursor,
+		id:        id,
+		resources: r.resources,
+	}, nil
+}
+
+// FindWatchers returns the ids of the connection's watchers that
+// are watching the entity with the given tag, in the order they were
+// started, so that a particular entity's watcher can be stopped or
+// inspected.
+func (r *srvRoot) FindWatchers(tag string) []string {
+	return r.resources.Find(tag)
+}
+
+// StopWatchersByType stops and unregisters all the connection's
+// watchers of the given kind, such as "NotifyWatcher" or
+// "AllWatcher", and returns the number stopped. Unknown kinds stop
+// nothing.
+func (r *srvRoot) StopWatchersByType(kind string) int {
+	ids := r.resources.StopKind(kind)
+	for _, id := range ids {
+		r.forgetDelivery(id)
+	}
+	return len(ids)
+}
+
+// StopWatchers stops and unregisters each of the connection's watchers
+// with the given ids, so that an agent can tear down a worker's
+// watchers in a single request. The errors are returned in the same
+// order as the ids: common.ErrUnknownWatcher for an id that names no
+// watcher, or the error from stopping the watcher. Every id is
+// attempted, whatever happens to the others.
+func (r *srvRoot) StopWatchers(ids []string) []error {
+	errs := make([]error, len(ids))
+	for i, id := range ids {
+		if common.ResourceKind(r.resources.Get(id)) == "" {
+			errs[i] = common.ErrUnknownWatcher
+			continue
+		}
+		r.forgetDelivery(id)
+		errs[i] = r.resources.Stop(id)
+	}
+	return errs
+}
+
+// WatcherManager returns an object through which the connection's
+// watchers may be managed together. The id argument is reserved for
+// future use and must be empty.
+func (r *srvRoot) WatcherManager(id string) (srvWatcherManager, error) {
+	if id != "" {
+		return srvWatcherManager{}, common.ErrBadId
+	}
+	return srvWatcherManager{r}, nil
+}
+
+type srvWatcherManager struct {
+	root *srvRoot
+}
+
+// Stop stops and unregisters each of the watchers with the given
+// ids; see srvRoot.StopWatchers.
+func (m srvWatcherManager) Stop(args params.WatcherIds) (params.ErrorResults, error) {
+	errs := m.root.StopWatchers(args.Ids)
+	result := params.ErrorResults{
+		Errors: make([]*params.Error, len(errs)),
+	}
+	for i, err := range errs {
+		result.Errors[i] = common.ServerError(err)
+	}
+	return result, nil
+}
+
+// StopKind stops and unregisters all the watchers of the given
+// kind; see srvRoot.StopWatchersByType.
+func (m srvWatcherManager) StopKind(args params.WatcherKind) (params.StoppedWatchers, error) {
+	return params.StoppedWatchers{Count: m.root.StopWatchersByType(args.Kind)}, nil
+}
+
+// entityWatcher is implemented by entities, such as machines and
+// units, that can be watched for changes.
+type entityWatcher interface {
+	Watch() state.NotifyWatcher
+}
+
+// WatchEntities starts a single StringsWatcher reporting changes to
+// any of the entities with the given tags, each change holding the
+// tags of the entities that changed. Tags rejected by authFor are
+// dropped from the set with a warning, rather than failing the
+// whole request. The watcher is registered in r.resources and its
+// initial event, holding all the watched tags, is consumed and
+// returned in the result.
+func (r *srvRoot) WatchEntities(tags []string, authFor func(tag string) bool) (params.StringsWatchResult, error) {
+	watchers := make(map[string]state.NotifyWatcher)
+	stopAll := func() {
+		for _, w := range watchers {
+			w.Stop()
+		}
+	}
+	for _, tag := range tags {
+		if !authFor(tag) {
+			log.Warningf("state/api: not watching %q for %q: permission denied", tag, r.GetAuthTag())
+			continue
+		}
+		if _, ok := watchers[tag]; ok {
+			continue
+		}
+		w, err := r.watchEntity(tag)
+		if err != nil {
+			stopAll()
+			return params.StringsWatchResult{}, err
+		}
+		watchers[tag] = w
+	}
+	watch := common.NewAggregateWatcher(watchers, func(fn func()) { r.spawn(fn) })
+	// Consume the initial event and forward it to the result.
+	changes, err := common.InitialStringsEvent(r.resources, watch)
+	if err != nil {
+		return params.StringsWatchResult{}, err
+	}
+	id := r.resources.Register(watch)
+	page, more := r.pageSnapshot(id, changes)
+	return params.StringsWatchResult{
+		StringsWatcherId: id,
+		Changes:          page,
+		MorePages:        more,
+	}, nil
+}
+
+// WatchWildcard starts a StringsWatcher tracking the entities matched
+// by the given pattern, which is either "unit-<service>-*", matching
+// the units of a service, or "machine-<id>-<container type>-*",
+// matching the containers of that type on a machine. Each event holds
+// the tags of the entities that have joined or left the matched set,
+// or changed, since the previous event. authFor is applied to the
+// scope of the pattern: the service or the machine. The watcher is
+// registered in r.resources and its initial event, holding the tags of
+// all the matched entities, is consumed and returned in the result.
+func (r *srvRoot) WatchWildcard(pattern string, authFor func(tag string) bool) (params.StringsWatchResult, error) {
+	if !strings.HasSuffix(pattern, "-*") {
+		return params.StringsWatchResult{}, fmt.Errorf("invalid wildcard %q", pattern)
+	}
+	prefix := pattern[:len(pattern)-len("-*")]
+	var members state.StringsWatcher
+	var toTag func(id string) string
+	// scope holds the tag of the service or machine whose
+	// members are watched, with which the watcher is registered.
+	var scope string
+	switch {
+	case strings.HasPrefix(prefix, "unit-"):
+		name := prefix[len("unit-"):]
+		if !state.IsServiceName(name) {
+			return params.StringsWatchResult{}, fmt.Errorf("invalid wildcard %q", pattern)
+		}
+		scope = "service-" + name
+		if !authFor(scope) {
+			return params.StringsWatchResult{}, common.ErrPerm
+		}
+		service, err := r.srv.state.Service(name)
+		if err != nil {
+			return params.StringsWatchResult{}, err
+		}
+		members, toTag = service.WatchUnits(), state.UnitTag
+	case strings.HasPrefix(prefix, "machine-"):
+		i := strings.LastIndex(prefix, "-")
+		machineTag := prefix[:i]
+		ctype, err := instance.ParseSupportedContainerType(prefix[i+1:])
+		if err != nil || machineTag == "machine" {
+			return params.StringsWatchResult{}, fmt.Errorf("invalid wildcard %q", pattern)
+		}
+		scope = machineTag
+		if !authFor(scope) {
+			return params.StringsWatchResult{}, common.ErrPerm
+		}
+		machine, err := r.srv.state.Machine(state.MachineIdFromTag(machineTag))
+		if err != nil {
+			return params.StringsWatchResult{}, err
+		}
+		members, toTag = machine.WatchContainers(ctype), state.MachineTag
+	default:
+		return params.StringsWatchResult{}, fmt.Errorf("invalid wildcard %q", pattern)
+	}
+	watchMember := func(tag string) (state.NotifyWatcher, error) {
+		entity, err := r.srv.state.Lifer(tag)
+		if err != nil {
+			return nil, err
+		}
+		return entity.(entityWatcher).Watch(), nil
+	}
+	watch := common.NewMembershipWatcher(members, toTag, watchMember, func(fn func()) { r.spawn(fn) })
+	// Consume the initial event and forward it to the result.
+	changes, err := common.InitialStringsEvent(r.resources, watch)
+	if err != nil {
+		return params.StringsWatchResult{}, err
+	}
+	id := r.resources.RegisterFor(watch, scope)
+	page, more := r.pageSnapshot(id, changes)
+	return params.StringsWatchResult{
+		StringsWatcherId: id,
+		Changes:          page,
+		MorePages:        more,
+	}, nil
+}
+
+// watchContainers returns a StringsWatcher, registered in r.resources,
+// reporting changes to the lifecycles of the containers of the given
+// type, such as "lxc", on the machine with the given tag, as needed by
+// the container provisioner. Only the agent of the machine itself may
+// watch its containers. The watcher's initial event, holding the ids
+// of all the containers, is consumed and returned in the result.
+func (r *srvRoot) watchContainers(machineTag, containerType string) (params.StringsWatchResult, error) {
+	if !r.AuthMachineAgent() || !r.AuthOwner(machineTag) {
+		return params.StringsWatchResult{}, common.ErrPerm
+	}
+	ctype, err := instance.ParseSupportedContainerType(containerType)
+	if err != nil {
+		return params.StringsWatchResult{}, &common.BadRequestError{Field: "ContainerType", Reason: err.Error()}
+	}
+	machine, err := r.srv.state.Machine(state.MachineIdFromTag(machineTag))
+	if err != nil {
+		return params.StringsWatchResult{}, err
+	}
+	watch := machine.WatchContainers(ctype)
+	// Consume the initial event and forward it to the result.
+	changes, err := common.InitialStringsEvent(r.resources, watch)
+	if err != nil {
+		return params.StringsWatchResult{}, err
+	}
+	id := r.resources.RegisterFor(watch, machineTag)
+	page, more := r.pageSnapshot(id, changes)
+	return params.StringsWatchResult{
+		StringsWatcherId: id,
+		Changes:          page,
+		MorePages:        more,
+	}, nil
+}
+
+// watchServiceConfig returns a NotifyWatcher, registered in
+// r.resources, that fires when the configuration settings of the
+// service with the given tag change, so that the unit agents of the
+// service can run their config-changed hooks without polling. Only
+// the agents of the service's own units may watch its settings.
+func (r *srvRoot) watchServiceConfig(serviceTag string) (params.NotifyWatchResult, error) {
+	unit, ok := r.authEntity().(*state.Unit)
+	if !ok || serviceTag != "service-"+unit.ServiceName() {
+		return params.NotifyWatchResult{}, common.ErrPerm
+	}
+	service, err := r.srv.state.Service(unit.ServiceName())
+	if err != nil {
+		return params.NotifyWatchResult{}, err
+	}
+	watch := service.WatchConfigSettings()
+	if err := common.InitialNotifyEvent(r.resources, watch); err != nil {
+		return params.NotifyWatchResult{}, err
+	}
+	return params.NotifyWatchResult{
+		NotifyWatcherId: r.resources.RegisterFor(watch, serviceTag),
+	}, nil
+}
+
+// WatchInOrder is like WatchEntities, but reports the changes to the
+// entities in the order they were made, so that an agent watching,
+// say, its machine's lifecycle and the environment configuration never
+// sees one change before another that preceded it; see
+// state.WatchInOrder for the cost of the guarantee.
+func (r *srvRoot) WatchInOrder(tags []string, authFor func(tag string) bool) (params.StringsWatchResult, error) {
+	var permitted []string
+	for _, tag := range tags {
+		if !authFor(tag) {
+			log.Warningf("state/api: not watching %q for %q: permission denied", tag, r.GetAuthTag())
+			continue
+		}
+		permitted = append(permitted, tag)
+	}
+	watch, err := r.srv.state.WatchInOrder(permitted)
+	if err != nil {
+		return params.StringsWatchResult{}, err
+	}
+	// Consume the initial event and forward it to the result.
+	changes, err := common.InitialStringsEvent(r.resources, watch)
+	if err != nil {
+		return params.StringsWatchResult{}, err
+	}
+	id := r.resources.Register(watch)
+	page, more := r.pageSnapshot(id, changes)
+	return params.StringsWatchResult{
+		StringsWatcherId: id,
+		Changes:          page,
+		MorePages:        more,
+	}, nil
+}
+
+// WatchConstraints returns the environment constraints, and a
+// NotifyWatcher, registered in r.resources, that fires when they
+// change, so that provisioning agents can follow the constraints
+// without polling. The constraints are read once the watcher has
+// started, so no change can be missed.
+func (r *srvRoot) WatchConstraints() (params.ConstraintsWatchResult, error) {
+	if !r.AuthEnvironManager() {
+		return params.ConstraintsWatchResult{}, common.ErrPerm
+	}
+	var result params.ConstraintsWatchResult
+	id, err := common.NotifyWatchAndGet(r.resources, r.srv.state.WatchForEnvironConstraintsChanges(), func() (err error) {
+		result.Constraints, err = r.srv.state.EnvironConstraints()
+		return err
+	})
+	if err != nil {
+		return params.ConstraintsWatchResult{}, err
+	}
+	result.NotifyWatcherId = id
+	return result, nil
+}
+
+// WatchEnvironLife returns the life of the environment, and a
+// NotifyWatcher, registered in r.resources, that fires when the
+// environment changes, so that agents notice when it is being
+// destroyed and can shut down cleanly rather than failing on the
+// entities removed under them. As for WatchConstraints, the life is
+// read once the watcher has started, so no change can be missed.
+func (r *srvRoot) WatchEnvironLife() (params.EnvironLifeWatchResult, error) {
+	if err := r.requireAgent(); err != nil {
+		return params.EnvironLifeWatchResult{}, err
+	}
+	env, err := r.srv.state.Environment()
+	if err != nil {
+		return params.EnvironLifeWatchResult{}, err
+	}
+	var result params.EnvironLifeWatchResult
+	id, err := common.NotifyWatchAndGet(r.resources, env.Watch(), func() error {
+		if err := env.Refresh(); err != nil {
+			return err
+		}
+		result.Life = params.Life(env.Life().String())
+		return nil
+	})
+	if err != nil {
+		return params.EnvironLifeWatchResult{}, err
+	}
+	result.NotifyWatcherId = id
+	return result, nil
+}
+
+// resourceAgeBounds holds the bounds of the buckets
+// returned by srvRoot.ResourceAges.
+var resourceAgeBounds = []time.Duration{
+	time.Minute,
+	5 * time.Minute,
+	time.Hour,
+}
+
+// ResourceAges returns how many of the connection's resources have
+// been registered for less than a minute, five minutes and an hour,
+// and how many for longer, so that long-lived watchers that are never
+// stopped can be spotted.
+func (r *srvRoot) ResourceAges() []common.AgeBucket {
+	return r.resources.AgeDistribution(resourceAgeBounds...)
+}
+
+// ExpiringWatcher describes a watcher nearing the end
+// of its lifetime; see srvRoot.ExpiringWatchers.
+type ExpiringWatcher struct {
+	Id   string
+	Kind string
+
+	// Tag holds the tag of the entity watched, if any.
+	Tag string
+
+	// Age holds the time for which the watcher has been
+	// registered, and Remaining the time left before it
+	// expires.
+	Age       time.Duration
+	Remaining time.Duration
+}
+
+// ExpiringWatchers returns, in order of registration, the connection's
+// watchers with no more than the given fraction of their lifetime
+// left, as bounded by ServerConfig.MaxWatcherLifetime, so that a
+// client about to lose its watchers can be told from one whose
+// watchers are merely idle. Watchers that have outlived their
+// lifetime, but have not been called since, are reported with no
+// time remaining. It returns nil if watcher lifetimes are not bounded.
+func (r *srvRoot) ExpiringWatchers(fraction float64) []ExpiringWatcher {
+	max := r.srv.cfg.MaxWatcherLifetime
+	if max <= 0 {
+		return nil
+	}
+	threshold := time.Duration(fraction * float64(max))
+	now := time.Now()
+	var expiring []ExpiringWatcher
+	for _, e := range r.resources.Entries() {
+		if e.Kind == "" {
+			continue
+		}
+		registered := r.resources.Registered(e.Id)
+		if registered.IsZero() {
+			// Stopped meanwhile.
+			continue
+		}
+		age := now.Sub(registered)
+		remaining := max - age
+		if remaining > threshold {
+			continue
+		}
+		if remaining < 0 {
+			remaining = 0
+		}
+		expiring = append(expiring, ExpiringWatcher{
+			Id:        e.Id,
+			Kind:      e.Kind,
+			Tag:       e.Tag,
+			Age:       age,
+			Remaining: remaining,
+		})
+	}
+	return expiring
+}
+
+// Pinger returns an object with a "Ping" method, used by the client
+// heartbeat monitor, and a "ConnectionHealth" method.
 func (r *srvRoot) Pinger(id string) (srvPinger, error) {
-	return srvPinger{}, nil
+	return srvPinger{r}, nil
 }
 
-type srvPinger struct{}
+type srvPinger struct {
+	root *srvRoot
+}
 
-// Ping is a no-op used by client heartbeat monitor.
-func (r srvPinger) Ping() {}
+// Ping is used by the client heartbeat monitor. When the client
+// gives a nonce, it is returned along with the server's time, so that
+// the client can measure the round trip time and the clock skew.
+// Each ping also saves the connection's session if its watchers have
+// changed, so that the session can be resumed after a failover.
+func (r srvPinger) Ping(args params.Ping) params.PingResult {
+	r.root.health.ping()
+	if err := r.root.saveSession(); err != nil {
+		log.Errorf("state/api: cannot save session of %q: %v", r.root.GetAuthTag(), err)
+	}
+	if args.Nonce == "" {
+		return params.PingResult{}
+	}
+	return params.PingResult{
+		Nonce:      args.Nonce,
+		ServerTime: time.Now(),
+	}
+}
+
+// ConnectionHealth returns a summary of the connection's health.
+func (r srvPinger) ConnectionHealth() params.ConnectionHealth {
+	return r.root.ConnectionHealth()
+}
 
 // AuthMachineAgent returns whether the current client is a machine agent.
 func (r *srvRoot) AuthMachineAgent() bool {
-	_, ok := r.entity.(*state.Machine)
+	_, ok := r.authEntity().(*state.Machine)
+	return ok
+}
+
+// AuthUnitAgent returns whether the current client is a unit agent.
+func (r *srvRoot) AuthUnitAgent() bool {
+	_, ok := r.authEntity().(*state.Unit)
 	return ok
 }
 
 // AuthOwner returns whether the authenticated user's tag matches the
 // given entity tag.
 func (r *srvRoot) AuthOwner(tag string) bool {
-	return r.entity.Tag() == tag
+	return r.authEntity().Tag() == tag
 }
 
 // AuthEnvironManager returns whether the authenticated user is a
 // machine with running the ManageEnviron job.
 func (r *srvRoot) AuthEnvironManager() bool {
-	return isMachineWithJob(r.entity, state.JobManageEnviron)
+	return isMachineWithJob(r.authEntity(), state.JobManageEnviron)
+}
+
+// AuthController returns whether the authenticated entity is a
+// machine running the ManageState job.
+func (r *srvRoot) AuthController() bool {
+	return isMachineWithJob(r.authEntity(), state.JobManageState)
 }
 
 // AuthClient returns whether the authenticated entity is a client
 // user.
 func (r *srvRoot) AuthClient() bool {
-	return !isAgent(r.entity)
+	return !isAgent(r.authEntity())
 }
 
-// GetAuthTag returns the tag of the authenticated entity.
+// GetAuthTag returns the tag of the authenticated entity. When a
+// controller has logged in on behalf of an agent, this is the tag of
+// the agent.
 func (r *srvRoot) GetAuthTag() string {
-	return r.entity.Tag()
+	return r.authEntity().Tag()
 }
@@ -4,15 +4,32 @@
 package apiserver_test
 
 import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
 	"io"
 	. "launchpad.net/gocheck"
+	"launchpad.net/juju-core/charm"
+	"launchpad.net/juju-core/constraints"
+	"launchpad.net/juju-core/errors"
+	"launchpad.net/juju-core/instance"
 	jujutesting "launchpad.net/juju-core/juju/testing"
 	"launchpad.net/juju-core/rpc"
 	"launchpad.net/juju-core/state"
 	"launchpad.net/juju-core/state/api"
 	"launchpad.net/juju-core/state/api/params"
+	"launchpad.net/juju-core/state/api/watcher"
 	"launchpad.net/juju-core/state/apiserver"
+	"launchpad.net/juju-core/state/apiserver/common"
+	apiservertesting "launchpad.net/juju-core/state/apiserver/testing"
+	statetesting "launchpad.net/juju-core/state/testing"
 	coretesting "launchpad.net/juju-core/testing"
+	"launchpad.net/juju-core/utils"
+	"launchpad.net/juju-core/version"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
 	stdtesting "testing"
 	"time"
 )
@@ -29,6 +46,18 @@ type serverSuite struct {
 
 var _ = Suite(&serverSuite{})
 
+// openAsNewMachine adds a provisioned machine with the given jobs,
+// and returns it with an API connection logged in as its agent.
+func (s *serverSuite) openAsNewMachine(c *C, jobs ...state.MachineJob) (*state.Machine, *api.State) {
+	stm, err := s.State.AddMachine("series", jobs...)
+	c.Assert(err, IsNil)
+	err = stm.SetProvisioned("foo", "fake_nonce", nil)
+	c.Assert(err, IsNil)
+	err = stm.SetPassword("password")
+	c.Assert(err, IsNil)
+	return stm, s.OpenAPIAsMachine(c, stm.Tag(), "password", "fake_nonce")
+}
+
 func (s *serverSuite) TestStop(c *C) {
 	// Start our own instance of the server so we have
 	// a handle on it to stop it.
@@ -74,6 +103,416 @@ func (s *serverSuite) TestStop(c *C) {
 	c.Assert(err, IsNil)
 }
 
+func (s *serverSuite) TestShutdown(c *C) {
+	srv, err := apiserver.NewServer(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey))
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	err = stm.SetProvisioned("foo", "fake_nonce", nil)
+	c.Assert(err, IsNil)
+	err = stm.SetPassword("password")
+	c.Assert(err, IsNil)
+
+	apiInfo := &api.Info{
+		Tag:      stm.Tag(),
+		Password: "password",
+		Nonce:    "fake_nonce",
+		Addrs:    []string{srv.Addr()},
+		CACert:   []byte(coretesting.CACert),
+	}
+	st, err := api.Open(apiInfo, fastDialOpts)
+	c.Assert(err, IsNil)
+	defer st.Close()
+
+	_, err = st.Machiner().Machine(stm.Tag())
+	c.Assert(err, IsNil)
+
+	// Shutting down kills the connection, which stops the
+	// machine agent's pinger along with any other resources.
+	err = srv.Shutdown()
+	c.Assert(err, IsNil)
+
+	_, err = st.Machiner().Machine(stm.Tag())
+	if err != rpc.ErrShutdown && err != io.ErrUnexpectedEOF {
+		c.Fatalf("unexpected error from request: %v", err)
+	}
+	s.State.Sync()
+	alive, err := stm.AgentAlive()
+	c.Assert(err, IsNil)
+	c.Assert(alive, Equals, false)
+
+	// Check it can be shut down twice, and stopped afterwards.
+	err = srv.Shutdown()
+	c.Assert(err, IsNil)
+	err = srv.Stop()
+	c.Assert(err, IsNil)
+}
+
+type fakeTracer struct {
+	mu    sync.Mutex
+	spans []apiserver.SpanInfo
+	ended int
+}
+
+func (t *fakeTracer) StartSpan(info apiserver.SpanInfo) apiserver.Span {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.spans = append(t.spans, info)
+	return t
+}
+
+func (t *fakeTracer) End(time.Duration, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ended++
+}
+
+func (s *serverSuite) TestTracer(c *C) {
+	tracer := &fakeTracer{}
+	srv, err := apiserver.NewServerWithConfig(
+		s.State,
+		"localhost:0",
+		[]byte(coretesting.ServerCert),
+		[]byte(coretesting.ServerKey),
+		apiserver.ServerConfig{Tracer: tracer},
+	)
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	err = stm.SetProvisioned("foo", "fake_nonce", nil)
+	c.Assert(err, IsNil)
+	err = stm.SetPassword("password")
+	c.Assert(err, IsNil)
+
+	st, err := api.Open(&api.Info{
+		Addrs:  []string{srv.Addr()},
+		CACert: []byte(coretesting.CACert),
+	}, fastDialOpts)
+	c.Assert(err, IsNil)
+	defer st.Close()
+	err = st.Call("Admin", "", "Login", &params.Creds{
+		AuthTag:  stm.Tag(),
+		Password: "password",
+		Nonce:    "fake_nonce",
+		TraceId:  "trace-id",
+	}, nil)
+	c.Assert(err, IsNil)
+	_, err = st.Machiner().Machine(stm.Tag())
+	c.Assert(err, IsNil)
+
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+	// The heartbeat pinger may also have made requests,
+	// so look for the Machiner request alone.
+	var found []apiserver.SpanInfo
+	for _, span := range tracer.spans {
+		if span.Facade == "Machiner" {
+			found = append(found, span)
+		}
+	}
+	c.Assert(found, HasLen, 1)
+	c.Assert(found[0].TraceId, Equals, "trace-id")
+	c.Assert(found[0].Method, Equals, "Life")
+	c.Assert(found[0].ConnId, Not(Equals), uint64(0))
+	c.Assert(tracer.ended, Equals, len(tracer.spans))
+}
+
+func (s *serverSuite) TestMessageSizeLimits(c *C) {
+	srv, err := apiserver.NewServerWithConfig(
+		s.State,
+		"localhost:0",
+		[]byte(coretesting.ServerCert),
+		[]byte(coretesting.ServerKey),
+		apiserver.ServerConfig{
+			MaxRequestSize:  1024,
+			MaxResponseSize: 20,
+		},
+	)
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	err = stm.SetProvisioned("foo", "fake_nonce", nil)
+	c.Assert(err, IsNil)
+	err = stm.SetPassword("password")
+	c.Assert(err, IsNil)
+	info := &api.Info{
+		Addrs:  []string{srv.Addr()},
+		CACert: []byte(coretesting.CACert),
+	}
+
+	// A request larger than the limit is refused,
+	// but the connection can still be used.
+	st, err := api.Open(info, fastDialOpts)
+	c.Assert(err, IsNil)
+	defer st.Close()
+	err = st.Call("Admin", "", "Login", &params.Creds{
+		AuthTag:  stm.Tag(),
+		Password: strings.Repeat("x", 2048),
+		Nonce:    "fake_nonce",
+	}, nil)
+	c.Assert(err, ErrorMatches, "message too large")
+	c.Assert(params.ErrCode(err), Equals, params.CodeMessageTooLarge)
+	err = st.Call("Admin", "", "Login", &params.Creds{
+		AuthTag:  stm.Tag(),
+		Password: "password",
+		Nonce:    "fake_nonce",
+	}, nil)
+	c.Assert(err, IsNil)
+
+	// Requests within the limit are served, but a
+	// response larger than the limit is refused.
+	st1, err := api.Open(info, fastDialOpts)
+	c.Assert(err, IsNil)
+	defer st1.Close()
+	err = st1.Call("Admin", "", "Login", &params.Creds{
+		AuthTag:  stm.Tag(),
+		Password: "password",
+		Nonce:    "fake_nonce",
+	}, nil)
+	c.Assert(err, IsNil)
+	_, err = st1.Machiner().Machine(stm.Tag())
+	c.Assert(err, ErrorMatches, "message too large")
+	c.Assert(params.ErrCode(err), Equals, params.CodeMessageTooLarge)
+}
+
+func (s *serverSuite) TestMaxConnections(c *C) {
+	srv, err := apiserver.NewServerWithConfig(
+		s.State,
+		"localhost:0",
+		[]byte(coretesting.ServerCert),
+		[]byte(coretesting.ServerKey),
+		apiserver.ServerConfig{
+			MaxConnections:      2,
+			ReservedConnections: 1,
+		},
+	)
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+
+	open := func(jobs ...state.MachineJob) (*api.State, error) {
+		stm, err := s.State.AddMachine("series", jobs...)
+		c.Assert(err, IsNil)
+		err = stm.SetProvisioned("foo", "fake_nonce", nil)
+		c.Assert(err, IsNil)
+		err = stm.SetPassword("password")
+		c.Assert(err, IsNil)
+		return api.Open(&api.Info{
+			Tag:      stm.Tag(),
+			Password: "password",
+			Nonce:    "fake_nonce",
+			Addrs:    []string{srv.Addr()},
+			CACert:   []byte(coretesting.CACert),
+		}, fastDialOpts)
+	}
+
+	st, err := open(state.JobHostUnits)
+	c.Assert(err, IsNil)
+	defer st.Close()
+
+	// The remaining slot is reserved for environment managers.
+	_, err = open(state.JobHostUnits)
+	c.Assert(err, ErrorMatches, "server at capacity")
+	c.Assert(params.ErrCode(err), Equals, params.CodeTryAgain)
+	manager, err := open(state.JobManageEnviron)
+	c.Assert(err, IsNil)
+	defer manager.Close()
+	_, err = open(state.JobManageEnviron)
+	c.Assert(err, ErrorMatches, "server at capacity")
+
+	// Closing a connection frees its slot.
+	err = st.Close()
+	c.Assert(err, IsNil)
+	attempt := utils.AttemptStrategy{
+		Total: coretesting.LongWait,
+		Delay: 10 * time.Millisecond,
+	}
+	for a := attempt.Start(); a.Next(); {
+		var st1 *api.State
+		st1, err = open(state.JobHostUnits)
+		if err == nil {
+			st1.Close()
+			break
+		}
+		c.Assert(params.ErrCode(err), Equals, params.CodeTryAgain)
+	}
+	c.Assert(err, IsNil)
+}
+
+func (s *serverSuite) TestUnitLoginHeadroom(c *C) {
+	srv, err := apiserver.NewServerWithConfig(
+		s.State,
+		"localhost:0",
+		[]byte(coretesting.ServerCert),
+		[]byte(coretesting.ServerKey),
+		apiserver.ServerConfig{
+			MaxConnections:      3,
+			ReservedConnections: 1,
+			UnitLoginHeadroom:   1,
+		},
+	)
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+
+	info := func(tag, password, nonce string) *api.Info {
+		return &api.Info{
+			Tag:      tag,
+			Password: password,
+			Nonce:    nonce,
+			Addrs:    []string{srv.Addr()},
+			CACert:   []byte(coretesting.CACert),
+		}
+	}
+	openMachine := func(jobs ...state.MachineJob) (*api.State, error) {
+		stm, err := s.State.AddMachine("series", jobs...)
+		c.Assert(err, IsNil)
+		err = stm.SetProvisioned("foo", "fake_nonce", nil)
+		c.Assert(err, IsNil)
+		err = stm.SetPassword("password")
+		c.Assert(err, IsNil)
+		return api.Open(info(stm.Tag(), "password", "fake_nonce"), fastDialOpts)
+	}
+	svc, err := s.State.AddService("wordpress", s.AddTestingCharm(c, "wordpress"))
+	c.Assert(err, IsNil)
+	addUnit := func() *state.Unit {
+		u, err := svc.AddUnit()
+		c.Assert(err, IsNil)
+		err = u.SetPassword("password")
+		c.Assert(err, IsNil)
+		return u
+	}
+
+	u0 := addUnit()
+	st, err := api.Open(info(u0.Tag(), "password", ""), fastDialOpts)
+	c.Assert(err, IsNil)
+	defer st.Close()
+
+	// Unit agents may not take the headroom, and are refused
+	// before their credentials are checked.
+	u1 := addUnit()
+	_, err = api.Open(info(u1.Tag(), "password", ""), fastDialOpts)
+	c.Assert(err, ErrorMatches, "server at capacity")
+	c.Assert(params.ErrCode(err), Equals, params.CodeTryAgain)
+	_, err = api.Open(info(u1.Tag(), "wrong", ""), fastDialOpts)
+	c.Assert(err, ErrorMatches, "server at capacity")
+
+	// Other machine agents may take the headroom, and
+	// environment managers the reserved slot as well.
+	machine, err := openMachine(state.JobHostUnits)
+	c.Assert(err, IsNil)
+	defer machine.Close()
+	_, err = openMachine(state.JobHostUnits)
+	c.Assert(err, ErrorMatches, "server at capacity")
+	manager, err := openMachine(state.JobManageEnviron)
+	c.Assert(err, IsNil)
+	defer manager.Close()
+}
+
+type reverseRoot struct{}
+
+func (reverseRoot) Agent(id string) (reverseAgent, error) {
+	return reverseAgent{}, nil
+}
+
+type reverseAgent struct{}
+
+type echoArgs struct {
+	Message string
+}
+
+func (reverseAgent) Echo(args echoArgs) echoArgs {
+	return args
+}
+
+func (s *serverSuite) TestCallAgent(c *C) {
+	srv, err := apiserver.NewServer(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey))
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	err = stm.SetProvisioned("foo", "fake_nonce", nil)
+	c.Assert(err, IsNil)
+	err = stm.SetPassword("password")
+	c.Assert(err, IsNil)
+
+	var result echoArgs
+	err = srv.CallAgent(stm.Tag(), "Agent", "", "Echo", echoArgs{"hello"}, &result)
+	c.Assert(errors.IsNotFoundError(err), Equals, true, Commentf("error %v", err))
+
+	st, err := api.Open(&api.Info{
+		Tag:      stm.Tag(),
+		Password: "password",
+		Nonce:    "fake_nonce",
+		Addrs:    []string{srv.Addr()},
+		CACert:   []byte(coretesting.CACert),
+	}, fastDialOpts)
+	c.Assert(err, IsNil)
+	defer st.Close()
+	err = st.ServeReverse(reverseRoot{})
+	c.Assert(err, IsNil)
+
+	err = srv.CallAgent(stm.Tag(), "Agent", "", "Echo", echoArgs{"hello"}, &result)
+	c.Assert(err, IsNil)
+	c.Assert(result, Equals, echoArgs{"hello"})
+
+	// The channel goes when the connection does.
+	err = st.Close()
+	c.Assert(err, IsNil)
+	attempt := utils.AttemptStrategy{
+		Total: coretesting.LongWait,
+		Delay: 10 * time.Millisecond,
+	}
+	for a := attempt.Start(); a.Next(); {
+		err = srv.CallAgent(stm.Tag(), "Agent", "", "Echo", echoArgs{"hello"}, &result)
+		if errors.IsNotFoundError(err) {
+			break
+		}
+	}
+	c.Assert(errors.IsNotFoundError(err), Equals, true, Commentf("error %v", err))
+}
+
+func (s *serverSuite) TestArgsValidated(c *C) {
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	err = stm.SetProvisioned("foo", "fake_nonce", nil)
+	c.Assert(err, IsNil)
+	err = stm.SetPassword("password")
+	c.Assert(err, IsNil)
+	st := s.OpenAPIAsMachine(c, stm.Tag(), "password", "fake_nonce")
+	defer st.Close()
+
+	// An invalid entry of a bulk call fails only that entry.
+	var results params.LifeResults
+	args := params.Entities{Entities: []params.Entity{{Tag: stm.Tag()}, {Tag: "foo"}, {Tag: "machine-42"}}}
+	err = st.Call("Machiner", "", "Life", args, &results)
+	c.Assert(err, IsNil)
+	c.Assert(results.Results, HasLen, 3)
+	c.Assert(results.Results[0], DeepEquals, params.LifeResult{Life: params.Alive})
+	c.Assert(results.Results[1].Error, ErrorMatches, `invalid request: Entities\[1\]\.Tag: invalid entity name "foo"`)
+	c.Assert(results.Results[1].Error.Code, Equals, params.CodeBadRequest)
+	c.Assert(results.Results[2].Error, DeepEquals, &params.Error{
+		Message: "permission denied",
+		Code:    params.CodeUnauthorized,
+	})
+
+	var errResults params.ErrorResults
+	statusArgs := params.MachinesSetStatus{Machines: []params.MachineSetStatus{
+		{Tag: "foo", Status: params.StatusStarted},
+		{Tag: stm.Tag(), Status: params.StatusStarted},
+	}}
+	err = st.Call("Machiner", "", "SetStatus", statusArgs, &errResults)
+	c.Assert(err, IsNil)
+	c.Assert(errResults.Errors, HasLen, 2)
+	c.Assert(errResults.Errors[0], ErrorMatches, `invalid request: Machines\[0\]\.Tag: invalid entity name "foo"`)
+	c.Assert(errResults.Errors[1], IsNil)
+}
+
 func (s *serverSuite) TestOpenAsMachineErrors(c *C) {
 	stm, err := s.State.AddMachine("series", state.JobHostUnits)
 	c.Assert(err, IsNil)
@@ -158,3 +597,1646 @@ func (s *serverSuite) TestMachineLoginStartsPinger(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(alive, Equals, false)
 }
+
+func (s *serverSuite) TestOnKill(c *C) {
+	srv, err := apiserver.NewServer(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey))
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+
+	// The functions are called in order, even
+	// when one of those before them panics.
+	var called []int
+	apiserver.KillRoot(srv, stm,
+		func() { called = append(called, 0) },
+		func() { panic("cleanup failed") },
+		func() { called = append(called, 2) },
+	)
+	c.Assert(called, DeepEquals, []int{0, 2})
+}
+
+func (s *serverSuite) TestRefreshEntity(c *C) {
+	stm, st := s.openAsNewMachine(c, state.JobHostUnits)
+	defer st.Close()
+	err := st.Call("AuthEntity", "", "Refresh", nil, nil)
+	c.Assert(err, IsNil)
+
+	// Once the entity is found removed, every
+	// request fails as not logged in.
+	err = stm.EnsureDead()
+	c.Assert(err, IsNil)
+	err = stm.Remove()
+	c.Assert(err, IsNil)
+	err = st.Call("AuthEntity", "", "Refresh", nil, nil)
+	c.Assert(err, ErrorMatches, "not logged in")
+	c.Assert(params.ErrCode(err), Equals, params.CodeUnauthorized)
+	err = st.Call("Pinger", "", "Ping", nil, nil)
+	c.Assert(err, ErrorMatches, "not logged in")
+	err = st.Call("AuthEntity", "", "Refresh", nil, nil)
+	c.Assert(err, ErrorMatches, "not logged in")
+}
+
+func (s *serverSuite) TestWatchControllerInfo(c *C) {
+	stm, st := s.openAsNewMachine(c, state.JobHostUnits, state.JobManageState)
+	defer st.Close()
+
+	// Both agents and clients may watch the controllers.
+	var result params.ControllersWatchResult
+	err := s.APIState.Call("Client", "", "WatchControllerInfo", nil, &result)
+	c.Assert(err, IsNil)
+	c.Assert(result.MachineIds, DeepEquals, []string{stm.Id()})
+	clientW := watcher.NewNotifyWatcher(s.APIState, params.NotifyWatchResult{NotifyWatcherId: result.NotifyWatcherId})
+	clientWC := statetesting.NewNotifyWatcherC(c, s.State, clientW)
+	clientWC.AssertOneChange()
+
+	err = st.Call("AgentWatchers", "", "WatchControllerInfo", nil, &result)
+	c.Assert(err, IsNil)
+	c.Assert(result.MachineIds, DeepEquals, []string{stm.Id()})
+	w := watcher.NewNotifyWatcher(st, params.NotifyWatchResult{NotifyWatcherId: result.NotifyWatcherId})
+	wc := statetesting.NewNotifyWatcherC(c, s.State, w)
+	wc.AssertOneChange()
+
+	// Adding a controller is reported.
+	_, err = s.State.AddMachine("series", state.JobManageState)
+	c.Assert(err, IsNil)
+	clientWC.AssertOneChange()
+	wc.AssertOneChange()
+	statetesting.AssertStop(c, clientW)
+	clientWC.AssertClosed()
+	statetesting.AssertStop(c, w)
+	wc.AssertClosed()
+}
+
+func (s *serverSuite) TestWatchConstraints(c *C) {
+	err := s.State.SetEnvironConstraints(constraints.MustParse("mem=4G"))
+	c.Assert(err, IsNil)
+
+	// Only environment managers may watch the constraints.
+	_, agentSt := s.openAsNewMachine(c, state.JobHostUnits)
+	defer agentSt.Close()
+	var result params.ConstraintsWatchResult
+	err = agentSt.Call("AgentWatchers", "", "WatchConstraints", nil, &result)
+	c.Assert(err, ErrorMatches, "permission denied")
+	c.Assert(params.ErrCode(err), Equals, params.CodeUnauthorized)
+
+	_, st := s.openAsNewMachine(c, state.JobManageEnviron)
+	defer st.Close()
+	err = st.Call("AgentWatchers", "", "WatchConstraints", nil, &result)
+	c.Assert(err, IsNil)
+	c.Assert(result.Constraints, DeepEquals, constraints.MustParse("mem=4G"))
+	w := watcher.NewNotifyWatcher(st, params.NotifyWatchResult{NotifyWatcherId: result.NotifyWatcherId})
+	wc := statetesting.NewNotifyWatcherC(c, s.State, w)
+	wc.AssertOneChange()
+
+	err = s.State.SetEnvironConstraints(constraints.MustParse("mem=8G"))
+	c.Assert(err, IsNil)
+	wc.AssertOneChange()
+	statetesting.AssertStop(c, w)
+	wc.AssertClosed()
+}
+
+func (s *serverSuite) TestWatchEnvironLife(c *C) {
+	// Only agents may watch the environment's life.
+	var result params.EnvironLifeWatchResult
+	err := s.APIState.Call("AgentWatchers", "", "WatchEnvironLife", nil, &result)
+	c.Assert(err, ErrorMatches, "permission denied")
+	c.Assert(params.ErrCode(err), Equals, params.CodeUnauthorized)
+
+	_, st := s.openAsNewMachine(c, state.JobHostUnits)
+	defer st.Close()
+	err = st.Call("AgentWatchers", "", "WatchEnvironLife", nil, &result)
+	c.Assert(err, IsNil)
+	c.Assert(result.Life, Equals, params.Alive)
+	w := watcher.NewNotifyWatcher(st, params.NotifyWatchResult{NotifyWatcherId: result.NotifyWatcherId})
+	wc := statetesting.NewNotifyWatcherC(c, s.State, w)
+	wc.AssertOneChange()
+
+	env, err := s.State.Environment()
+	c.Assert(err, IsNil)
+	err = env.Destroy()
+	c.Assert(err, IsNil)
+	wc.AssertOneChange()
+	statetesting.AssertStop(c, w)
+	wc.AssertClosed()
+}
+
+func (s *serverSuite) TestDeprecationNotices(c *C) {
+	notice := params.DeprecationNotice{
+		Facade:         "Machiner",
+		Method:         "Life",
+		Replacement:    "Machiner.Watch",
+		RemovalVersion: "2.0",
+	}
+	srv, err := apiserver.NewServerWithConfig(
+		s.State,
+		"localhost:0",
+		[]byte(coretesting.ServerCert),
+		[]byte(coretesting.ServerKey),
+		apiserver.ServerConfig{Deprecated: []params.DeprecationNotice{notice}},
+	)
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	err = stm.SetProvisioned("foo", "fake_nonce", nil)
+	c.Assert(err, IsNil)
+	err = stm.SetPassword("password")
+	c.Assert(err, IsNil)
+	st, err := api.Open(&api.Info{
+		Tag:      stm.Tag(),
+		Password: "password",
+		Nonce:    "fake_nonce",
+		Addrs:    []string{srv.Addr()},
+		CACert:   []byte(coretesting.CACert),
+	}, fastDialOpts)
+	c.Assert(err, IsNil)
+	defer st.Close()
+
+	notices, err := st.DeprecationNotices()
+	c.Assert(err, IsNil)
+	c.Assert(notices, HasLen, 0)
+
+	// The deprecated method is served as usual, and
+	// a single notice is recorded however often it is
+	// called.
+	for i := 0; i < 2; i++ {
+		m, err := st.Machiner().Machine(stm.Tag())
+		c.Assert(err, IsNil)
+		c.Assert(m.Life(), Equals, params.Alive)
+	}
+	notices, err = st.DeprecationNotices()
+	c.Assert(err, IsNil)
+	c.Assert(notices, DeepEquals, []params.DeprecationNotice{notice})
+
+	// Notices are returned only once.
+	_, err = st.Machiner().Machine(stm.Tag())
+	c.Assert(err, IsNil)
+	notices, err = st.DeprecationNotices()
+	c.Assert(err, IsNil)
+	c.Assert(notices, HasLen, 0)
+
+	var result params.DeprecationNotices
+	err = st.Call("Deprecations", "x", "Notices", nil, &result)
+	c.Assert(err, ErrorMatches, "id not found")
+}
+
+func (s *serverSuite) TestConnectionsCountGoroutines(c *C) {
+	srv, err := apiserver.NewServer(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey))
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+	m0, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	m1, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	root, err := apiserver.AddWatchingRoot(srv, m0)
+	c.Assert(err, IsNil)
+
+	conns := srv.Connections()
+	c.Assert(conns, HasLen, 1)
+	c.Assert(conns[0].AuthTag, Equals, m0.Tag())
+	c.Assert(conns[0].Resources, Equals, 0)
+	c.Assert(conns[0].Goroutines, Equals, 0)
+
+	// The aggregate watcher runs one goroutine of its
+	// own, and one for each entity watched.
+	allow := func(string) bool { return true }
+	_, err = root.WatchEntities([]string{m0.Tag(), m1.Tag()}, allow)
+	c.Assert(err, IsNil)
+	conns = srv.Connections()
+	c.Assert(conns, HasLen, 1)
+	c.Assert(conns[0].Resources, Equals, 1)
+	c.Assert(conns[0].Goroutines, Equals, 3)
+
+	// Killing the connection stops the watcher, whose
+	// goroutines exit, and removes the connection.
+	root.Kill()
+	c.Assert(srv.Connections(), HasLen, 0)
+}
+
+func (s *serverSuite) TestWatchEntitiesFacade(c *C) {
+	stm, st := s.openAsNewMachine(c, state.JobHostUnits)
+	defer st.Close()
+	svc, err := s.State.AddService("wordpress", s.AddTestingCharm(c, "wordpress"))
+	c.Assert(err, IsNil)
+	u, err := svc.AddUnit()
+	c.Assert(err, IsNil)
+	err = u.AssignToMachine(stm)
+	c.Assert(err, IsNil)
+	other, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+
+	// A machine agent may watch itself and its units;
+	// other entities are dropped.
+	args := params.Entities{Entities: []params.Entity{
+		{Tag: stm.Tag()},
+		{Tag: u.Tag()},
+		{Tag: other.Tag()},
+	}}
+	var result params.StringsWatchResult
+	err = st.Call("AgentWatchers", "", "WatchEntities", args, &result)
+	c.Assert(err, IsNil)
+	w := watcher.NewStringsWatcher(st, result)
+	wc := statetesting.NewStringsWatcherC(c, s.State, w)
+	wc.AssertChange(stm.Tag(), u.Tag())
+	wc.AssertNoChange()
+
+	err = other.Destroy()
+	c.Assert(err, IsNil)
+	wc.AssertNoChange()
+	err = u.SetPublicAddress("example.com")
+	c.Assert(err, IsNil)
+	wc.AssertChange(u.Tag())
+	wc.AssertNoChange()
+	statetesting.AssertStop(c, w)
+	wc.AssertClosed()
+}
+
+func (s *serverSuite) TestWatchInOrderFacade(c *C) {
+	stm, st := s.openAsNewMachine(c, state.JobHostUnits)
+	defer st.Close()
+	env, err := s.State.Environment()
+	c.Assert(err, IsNil)
+	other, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+
+	// An agent may watch itself and the environment;
+	// other entities are dropped.
+	args := params.Entities{Entities: []params.Entity{
+		{Tag: stm.Tag()},
+		{Tag: other.Tag()},
+		{Tag: env.Tag()},
+	}}
+	var result params.StringsWatchResult
+	err = st.Call("AgentWatchers", "", "WatchInOrder", args, &result)
+	c.Assert(err, IsNil)
+	c.Assert(result.Changes, DeepEquals, []string{stm.Tag(), env.Tag()})
+	w := watcher.NewStringsWatcher(st, params.StringsWatchResult{StringsWatcherId: result.StringsWatcherId})
+	defer statetesting.AssertStop(c, w)
+
+	// next returns the tags reported by w until n have been read.
+	next := func(n int) []string {
+		s.State.StartSync()
+		var tags []string
+		for len(tags) < n {
+			select {
+			case changes, ok := <-w.Changes():
+				c.Assert(ok, Equals, true)
+				tags = append(tags, changes...)
+			case <-time.After(coretesting.LongWait):
+				c.Fatalf("watcher did not send change")
+			}
+		}
+		return tags
+	}
+	// Changes are reported in the order they were made.
+	err = statetesting.SetAgentVersion(s.State, version.MustParse("1.2.3"))
+	c.Assert(err, IsNil)
+	err = stm.Destroy()
+	c.Assert(err, IsNil)
+	c.Assert(next(2), DeepEquals, []string{env.Tag(), stm.Tag()})
+}
+
+func (s *serverSuite) TestWatchWildcard(c *C) {
+	srv, err := apiserver.NewServer(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey))
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	svc, err := s.State.AddService("wordpress", s.AddTestingCharm(c, "wordpress"))
+	c.Assert(err, IsNil)
+	u0, err := svc.AddUnit()
+	c.Assert(err, IsNil)
+	root, err := apiserver.AddWatchingRoot(srv, stm)
+	c.Assert(err, IsNil)
+	defer root.Kill()
+
+	// Permission is checked against the service.
+	var checked []string
+	authFor := func(tag string) bool {
+		checked = append(checked, tag)
+		return tag == svc.Tag()
+	}
+	result, err := root.WatchWildcard("unit-wordpress-*", authFor)
+	c.Assert(err, IsNil)
+	c.Assert(checked, DeepEquals, []string{svc.Tag()})
+	c.Assert(result.Changes, DeepEquals, []string{u0.Tag()})
+
+	_, err = root.WatchWildcard("machine-0-lxc-*", authFor)
+	c.Assert(err, Equals, common.ErrPerm)
+	for _, pattern := range []string{"unit-wordpress", "user-*", "machine-lxc-*", "machine-0-foo-*"} {
+		_, err = root.WatchWildcard(pattern, authFor)
+		c.Check(err, ErrorMatches, `invalid wildcard ".*"`)
+	}
+}
+
+func (s *serverSuite) TestWatchWildcardFacade(c *C) {
+	stm, st := s.openAsNewMachine(c, state.JobHostUnits)
+	defer st.Close()
+	svc, err := s.State.AddService("wordpress", s.AddTestingCharm(c, "wordpress"))
+	c.Assert(err, IsNil)
+	u0, err := svc.AddUnit()
+	c.Assert(err, IsNil)
+	err = u0.AssignToMachine(stm)
+	c.Assert(err, IsNil)
+	_, err = s.State.AddService("other", s.AddTestingCharm(c, "wordpress"))
+	c.Assert(err, IsNil)
+
+	// A machine agent may watch the services of its units,
+	// and its own containers.
+	var result params.StringsWatchResult
+	err = st.Call("AgentWatchers", "", "WatchWildcard", params.WatchWildcard{Pattern: "unit-other-*"}, &result)
+	c.Assert(err, ErrorMatches, "permission denied")
+	err = st.Call("AgentWatchers", "", "WatchWildcard", params.WatchWildcard{Pattern: "machine-99-lxc-*"}, &result)
+	c.Assert(err, ErrorMatches, "permission denied")
+	err = st.Call("AgentWatchers", "", "WatchWildcard", params.WatchWildcard{Pattern: stm.Tag() + "-lxc-*"}, &result)
+	c.Assert(err, IsNil)
+	c.Assert(result.Changes, HasLen, 0)
+
+	err = st.Call("AgentWatchers", "", "WatchWildcard", params.WatchWildcard{Pattern: "unit-wordpress-*"}, &result)
+	c.Assert(err, IsNil)
+	w := watcher.NewStringsWatcher(st, result)
+	wc := statetesting.NewStringsWatcherC(c, s.State, w)
+	wc.AssertChange(u0.Tag())
+	wc.AssertNoChange()
+
+	u1, err := svc.AddUnit()
+	c.Assert(err, IsNil)
+	wc.AssertChange(u1.Tag())
+	wc.AssertNoChange()
+	statetesting.AssertStop(c, w)
+	wc.AssertClosed()
+}
+
+type fakeStringsWatcher struct {
+	changes chan []string
+}
+
+func (w *fakeStringsWatcher) Stop() error              { return nil }
+func (w *fakeStringsWatcher) Err() error               { return nil }
+func (w *fakeStringsWatcher) Changes() <-chan []string { return w.changes }
+
+type fakeResource struct{}
+
+func (*fakeResource) Stop() error { return nil }
+
+func (s *serverSuite) TestFindWatchers(c *C) {
+	srv, err := apiserver.NewServer(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey))
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	other, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	root, err := apiserver.AddWatchingRoot(srv, stm)
+	c.Assert(err, IsNil)
+	defer root.Kill()
+
+	resources := root.Resources()
+	first := resources.RegisterFor(stm.Watch(), stm.Tag())
+	resources.RegisterFor(other.Watch(), other.Tag())
+	resources.Register(&fakeStringsWatcher{})
+	second := resources.RegisterFor(stm.WatchUnits(), stm.Tag())
+
+	// The watchers of the entity are found in the order
+	// they were started, and no longer once stopped.
+	c.Assert(root.FindWatchers(stm.Tag()), DeepEquals, []string{first, second})
+	c.Assert(root.FindWatchers("machine-99"), HasLen, 0)
+	err = resources.Stop(first)
+	c.Assert(err, IsNil)
+	c.Assert(root.FindWatchers(stm.Tag()), DeepEquals, []string{second})
+}
+
+func (s *serverSuite) TestResourceAges(c *C) {
+	srv, err := apiserver.NewServer(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey))
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	root, err := apiserver.AddWatchingRoot(srv, stm)
+	c.Assert(err, IsNil)
+	defer root.Kill()
+
+	// The resources just registered are counted
+	// as younger than a minute.
+	resources := root.Resources()
+	resources.Register(&fakeStringsWatcher{})
+	resources.Register(&fakeResource{})
+	c.Assert(root.ResourceAges(), DeepEquals, []common.AgeBucket{
+		{MaxAge: time.Minute, Count: 2},
+		{MaxAge: 5 * time.Minute},
+		{MaxAge: time.Hour},
+		{},
+	})
+}
+
+func (s *serverSuite) TestStopWatchersByType(c *C) {
+	srv, err := apiserver.NewServer(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey))
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	root, err := apiserver.AddWatchingRoot(srv, stm)
+	c.Assert(err, IsNil)
+	defer root.Kill()
+
+	resources := root.Resources()
+	notifyId := resources.Register(stm.Watch())
+	identity := func(change string) (string, bool) { return change, true }
+	stringsId1 := apiserver.RegisterCompacted(root, &fakeStringsWatcher{}, identity)
+	stringsId2 := resources.Register(&fakeStringsWatcher{})
+	c.Assert(apiserver.HasWatcherSettings(root, stringsId1), Equals, true)
+
+	c.Assert(root.StopWatchersByType("NoSuchWatcher"), Equals, 0)
+	c.Assert(root.StopWatchersByType("AllWatcher"), Equals, 0)
+	c.Assert(resources.Count(), Equals, 3)
+
+	c.Assert(root.StopWatchersByType("StringsWatcher"), Equals, 2)
+	c.Assert(resources.Get(stringsId1), IsNil)
+	c.Assert(resources.Get(stringsId2), IsNil)
+	c.Assert(resources.Get(notifyId), NotNil)
+	c.Assert(apiserver.HasWatcherSettings(root, stringsId1), Equals, false)
+
+	c.Assert(root.StopWatchersByType("NotifyWatcher"), Equals, 1)
+	c.Assert(resources.Count(), Equals, 0)
+}
+
+func (s *serverSuite) TestStopWatchers(c *C) {
+	srv, err := apiserver.NewServer(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey))
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	root, err := apiserver.AddWatchingRoot(srv, stm)
+	c.Assert(err, IsNil)
+	defer root.Kill()
+
+	resources := root.Resources()
+	notifyId := resources.Register(stm.Watch())
+	stringsId := resources.Register(&fakeStringsWatcher{})
+	keptId := resources.Register(&fakeStringsWatcher{})
+	// Resources other than watchers cannot be stopped this way.
+	otherId := resources.Register(&fakeResource{})
+
+	errs := root.StopWatchers([]string{notifyId, "42", stringsId, notifyId, otherId})
+	c.Assert(errs, DeepEquals, []error{
+		nil,
+		common.ErrUnknownWatcher,
+		nil,
+		common.ErrUnknownWatcher,
+		common.ErrUnknownWatcher,
+	})
+	c.Assert(resources.Get(notifyId), IsNil)
+	c.Assert(resources.Get(stringsId), IsNil)
+	c.Assert(resources.Get(keptId), NotNil)
+	c.Assert(resources.Get(otherId), NotNil)
+	c.Assert(root.StopWatchers(nil), HasLen, 0)
+}
+
+func (s *serverSuite) TestWatcherManagerStop(c *C) {
+	stm, st := s.openAsNewMachine(c, state.JobHostUnits)
+	defer st.Close()
+	watch := func() string {
+		args := params.Entities{Entities: []params.Entity{{Tag: stm.Tag()}}}
+		var results params.NotifyWatchResults
+		err := st.Call("Machiner", "", "Watch", args, &results)
+		c.Assert(err, IsNil)
+		c.Assert(results.Results, HasLen, 1)
+		c.Assert(results.Results[0].Error, IsNil)
+		return results.Results[0].NotifyWatcherId
+	}
+	id1, id2, kept := watch(), watch(), watch()
+
+	args := params.WatcherIds{Ids: []string{id1, "42", id2, id1}}
+	var results params.ErrorResults
+	err := st.Call("WatcherManager", "", "Stop", args, &results)
+	c.Assert(err, IsNil)
+	c.Assert(results.Errors, HasLen, 4)
+	c.Assert(results.Errors[0], IsNil)
+	c.Assert(results.Errors[1], ErrorMatches, "unknown watcher id")
+	c.Assert(results.Errors[2], IsNil)
+	c.Assert(results.Errors[3], ErrorMatches, "unknown watcher id")
+
+	// The stopped watchers are gone; the other is still served.
+	err = st.Call("NotifyWatcher", id1, "Next", nil, nil)
+	c.Assert(err, ErrorMatches, "unknown watcher id")
+	err = st.Call("NotifyWatcher", id2, "Next", nil, nil)
+	c.Assert(err, ErrorMatches, "unknown watcher id")
+	err = stm.Destroy()
+	c.Assert(err, IsNil)
+	s.State.StartSync()
+	err = st.Call("NotifyWatcher", kept, "Next", nil, nil)
+	c.Assert(err, IsNil)
+}
+
+func (s *serverSuite) TestWatcherManagerStopKind(c *C) {
+	stm, st := s.openAsNewMachine(c, state.JobHostUnits)
+	defer st.Close()
+	var notify params.NotifyWatchResults
+	err := st.Call("Machiner", "", "Watch", params.Entities{Entities: []params.Entity{{Tag: stm.Tag()}}}, &notify)
+	c.Assert(err, IsNil)
+	c.Assert(notify.Results, HasLen, 1)
+	c.Assert(notify.Results[0].Error, IsNil)
+	var watched params.StringsWatchResult
+	err = st.Call("AgentWatchers", "", "WatchEntities", params.Entities{Entities: []params.Entity{{Tag: stm.Tag()}}}, &watched)
+	c.Assert(err, IsNil)
+
+	stopKind := func(kind string) int {
+		var result params.StoppedWatchers
+		err := st.Call("WatcherManager", "", "StopKind", params.WatcherKind{Kind: kind}, &result)
+		c.Assert(err, IsNil)
+		return result.Count
+	}
+	c.Assert(stopKind("NoSuchWatcher"), Equals, 0)
+	c.Assert(stopKind("StringsWatcher"), Equals, 1)
+	err = st.Call("StringsWatcher", watched.StringsWatcherId, "Next", nil, nil)
+	c.Assert(err, ErrorMatches, "unknown watcher id")
+
+	// The NotifyWatcher is still served.
+	err = stm.Destroy()
+	c.Assert(err, IsNil)
+	s.State.StartSync()
+	err = st.Call("NotifyWatcher", notify.Results[0].NotifyWatcherId, "Next", nil, nil)
+	c.Assert(err, IsNil)
+	c.Assert(stopKind("NotifyWatcher"), Equals, 1)
+}
+
+func (s *serverSuite) TestWatcherLags(c *C) {
+	srv, err := apiserver.NewServer(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey))
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	root, err := apiserver.AddWatchingRoot(srv, stm)
+	c.Assert(err, IsNil)
+	defer root.Kill()
+	c.Assert(srv.WatcherLags(), HasLen, 0)
+
+	fw := &fakeStringsWatcher{changes: make(chan []string, 1)}
+	id := root.Resources().Register(fw)
+	w, err := root.StringsWatcher(id)
+	c.Assert(err, IsNil)
+
+	// An event left waiting is counted as lagging
+	// since the watcher was registered.
+	fw.changes <- []string{"a"}
+	time.Sleep(10 * time.Millisecond)
+	result, err := w.Next()
+	c.Assert(err, IsNil)
+	c.Assert(result.Changes, DeepEquals, []string{"a"})
+	lags := srv.WatcherLags()
+	c.Assert(lags, HasLen, 1)
+	c.Assert(lags[0].Facade, Equals, "StringsWatcher")
+	c.Assert(lags[0].Method, Equals, "Next")
+	c.Assert(lags[0].Count, Equals, uint64(1))
+	c.Assert(lags[0].P50 >= 5*time.Millisecond, Equals, true)
+
+	// An event that arrives while Next is waiting has no lag.
+	done := make(chan error)
+	go func() {
+		_, err := w.Next()
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	fw.changes <- []string{"b"}
+	c.Assert(<-done, IsNil)
+	lags = srv.WatcherLags()
+	c.Assert(lags, HasLen, 1)
+	c.Assert(lags[0].Count, Equals, uint64(2))
+	c.Assert(lags[0].P50 < time.Millisecond, Equals, true)
+}
+
+func (s *serverSuite) TestSessions(c *C) {
+	srv, err := apiserver.NewServer(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey))
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	err = stm.SetProvisioned("foo", "fake_nonce", nil)
+	c.Assert(err, IsNil)
+	err = stm.SetPassword("password")
+	c.Assert(err, IsNil)
+	other, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	admin, err := s.State.User("admin")
+	c.Assert(err, IsNil)
+	root, err := apiserver.AddWatchingRoot(srv, admin)
+	c.Assert(err, IsNil)
+	defer root.Kill()
+
+	// Only the administrative user may list and revoke sessions.
+	otherRoot, err := apiserver.AddWatchingRoot(srv, other)
+	c.Assert(err, IsNil)
+	defer otherRoot.Kill()
+	_, err = otherRoot.Sessions(stm.Tag())
+	c.Assert(err, Equals, common.ErrPerm)
+	err = otherRoot.RevokeSession("1")
+	c.Assert(err, Equals, common.ErrPerm)
+
+	// The machine has two sessions, one over the network.
+	stmRoot, err := apiserver.AddWatchingRoot(srv, stm)
+	c.Assert(err, IsNil)
+	defer stmRoot.Kill()
+	st, err := api.Open(&api.Info{
+		Tag:      stm.Tag(),
+		Password: "password",
+		Nonce:    "fake_nonce",
+		Addrs:    []string{srv.Addr()},
+		CACert:   []byte(coretesting.CACert),
+	}, fastDialOpts)
+	c.Assert(err, IsNil)
+	defer st.Close()
+	result, err := root.Sessions(stm.Tag())
+	c.Assert(err, IsNil)
+	sessions := result.Sessions
+	c.Assert(sessions, HasLen, 2)
+	for _, session := range sessions {
+		c.Assert(session.Tag, Equals, stm.Tag())
+		c.Assert(session.LoginTime.IsZero(), Equals, false)
+	}
+	c.Assert(sessions[0].RemoteAddr, Equals, "")
+	c.Assert(sessions[1].RemoteAddr, Not(Equals), "")
+
+	// Revoking the network session closes its connection
+	// and leaves the other session alone.
+	err = root.RevokeSession(sessions[1].ConnId)
+	c.Assert(err, IsNil)
+	_, err = st.Machiner().Machine(stm.Tag())
+	if err != rpc.ErrShutdown && err != io.ErrUnexpectedEOF {
+		c.Fatalf("unexpected error from request: %v", err)
+	}
+	result, err = root.Sessions(stm.Tag())
+	c.Assert(err, IsNil)
+	c.Assert(result.Sessions, DeepEquals, sessions[:1])
+
+	err = root.RevokeSession(sessions[1].ConnId)
+	c.Assert(err, Equals, common.ErrBadId)
+	err = root.RevokeSession("not a number")
+	c.Assert(err, Equals, common.ErrBadId)
+}
+
+func (s *serverSuite) TestWatchConnections(c *C) {
+	srv, err := apiserver.NewServer(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey))
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+	admin, err := s.State.User("admin")
+	c.Assert(err, IsNil)
+	root, err := apiserver.AddWatchingRoot(srv, admin)
+	c.Assert(err, IsNil)
+	defer root.Kill()
+
+	// Only the administrative user may watch connections.
+	other, err := s.State.AddUser("other", "")
+	c.Assert(err, IsNil)
+	otherRoot, err := apiserver.AddWatchingRoot(srv, other)
+	c.Assert(err, IsNil)
+	_, err = otherRoot.WatchConnections()
+	c.Assert(err, Equals, common.ErrPerm)
+	otherRoot.Kill()
+
+	// The initial event holds the connected entities.
+	result, err := root.WatchConnections()
+	c.Assert(err, IsNil)
+	c.Assert(result.Changes, DeepEquals, []string{"user-admin connected"})
+	w, ok := root.Resources().Get(result.StringsWatcherId).(state.StringsWatcher)
+	c.Assert(ok, Equals, true)
+	wc := statetesting.NewStringsWatcherC(c, s.State, w)
+	wc.AssertNoChange()
+
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	machineRoot, err := apiserver.AddWatchingRoot(srv, stm)
+	c.Assert(err, IsNil)
+	_, err = machineRoot.WatchConnections()
+	c.Assert(err, Equals, common.ErrPerm)
+	wc.AssertChange(stm.Tag() + " connected")
+	machineRoot.Kill()
+	wc.AssertChange(stm.Tag() + " disconnected")
+	wc.AssertNoChange()
+
+	// The watcher is stopped with the connection.
+	root.Kill()
+	wc.AssertClosed()
+}
+
+func (s *serverSuite) TestWatcherRateLimit(c *C) {
+	srv, err := apiserver.NewServer(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey))
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	root, err := apiserver.AddWatchingRoot(srv, stm)
+	c.Assert(err, IsNil)
+	defer root.Kill()
+
+	fw := &fakeStringsWatcher{changes: make(chan []string, 10)}
+	id := root.Resources().Register(fw)
+	next := func() time.Duration {
+		// The limit applies to every call on the watcher.
+		w, err := root.StringsWatcher(id)
+		c.Assert(err, IsNil)
+		fw.changes <- []string{"a"}
+		start := time.Now()
+		_, err = w.Next()
+		c.Assert(err, IsNil)
+		return time.Since(start)
+	}
+
+	w, err := root.StringsWatcher(id)
+	c.Assert(err, IsNil)
+	for _, limit := range []params.WatcherRateLimit{
+		{Count: -1, Interval: time.Second},
+		{Count: 1},
+		{Count: 1, Interval: time.Hour},
+	} {
+		err = w.SetRateLimit(limit)
+		c.Check(err, ErrorMatches, "invalid request: .*")
+	}
+
+	const interval = 100 * time.Millisecond
+	err = w.SetRateLimit(params.WatcherRateLimit{Count: 2, Interval: interval})
+	c.Assert(err, IsNil)
+	start := time.Now()
+	c.Assert(next() < interval/2, Equals, true)
+	c.Assert(next() < interval/2, Equals, true)
+	// The third event is held back until the first
+	// was delivered an interval ago.
+	next()
+	c.Assert(time.Since(start) >= interval, Equals, true)
+
+	// A zero count removes the limit.
+	err = w.SetRateLimit(params.WatcherRateLimit{})
+	c.Assert(err, IsNil)
+	for i := 0; i < 3; i++ {
+		c.Assert(next() < interval/2, Equals, true)
+	}
+
+	// A call held back is released when the connection is killed.
+	err = w.SetRateLimit(params.WatcherRateLimit{Count: 1, Interval: 10 * time.Minute})
+	c.Assert(err, IsNil)
+	next()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		next()
+	}()
+	select {
+	case <-done:
+		c.Fatalf("rate limited call was not held back")
+	case <-time.After(50 * time.Millisecond):
+	}
+	root.Kill()
+	select {
+	case <-done:
+	case <-time.After(coretesting.LongWait):
+		c.Fatalf("rate limited call was not released")
+	}
+}
+
+func (s *serverSuite) TestStringsWatcherSnapshot(c *C) {
+	srv, err := apiserver.NewServer(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey))
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	root, err := apiserver.AddWatchingRoot(srv, stm)
+	c.Assert(err, IsNil)
+	defer root.Kill()
+
+	sw := s.State.WatchServices()
+	_, err = common.InitialStringsEvent(root.Resources(), sw)
+	c.Assert(err, IsNil)
+	id := root.Resources().Register(sw)
+	_, err = s.State.AddService("wordpress", s.AddTestingCharm(c, "wordpress"))
+	c.Assert(err, IsNil)
+
+	result, err := root.Snapshot(id)
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, params.StringsWatchResult{
+		StringsWatcherId: id,
+		Changes:          []string{"wordpress"},
+		Snapshot:         true,
+	})
+	w, err := root.StringsWatcher(id)
+	c.Assert(err, IsNil)
+	result, err = w.Snapshot()
+	c.Assert(err, IsNil)
+	c.Assert(result.Changes, DeepEquals, []string{"wordpress"})
+
+	// The watcher still reports the change.
+	s.State.StartSync()
+	result, err = w.Next()
+	c.Assert(err, IsNil)
+	c.Assert(result.Changes, DeepEquals, []string{"wordpress"})
+	c.Assert(result.Snapshot, Equals, false)
+
+	_, err = root.Snapshot("999")
+	c.Assert(err, Equals, common.ErrUnknownWatcher)
+	fakeId := root.Resources().Register(&fakeStringsWatcher{})
+	_, err = root.Snapshot(fakeId)
+	c.Assert(err, ErrorMatches, `watcher ".*" cannot take snapshots`)
+}
+
+func (s *serverSuite) TestStringsWatcherTransform(c *C) {
+	srv, err := apiserver.NewServer(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey))
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	restore := apiserver.SetStringsTransform(0, func(changes []string) []string {
+		return []string{strings.Join(changes, ",")}
+	})
+	defer restore()
+
+	next := func(version int) []string {
+		root, err := apiserver.AddWatchingRootAtVersion(srv, stm, version)
+		c.Assert(err, IsNil)
+		defer root.Kill()
+		fw := &fakeStringsWatcher{changes: make(chan []string, 1)}
+		w, err := root.StringsWatcher(root.Resources().Register(fw))
+		c.Assert(err, IsNil)
+		fw.changes <- []string{"a", "b"}
+		result, err := w.Next()
+		c.Assert(err, IsNil)
+		return result.Changes
+	}
+	// Clients of the version with a transform get transformed
+	// changes; others get the changes unchanged.
+	c.Assert(next(0), DeepEquals, []string{"a,b"})
+	c.Assert(next(params.APIVersion), DeepEquals, []string{"a", "b"})
+}
+
+func (s *serverSuite) TestMethodCosts(c *C) {
+	srv, err := apiserver.NewServer(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey))
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	err = stm.SetProvisioned("foo", "fake_nonce", nil)
+	c.Assert(err, IsNil)
+	err = stm.SetPassword("password")
+	c.Assert(err, IsNil)
+	st, err := api.Open(&api.Info{
+		Tag:      stm.Tag(),
+		Password: "password",
+		Nonce:    "fake_nonce",
+		Addrs:    []string{srv.Addr()},
+		CACert:   []byte(coretesting.CACert),
+	}, fastDialOpts)
+	c.Assert(err, IsNil)
+	defer st.Close()
+
+	// Calls made while counting is disabled are not recorded.
+	_, err = st.Machiner().Machine(stm.Tag())
+	c.Assert(err, IsNil)
+	c.Assert(srv.MethodCosts(), HasLen, 0)
+
+	srv.SetOpCounting(true)
+	defer srv.SetOpCounting(false)
+	_, err = st.Machiner().Machine(stm.Tag())
+	c.Assert(err, IsNil)
+	var found bool
+	for _, cost := range srv.MethodCosts() {
+		if cost.Facade != "Machiner" || cost.Method != "Life" {
+			continue
+		}
+		found = true
+		c.Assert(cost.Calls, Equals, uint64(1))
+		c.Assert(cost.Ops.Reads >= 1, Equals, true)
+	}
+	c.Assert(found, Equals, true)
+}
+
+func (s *serverSuite) TestRequestLogSampling(c *C) {
+	srv, err := apiserver.NewServerWithConfig(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey), apiserver.ServerConfig{
+		RequestLogSampling: 3,
+	})
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	err = stm.SetProvisioned("foo", "fake_nonce", nil)
+	c.Assert(err, IsNil)
+	err = stm.SetPassword("password")
+	c.Assert(err, IsNil)
+	st, err := api.Open(&api.Info{
+		Tag:      stm.Tag(),
+		Password: "password",
+		Nonce:    "fake_nonce",
+		Addrs:    []string{srv.Addr()},
+		CACert:   []byte(coretesting.CACert),
+	}, fastDialOpts)
+	c.Assert(err, IsNil)
+	defer st.Close()
+
+	// One in three requests is logged.
+	for i := 0; i < 6; i++ {
+		_, err = st.Machiner().Machine(stm.Tag())
+		c.Assert(err, IsNil)
+	}
+	sampled := regexp.MustCompile(`state/api: connection \d+: Machiner\.Life took .*\n`)
+	c.Assert(sampled.FindAllString(c.GetTestLog(), -1), HasLen, 2)
+
+	// Failed requests always are.
+	err = st.Call("Client", "", "Status", nil, nil)
+	c.Assert(err, ErrorMatches, "permission denied")
+	c.Assert(c.GetTestLog(), Matches, `(?s).*state/api: connection \d+: Client\.Status took .*: permission denied\n.*`)
+}
+
+func (s *serverSuite) TestMaxWatcherLifetime(c *C) {
+	srv, err := apiserver.NewServerWithConfig(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey), apiserver.ServerConfig{
+		MaxWatcherLifetime: 500 * time.Millisecond,
+	})
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	err = stm.SetProvisioned("foo", "fake_nonce", nil)
+	c.Assert(err, IsNil)
+	err = stm.SetPassword("password")
+	c.Assert(err, IsNil)
+	st, err := api.Open(&api.Info{
+		Tag:      stm.Tag(),
+		Password: "password",
+		Nonce:    "fake_nonce",
+		Addrs:    []string{srv.Addr()},
+		CACert:   []byte(coretesting.CACert),
+	}, fastDialOpts)
+	c.Assert(err, IsNil)
+	defer st.Close()
+	watch := func() string {
+		args := params.Entities{Entities: []params.Entity{{Tag: stm.Tag()}}}
+		var results params.NotifyWatchResults
+		err := st.Call("Machiner", "", "Watch", args, &results)
+		c.Assert(err, IsNil)
+		c.Assert(results.Results, HasLen, 1)
+		c.Assert(results.Results[0].Error, IsNil)
+		return results.Results[0].NotifyWatcherId
+	}
+
+	// A watcher that has outlived its lifetime is stopped.
+	id := watch()
+	time.Sleep(600 * time.Millisecond)
+	err = st.Call("NotifyWatcher", id, "Next", nil, nil)
+	c.Assert(err, ErrorMatches, "watcher has outlived its maximum lifetime")
+	c.Assert(params.ErrCode(err), Equals, params.CodeWatcherExpired)
+	err = st.Call("NotifyWatcher", id, "Next", nil, nil)
+	c.Assert(params.ErrCode(err), Equals, params.CodeWatcherExpired)
+
+	// A new watcher is served.
+	id = watch()
+	err = stm.Destroy()
+	c.Assert(err, IsNil)
+	s.State.StartSync()
+	err = st.Call("NotifyWatcher", id, "Next", nil, nil)
+	c.Assert(err, IsNil)
+}
+
+func (s *serverSuite) TestMaxWatcherLifetimeSweep(c *C) {
+	srv, err := apiserver.NewServerWithConfig(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey), apiserver.ServerConfig{
+		MaxWatcherLifetime: 500 * time.Millisecond,
+	})
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	root, err := apiserver.AddWatchingRoot(srv, stm)
+	c.Assert(err, IsNil)
+	defer root.Kill()
+
+	// A watcher whose Next is never called is stopped once it
+	// has outlived its lifetime, and calls on it report its expiry.
+	resources := root.Resources()
+	id := resources.Register(&fakeStringsWatcher{})
+	other := resources.Register(&fakeResource{})
+	// Watchers are swept no more often than once a second.
+	time.Sleep(1200 * time.Millisecond)
+	c.Assert(resources.Get(id), IsNil)
+	c.Assert(resources.Get(other), NotNil)
+	_, err = root.StringsWatcher(id)
+	c.Assert(err, Equals, common.ErrWatcherExpired)
+	_, err = root.Snapshot(id)
+	c.Assert(err, Equals, common.ErrWatcherExpired)
+}
+
+func (s *serverSuite) TestExpiringWatchers(c *C) {
+	srv, err := apiserver.NewServerWithConfig(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey), apiserver.ServerConfig{
+		MaxWatcherLifetime: time.Second,
+	})
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	root, err := apiserver.AddWatchingRoot(srv, stm)
+	c.Assert(err, IsNil)
+	defer root.Kill()
+	c.Assert(root.ExpiringWatchers(0.5), HasLen, 0)
+
+	old := root.Resources().RegisterFor(&fakeStringsWatcher{changes: make(chan []string)}, stm.Tag())
+	time.Sleep(600 * time.Millisecond)
+	root.Resources().Register(&fakeStringsWatcher{changes: make(chan []string)})
+
+	// Only the watcher with less than half its
+	// lifetime left is reported.
+	expiring := root.ExpiringWatchers(0.5)
+	c.Assert(expiring, HasLen, 1)
+	c.Assert(expiring[0].Id, Equals, old)
+	c.Assert(expiring[0].Kind, Equals, "StringsWatcher")
+	c.Assert(expiring[0].Tag, Equals, stm.Tag())
+	c.Assert(expiring[0].Age >= 600*time.Millisecond, Equals, true)
+	c.Assert(expiring[0].Remaining <= 400*time.Millisecond, Equals, true)
+
+	// All watchers are nearing the end of their whole lifetime.
+	c.Assert(root.ExpiringWatchers(1), HasLen, 2)
+}
+
+func (s *serverSuite) TestStringsWatcherResyncRequired(c *C) {
+	srv, err := apiserver.NewServerWithConfig(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey), apiserver.ServerConfig{
+		MaxWatcherChanges: map[string]int{"StringsWatcher": 2},
+	})
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	root, err := apiserver.AddWatchingRoot(srv, stm)
+	c.Assert(err, IsNil)
+	defer root.Kill()
+
+	fw := &fakeStringsWatcher{changes: make(chan []string, 1)}
+	w, err := root.StringsWatcher(root.Resources().Register(fw))
+	c.Assert(err, IsNil)
+
+	// Changes within the limit are sent.
+	fw.changes <- []string{"a", "b"}
+	result, err := w.Next()
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, params.StringsWatchResult{Changes: []string{"a", "b"}})
+
+	// Changes beyond it are not.
+	fw.changes <- []string{"a", "b", "c"}
+	result, err = w.Next()
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, params.StringsWatchResult{ResyncRequired: true})
+
+	// The watcher carries on.
+	fw.changes <- []string{"d"}
+	result, err = w.Next()
+	c.Assert(err, IsNil)
+	c.Assert(result.Changes, DeepEquals, []string{"d"})
+}
+
+func (s *serverSuite) TestRetryTransient(c *C) {
+	srv, err := apiserver.NewServer(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey))
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	policy := apiserver.RetryPolicy{
+		Attempts: 4,
+		Delay:    time.Millisecond,
+		MaxDelay: 2 * time.Millisecond,
+	}
+	transient := fmt.Errorf("connection reset")
+	for i, test := range []struct {
+		about string
+		errs  []error
+		err   error
+		calls int
+	}{{
+		about: "success needs one call",
+		calls: 1,
+	}, {
+		about: "transient errors are retried",
+		errs:  []error{transient, transient},
+		calls: 3,
+	}, {
+		about: "retries are bounded",
+		errs:  []error{transient, transient, transient, transient, transient},
+		err:   transient,
+		calls: 4,
+	}, {
+		about: "errors with codes are not retried",
+		errs:  []error{common.ErrPerm},
+		err:   common.ErrPerm,
+		calls: 1,
+	}, {
+		about: "permanent errors are not retried",
+		errs:  []error{apiserver.PermanentError(transient)},
+		err:   transient,
+		calls: 1,
+	}} {
+		c.Logf("test %d: %s", i, test.about)
+		calls := 0
+		err := apiserver.RetryTransient(srv, stm, policy, func() error {
+			calls++
+			if calls <= len(test.errs) {
+				return test.errs[calls-1]
+			}
+			return nil
+		})
+		c.Check(err, Equals, test.err)
+		c.Check(calls, Equals, test.calls)
+	}
+
+	// By default, no retries are made.
+	calls := 0
+	err = apiserver.RetryTransient(srv, stm, apiserver.RetryPolicy{}, func() error {
+		calls++
+		return transient
+	})
+	c.Assert(err, Equals, transient)
+	c.Assert(calls, Equals, 1)
+}
+
+func (s *serverSuite) TestWatchersByService(c *C) {
+	srv, err := apiserver.NewServer(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey))
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+	m0, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	m1, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	charm := s.AddTestingCharm(c, "wordpress")
+	_, err = s.State.AddService("my-blog", charm)
+	c.Assert(err, IsNil)
+	_, err = s.State.AddService("wordpress", charm)
+	c.Assert(err, IsNil)
+	root0, err := apiserver.AddWatchingRoot(srv, m0)
+	c.Assert(err, IsNil)
+	defer root0.Kill()
+	root1, err := apiserver.AddWatchingRoot(srv, m1)
+	c.Assert(err, IsNil)
+	defer root1.Kill()
+	c.Assert(srv.WatchersByService(), HasLen, 0)
+
+	allow := func(string) bool { return true }
+	for _, watch := range []struct {
+		root    apiserver.WatchingRoot
+		pattern string
+	}{
+		{root0, "unit-my-blog-*"},
+		{root0, "unit-my-blog-*"},
+		{root0, "unit-wordpress-*"},
+		{root1, "unit-my-blog-*"},
+		{root1, "machine-0-lxc-*"},
+	} {
+		_, err = watch.root.WatchWildcard(watch.pattern, allow)
+		c.Assert(err, IsNil)
+	}
+	// Watchers registered without a tag are not counted.
+	_, err = root1.WatchEntities([]string{m0.Tag()}, allow)
+	c.Assert(err, IsNil)
+
+	c.Assert(srv.WatchersByService(), DeepEquals, []apiserver.ServiceWatchers{
+		{Service: "my-blog", Watchers: 3, Connections: 2},
+		{Service: "wordpress", Watchers: 1, Connections: 1},
+	})
+
+	root0.Kill()
+	c.Assert(srv.WatchersByService(), DeepEquals, []apiserver.ServiceWatchers{
+		{Service: "my-blog", Watchers: 1, Connections: 1},
+	})
+}
+
+func (s *serverSuite) TestDegradedStringsWatcher(c *C) {
+	// The watermark is set far above any real heap usage, so
+	// that only the checks made below change the server's mode.
+	const watermark = 1 << 50
+	srv, err := apiserver.NewServerWithConfig(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey), apiserver.ServerConfig{
+		HeapWatermark:       watermark,
+		DegradedBatchWindow: 50 * time.Millisecond,
+		DegradedMaxChanges:  3,
+	})
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	root, err := apiserver.AddWatchingRoot(srv, stm)
+	c.Assert(err, IsNil)
+	defer root.Kill()
+
+	fw := &fakeStringsWatcher{changes: make(chan []string)}
+	w, err := root.StringsWatcher(root.Resources().Register(fw))
+	c.Assert(err, IsNil)
+	send := func(changes ...[]string) {
+		go func() {
+			for _, change := range changes {
+				fw.changes <- change
+			}
+		}()
+	}
+
+	// Below the watermark, each change is delivered as it comes.
+	apiserver.CheckMemory(srv, watermark-1)
+	c.Assert(srv.Degraded(), Equals, false)
+	send([]string{"a"}, []string{"b"})
+	result, err := w.Next()
+	c.Assert(err, IsNil)
+	c.Assert(result.Changes, DeepEquals, []string{"a"})
+	result, err = w.Next()
+	c.Assert(err, IsNil)
+	c.Assert(result.Changes, DeepEquals, []string{"b"})
+
+	// At the watermark, changes are coalesced over the batch window.
+	apiserver.CheckMemory(srv, watermark)
+	c.Assert(srv.Degraded(), Equals, true)
+	send([]string{"a", "b"}, []string{"b", "c"})
+	result, err = w.Next()
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, params.StringsWatchResult{Changes: []string{"a", "b", "c"}})
+
+	// Too many changes ask the client to resync.
+	send([]string{"a", "b"}, []string{"c", "d"})
+	result, err = w.Next()
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, params.StringsWatchResult{ResyncRequired: true})
+
+	// Delivery is restored only once usage has fallen
+	// well below the watermark.
+	apiserver.CheckMemory(srv, watermark-watermark/20)
+	c.Assert(srv.Degraded(), Equals, true)
+	apiserver.CheckMemory(srv, watermark-watermark/10-1)
+	c.Assert(srv.Degraded(), Equals, false)
+	send([]string{"a", "b", "c", "d"})
+	result, err = w.Next()
+	c.Assert(err, IsNil)
+	c.Assert(result.Changes, DeepEquals, []string{"a", "b", "c", "d"})
+}
+
+func (s *serverSuite) TestCompactedStringsWatcher(c *C) {
+	srv, err := apiserver.NewServer(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey))
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	root, err := apiserver.AddWatchingRoot(srv, stm)
+	c.Assert(err, IsNil)
+	defer root.Kill()
+
+	// Changes are of the form "<key> <op>",
+	// where only "set" is an update.
+	key := func(change string) (string, bool) {
+		fields := strings.Fields(change)
+		return fields[0], fields[1] == "set"
+	}
+	fw := &fakeStringsWatcher{changes: make(chan []string, 1)}
+	w, err := root.StringsWatcher(apiserver.RegisterCompacted(root, fw, key))
+	c.Assert(err, IsNil)
+	for i, test := range []struct {
+		changes []string
+		expect  []string
+	}{{
+		changes: []string{"a set", "b set", "a set"},
+		expect:  []string{"b set", "a set"},
+	}, {
+		changes: []string{"a add", "a set", "a set", "a remove"},
+		expect:  []string{"a add", "a set", "a remove"},
+	}, {
+		changes: []string{"a set", "a remove", "a add", "a set"},
+		expect:  []string{"a set", "a remove", "a add", "a set"},
+	}, {
+		changes: []string{"a add", "a remove"},
+		expect:  []string{"a add", "a remove"},
+	}} {
+		c.Logf("test %d", i)
+		fw.changes <- test.changes
+		result, err := w.Next()
+		c.Assert(err, IsNil)
+		c.Assert(result.Changes, DeepEquals, test.expect)
+	}
+}
+
+func (s *serverSuite) TestRequestPriorities(c *C) {
+	srv, err := apiserver.NewServerWithConfig(
+		s.State,
+		"localhost:0",
+		[]byte(coretesting.ServerCert),
+		[]byte(coretesting.ServerKey),
+		apiserver.ServerConfig{
+			RequestPriorities: map[string]int{"unit": 5},
+		},
+	)
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+
+	machine := apiserver.RequestPriority(srv, "machine-0")
+	user := apiserver.RequestPriority(srv, "user-admin")
+	c.Assert(machine > user, Equals, true)
+	c.Assert(apiserver.RequestPriority(srv, "unit-wordpress-0"), Equals, 5)
+}
+
+func (s *serverSuite) TestWatcherHandoff(c *C) {
+	srv, err := apiserver.NewServer(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey))
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	from, err := apiserver.AddWatchingRoot(srv, stm)
+	c.Assert(err, IsNil)
+	defer from.Kill()
+	to, err := apiserver.AddWatchingRoot(srv, stm)
+	c.Assert(err, IsNil)
+	defer to.Kill()
+
+	w := stm.Watch()
+	wc := statetesting.NewNotifyWatcherC(c, s.State, w)
+	wc.AssertOneChange()
+	id := from.Resources().RegisterFor(w, stm.Tag())
+
+	_, err = from.DetachWatcher("99")
+	c.Assert(err, Equals, common.ErrUnknownWatcher)
+	h, err := from.DetachWatcher(id)
+	c.Assert(err, IsNil)
+	c.Assert(from.Resources().Get(id), IsNil)
+
+	// Only a connection allowed to watch the
+	// machine may take the watcher over.
+	other, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	otherRoot, err := apiserver.AddWatchingRoot(srv, other)
+	c.Assert(err, IsNil)
+	defer otherRoot.Kill()
+	_, err = otherRoot.AttachWatcher(h)
+	c.Assert(err, Equals, common.ErrPerm)
+
+	newId, err := to.AttachWatcher(h)
+	c.Assert(err, IsNil)
+	c.Assert(to.Resources().Get(newId), Equals, w)
+	c.Assert(to.Resources().Tag(newId), Equals, stm.Tag())
+
+	// The handle cannot be used again.
+	_, err = to.AttachWatcher(h)
+	c.Assert(err, ErrorMatches, "watcher handle already used")
+	err = to.DiscardWatcher(h)
+	c.Assert(err, ErrorMatches, "watcher handle already used")
+
+	// The watcher survives its old connection
+	// and still delivers events.
+	from.Kill()
+	err = stm.SetPassword("new password")
+	c.Assert(err, IsNil)
+	wc.AssertOneChange()
+
+	to.Kill()
+	wc.AssertClosed()
+}
+
+func (s *serverSuite) TestDiscardDetachedWatcher(c *C) {
+	srv, err := apiserver.NewServer(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey))
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	root, err := apiserver.AddWatchingRoot(srv, stm)
+	c.Assert(err, IsNil)
+	defer root.Kill()
+
+	w := stm.Watch()
+	wc := statetesting.NewNotifyWatcherC(c, s.State, w)
+	wc.AssertOneChange()
+	h, err := root.DetachWatcher(root.Resources().Register(w))
+	c.Assert(err, IsNil)
+	err = root.DiscardWatcher(h)
+	c.Assert(err, IsNil)
+	wc.AssertClosed()
+	_, err = root.AttachWatcher(h)
+	c.Assert(err, ErrorMatches, "watcher handle already used")
+}
+
+func (s *serverSuite) TestWatchContainers(c *C) {
+	host, st := s.openAsNewMachine(c, state.JobHostUnits)
+	defer st.Close()
+	addContainer := func() *state.Machine {
+		m, err := s.State.AddMachineWithConstraints(&state.AddMachineParams{
+			Series:        "series",
+			ParentId:      host.Id(),
+			ContainerType: instance.LXC,
+			Jobs:          []state.MachineJob{state.JobHostUnits},
+		})
+		c.Assert(err, IsNil)
+		return m
+	}
+	first := addContainer()
+	other, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+
+	// Only the host's own agent may watch its containers.
+	args := params.WatchContainers{Params: []params.WatchContainer{
+		{MachineTag: other.Tag(), ContainerType: "lxc"},
+		{MachineTag: host.Tag(), ContainerType: "bogus"},
+		{MachineTag: host.Tag(), ContainerType: "lxc"},
+	}}
+	var results params.StringsWatchResults
+	err = st.Call("AgentWatchers", "", "WatchContainers", args, &results)
+	c.Assert(err, IsNil)
+	c.Assert(results.Results, HasLen, 3)
+	c.Assert(results.Results[0].Error, DeepEquals, apiservertesting.ErrUnauthorized)
+	c.Assert(results.Results[1].Error, ErrorMatches, `invalid request: ContainerType: invalid container type "bogus"`)
+	c.Assert(results.Results[2].Error, IsNil)
+	c.Assert(results.Results[2].Changes, DeepEquals, []string{first.Id()})
+
+	w := watcher.NewStringsWatcher(st, results.Results[2])
+	wc := statetesting.NewStringsWatcherC(c, s.State, w)
+	wc.AssertChange(first.Id())
+	wc.AssertNoChange()
+
+	second := addContainer()
+	wc.AssertChange(second.Id())
+	err = first.EnsureDead()
+	c.Assert(err, IsNil)
+	wc.AssertChange(first.Id())
+	wc.AssertNoChange()
+	statetesting.AssertStop(c, w)
+	wc.AssertClosed()
+}
+
+func (s *serverSuite) TestSnapshotPages(c *C) {
+	srv, err := apiserver.NewServerWithConfig(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey), apiserver.ServerConfig{
+		SnapshotPageSize: 2,
+	})
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+	host, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	err = host.SetProvisioned("foo", "fake_nonce", nil)
+	c.Assert(err, IsNil)
+	err = host.SetPassword("password")
+	c.Assert(err, IsNil)
+	addContainer := func() *state.Machine {
+		m, err := s.State.AddMachineWithConstraints(&state.AddMachineParams{
+			Series:        "series",
+			ParentId:      host.Id(),
+			ContainerType: instance.LXC,
+			Jobs:          []state.MachineJob{state.JobHostUnits},
+		})
+		c.Assert(err, IsNil)
+		return m
+	}
+	var expect []string
+	for i := 0; i < 3; i++ {
+		expect = append(expect, addContainer().Id())
+	}
+	st, err := api.Open(&api.Info{
+		Tag:      host.Tag(),
+		Password: "password",
+		Nonce:    "fake_nonce",
+		Addrs:    []string{srv.Addr()},
+		CACert:   []byte(coretesting.CACert),
+	}, fastDialOpts)
+	c.Assert(err, IsNil)
+	defer st.Close()
+
+	// The initial event is returned a page at a time.
+	args := params.WatchContainers{Params: []params.WatchContainer{
+		{MachineTag: host.Tag(), ContainerType: "lxc"},
+	}}
+	var results params.StringsWatchResults
+	err = st.Call("AgentWatchers", "", "WatchContainers", args, &results)
+	c.Assert(err, IsNil)
+	c.Assert(results.Results, HasLen, 1)
+	result := results.Results[0]
+	c.Assert(result.Error, IsNil)
+	c.Assert(result.Changes, HasLen, 2)
+	c.Assert(result.MorePages, Equals, true)
+	got := result.Changes
+	var next params.StringsWatchResult
+	err = st.Call("StringsWatcher", result.StringsWatcherId, "Next", nil, &next)
+	c.Assert(err, IsNil)
+	c.Assert(next.Changes, HasLen, 1)
+	c.Assert(next.MorePages, Equals, false)
+	got = append(got, next.Changes...)
+	sort.Strings(got)
+	sort.Strings(expect)
+	c.Assert(got, DeepEquals, expect)
+
+	// Later changes follow the last page.
+	added := addContainer()
+	s.State.StartSync()
+	next = params.StringsWatchResult{}
+	err = st.Call("StringsWatcher", result.StringsWatcherId, "Next", nil, &next)
+	c.Assert(err, IsNil)
+	c.Assert(next, DeepEquals, params.StringsWatchResult{Changes: []string{added.Id()}})
+}
+
+func (s *serverSuite) TestWatchServiceConfig(c *C) {
+	svc, err := s.State.AddService("wordpress", s.AddTestingCharm(c, "wordpress"))
+	c.Assert(err, IsNil)
+	u, err := svc.AddUnit()
+	c.Assert(err, IsNil)
+	err = u.SetPassword("password")
+	c.Assert(err, IsNil)
+	other, err := s.State.AddService("other", s.AddTestingCharm(c, "wordpress"))
+	c.Assert(err, IsNil)
+	args := params.Entities{Entities: []params.Entity{
+		{Tag: svc.Tag()},
+		{Tag: other.Tag()},
+	}}
+
+	// Machine agents may not watch service settings.
+	_, machineSt := s.openAsNewMachine(c, state.JobHostUnits)
+	defer machineSt.Close()
+	var results params.NotifyWatchResults
+	err = machineSt.Call("AgentWatchers", "", "WatchServiceConfig", args, &results)
+	c.Assert(err, IsNil)
+	c.Assert(results, DeepEquals, params.NotifyWatchResults{
+		Results: []params.NotifyWatchResult{
+			{Error: apiservertesting.ErrUnauthorized},
+			{Error: apiservertesting.ErrUnauthorized},
+		},
+	})
+
+	// Nor may unit agents watch the settings of other services.
+	st := s.OpenAPIAs(c, u.Tag(), "password")
+	defer st.Close()
+	err = st.Call("AgentWatchers", "", "WatchServiceConfig", args, &results)
+	c.Assert(err, IsNil)
+	c.Assert(results.Results, HasLen, 2)
+	c.Assert(results.Results[0].Error, IsNil)
+	c.Assert(results.Results[1].Error, DeepEquals, apiservertesting.ErrUnauthorized)
+
+	w := watcher.NewNotifyWatcher(st, results.Results[0])
+	wc := statetesting.NewNotifyWatcherC(c, s.State, w)
+	wc.AssertOneChange()
+
+	err = svc.UpdateConfigSettings(charm.Settings{"blog-title": "no title"})
+	c.Assert(err, IsNil)
+	wc.AssertOneChange()
+	statetesting.AssertStop(c, w)
+	wc.AssertClosed()
+}
+
+func (s *serverSuite) TestAuthorizationPolicy(c *C) {
+	srv, err := apiserver.NewServer(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey))
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+
+	rules := srv.AuthorizationPolicy()
+	requirements := make(map[string]string)
+	for _, rule := range rules {
+		// Every facade served has a rule.
+		c.Check(rule.Requirement, Not(Equals), "unrestricted: no rule in the policy", Commentf("%s.%s", rule.Facade, rule.Method))
+		requirements[rule.Facade+"."+rule.Method] = rule.Requirement
+	}
+	c.Assert(requirements["Resolver.WatchResolved"], Equals, "unit agents")
+	c.Assert(requirements["MachineUndertaker.CompleteMachineRemovals"], Equals, "machine agents running the ManageEnviron job")
+	c.Assert(requirements["Undertaker.RemoveEnviron"], Equals, "machine agents running the ManageState job")
+	c.Assert(requirements["Client.Status"], Equals, "client users")
+	c.Assert(requirements["NotifyWatcher.Next"], Equals, "machine and unit agents, and client users")
+	c.Assert(requirements["Pinger.Ping"], Equals, "any logged in entity")
+
+	// Facades that are not served, such as that
+	// for detached watchers, are not listed.
+	for _, rule := range rules {
+		c.Assert(rule.Facade, Not(Equals), "DetachWatcher")
+	}
+}
+
+func (s *serverSuite) TestCertMethodsEnforced(c *C) {
+	srv, err := apiserver.NewServer(s.State, "localhost:0", []byte(coretesting.ServerCert), []byte(coretesting.ServerKey))
+	c.Assert(err, IsNil)
+	defer srv.Stop()
+	user, err := s.State.User("admin")
+	c.Assert(err, IsNil)
+	root, err := apiserver.AddWatchingRoot(srv, user)
+	c.Assert(err, IsNil)
+	defer root.Kill()
+
+	// Unrestricted connections may call any method.
+	c.Assert(apiserver.AuthorizeMethod(root, "Client", "ServiceDeploy"), IsNil)
+
+	cert := &x509.Certificate{Subject: pkix.Name{
+		CommonName:         "user-admin",
+		OrganizationalUnit: []string{"Client.Status"},
+	}}
+	err = apiserver.RestrictToCertMethods(root, cert, apiserver.CertFieldOrganizationalUnit)
+	c.Assert(err, IsNil)
+	c.Assert(apiserver.AuthorizeMethod(root, "Client", "Status"), IsNil)
+	c.Assert(apiserver.AuthorizeMethod(root, "Client", "ServiceDeploy"), Equals, common.ErrPerm)
+	c.Assert(apiserver.AuthorizeMethod(root, "AllWatcher", "Next"), Equals, common.ErrPerm)
+
+	// The connection can always be kept alive.
+	c.Assert(apiserver.AuthorizeMethod(root, "Pinger", "Ping"), IsNil)
+}
+
+func (s *serverSuite) TestAuthorizationPolicyEnforced(c *C) {
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	err = stm.SetProvisioned("foo", "fake_nonce", nil)
+	c.Assert(err, IsNil)
+	err = stm.SetPassword("password")
+	c.Assert(err, IsNil)
+	st := s.OpenAPIAsMachine(c, stm.Tag(), "password", "fake_nonce")
+	defer st.Close()
+
+	// The policy is checked before the facade is obtained.
+	var result params.StringsWatchResult
+	err = st.Call("ApplicationScaler", "", "Watch", nil, &result)
+	c.Assert(err, ErrorMatches, "permission denied")
+	err = st.Call("AllWatcher", "1", "Next", nil, nil)
+	c.Assert(err, ErrorMatches, "permission denied")
+
+	// Facades open to agents are served.
+	err = st.Call("Pinger", "", "Ping", nil, nil)
+	c.Assert(err, IsNil)
+}
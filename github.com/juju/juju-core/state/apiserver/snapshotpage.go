@@ -0,0 +1,44 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+// pageSnapshot returns the first page of the given ids, the initial
+// event of the StringsWatcher registered with the given resource id,
+// and whether more pages remain. The remaining ids are kept for the
+// watcher's Next to return; see ServerConfig.SnapshotPageSize. The
+// srvStringsWatcher serving Next is made afresh for each call, so the
+// paging state is kept in r, like the watcher's other settings.
+func (r *srvRoot) pageSnapshot(id string, changes []string) ([]string, bool) {
+	size := r.srv.cfg.SnapshotPageSize
+	if size <= 0 || len(changes) <= size {
+		return changes, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.snapshotPages == nil {
+		r.snapshotPages = make(map[string][]string)
+	}
+	r.snapshotPages[id] = changes[size:]
+	return changes[:size], true
+}
+
+// nextSnapshotPage returns the next page of the initial event of the
+// watcher with the given resource id, and whether more pages remain
+// after it. It returns false for ok once all the pages have been
+// returned, or if the event was not paged.
+func (r *srvRoot) nextSnapshotPage(id string) (page []string, more, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rest, ok := r.snapshotPages[id]
+	if !ok {
+		return nil, false, false
+	}
+	size := r.srv.cfg.SnapshotPageSize
+	if len(rest) <= size {
+		delete(r.snapshotPages, id)
+		return rest, false, true
+	}
+	r.snapshotPages[id] = rest[size:]
+	return rest[:size], true, true
+}
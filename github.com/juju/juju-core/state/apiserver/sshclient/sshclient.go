@@ -0,0 +1,86 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package sshclient
+
+import (
+	"fmt"
+
+	"launchpad.net/juju-core/environs"
+	"launchpad.net/juju-core/instance"
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/api/params"
+	"launchpad.net/juju-core/state/apiserver/common"
+)
+
+// SSHClientAPI provides access to the SSHClient API facade, used by
+// clients to find the addresses to ssh to.
+type SSHClientAPI struct {
+	st         *state.State
+	authorizer common.Authorizer
+}
+
+// NewSSHClientAPI creates a new server-side SSHClient API facade.
+func NewSSHClientAPI(st *state.State, authorizer common.Authorizer) (*SSHClientAPI, error) {
+	if !authorizer.AuthClient() {
+		return nil, common.ErrPerm
+	}
+	return &SSHClientAPI{st: st, authorizer: authorizer}, nil
+}
+
+// PublicAddress returns the public address of each given machine or
+// unit. A machine's address is that of its instance, as reported by
+// the provider.
+func (api *SSHClientAPI) PublicAddress(args params.Entities) (params.StringResults, error) {
+	result := params.StringResults{
+		Results: make([]params.StringResult, len(args.Entities)),
+	}
+	var environ environs.Environ
+	for i, entity := range args.Entities {
+		var addr string
+		lifer, err := api.st.Lifer(entity.Tag)
+		if err == nil {
+			switch e := lifer.(type) {
+			case *state.Unit:
+				var ok bool
+				if addr, ok = e.PublicAddress(); !ok {
+					err = fmt.Errorf("unit %q has no public address", e)
+				}
+			case *state.Machine:
+				if environ == nil {
+					environ, err = api.environ()
+				}
+				if err == nil {
+					addr, err = machineAddress(environ, e)
+				}
+			default:
+				err = fmt.Errorf("entity %q has no public address", entity.Tag)
+			}
+		}
+		result.Results[i].Result = addr
+		result.Results[i].Error = common.ServerError(err)
+	}
+	return result, nil
+}
+
+// environ returns the environment's provider.
+func (api *SSHClientAPI) environ() (environs.Environ, error) {
+	cfg, err := api.st.EnvironConfig()
+	if err != nil {
+		return nil, err
+	}
+	return environs.New(cfg)
+}
+
+// machineAddress returns the DNS name of the machine's instance.
+func machineAddress(environ environs.Environ, m *state.Machine) (string, error) {
+	instId, err := m.InstanceId()
+	if err != nil {
+		return "", err
+	}
+	insts, err := environ.Instances([]instance.Id{instId})
+	if err != nil {
+		return "", err
+	}
+	return insts[0].DNSName()
+}
@@ -0,0 +1,92 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package sshclient_test
+
+import (
+	. "launchpad.net/gocheck"
+
+	jujutesting "launchpad.net/juju-core/juju/testing"
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/api/params"
+	"launchpad.net/juju-core/state/apiserver/common"
+	"launchpad.net/juju-core/state/apiserver/sshclient"
+	apiservertesting "launchpad.net/juju-core/state/apiserver/testing"
+)
+
+type sshClientSuite struct {
+	jujutesting.JujuConnSuite
+	sshClient *sshclient.SSHClientAPI
+}
+
+var _ = Suite(&sshClientSuite{})
+
+func (s *sshClientSuite) SetUpTest(c *C) {
+	s.JujuConnSuite.SetUpTest(c)
+	var err error
+	s.sshClient, err = sshclient.NewSSHClientAPI(s.State, apiservertesting.FakeAuthorizer{
+		Tag:      "user-admin",
+		LoggedIn: true,
+		Client:   true,
+	})
+	c.Assert(err, IsNil)
+}
+
+func (s *sshClientSuite) TestNewSSHClientAPIRefusesNonClient(c *C) {
+	api, err := sshclient.NewSSHClientAPI(s.State, apiservertesting.FakeAuthorizer{
+		Tag:          "machine-0",
+		LoggedIn:     true,
+		MachineAgent: true,
+	})
+	c.Assert(api, IsNil)
+	c.Assert(err, Equals, common.ErrPerm)
+}
+
+func (s *sshClientSuite) TestPublicAddress(c *C) {
+	m0, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	inst, _ := jujutesting.StartInstance(c, s.Conn.Environ, m0.Id())
+	err = m0.SetProvisioned(inst.Id(), "fake_nonce", nil)
+	c.Assert(err, IsNil)
+	dnsName, err := inst.DNSName()
+	c.Assert(err, IsNil)
+	m1, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+
+	svc, err := s.State.AddService("wordpress", s.AddTestingCharm(c, "wordpress"))
+	c.Assert(err, IsNil)
+	u0, err := svc.AddUnit()
+	c.Assert(err, IsNil)
+	err = u0.SetPublicAddress("u0.example.com")
+	c.Assert(err, IsNil)
+	u1, err := svc.AddUnit()
+	c.Assert(err, IsNil)
+
+	result, err := s.sshClient.PublicAddress(params.Entities{
+		Entities: []params.Entity{
+			{Tag: m0.Tag()},
+			{Tag: m1.Tag()},
+			{Tag: u0.Tag()},
+			{Tag: u1.Tag()},
+			{Tag: "machine-42"},
+			{Tag: "user-admin"},
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, params.StringResults{
+		Results: []params.StringResult{
+			{Result: dnsName},
+			{Error: &params.Error{
+				Code:    params.CodeNotProvisioned,
+				Message: `machine 1 is not provisioned`,
+			}},
+			{Result: "u0.example.com"},
+			{Error: &params.Error{Message: `unit "wordpress/1" has no public address`}},
+			{Error: &params.Error{
+				Code:    params.CodeNotFound,
+				Message: `machine 42 not found`,
+			}},
+			{Error: &params.Error{Message: `entity "user-admin" does not support lifecycles`}},
+		},
+	})
+}
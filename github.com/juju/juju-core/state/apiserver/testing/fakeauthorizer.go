@@ -8,7 +8,9 @@ type FakeAuthorizer struct {
 	Tag          string
 	LoggedIn     bool
 	Manager      bool
+	Controller   bool
 	MachineAgent bool
+	UnitAgent    bool
 	Client       bool
 }
 
@@ -20,10 +22,18 @@ func (fa FakeAuthorizer) AuthEnvironManager() bool {
 	return fa.Manager
 }
 
+func (fa FakeAuthorizer) AuthController() bool {
+	return fa.Controller
+}
+
 func (fa FakeAuthorizer) AuthMachineAgent() bool {
 	return fa.MachineAgent
 }
 
+func (fa FakeAuthorizer) AuthUnitAgent() bool {
+	return fa.UnitAgent
+}
+
 func (fa FakeAuthorizer) AuthClient() bool {
 	return fa.Client
 }
@@ -0,0 +1,51 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"time"
+)
+
+// Tracer is implemented by types that record the spans
+// making up a distributed trace.
+type Tracer interface {
+	// StartSpan starts a span for the request described
+	// by info.
+	StartSpan(info SpanInfo) Span
+}
+
+// Span represents a single traced request.
+type Span interface {
+	// End is called when the request has completed, with
+	// the time it took and the error it returned, if any.
+	End(duration time.Duration, err error)
+}
+
+// SpanInfo describes a traced request.
+type SpanInfo struct {
+	// TraceId holds the trace id given by the client at
+	// login, if any.
+	TraceId string
+
+	// ConnId identifies the connection the request was made on.
+	ConnId uint64
+
+	// Facade, Id and Method identify the request. Watcher
+	// operations appear as requests on the watcher facades,
+	// such as NotifyWatcher.Next.
+	Facade string
+	Id     string
+	Method string
+}
+
+// nopTracer is the Tracer used when none has been configured.
+type nopTracer struct{}
+
+func (nopTracer) StartSpan(SpanInfo) Span {
+	return nopSpan{}
+}
+
+type nopSpan struct{}
+
+func (nopSpan) End(time.Duration, error) {}
@@ -0,0 +1,84 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package undertaker
+
+import (
+	"launchpad.net/juju-core/errors"
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/api/params"
+	"launchpad.net/juju-core/state/apiserver/common"
+)
+
+// UndertakerAPI provides access to the Undertaker API facade, used
+// by the controller to tear down an environment that is being
+// destroyed: it watches the environment's life, and once the
+// environment's resources have gone, sets it to dead and removes it.
+type UndertakerAPI struct {
+	st         *state.State
+	resources  *common.Resources
+	authorizer common.Authorizer
+}
+
+// NewUndertakerAPI creates a new server-side Undertaker API facade.
+func NewUndertakerAPI(
+	st *state.State,
+	resources *common.Resources,
+	authorizer common.Authorizer,
+) (*UndertakerAPI, error) {
+	if !authorizer.AuthController() {
+		return nil, common.ErrPerm
+	}
+	return &UndertakerAPI{
+		st:         st,
+		resources:  resources,
+		authorizer: authorizer,
+	}, nil
+}
+
+// WatchEnvironLife returns the life of the environment, and a
+// NotifyWatcher, registered in the facade's resources, that fires
+// when the environment changes. The life is read once the watcher
+// has started, so no change can be missed.
+func (api *UndertakerAPI) WatchEnvironLife() (params.EnvironLifeWatchResult, error) {
+	env, err := api.st.Environment()
+	if err != nil {
+		return params.EnvironLifeWatchResult{}, err
+	}
+	var result params.EnvironLifeWatchResult
+	id, err := common.NotifyWatchAndGet(api.resources, env.Watch(), func() error {
+		if err := env.Refresh(); err != nil {
+			return err
+		}
+		result.Life = params.Life(env.Life().String())
+		return nil
+	})
+	if err != nil {
+		return params.EnvironLifeWatchResult{}, err
+	}
+	result.NotifyWatcherId = id
+	return result, nil
+}
+
+// ProcessDyingEnviron sets the dying environment to dead, once its
+// services, and its machines other than the controllers, have been
+// removed.
+func (api *UndertakerAPI) ProcessDyingEnviron() error {
+	env, err := api.st.Environment()
+	if err != nil {
+		return err
+	}
+	return env.EnsureDead()
+}
+
+// RemoveEnviron removes the dead environment from state. It does
+// nothing if the environment has already been removed.
+func (api *UndertakerAPI) RemoveEnviron() error {
+	env, err := api.st.Environment()
+	if errors.IsNotFoundError(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return env.Remove()
+}
@@ -0,0 +1,116 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package undertaker_test
+
+import (
+	. "launchpad.net/gocheck"
+
+	"launchpad.net/juju-core/errors"
+	jujutesting "launchpad.net/juju-core/juju/testing"
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/api/params"
+	"launchpad.net/juju-core/state/apiserver/common"
+	apiservertesting "launchpad.net/juju-core/state/apiserver/testing"
+	"launchpad.net/juju-core/state/apiserver/undertaker"
+	statetesting "launchpad.net/juju-core/state/testing"
+	"launchpad.net/juju-core/testing/checkers"
+)
+
+type undertakerSuite struct {
+	jujutesting.JujuConnSuite
+
+	api        *undertaker.UndertakerAPI
+	resources  *common.Resources
+	authorizer apiservertesting.FakeAuthorizer
+}
+
+var _ = Suite(&undertakerSuite{})
+
+func (s *undertakerSuite) SetUpTest(c *C) {
+	s.JujuConnSuite.SetUpTest(c)
+	s.resources = common.NewResources()
+
+	s.authorizer = apiservertesting.FakeAuthorizer{
+		Tag:          "machine-0",
+		LoggedIn:     true,
+		Manager:      true,
+		Controller:   true,
+		MachineAgent: true,
+	}
+	var err error
+	s.api, err = undertaker.NewUndertakerAPI(s.State, s.resources, s.authorizer)
+	c.Assert(err, IsNil)
+}
+
+func (s *undertakerSuite) TearDownTest(c *C) {
+	if s.resources != nil {
+		s.resources.StopAll()
+	}
+	s.JujuConnSuite.TearDownTest(c)
+}
+
+func (s *undertakerSuite) TestRefusesNonController(c *C) {
+	anAuthorizer := s.authorizer
+	anAuthorizer.Controller = false
+	api, err := undertaker.NewUndertakerAPI(s.State, s.resources, anAuthorizer)
+	c.Assert(err, ErrorMatches, "permission denied")
+	c.Assert(api, IsNil)
+}
+
+func (s *undertakerSuite) TestWatchEnvironLife(c *C) {
+	result, err := s.api.WatchEnvironLife()
+	c.Assert(err, IsNil)
+	c.Assert(result.Life, Equals, params.Alive)
+
+	w, ok := s.resources.Get(result.NotifyWatcherId).(state.NotifyWatcher)
+	c.Assert(ok, Equals, true)
+	wc := statetesting.NewNotifyWatcherC(c, s.State, w)
+	wc.AssertNoChange()
+
+	env, err := s.State.Environment()
+	c.Assert(err, IsNil)
+	err = env.Destroy()
+	c.Assert(err, IsNil)
+	wc.AssertOneChange()
+	statetesting.AssertStop(c, w)
+	wc.AssertClosed()
+}
+
+func (s *undertakerSuite) TestTeardown(c *C) {
+	m, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+
+	// The environment cannot be set to dead or
+	// removed before it has been destroyed.
+	err = s.api.ProcessDyingEnviron()
+	c.Assert(err, ErrorMatches, "cannot set environment to dead: environment is not dying")
+	err = s.api.RemoveEnviron()
+	c.Assert(err, ErrorMatches, "cannot remove environment: environment is not dead")
+
+	env, err := s.State.Environment()
+	c.Assert(err, IsNil)
+	err = env.Destroy()
+	c.Assert(err, IsNil)
+	err = s.api.ProcessDyingEnviron()
+	c.Assert(err, ErrorMatches, "cannot set environment to dead: environment still has 1 machines")
+	err = m.EnsureDead()
+	c.Assert(err, IsNil)
+	err = m.Remove()
+	c.Assert(err, IsNil)
+
+	err = s.api.ProcessDyingEnviron()
+	c.Assert(err, IsNil)
+	err = env.Refresh()
+	c.Assert(err, IsNil)
+	c.Assert(env.Life(), Equals, state.Dead)
+
+	err = s.api.RemoveEnviron()
+	c.Assert(err, IsNil)
+	_, err = s.State.Environment()
+	c.Assert(err, checkers.Satisfies, errors.IsNotFoundError)
+
+	// Removing it again does nothing.
+	err = s.api.RemoveEnviron()
+	c.Assert(err, IsNil)
+}
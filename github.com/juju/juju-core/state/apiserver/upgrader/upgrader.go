@@ -9,7 +9,6 @@ import (
 	"launchpad.net/juju-core/state"
 	"launchpad.net/juju-core/state/api/params"
 	"launchpad.net/juju-core/state/apiserver/common"
-	"launchpad.net/juju-core/state/watcher"
 	"launchpad.net/juju-core/version"
 )
 
@@ -47,11 +46,8 @@ func (u *UpgraderAPI) WatchAPIVersion(args params.Entities) (params.NotifyWatchR
 			// calls to Watch 'transmit' the initial event
 			// in the Watch response. But NotifyWatchers
 			// have no state to transmit.
-			if _, ok := <-watch.Changes(); ok {
+			if err = common.InitialNotifyEvent(u.resources, watch); err == nil {
 				result.Results[i].NotifyWatcherId = u.resources.Register(watch)
-				err = nil
-			} else {
-				err = watcher.MustErr(watch)
 			}
 		}
 		result.Results[i].Error = common.ServerError(err)
@@ -0,0 +1,164 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/apiserver/common"
+)
+
+// argSchema declares the checks made on the arguments of a facade
+// method before the method is invoked. Fields are named by their path
+// through the arguments, such as "Entities.Tag", where a slice stands
+// for each of its elements in turn.
+type argSchema struct {
+	// required holds the paths of fields that must not be empty.
+	required []string
+
+	// tags holds the paths of fields that must hold entity tags.
+	tags []string
+
+	// entries, if not empty, holds the path of a slice of the
+	// arguments whose elements are checked one by one, as for a
+	// bulk call taking a list of entities. The paths of required
+	// and tags are then relative to an element, and an invalid
+	// element does not fail the request: the method is called
+	// anyway, and the element's error replaces the error of the
+	// corresponding result, which must be held in the only slice
+	// of the method's results.
+	entries string
+}
+
+// entitiesSchema is the schema of methods taking params.Entities.
+var entitiesSchema = argSchema{
+	entries: "Entities",
+	tags:    []string{"Tag"},
+}
+
+// argSchemas holds the schema declared by each facade method that
+// opts in to validation of its arguments.
+var argSchemas = map[methodKey]argSchema{
+	{"Machiner", "Life"}:       entitiesSchema,
+	{"Machiner", "Watch"}:      entitiesSchema,
+	{"Machiner", "EnsureDead"}: entitiesSchema,
+	{"Machiner", "SetStatus"}: {
+		entries: "Machines",
+		tags:    []string{"Tag"},
+	},
+	{"LeadershipService", "ClaimLeadership"}: {
+		required: []string{"Params.DurationSeconds"},
+		tags:     []string{"Params.ServiceTag", "Params.UnitTag"},
+	},
+	{"LeadershipService", "BlockUntilLeadershipReleased"}: entitiesSchema,
+}
+
+// validateArgs checks the arguments of a request against the schema
+// declared by the method, if any, returning a *common.BadRequestError
+// describing the first invalid field found. If the schema declares
+// entries, the errors for invalid elements are returned instead in
+// entryErrs, keyed by index, and err is non-nil only if the entries
+// themselves cannot be found.
+func validateArgs(st *state.State, req methodKey, args interface{}) (entryErrs map[int]error, err error) {
+	schema, ok := argSchemas[req]
+	if !ok || args == nil {
+		return nil, nil
+	}
+	v := reflect.ValueOf(args)
+	if schema.entries == "" {
+		return nil, schema.check(st, v, "")
+	}
+	// The elements are visited in order.
+	i := 0
+	err = walkField(v, "", strings.Split(schema.entries, "."), func(field string, v reflect.Value) error {
+		if err := schema.check(st, v, field); err != nil {
+			if entryErrs == nil {
+				entryErrs = make(map[int]error)
+			}
+			entryErrs[i] = err
+		}
+		i++
+		return nil
+	})
+	return entryErrs, err
+}
+
+// check checks v, whose own path is given by prefix,
+// against the required and tags paths of the schema.
+func (schema argSchema) check(st *state.State, v reflect.Value, prefix string) error {
+	for _, path := range schema.required {
+		err := walkField(v, prefix, strings.Split(path, "."), func(field string, v reflect.Value) error {
+			if isEmptyValue(v) {
+				return &common.BadRequestError{Field: field, Reason: "missing"}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, path := range schema.tags {
+		err := walkField(v, prefix, strings.Split(path, "."), func(field string, v reflect.Value) error {
+			if v.Kind() != reflect.String {
+				return fmt.Errorf("field %s is not a string", field)
+			}
+			if _, _, err := st.ParseTag(v.String()); err != nil {
+				return &common.BadRequestError{Field: field, Reason: err.Error()}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkField calls check with each value found by following path
+// from v, whose own path is given by prefix.
+func walkField(v reflect.Value, prefix string, path []string, check func(string, reflect.Value) error) error {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return &common.BadRequestError{Field: prefix, Reason: "missing"}
+		}
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Slice {
+		for i := 0; i < v.Len(); i++ {
+			err := walkField(v.Index(i), fmt.Sprintf("%s[%d]", prefix, i), path, check)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(path) == 0 {
+		return check(prefix, v)
+	}
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("field %s is not a struct", prefix)
+	}
+	f := v.FieldByName(path[0])
+	if !f.IsValid() {
+		return fmt.Errorf("no field %s in %s", path[0], v.Type())
+	}
+	field := path[0]
+	if prefix != "" {
+		field = prefix + "." + field
+	}
+	return walkField(f, field, path[1:], check)
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
@@ -4,6 +4,8 @@
 package apiserver
 
 import (
+	"launchpad.net/juju-core/errors"
+	"launchpad.net/juju-core/log"
 	"launchpad.net/juju-core/state"
 	"launchpad.net/juju-core/state/api/params"
 	"launchpad.net/juju-core/state/apiserver/common"
@@ -27,28 +29,134 @@ func (w *srvClientAllWatcher) Stop() error {
 	return w.resources.Stop(w.id)
 }
 
+type srvMachinesCursor struct {
+	cursor    *common.Cursor
+	id        string
+	resources *common.Resources
+}
+
+// Next returns the next page of machines from the cursor.
+// The cursor is stopped once the last page has been returned.
+func (c *srvMachinesCursor) Next() (params.MachinesPage, error) {
+	page, last := c.cursor.Next()
+	if last {
+		if err := c.resources.Stop(c.id); err != nil {
+			return params.MachinesPage{}, err
+		}
+	}
+	return params.MachinesPage{
+		Machines: page.([]params.MachineDetails),
+		Done:     last,
+	}, nil
+}
+
+func (c *srvMachinesCursor) Stop() error {
+	return c.resources.Stop(c.id)
+}
+
 type srvNotifyWatcher struct {
 	watcher   state.NotifyWatcher
 	id        string
 	resources *common.Resources
+	root      *srvRoot
+
+	// st and tag identify the entity being watched,
+	// if the watcher was registered with its tag.
+	st  *state.State
+	tag string
 }
 
 // Next returns when a change has occurred to the
 // entity being watched since the most recent call to Next
 // or the Watch call that created the NotifyWatcher.
+// If the watcher was registered with a stop condition, the
+// change that brings the entity's life to it is the last to be
+// notified, and further calls to Next fail with
+// common.ErrStoppedWatcher; likewise, the change that removes
+// the entity is the last, and further calls fail with
+// common.ErrEntityRemoved. Without a stop condition, removal is
+// noticed only if the underlying watcher fails because the
+// entity has gone, when the watcher is stopped and Next fails
+// with common.ErrEntityRemoved.
 func (w *srvNotifyWatcher) Next() error {
-	if _, ok := <-w.watcher.Changes(); ok {
+	w.root.awaitRate(w.id)
+	var ok bool
+	select {
+	case _, ok = <-w.watcher.Changes():
+		w.noteDelivery(ok, true)
+	default:
+		_, ok = <-w.watcher.Changes()
+		w.noteDelivery(ok, false)
+	}
+	if ok {
+		if reason := w.finished(); reason != nil {
+			w.retire(reason)
+		}
 		return nil
 	}
 	err := w.watcher.Err()
 	if err == nil {
 		err = common.ErrStoppedWatcher
+	} else if errors.IsNotFoundError(err) && w.tag != "" {
+		// The watcher failed because the entity has gone.
+		w.retire(common.ErrEntityRemoved)
+		err = common.ErrEntityRemoved
 	}
 	return err
 }
 
+// finished returns the reason the watcher is to be stopped after
+// delivering the current change, or nil if it is not. Only watchers
+// registered with a stop condition look up their entity, to find
+// that it has been removed or that its life has reached the
+// condition.
+func (w *srvNotifyWatcher) finished() error {
+	if w.tag == "" {
+		return nil
+	}
+	life, ok := w.root.stopAtLife(w.id)
+	if !ok {
+		return nil
+	}
+	entity, err := w.st.Lifer(w.tag)
+	if errors.IsNotFoundError(err) {
+		return common.ErrEntityRemoved
+	} else if err != nil {
+		return nil
+	}
+	if entity.Life() >= life {
+		return common.ErrStoppedWatcher
+	}
+	return nil
+}
+
+// retire stops the watcher, so that further calls
+// to Next fail with the given reason.
+func (w *srvNotifyWatcher) retire(reason error) {
+	w.root.forgetDelivery(w.id)
+	if err := w.resources.Retire(w.id, reason); err != nil {
+		log.Errorf("state/api: error stopping watcher %s: %v", w.id, err)
+	}
+}
+
+// noteDelivery records the lag of an event delivered
+// by Next, if ok reports that there was one.
+func (w *srvNotifyWatcher) noteDelivery(ok, pending bool) {
+	if ok {
+		w.root.noteDelivery("NotifyWatcher", w.id, pending)
+		w.root.noteRate(w.id)
+	}
+}
+
+// SetRateLimit caps the rate at which Next delivers events.
+// Changes made while Next is held back are coalesced.
+func (w *srvNotifyWatcher) SetRateLimit(limit params.WatcherRateLimit) error {
+	return w.root.setRateLimit(w.id, limit)
+}
+
 // Stop stops the watcher.
 func (w *srvNotifyWatcher) Stop() error {
+	w.root.forgetDelivery(w.id)
 	return w.resources.Stop(w.id)
 }
 
@@ -58,15 +166,60 @@ type srvStringsWatcher struct {
 	watcher   state.StringsWatcher
 	id        string
 	resources *common.Resources
+	root      *srvRoot
+
+	// transform maps the watcher's changes to the
+	// shape expected by the connection's client.
+	transform func(changes []string) []string
+}
+
+// stringsTransforms holds, for each API version whose clients expect
+// StringsWatcher changes in a different shape from the current one,
+// the function that maps current changes to that shape. Clients of
+// other versions are sent the changes as they are.
+var stringsTransforms = map[int]func(changes []string) []string{}
+
+// stringsTransform returns the function that maps StringsWatcher
+// changes to the shape expected by the connection's client.
+func (r *srvRoot) stringsTransform() func(changes []string) []string {
+	if transform := stringsTransforms[r.version]; transform != nil {
+		return transform
+	}
+	return func(changes []string) []string { return changes }
 }
 
 // Next returns when a change has occured to an entity of the
 // collection being watched since the most recent call to Next
 // or the Watch call that created the srvStringsWatcher.
 func (w *srvStringsWatcher) Next() (params.StringsWatchResult, error) {
-	if changes, ok := <-w.watcher.Changes(); ok {
+	if page, more, ok := w.root.nextSnapshotPage(w.id); ok {
+		// The initial event was paged; return its
+		// next page before any later change.
+		return params.StringsWatchResult{
+			Changes:   w.transform(page),
+			MorePages: more,
+		}, nil
+	}
+	w.root.awaitRate(w.id)
+	var changes []string
+	var ok bool
+	select {
+	case changes, ok = <-w.watcher.Changes():
+		w.noteDelivery(ok, true)
+	default:
+		changes, ok = <-w.watcher.Changes()
+		w.noteDelivery(ok, false)
+	}
+	if ok {
+		changes = w.root.coalesceStrings(w.watcher.Changes(), changes)
+		if key := w.root.compaction(w.id); key != nil {
+			changes = compactChanges(changes, key)
+		}
+		if w.root.tooManyChanges("StringsWatcher", len(changes)) {
+			return params.StringsWatchResult{ResyncRequired: true}, nil
+		}
 		return params.StringsWatchResult{
-			Changes: changes,
+			Changes: w.transform(changes),
 		}, nil
 	}
 	err := w.watcher.Err()
@@ -76,7 +229,114 @@ func (w *srvStringsWatcher) Next() (params.StringsWatchResult, error) {
 	return params.StringsWatchResult{}, err
 }
 
+// Snapshot returns the full set of ids watched; see srvRoot.Snapshot.
+func (w *srvStringsWatcher) Snapshot() (params.StringsWatchResult, error) {
+	return w.root.Snapshot(w.id)
+}
+
+func (w *srvStringsWatcher) noteDelivery(ok, pending bool) {
+	if ok {
+		w.root.noteDelivery("StringsWatcher", w.id, pending)
+		w.root.noteRate(w.id)
+	}
+}
+
+// SetRateLimit caps the rate at which Next delivers events.
+// Changes made while Next is held back are coalesced.
+func (w *srvStringsWatcher) SetRateLimit(limit params.WatcherRateLimit) error {
+	return w.root.setRateLimit(w.id, limit)
+}
+
 // Stop stops the watcher.
 func (w *srvStringsWatcher) Stop() error {
+	w.root.forgetDelivery(w.id)
+	return w.resources.Stop(w.id)
+}
+
+// srvRelationUnitsWatcher notifies about units entering and leaving
+// the scope of a relation unit, and changes to their settings.
+type srvRelationUnitsWatcher struct {
+	watcher   *common.RelationUnitsWatcher
+	id        string
+	resources *common.Resources
+	root      *srvRoot
+}
+
+// Next returns when a change has occurred to the membership or
+// settings of the relation being watched since the most recent call
+// to Next. The first call returns the initial state of the relation
+// in the Joined and Changed fields, and its result is marked Initial.
+func (w *srvRelationUnitsWatcher) Next() (params.RelationUnitsWatchResult, error) {
+	w.root.awaitRate(w.id)
+	var changes state.RelationUnitsChange
+	var ok bool
+	select {
+	case changes, ok = <-w.watcher.Changes():
+		w.noteDelivery(ok, true)
+	default:
+		changes, ok = <-w.watcher.Changes()
+		w.noteDelivery(ok, false)
+	}
+	if ok {
+		initial := w.watcher.TakeInitial()
+		n := len(changes.Joined) + len(changes.Changed) + len(changes.Departed)
+		if !initial && w.root.tooManyChanges("RelationUnitsWatcher", n) {
+			return params.RelationUnitsWatchResult{ResyncRequired: true}, nil
+		}
+		return params.RelationUnitsWatchResult{
+			Changes: convertRelationUnitsChange(changes),
+			Initial: initial,
+		}, nil
+	}
+	err := w.watcher.Err()
+	if err == nil {
+		err = common.ErrStoppedWatcher
+	}
+	return params.RelationUnitsWatchResult{}, err
+}
+
+func (w *srvRelationUnitsWatcher) noteDelivery(ok, pending bool) {
+	if ok {
+		w.root.noteDelivery("RelationUnitsWatcher", w.id, pending)
+		w.root.noteRate(w.id)
+	}
+}
+
+// SetRateLimit caps the rate at which Next delivers events.
+// Changes made while Next is held back are coalesced.
+func (w *srvRelationUnitsWatcher) SetRateLimit(limit params.WatcherRateLimit) error {
+	return w.root.setRateLimit(w.id, limit)
+}
+
+// Stop stops the watcher.
+func (w *srvRelationUnitsWatcher) Stop() error {
+	w.root.forgetDelivery(w.id)
 	return w.resources.Stop(w.id)
 }
+
+// tooManyChanges reports whether n changes are more than a watcher
+// of the given type may return at once; see
+// ServerConfig.MaxWatcherChanges and ServerConfig.HeapWatermark.
+func (r *srvRoot) tooManyChanges(kind string, n int) bool {
+	max := r.srv.cfg.MaxWatcherChanges[kind]
+	if kind == "StringsWatcher" && r.srv.Degraded() {
+		if degraded := r.srv.cfg.DegradedMaxChanges; max <= 0 || max > degraded {
+			max = degraded
+		}
+	}
+	return max > 0 && n > max
+}
+
+func convertRelationUnitsChange(changes state.RelationUnitsChange) params.RelationUnitsChange {
+	result := params.RelationUnitsChange{
+		Joined:   changes.Joined,
+		Departed: changes.Departed,
+	}
+	if changes.Changed != nil {
+		result.Changed = make(map[string]params.UnitSettings)
+		for name, settings := range changes.Changed {
+			result.Changed[name] = params.UnitSettings{Version: settings.Version}
+		}
+	}
+	return result
+}
@@ -0,0 +1,77 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"time"
+
+	"launchpad.net/juju-core/log"
+	"launchpad.net/juju-core/state/apiserver/common"
+)
+
+// minSweepInterval holds the shortest interval at which
+// sweepWatchers looks for expired watchers.
+const minSweepInterval = time.Second
+
+// sweepWatchers stops, at intervals of a tenth of the maximum watcher
+// lifetime but no less than minSweepInterval, the watchers of every
+// connection that have outlived it, until the server is stopped, so
+// that watchers a client no longer calls do not accumulate; see
+// ServerConfig.MaxWatcherLifetime.
+func (srv *Server) sweepWatchers() {
+	defer srv.wg.Done()
+	interval := srv.cfg.MaxWatcherLifetime / 10
+	if interval < minSweepInterval {
+		interval = minSweepInterval
+	}
+	for {
+		select {
+		case <-srv.tomb.Dying():
+			return
+		case <-time.After(interval):
+		}
+		srv.mu.Lock()
+		roots := make([]*srvRoot, 0, len(srv.roots))
+		for root := range srv.roots {
+			roots = append(roots, root)
+		}
+		srv.mu.Unlock()
+		for _, root := range roots {
+			root.expireWatchers()
+		}
+	}
+	panic("unreachable")
+}
+
+// expireWatchers stops and unregisters all the connection's watchers
+// that have outlived ServerConfig.MaxWatcherLifetime.
+func (r *srvRoot) expireWatchers() {
+	for _, e := range r.resources.Entries() {
+		if e.Kind != "" {
+			r.expireWatcher(e.Id)
+		}
+	}
+}
+
+// expireWatcher stops and unregisters the watcher with the given
+// resource id, returning common.ErrWatcherExpired, if it has been
+// registered for longer than ServerConfig.MaxWatcherLifetime. The
+// watcher is retired, so that later calls on it, including those
+// made after it has been stopped by sweepWatchers, fail with the
+// same error.
+func (r *srvRoot) expireWatcher(id string) error {
+	max := r.srv.cfg.MaxWatcherLifetime
+	if max <= 0 {
+		return nil
+	}
+	registered := r.resources.Registered(id)
+	if registered.IsZero() || time.Since(registered) < max {
+		return nil
+	}
+	r.forgetDelivery(id)
+	if err := r.resources.Retire(id, common.ErrWatcherExpired); err != nil {
+		log.Errorf("state/api: error stopping watcher %s: %v", id, err)
+	}
+	return common.ErrWatcherExpired
+}
@@ -0,0 +1,57 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"time"
+)
+
+// WatcherLags returns, for each type of watcher, estimated
+// percentiles of the time events have waited for a client to consume
+// them with Next, sorted by watcher type. The lag of an event that
+// was already waiting when Next was called is taken to be the time
+// since the previous call to Next on the same watcher returned, or
+// since the watcher was registered, which bounds it from above; an
+// event that arrives while Next is waiting has no lag. A large lag
+// indicates a client that is slow to keep up with its watchers.
+func (srv *Server) WatcherLags() []MethodLatency {
+	return srv.watcherLags.snapshot()
+}
+
+// noteDelivery records the lag of an event delivered by Next on the
+// watcher with the given facade name and resource id. If pending is
+// true, the event was already waiting when Next was called.
+func (r *srvRoot) noteDelivery(facade, id string, pending bool) {
+	now := time.Now()
+	r.mu.Lock()
+	since, ok := r.deliveries[id]
+	if r.deliveries == nil {
+		r.deliveries = make(map[string]time.Time)
+	}
+	r.deliveries[id] = now
+	r.mu.Unlock()
+	var lag time.Duration
+	if pending {
+		if !ok {
+			since = r.resources.Registered(id)
+		}
+		if !since.IsZero() {
+			lag = now.Sub(since)
+		}
+	}
+	r.srv.watcherLags.record(facade, "Next", lag)
+}
+
+// forgetDelivery discards the time at which the watcher with
+// the given resource id last delivered an event, and the other
+// settings made for it, such as its rate limit.
+func (r *srvRoot) forgetDelivery(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.deliveries, id)
+	delete(r.rateLimits, id)
+	delete(r.stopAt, id)
+	delete(r.compactions, id)
+	delete(r.snapshotPages, id)
+}
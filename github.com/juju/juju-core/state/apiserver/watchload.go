@@ -0,0 +1,88 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"sort"
+	"strings"
+)
+
+// ServiceWatchers describes the watchers, across all
+// connections, that are tracking a service or its units.
+type ServiceWatchers struct {
+	Service string
+
+	// Watchers holds the number of watchers
+	// tracking the service or its units.
+	Watchers int
+
+	// Connections holds the number of connections
+	// holding at least one of those watchers.
+	Connections int
+}
+
+type serviceWatchersSlice []ServiceWatchers
+
+func (s serviceWatchersSlice) Len() int           { return len(s) }
+func (s serviceWatchersSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s serviceWatchersSlice) Less(i, j int) bool { return s[i].Service < s[j].Service }
+
+// WatchersByService returns the number of watchers tracking each
+// service, or one of its units, across all the server's logged in
+// connections, ordered by service name. A watcher is attributed to a
+// service by the tag with which it was registered; watchers registered
+// without a tag, or for other entities, are not counted.
+func (srv *Server) WatchersByService() []ServiceWatchers {
+	srv.mu.Lock()
+	roots := make([]*srvRoot, 0, len(srv.roots))
+	for root := range srv.roots {
+		roots = append(roots, root)
+	}
+	srv.mu.Unlock()
+	byService := make(map[string]*ServiceWatchers)
+	for _, root := range roots {
+		seen := make(map[string]bool)
+		for _, e := range root.resources.Entries() {
+			name := serviceOfTag(e.Tag)
+			if name == "" || e.Kind == "" {
+				continue
+			}
+			sw := byService[name]
+			if sw == nil {
+				sw = &ServiceWatchers{Service: name}
+				byService[name] = sw
+			}
+			sw.Watchers++
+			if !seen[name] {
+				seen[name] = true
+				sw.Connections++
+			}
+		}
+	}
+	result := make(serviceWatchersSlice, 0, len(byService))
+	for _, sw := range byService {
+		result = append(result, *sw)
+	}
+	sort.Sort(result)
+	return result
+}
+
+// serviceOfTag returns the name of the service with the given tag,
+// or of the service of the unit with the given tag, or "" if the
+// tag is neither.
+func serviceOfTag(tag string) string {
+	if strings.HasPrefix(tag, "service-") {
+		return tag[len("service-"):]
+	}
+	if !strings.HasPrefix(tag, "unit-") {
+		return ""
+	}
+	// Service names may hold hyphens, so only
+	// the last one separates the unit number.
+	name := tag[len("unit-"):]
+	if i := strings.LastIndex(name, "-"); i > 0 {
+		return name[:i]
+	}
+	return ""
+}
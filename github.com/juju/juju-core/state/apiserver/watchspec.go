@@ -0,0 +1,324 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"fmt"
+	"strings"
+
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/api/params"
+	"launchpad.net/juju-core/state/apiserver/common"
+)
+
+// RegisterWatchers starts the watchers described by the given specs,
+// registering each in r.resources, so that an agent can start all the
+// watchers it needs in a single request. The agent's permission to
+// watch is checked once; each spec's tag must then be that of the
+// agent itself. A spec that cannot be watched is reported in its own
+// result, without stopping the watchers started for the others.
+func (r *srvRoot) RegisterWatchers(specs []params.WatchSpec) (params.WatchResults, error) {
+	if err := r.requireAgent(); err != nil {
+		return params.WatchResults{}, err
+	}
+	results := params.WatchResults{
+		Results: make([]params.WatchResult, len(specs)),
+	}
+	for i, spec := range specs {
+		result, err := r.registerWatcher(spec)
+		if err != nil {
+			result = params.WatchResult{Error: common.ServerError(err)}
+		}
+		results.Results[i] = result
+	}
+	return results, nil
+}
+
+// registerWatcher starts and registers the watcher described by
+// spec, retrying as configured by ServerConfig.WatcherRetry if it
+// fails transiently.
+func (r *srvRoot) registerWatcher(spec params.WatchSpec) (params.WatchResult, error) {
+	var result params.WatchResult
+	err := r.retryTransient(r.srv.cfg.WatcherRetry, func() (err error) {
+		result, err = r.startWatcher(spec)
+		return err
+	})
+	return result, err
+}
+
+// startWatcher starts and registers the watcher described by spec.
+func (r *srvRoot) startWatcher(spec params.WatchSpec) (params.WatchResult, error) {
+	if spec.Kind == params.WatchEnvironConfig {
+		w, err := r.newSpecNotifyWatcher(spec, func() (state.NotifyWatcher, error) {
+			return r.srv.state.WatchForEnvironConfigChanges(), nil
+		})
+		if err != nil {
+			return params.WatchResult{}, err
+		}
+		return params.WatchResult{NotifyWatcherId: r.resources.Register(w)}, nil
+	}
+	if !r.AuthOwner(spec.Tag) {
+		return params.WatchResult{}, common.ErrPerm
+	}
+	stopAt, ok := lifeValues[spec.StopAt]
+	switch {
+	case spec.StopAt != "" && !ok:
+		return params.WatchResult{}, &common.BadRequestError{Field: "StopAt", Reason: "must be a life value"}
+	case spec.StopAt != "" && spec.Kind != params.WatchEntity:
+		return params.WatchResult{}, &common.BadRequestError{Field: "StopAt", Reason: "only valid for " + params.WatchEntity + " watchers"}
+	}
+	entity, err := r.srv.state.Lifer(spec.Tag)
+	if err != nil {
+		return params.WatchResult{}, err
+	}
+	var newNotify func() (state.NotifyWatcher, error)
+	var newStrings func() (state.StringsWatcher, error)
+	switch entity := entity.(type) {
+	case *state.Machine:
+		switch spec.Kind {
+		case params.WatchEntity:
+			newNotify = func() (state.NotifyWatcher, error) {
+				return entity.Watch(), nil
+			}
+		case params.WatchUnits:
+			newStrings = func() (state.StringsWatcher, error) {
+				return entity.WatchPrincipalUnits(), nil
+			}
+		}
+	case *state.Unit:
+		switch spec.Kind {
+		case params.WatchEntity:
+			newNotify = func() (state.NotifyWatcher, error) {
+				return entity.Watch(), nil
+			}
+		case params.WatchConfigSettings:
+			newNotify = func() (state.NotifyWatcher, error) {
+				return entity.WatchConfigSettings()
+			}
+		case params.WatchRelations:
+			service, err := entity.Service()
+			if err != nil {
+				return params.WatchResult{}, err
+			}
+			newStrings = func() (state.StringsWatcher, error) {
+				return service.WatchRelations(), nil
+			}
+		}
+	}
+	switch {
+	case newNotify != nil:
+		nw, err := r.newSpecNotifyWatcher(spec, newNotify)
+		if err != nil {
+			return params.WatchResult{}, err
+		}
+		// The watcher is registered with the entity's tag so
+		// that Next can check the entity's life, if asked to.
+		id := r.resources.RegisterFor(nw, spec.Tag)
+		if spec.StopAt != "" {
+			r.setStopAt(id, stopAt)
+		}
+		return params.WatchResult{NotifyWatcherId: id}, nil
+	case newStrings != nil:
+		if spec.Lazy {
+			return params.WatchResult{
+				StringsWatcherId: r.resources.RegisterFor(newLazyStringsWatcher(newStrings), spec.Tag),
+			}, nil
+		}
+		sw, err := newStrings()
+		if err != nil {
+			return params.WatchResult{}, err
+		}
+		changes, err := common.InitialStringsEvent(r.resources, sw)
+		if err != nil {
+			return params.WatchResult{}, err
+		}
+		id := r.resources.RegisterFor(sw, spec.Tag)
+		page, more := r.pageSnapshot(id, changes)
+		return params.WatchResult{
+			StringsWatcherId: id,
+			Changes:          page,
+			MorePages:        more,
+		}, nil
+	}
+	return params.WatchResult{}, permanentError{fmt.Errorf("cannot watch %q of %q", spec.Kind, spec.Tag)}
+}
+
+// newSpecNotifyWatcher returns the NotifyWatcher started by
+// newWatcher, its initial event consumed, or, if the spec asks for
+// a lazy watcher, one that calls newWatcher when it is first read.
+func (r *srvRoot) newSpecNotifyWatcher(spec params.WatchSpec, newWatcher func() (state.NotifyWatcher, error)) (state.NotifyWatcher, error) {
+	if spec.Lazy {
+		return newLazyNotifyWatcher(newWatcher), nil
+	}
+	w, err := newWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := common.InitialNotifyEvent(r.resources, w); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// lifeValues maps the life values of the API to those of state.
+var lifeValues = map[params.Life]state.Life{
+	params.Alive: state.Alive,
+	params.Dying: state.Dying,
+	params.Dead:  state.Dead,
+}
+
+// setStopAt records that the watcher with the given resource id is
+// to be stopped once its entity's life reaches the given life.
+func (r *srvRoot) setStopAt(id string, life state.Life) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopAt == nil {
+		r.stopAt = make(map[string]state.Life)
+	}
+	r.stopAt[id] = life
+}
+
+// stopAtLife returns the life at which the watcher with the given
+// resource id is to be stopped, and whether there is one.
+func (r *srvRoot) stopAtLife(id string) (state.Life, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	life, ok := r.stopAt[id]
+	return life, ok
+}
+
+// AgentWatchers returns an object through which an agent may start
+// the watchers it needs, several in a single request if it likes.
+// The id argument is reserved for future use and must be empty.
+func (r *srvRoot) AgentWatchers(id string) (srvAgentWatchers, error) {
+	if id != "" {
+		return srvAgentWatchers{}, common.ErrBadId
+	}
+	return srvAgentWatchers{r}, nil
+}
+
+type srvAgentWatchers struct {
+	root *srvRoot
+}
+
+// Register starts the watchers described by args; see
+// srvRoot.RegisterWatchers.
+func (w srvAgentWatchers) Register(args params.WatchSpecs) (params.WatchResults, error) {
+	return w.root.RegisterWatchers(args.Specs)
+}
+
+// WatchConstraints returns the environment constraints and a
+// watcher of their changes; see srvRoot.WatchConstraints.
+func (w srvAgentWatchers) WatchConstraints() (params.ConstraintsWatchResult, error) {
+	return w.root.WatchConstraints()
+}
+
+// WatchControllerInfo returns the ids of the controller machines and
+// a watcher of their changes; see common.ControllersWatcher.
+func (w srvAgentWatchers) WatchControllerInfo() (params.ControllersWatchResult, error) {
+	return common.NewControllersWatcher(w.root.srv.state, w.root.resources).WatchControllerInfo()
+}
+
+// WatchEnvironLife returns the life of the environment and a
+// watcher of its changes; see srvRoot.WatchEnvironLife.
+func (w srvAgentWatchers) WatchEnvironLife() (params.EnvironLifeWatchResult, error) {
+	return w.root.WatchEnvironLife()
+}
+
+// WatchServiceConfig starts a NotifyWatcher for the configuration
+// settings of each given service; see srvRoot.watchServiceConfig.
+func (w srvAgentWatchers) WatchServiceConfig(args params.Entities) (params.NotifyWatchResults, error) {
+	results := params.NotifyWatchResults{
+		Results: make([]params.NotifyWatchResult, len(args.Entities)),
+	}
+	for i, entity := range args.Entities {
+		result, err := w.root.watchServiceConfig(entity.Tag)
+		result.Error = common.ServerError(err)
+		results.Results[i] = result
+	}
+	return results, nil
+}
+
+// WatchContainers starts a StringsWatcher for the containers of each
+// given type and machine; see srvRoot.watchContainers.
+func (w srvAgentWatchers) WatchContainers(args params.WatchContainers) (params.StringsWatchResults, error) {
+	results := params.StringsWatchResults{
+		Results: make([]params.StringsWatchResult, len(args.Params)),
+	}
+	for i, arg := range args.Params {
+		result, err := w.root.watchContainers(arg.MachineTag, arg.ContainerType)
+		result.Error = common.ServerError(err)
+		results.Results[i] = result
+	}
+	return results, nil
+}
+
+// watchAuthFunc returns the function with which the facade methods
+// watching groups of entities check each entity they are asked to
+// watch. An agent may watch its own entity, and a machine agent the
+// units assigned to its machine; either may watch the services of
+// the units it may watch, by which wildcard watches are scoped.
+func (r *srvRoot) watchAuthFunc() (func(tag string) bool, error) {
+	allowed := map[string]bool{r.GetAuthTag(): true}
+	switch entity := r.authEntity().(type) {
+	case *state.Unit:
+		allowed["service-"+entity.ServiceName()] = true
+	case *state.Machine:
+		units, err := entity.Units()
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range units {
+			allowed[u.Tag()] = true
+			allowed["service-"+u.ServiceName()] = true
+		}
+	}
+	return func(tag string) bool {
+		return allowed[tag]
+	}, nil
+}
+
+// WatchEntities starts a single StringsWatcher reporting changes to
+// any of the given entities; see srvRoot.WatchEntities. Entities the
+// agent may not watch are dropped.
+func (w srvAgentWatchers) WatchEntities(args params.Entities) (params.StringsWatchResult, error) {
+	authFor, err := w.root.watchAuthFunc()
+	if err != nil {
+		return params.StringsWatchResult{}, err
+	}
+	tags := make([]string, len(args.Entities))
+	for i, entity := range args.Entities {
+		tags[i] = entity.Tag
+	}
+	return w.root.WatchEntities(tags, authFor)
+}
+
+// WatchWildcard starts a StringsWatcher tracking the entities matched
+// by the given pattern; see srvRoot.WatchWildcard.
+func (w srvAgentWatchers) WatchWildcard(args params.WatchWildcard) (params.StringsWatchResult, error) {
+	authFor, err := w.root.watchAuthFunc()
+	if err != nil {
+		return params.StringsWatchResult{}, err
+	}
+	return w.root.WatchWildcard(args.Pattern, authFor)
+}
+
+// WatchInOrder starts a single StringsWatcher reporting changes to
+// any of the given entities in the order they were made; see
+// srvRoot.WatchInOrder. As well as the entities the agent may watch
+// through WatchEntities, it may watch the environment. Entities the
+// agent may not watch are dropped.
+func (w srvAgentWatchers) WatchInOrder(args params.Entities) (params.StringsWatchResult, error) {
+	authFor, err := w.root.watchAuthFunc()
+	if err != nil {
+		return params.StringsWatchResult{}, err
+	}
+	tags := make([]string, len(args.Entities))
+	for i, entity := range args.Entities {
+		tags[i] = entity.Tag
+	}
+	return w.root.WatchInOrder(tags, func(tag string) bool {
+		return strings.HasPrefix(tag, "environment-") || authFor(tag)
+	})
+}
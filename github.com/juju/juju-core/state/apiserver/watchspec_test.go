@@ -0,0 +1,234 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver_test
+
+import (
+	"time"
+
+	. "launchpad.net/gocheck"
+	"launchpad.net/juju-core/juju/testing"
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/api/params"
+	apiservertesting "launchpad.net/juju-core/state/apiserver/testing"
+	coretesting "launchpad.net/juju-core/testing"
+)
+
+type watchSpecSuite struct {
+	testing.JujuConnSuite
+}
+
+var _ = Suite(&watchSpecSuite{})
+
+func (s *watchSpecSuite) TestRegisterWatchers(c *C) {
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	err = stm.SetProvisioned("foo", "fake_nonce", nil)
+	c.Assert(err, IsNil)
+	err = stm.SetPassword("password")
+	c.Assert(err, IsNil)
+	other, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	st := s.OpenAPIAsMachine(c, stm.Tag(), "password", "fake_nonce")
+	defer st.Close()
+
+	var results params.WatchResults
+	err = st.Call("AgentWatchers", "", "Register", params.WatchSpecs{
+		Specs: []params.WatchSpec{
+			{Kind: params.WatchEntity, Tag: stm.Tag()},
+			{Kind: params.WatchUnits, Tag: stm.Tag()},
+			{Kind: params.WatchEnvironConfig},
+			{Kind: params.WatchEntity, Tag: other.Tag()},
+			{Kind: params.WatchRelations, Tag: stm.Tag()},
+		},
+	}, &results)
+	c.Assert(err, IsNil)
+	c.Assert(results.Results, HasLen, 5)
+
+	// The failures do not stop the other watchers being started.
+	c.Assert(results.Results[0].Error, IsNil)
+	c.Assert(results.Results[0].NotifyWatcherId, Not(Equals), "")
+	c.Assert(results.Results[1].Error, IsNil)
+	c.Assert(results.Results[1].StringsWatcherId, Not(Equals), "")
+	c.Assert(results.Results[1].Changes, HasLen, 0)
+	c.Assert(results.Results[2].Error, IsNil)
+	c.Assert(results.Results[2].NotifyWatcherId, Not(Equals), "")
+	c.Assert(results.Results[3], DeepEquals, params.WatchResult{
+		Error: apiservertesting.ErrUnauthorized,
+	})
+	c.Assert(results.Results[4].Error, ErrorMatches, `cannot watch "relations" of "machine-.*"`)
+
+	for _, i := range []int{0, 2} {
+		err = st.Call("NotifyWatcher", results.Results[i].NotifyWatcherId, "Stop", nil, nil)
+		c.Assert(err, IsNil)
+	}
+	err = st.Call("StringsWatcher", results.Results[1].StringsWatcherId, "Stop", nil, nil)
+	c.Assert(err, IsNil)
+}
+
+func (s *watchSpecSuite) TestRegisterWatchersRefusesClient(c *C) {
+	var results params.WatchResults
+	err := s.APIState.Call("AgentWatchers", "", "Register", params.WatchSpecs{
+		Specs: []params.WatchSpec{{Kind: params.WatchEnvironConfig}},
+	}, &results)
+	c.Assert(err, ErrorMatches, "permission denied")
+}
+
+func (s *watchSpecSuite) TestRegisterWatchersStopAt(c *C) {
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	err = stm.SetProvisioned("foo", "fake_nonce", nil)
+	c.Assert(err, IsNil)
+	err = stm.SetPassword("password")
+	c.Assert(err, IsNil)
+	st := s.OpenAPIAsMachine(c, stm.Tag(), "password", "fake_nonce")
+	defer st.Close()
+
+	var results params.WatchResults
+	err = st.Call("AgentWatchers", "", "Register", params.WatchSpecs{
+		Specs: []params.WatchSpec{
+			{Kind: params.WatchEntity, Tag: stm.Tag(), StopAt: params.Dying},
+			{Kind: params.WatchEntity, Tag: stm.Tag()},
+			{Kind: params.WatchUnits, Tag: stm.Tag(), StopAt: params.Dying},
+			{Kind: params.WatchEntity, Tag: stm.Tag(), StopAt: "gone"},
+		},
+	}, &results)
+	c.Assert(err, IsNil)
+	c.Assert(results.Results, HasLen, 4)
+	c.Assert(results.Results[0].Error, IsNil)
+	c.Assert(results.Results[1].Error, IsNil)
+	c.Assert(results.Results[2].Error, ErrorMatches, `invalid request: StopAt: only valid for entity watchers`)
+	c.Assert(results.Results[3].Error, ErrorMatches, `invalid request: StopAt: must be a life value`)
+	stopping, other := results.Results[0].NotifyWatcherId, results.Results[1].NotifyWatcherId
+
+	// A change that does not reach the stop condition
+	// leaves the watcher running.
+	err = stm.SetPassword("another password")
+	c.Assert(err, IsNil)
+	s.State.StartSync()
+	err = st.Call("NotifyWatcher", stopping, "Next", nil, nil)
+	c.Assert(err, IsNil)
+
+	// The change that does is delivered, then the watcher stops.
+	err = stm.Destroy()
+	c.Assert(err, IsNil)
+	s.State.StartSync()
+	err = st.Call("NotifyWatcher", stopping, "Next", nil, nil)
+	c.Assert(err, IsNil)
+	err = st.Call("NotifyWatcher", stopping, "Next", nil, nil)
+	c.Assert(err, ErrorMatches, "watcher has been stopped")
+	c.Assert(params.ErrCode(err), Equals, params.CodeStopped)
+
+	// The watcher without a stop condition carries on.
+	err = st.Call("NotifyWatcher", other, "Next", nil, nil)
+	c.Assert(err, IsNil)
+	err = st.Call("NotifyWatcher", other, "Stop", nil, nil)
+	c.Assert(err, IsNil)
+}
+
+func (s *watchSpecSuite) TestRegisterWatchersLazy(c *C) {
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	err = stm.SetProvisioned("foo", "fake_nonce", nil)
+	c.Assert(err, IsNil)
+	err = stm.SetPassword("password")
+	c.Assert(err, IsNil)
+	st := s.OpenAPIAsMachine(c, stm.Tag(), "password", "fake_nonce")
+	defer st.Close()
+
+	var results params.WatchResults
+	err = st.Call("AgentWatchers", "", "Register", params.WatchSpecs{
+		Specs: []params.WatchSpec{
+			{Kind: params.WatchEntity, Tag: stm.Tag(), Lazy: true},
+			{Kind: params.WatchUnits, Tag: stm.Tag(), Lazy: true},
+			{Kind: params.WatchEnvironConfig, Lazy: true},
+		},
+	}, &results)
+	c.Assert(err, IsNil)
+	c.Assert(results.Results, HasLen, 3)
+	for _, result := range results.Results {
+		c.Assert(result.Error, IsNil)
+		c.Assert(result.Changes, HasLen, 0)
+	}
+	entityId := results.Results[0].NotifyWatcherId
+	unitsId := results.Results[1].StringsWatcherId
+	configId := results.Results[2].NotifyWatcherId
+
+	// A unit assigned before the units watcher is first read
+	// is reported in its initial event.
+	svc, err := s.State.AddService("wordpress", s.AddTestingCharm(c, "wordpress"))
+	c.Assert(err, IsNil)
+	u, err := svc.AddUnit()
+	c.Assert(err, IsNil)
+	err = u.AssignToMachine(stm)
+	c.Assert(err, IsNil)
+	var units params.StringsWatchResult
+	err = st.Call("StringsWatcher", unitsId, "Next", nil, &units)
+	c.Assert(err, IsNil)
+	c.Assert(units.Changes, DeepEquals, []string{u.Name()})
+
+	// The first call to Next on a lazy NotifyWatcher
+	// returns at once, with the initial event.
+	err = st.Call("NotifyWatcher", entityId, "Next", nil, nil)
+	c.Assert(err, IsNil)
+
+	// A lazy watcher may be stopped without ever being read.
+	err = st.Call("NotifyWatcher", configId, "Stop", nil, nil)
+	c.Assert(err, IsNil)
+	err = st.Call("NotifyWatcher", entityId, "Stop", nil, nil)
+	c.Assert(err, IsNil)
+	err = st.Call("StringsWatcher", unitsId, "Stop", nil, nil)
+	c.Assert(err, IsNil)
+}
+
+func (s *watchSpecSuite) TestConcurrentNext(c *C) {
+	stm, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	err = stm.SetProvisioned("foo", "fake_nonce", nil)
+	c.Assert(err, IsNil)
+	err = stm.SetPassword("password")
+	c.Assert(err, IsNil)
+	st := s.OpenAPIAsMachine(c, stm.Tag(), "password", "fake_nonce")
+	defer st.Close()
+
+	var results params.WatchResults
+	err = st.Call("AgentWatchers", "", "Register", params.WatchSpecs{
+		Specs: []params.WatchSpec{{Kind: params.WatchEntity, Tag: stm.Tag()}},
+	}, &results)
+	c.Assert(err, IsNil)
+	c.Assert(results.Results[0].Error, IsNil)
+	id := results.Results[0].NotifyWatcherId
+
+	// Of two concurrent calls to Next, whichever arrives
+	// second is refused at once, while the first waits
+	// for the next change.
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			done <- st.Call("NotifyWatcher", id, "Next", nil, nil)
+		}()
+	}
+	select {
+	case err := <-done:
+		c.Assert(err, ErrorMatches, "watcher already has a call to Next in progress")
+		c.Assert(params.ErrCode(err), Equals, params.CodeConcurrentNext)
+	case <-time.After(coretesting.LongWait):
+		c.Fatalf("concurrent call to Next not refused")
+	}
+	err = stm.SetPassword("another password")
+	c.Assert(err, IsNil)
+	s.State.StartSync()
+	select {
+	case err := <-done:
+		c.Assert(err, IsNil)
+	case <-time.After(coretesting.LongWait):
+		c.Fatalf("first call to Next did not return")
+	}
+
+	// Once the first call has returned, Next may be called again.
+	err = stm.SetPassword("yet another password")
+	c.Assert(err, IsNil)
+	s.State.StartSync()
+	err = st.Call("NotifyWatcher", id, "Next", nil, nil)
+	c.Assert(err, IsNil)
+}
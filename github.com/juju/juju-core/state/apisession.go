@@ -0,0 +1,124 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package state
+
+import (
+	"fmt"
+	"time"
+
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/txn"
+
+	"launchpad.net/juju-core/errors"
+)
+
+// APISessionWatcher describes a watcher held by an API connection,
+// so that an equivalent watcher can be started for the client when
+// it reconnects to another API server.
+type APISessionWatcher struct {
+	// Id holds the watcher's resource id on its connection.
+	Id string
+
+	// Tag holds the tag of the entity watched.
+	Tag string
+
+	// Type names the Go type of the watcher, so that an
+	// equivalent watcher can be told from one that merely
+	// watches the same entity.
+	Type string
+}
+
+// apiSessionDoc records the watchers held by an API connection,
+// keyed by the session token handed to the client, so that any
+// API server can resume the session.
+type apiSessionDoc struct {
+	Token    string `bson:"_id"`
+	Tag      string
+	Watchers []APISessionWatcher
+	Saved    time.Time
+}
+
+// APISession represents the saved state of an API connection.
+type APISession struct {
+	doc apiSessionDoc
+}
+
+// Tag returns the tag of the entity logged in on the connection.
+func (s *APISession) Tag() string {
+	return s.doc.Tag
+}
+
+// Watchers returns the watchers held by the connection
+// when the session was last saved.
+func (s *APISession) Watchers() []APISessionWatcher {
+	return s.doc.Watchers
+}
+
+// Saved returns the time at which the session was last saved.
+func (s *APISession) Saved() time.Time {
+	return s.doc.Saved
+}
+
+// SaveAPISession records the watchers held by the API connection
+// with the given session token, logged in as the entity with the
+// given tag, replacing any previously saved.
+func (st *State) SaveAPISession(token, tag string, watchers []APISessionWatcher) error {
+	doc := apiSessionDoc{
+		Token:    token,
+		Tag:      tag,
+		Watchers: watchers,
+		Saved:    time.Now(),
+	}
+	// As for SwitchBlockOn, two attempts suffice.
+	for i := 0; i < 2; i++ {
+		ops := []txn.Op{{
+			C:      st.apiSessions.Name,
+			Id:     token,
+			Assert: txn.DocMissing,
+			Insert: &doc,
+		}}
+		if err := st.runTransaction(ops); err != txn.ErrAborted {
+			return err
+		}
+		ops = []txn.Op{{
+			C:      st.apiSessions.Name,
+			Id:     token,
+			Assert: D{{"tag", tag}},
+			Update: D{{"$set", D{
+				{"watchers", watchers},
+				{"saved", doc.Saved},
+			}}},
+		}}
+		if err := st.runTransaction(ops); err != txn.ErrAborted {
+			return err
+		}
+	}
+	return ErrExcessiveContention
+}
+
+// APISession returns the API session with the given token.
+func (st *State) APISession(token string) (*APISession, error) {
+	var doc apiSessionDoc
+	st.noteRead()
+	err := st.apiSessions.FindId(token).One(&doc)
+	if err == mgo.ErrNotFound {
+		return nil, errors.NotFoundf("API session")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot get API session: %v", err)
+	}
+	return &APISession{doc: doc}, nil
+}
+
+// RemoveAPISession removes the API session with the given token.
+// It does nothing if there is no such session.
+func (st *State) RemoveAPISession(token string) error {
+	ops := []txn.Op{{
+		C:      st.apiSessions.Name,
+		Id:     token,
+		Assert: txn.DocExists,
+		Remove: true,
+	}}
+	return onAbort(st.runTransaction(ops), nil)
+}
@@ -0,0 +1,48 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package state_test
+
+import (
+	. "launchpad.net/gocheck"
+
+	"launchpad.net/juju-core/errors"
+	"launchpad.net/juju-core/state"
+)
+
+type APISessionSuite struct {
+	ConnSuite
+}
+
+var _ = Suite(&APISessionSuite{})
+
+func (s *APISessionSuite) TestSaveAndRemove(c *C) {
+	_, err := s.State.APISession("token")
+	c.Assert(errors.IsNotFoundError(err), Equals, true)
+
+	watchers := []state.APISessionWatcher{{Id: "1", Tag: "machine-0", Type: "*state.entityWatcher"}}
+	err = s.State.SaveAPISession("token", "machine-0", watchers)
+	c.Assert(err, IsNil)
+	session, err := s.State.APISession("token")
+	c.Assert(err, IsNil)
+	c.Assert(session.Tag(), Equals, "machine-0")
+	c.Assert(session.Watchers(), DeepEquals, watchers)
+	saved := session.Saved()
+	c.Assert(saved.IsZero(), Equals, false)
+
+	// Saving again replaces the watchers.
+	watchers = append(watchers, state.APISessionWatcher{Id: "3", Tag: "unit-foo-0", Type: "*state.entityWatcher"})
+	err = s.State.SaveAPISession("token", "machine-0", watchers)
+	c.Assert(err, IsNil)
+	session, err = s.State.APISession("token")
+	c.Assert(err, IsNil)
+	c.Assert(session.Watchers(), DeepEquals, watchers)
+	c.Assert(session.Saved().Before(saved), Equals, false)
+
+	err = s.State.RemoveAPISession("token")
+	c.Assert(err, IsNil)
+	_, err = s.State.APISession("token")
+	c.Assert(errors.IsNotFoundError(err), Equals, true)
+	err = s.State.RemoveAPISession("token")
+	c.Assert(err, IsNil)
+}
@@ -0,0 +1,157 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package state
+
+import (
+	"fmt"
+
+	"labix.org/v2/mgo/txn"
+
+	"launchpad.net/juju-core/state/watcher"
+	"launchpad.net/tomb"
+)
+
+// BlockType identifies the operations prevented by a block.
+type BlockType string
+
+const (
+	// BlockRemove prevents the removal of services,
+	// units and relations.
+	BlockRemove BlockType = "remove"
+
+	// BlockChange prevents all changes to the environment,
+	// including those prevented by BlockRemove.
+	BlockChange BlockType = "change"
+)
+
+// blockDoc represents a block placed by an operator on
+// the operations of some type. There is at most one
+// block of each type.
+type blockDoc struct {
+	Type    BlockType `bson:"_id"`
+	Message string
+}
+
+// Block represents a block on the operations of some type.
+type Block struct {
+	doc blockDoc
+}
+
+// Type returns the type of the operations blocked.
+func (b *Block) Type() BlockType {
+	return b.doc.Type
+}
+
+// Message returns the operator's explanation of the block.
+func (b *Block) Message() string {
+	return b.doc.Message
+}
+
+// SwitchBlockOn blocks operations of the given type, with the given
+// message explaining why. If they are already blocked, the message
+// is replaced.
+func (st *State) SwitchBlockOn(t BlockType, message string) error {
+	if t != BlockRemove && t != BlockChange {
+		return fmt.Errorf("cannot switch on block: unknown block type %q", t)
+	}
+	doc := blockDoc{Type: t, Message: message}
+	// Racing clients switching blocks on and off generate at most one
+	// failure each way, so two attempts suffice.
+	for i := 0; i < 2; i++ {
+		ops := []txn.Op{{
+			C:      st.blocks.Name,
+			Id:     t,
+			Assert: txn.DocMissing,
+			Insert: &doc,
+		}}
+		if err := st.runTransaction(ops); err != txn.ErrAborted {
+			return err
+		}
+		ops = []txn.Op{{
+			C:      st.blocks.Name,
+			Id:     t,
+			Assert: txn.DocExists,
+			Update: D{{"$set", D{{"message", message}}}},
+		}}
+		if err := st.runTransaction(ops); err != txn.ErrAborted {
+			return err
+		}
+	}
+	return ErrExcessiveContention
+}
+
+// SwitchBlockOff unblocks operations of the given type. It does
+// nothing if they are not blocked.
+func (st *State) SwitchBlockOff(t BlockType) error {
+	ops := []txn.Op{{
+		C:      st.blocks.Name,
+		Id:     t,
+		Assert: txn.DocExists,
+		Remove: true,
+	}}
+	return onAbort(st.runTransaction(ops), nil)
+}
+
+// AllBlocks returns all the blocks in the environment, ordered by type.
+func (st *State) AllBlocks() ([]*Block, error) {
+	var docs []blockDoc
+	st.noteRead()
+	if err := st.blocks.Find(nil).Sort("_id").All(&docs); err != nil {
+		return nil, fmt.Errorf("cannot get blocks: %v", err)
+	}
+	blocks := make([]*Block, len(docs))
+	for i, doc := range docs {
+		blocks[i] = &Block{doc: doc}
+	}
+	return blocks, nil
+}
+
+// blocksWatcher notifies of changes to the blocks in the environment.
+type blocksWatcher struct {
+	commonWatcher
+	out chan struct{}
+}
+
+// WatchBlocks returns a NotifyWatcher that notifies when blocks
+// are switched on or off, or their messages change.
+func (st *State) WatchBlocks() NotifyWatcher {
+	w := &blocksWatcher{
+		commonWatcher: commonWatcher{st: st},
+		out:           make(chan struct{}),
+	}
+	go func() {
+		defer w.tomb.Done()
+		defer close(w.out)
+		w.tomb.Kill(w.loop())
+	}()
+	return w
+}
+
+// Changes returns the event channel for the blocksWatcher.
+func (w *blocksWatcher) Changes() <-chan struct{} {
+	return w.out
+}
+
+func (w *blocksWatcher) loop() error {
+	in := make(chan watcher.Change)
+	w.st.watcher.WatchCollection(w.st.blocks.Name, in)
+	defer w.st.watcher.UnwatchCollection(w.st.blocks.Name, in)
+	out := w.out
+	for {
+		select {
+		case <-w.tomb.Dying():
+			return tomb.ErrDying
+		case <-w.st.watcher.Dead():
+			return watcher.MustErr(w.st.watcher)
+		case ch := <-in:
+			if _, ok := collect(ch, in, w.tomb.Dying()); !ok {
+				return tomb.ErrDying
+			}
+			out = w.out
+		case out <- struct{}{}:
+			out = nil
+		}
+	}
+	return nil
+}
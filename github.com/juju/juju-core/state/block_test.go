@@ -0,0 +1,88 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package state_test
+
+import (
+	. "launchpad.net/gocheck"
+
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/testing"
+)
+
+type BlockSuite struct {
+	ConnSuite
+}
+
+var _ = Suite(&BlockSuite{})
+
+func (s *BlockSuite) assertBlocks(c *C, expect map[state.BlockType]string) {
+	blocks, err := s.State.AllBlocks()
+	c.Assert(err, IsNil)
+	got := make(map[state.BlockType]string)
+	for _, b := range blocks {
+		got[b.Type()] = b.Message()
+	}
+	c.Assert(got, DeepEquals, expect)
+}
+
+func (s *BlockSuite) TestSwitchBlocks(c *C) {
+	s.assertBlocks(c, map[state.BlockType]string{})
+
+	err := s.State.SwitchBlockOn(state.BlockRemove, "no removals")
+	c.Assert(err, IsNil)
+	err = s.State.SwitchBlockOn(state.BlockChange, "frozen")
+	c.Assert(err, IsNil)
+	s.assertBlocks(c, map[state.BlockType]string{
+		state.BlockRemove: "no removals",
+		state.BlockChange: "frozen",
+	})
+
+	// Switching a block on again replaces its message.
+	err = s.State.SwitchBlockOn(state.BlockRemove, "still no removals")
+	c.Assert(err, IsNil)
+	s.assertBlocks(c, map[state.BlockType]string{
+		state.BlockRemove: "still no removals",
+		state.BlockChange: "frozen",
+	})
+
+	err = s.State.SwitchBlockOff(state.BlockChange)
+	c.Assert(err, IsNil)
+	err = s.State.SwitchBlockOff(state.BlockChange)
+	c.Assert(err, IsNil)
+	s.assertBlocks(c, map[state.BlockType]string{
+		state.BlockRemove: "still no removals",
+	})
+
+	err = s.State.SwitchBlockOn("nonsense", "")
+	c.Assert(err, ErrorMatches, `cannot switch on block: unknown block type "nonsense"`)
+}
+
+func (s *BlockSuite) TestWatchBlocks(c *C) {
+	w := s.State.WatchBlocks()
+	defer testing.AssertStop(c, w)
+
+	// Initial event.
+	wc := testing.NewNotifyWatcherC(c, s.State, w)
+	wc.AssertOneChange()
+
+	err := s.State.SwitchBlockOn(state.BlockRemove, "no removals")
+	c.Assert(err, IsNil)
+	wc.AssertOneChange()
+
+	err = s.State.SwitchBlockOn(state.BlockRemove, "still no removals")
+	c.Assert(err, IsNil)
+	wc.AssertOneChange()
+
+	// Switching off a block that is not on changes nothing.
+	err = s.State.SwitchBlockOff(state.BlockChange)
+	c.Assert(err, IsNil)
+	wc.AssertNoChange()
+
+	err = s.State.SwitchBlockOff(state.BlockRemove)
+	c.Assert(err, IsNil)
+	wc.AssertOneChange()
+
+	testing.AssertStop(c, w)
+	wc.AssertClosed()
+}
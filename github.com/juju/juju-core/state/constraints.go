@@ -71,6 +71,7 @@ func removeConstraintsOp(st *State, id string) txn.Op {
 
 func readConstraints(st *State, id string) (constraints.Value, error) {
 	doc := constraintsDoc{}
+	st.noteRead()
 	if err := st.constraints.FindId(id).One(&doc); err == mgo.ErrNotFound {
 		return constraints.Value{}, errors.NotFoundf("constraints")
 	} else if err != nil {
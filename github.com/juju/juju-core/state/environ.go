@@ -4,10 +4,13 @@
 package state
 
 import (
+	"fmt"
+
 	"labix.org/v2/mgo"
 	"labix.org/v2/mgo/txn"
 
 	"launchpad.net/juju-core/errors"
+	"launchpad.net/juju-core/utils"
 )
 
 // environGlobalKey is the key for the environment, its
@@ -25,11 +28,13 @@ type Environment struct {
 type environmentDoc struct {
 	UUID string `bson:"_id"`
 	Name string
+	Life Life
 }
 
 // Environment returns the environment entity.
 func (st *State) Environment() (*Environment, error) {
 	doc := environmentDoc{}
+	st.noteRead()
 	err := st.environments.Find(D{{"uuid", D{{"$ne", ""}}}}).One(&doc)
 	if err == mgo.ErrNotFound {
 		return nil, errors.NotFoundf("environment")
@@ -60,6 +65,118 @@ func (e Environment) UUID() string {
 	return e.doc.UUID
 }
 
+// Life returns whether the environment is Alive, Dying or Dead.
+func (e *Environment) Life() Life {
+	return e.doc.Life
+}
+
+// Destroy sets the environment's lifecycle to Dying, so that its
+// agents can see that it is being destroyed and shut down cleanly.
+// It does nothing if the environment is not Alive.
+func (e *Environment) Destroy() (err error) {
+	defer utils.ErrorContextf(&err, "cannot destroy environment")
+	ops := []txn.Op{{
+		C:  e.st.environments.Name,
+		Id: e.doc.UUID,
+		// Environments created before they had a lifecycle
+		// have no life field, and are Alive.
+		Assert: D{{"life", D{{"$nin", []Life{Dying, Dead}}}}},
+		Update: D{{"$set", D{{"life", Dying}}}},
+	}}
+	switch err := e.st.runTransaction(ops); err {
+	case nil:
+		e.doc.Life = Dying
+	case txn.ErrAborted:
+		// The environment is already Dying or Dead.
+		return e.Refresh()
+	default:
+		return err
+	}
+	return nil
+}
+
+// EnsureDead sets the environment's lifecycle to Dead, once it is
+// Dying and its services, and its machines other than those running
+// the ManageState job, have been removed, so that the environment
+// can be removed. It does nothing if the environment is already Dead.
+func (e *Environment) EnsureDead() (err error) {
+	defer utils.ErrorContextf(&err, "cannot set environment to dead")
+	switch e.doc.Life {
+	case Dead:
+		return nil
+	case Alive:
+		return fmt.Errorf("environment is not dying")
+	}
+	e.st.noteRead()
+	n, err := e.st.services.Count()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return fmt.Errorf("environment still has %d services", n)
+	}
+	e.st.noteRead()
+	n, err = e.st.machines.Find(D{{"jobs", D{{"$ne", JobManageState}}}}).Count()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return fmt.Errorf("environment still has %d machines", n)
+	}
+	ops := []txn.Op{{
+		C:      e.st.environments.Name,
+		Id:     e.doc.UUID,
+		Assert: D{{"life", Dying}},
+		Update: D{{"$set", D{{"life", Dead}}}},
+	}}
+	switch err := e.st.runTransaction(ops); err {
+	case nil:
+		e.doc.Life = Dead
+	case txn.ErrAborted:
+		if err := e.Refresh(); err != nil {
+			return err
+		}
+		if e.doc.Life != Dead {
+			return fmt.Errorf("environment is not dying")
+		}
+	default:
+		return err
+	}
+	return nil
+}
+
+// Remove removes the environment from state. It will fail if the
+// environment is not Dead.
+func (e *Environment) Remove() (err error) {
+	defer utils.ErrorContextf(&err, "cannot remove environment")
+	if e.doc.Life != Dead {
+		return fmt.Errorf("environment is not dead")
+	}
+	ops := []txn.Op{{
+		C:      e.st.environments.Name,
+		Id:     e.doc.UUID,
+		Assert: txn.DocExists,
+		Remove: true,
+	}}
+	// The only abort condition in play indicates that the
+	// environment has already been removed.
+	return onAbort(e.st.runTransaction(ops), nil)
+}
+
+// Refresh refreshes the contents of the environment from the
+// underlying state.
+func (e *Environment) Refresh() error {
+	e.st.noteRead()
+	err := e.st.environments.FindId(e.doc.UUID).One(&e.doc)
+	if err == mgo.ErrNotFound {
+		return errors.NotFoundf("environment")
+	}
+	if err != nil {
+		return fmt.Errorf("cannot refresh environment: %v", err)
+	}
+	return nil
+}
+
 // globalKey returns the global database key for the environment.
 func (e *Environment) globalKey() string {
 	return environGlobalKey
@@ -68,7 +185,11 @@ func (e *Environment) globalKey() string {
 // createEnvironmentOp returns the operation needed to create
 // an environment document with the given name and UUID.
 func createEnvironmentOp(st *State, name, uuid string) txn.Op {
-	doc := &environmentDoc{uuid, name}
+	doc := &environmentDoc{
+		UUID: uuid,
+		Name: name,
+		Life: Alive,
+	}
 	return txn.Op{
 		C:      st.environments.Name,
 		Id:     uuid,
@@ -6,7 +6,10 @@ package state_test
 import (
 	. "launchpad.net/gocheck"
 
+	"launchpad.net/juju-core/errors"
 	"launchpad.net/juju-core/state"
+	statetesting "launchpad.net/juju-core/state/testing"
+	"launchpad.net/juju-core/testing/checkers"
 )
 
 type EnvironSuite struct {
@@ -50,3 +53,92 @@ func (s *EnvironSuite) TestAnnotatorForEnvironment(c *C) {
 		return s.State.Environment()
 	})
 }
+
+func (s *EnvironSuite) TestDestroy(c *C) {
+	c.Assert(s.env.Life(), Equals, state.Alive)
+	err := s.env.Destroy()
+	c.Assert(err, IsNil)
+	c.Assert(s.env.Life(), Equals, state.Dying)
+
+	env, err := s.State.Environment()
+	c.Assert(err, IsNil)
+	c.Assert(env.Life(), Equals, state.Dying)
+
+	// Destroying it again does nothing.
+	err = env.Destroy()
+	c.Assert(err, IsNil)
+	c.Assert(env.Life(), Equals, state.Dying)
+}
+
+func (s *EnvironSuite) TestEnsureDead(c *C) {
+	err := s.env.EnsureDead()
+	c.Assert(err, ErrorMatches, "cannot set environment to dead: environment is not dying")
+
+	controller, err := s.State.AddMachine("series", state.JobManageState)
+	c.Assert(err, IsNil)
+	m, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, IsNil)
+	svc, err := s.State.AddService("wordpress", s.AddTestingCharm(c, "wordpress"))
+	c.Assert(err, IsNil)
+	err = s.env.Destroy()
+	c.Assert(err, IsNil)
+
+	// The environment remains Dying while it has
+	// services, or machines other than controllers.
+	err = s.env.EnsureDead()
+	c.Assert(err, ErrorMatches, "cannot set environment to dead: environment still has 1 services")
+	err = svc.Destroy()
+	c.Assert(err, IsNil)
+	err = s.env.EnsureDead()
+	c.Assert(err, ErrorMatches, "cannot set environment to dead: environment still has 1 machines")
+	err = m.EnsureDead()
+	c.Assert(err, IsNil)
+	err = m.Remove()
+	c.Assert(err, IsNil)
+
+	err = s.env.EnsureDead()
+	c.Assert(err, IsNil)
+	c.Assert(s.env.Life(), Equals, state.Dead)
+	err = controller.Refresh()
+	c.Assert(err, IsNil)
+
+	env, err := s.State.Environment()
+	c.Assert(err, IsNil)
+	c.Assert(env.Life(), Equals, state.Dead)
+	err = env.EnsureDead()
+	c.Assert(err, IsNil)
+}
+
+func (s *EnvironSuite) TestRemove(c *C) {
+	err := s.env.Remove()
+	c.Assert(err, ErrorMatches, "cannot remove environment: environment is not dead")
+
+	err = s.env.Destroy()
+	c.Assert(err, IsNil)
+	err = s.env.EnsureDead()
+	c.Assert(err, IsNil)
+	err = s.env.Remove()
+	c.Assert(err, IsNil)
+	err = s.env.Refresh()
+	c.Assert(err, checkers.Satisfies, errors.IsNotFoundError)
+	_, err = s.State.Environment()
+	c.Assert(err, checkers.Satisfies, errors.IsNotFoundError)
+
+	// Removing it again does nothing.
+	err = s.env.Remove()
+	c.Assert(err, IsNil)
+}
+
+func (s *EnvironSuite) TestWatch(c *C) {
+	w := s.env.Watch()
+	defer statetesting.AssertStop(c, w)
+	wc := statetesting.NewNotifyWatcherC(c, s.State, w)
+	wc.AssertOneChange()
+
+	err := s.env.Destroy()
+	c.Assert(err, IsNil)
+	wc.AssertOneChange()
+
+	statetesting.AssertStop(c, w)
+	wc.AssertClosed()
+}
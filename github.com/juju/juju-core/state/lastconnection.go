@@ -0,0 +1,68 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package state
+
+import (
+	"fmt"
+	"time"
+
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/txn"
+
+	"launchpad.net/juju-core/errors"
+)
+
+// lastConnectionDoc records when the entity
+// with the given tag last logged in to the API.
+type lastConnectionDoc struct {
+	Tag  string `bson:"_id"`
+	Time time.Time
+}
+
+// SetLastConnection records that the entity with the given
+// tag logged in to the API at the given time.
+func (st *State) SetLastConnection(tag string, when time.Time) error {
+	doc := lastConnectionDoc{
+		Tag:  tag,
+		Time: when,
+	}
+	// As for SwitchBlockOn, two attempts suffice.
+	for i := 0; i < 2; i++ {
+		ops := []txn.Op{{
+			C:      st.lastConnections.Name,
+			Id:     tag,
+			Assert: txn.DocMissing,
+			Insert: &doc,
+		}}
+		if err := st.runTransaction(ops); err != txn.ErrAborted {
+			return err
+		}
+		ops = []txn.Op{{
+			C:      st.lastConnections.Name,
+			Id:     tag,
+			Assert: txn.DocExists,
+			Update: D{{"$set", D{{"time", when}}}},
+		}}
+		if err := st.runTransaction(ops); err != txn.ErrAborted {
+			return err
+		}
+	}
+	return ErrExcessiveContention
+}
+
+// LastConnection returns the time at which the entity with the given
+// tag last logged in to the API. It returns an error that satisfies
+// errors.IsNotFoundError if the entity has never logged in.
+func (st *State) LastConnection(tag string) (time.Time, error) {
+	var doc lastConnectionDoc
+	st.noteRead()
+	err := st.lastConnections.FindId(tag).One(&doc)
+	if err == mgo.ErrNotFound {
+		return time.Time{}, errors.NotFoundf("last connection of %q", tag)
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("cannot get last connection of %q: %v", tag, err)
+	}
+	return doc.Time, nil
+}
@@ -0,0 +1,43 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package state_test
+
+import (
+	"time"
+
+	. "launchpad.net/gocheck"
+
+	"launchpad.net/juju-core/errors"
+)
+
+type LastConnectionSuite struct {
+	ConnSuite
+}
+
+var _ = Suite(&LastConnectionSuite{})
+
+func (s *LastConnectionSuite) TestSetLastConnection(c *C) {
+	_, err := s.State.LastConnection("machine-0")
+	c.Assert(err, ErrorMatches, `last connection of "machine-0" not found`)
+	c.Assert(errors.IsNotFoundError(err), Equals, true)
+
+	first := time.Date(2013, 10, 1, 12, 0, 0, 0, time.UTC)
+	err = s.State.SetLastConnection("machine-0", first)
+	c.Assert(err, IsNil)
+	when, err := s.State.LastConnection("machine-0")
+	c.Assert(err, IsNil)
+	c.Assert(when.Equal(first), Equals, true)
+
+	// Setting it again replaces it.
+	second := first.Add(time.Hour)
+	err = s.State.SetLastConnection("machine-0", second)
+	c.Assert(err, IsNil)
+	when, err = s.State.LastConnection("machine-0")
+	c.Assert(err, IsNil)
+	c.Assert(when.Equal(second), Equals, true)
+
+	// Other entities are recorded separately.
+	_, err = s.State.LastConnection("unit-wordpress-0")
+	c.Assert(errors.IsNotFoundError(err), Equals, true)
+}
@@ -66,6 +66,7 @@ type machineDoc struct {
 	Jobs          []MachineJob
 	PasswordHash  string
 	Clean         bool
+	Addresses     []Address
 	// Deprecated. InstanceId, now lives on instanceData.
 	// This attribute is retained so that data from existing machines can be read.
 	// SCHEMACHANGE
@@ -138,6 +139,7 @@ func (m *Machine) HardwareCharacteristics() (*instance.HardwareCharacteristics,
 
 func getInstanceData(st *State, id string) (instanceData, error) {
 	var instData instanceData
+	st.noteRead()
 	err := st.instanceData.FindId(id).One(&instData)
 	if err == mgo.ErrNotFound {
 		return instanceData{}, errors.NotFoundf("instance data for machine %v", id)
@@ -243,6 +245,30 @@ func (m *Machine) SetPassword(password string) error {
 	return nil
 }
 
+// Addresses returns the network addresses of the machine,
+// as last reported by its agent.
+func (m *Machine) Addresses() []Address {
+	addresses := make([]Address, len(m.doc.Addresses))
+	copy(addresses, m.doc.Addresses)
+	return addresses
+}
+
+// SetAddresses records the network addresses of the machine,
+// replacing any recorded before.
+func (m *Machine) SetAddresses(addresses []Address) error {
+	ops := []txn.Op{{
+		C:      m.st.machines.Name,
+		Id:     m.doc.Id,
+		Assert: notDeadDoc,
+		Update: D{{"$set", D{{"addresses", addresses}}}},
+	}}
+	if err := m.st.runTransaction(ops); err != nil {
+		return fmt.Errorf("cannot set addresses of machine %v: %v", m, onAbort(err, errDead))
+	}
+	m.doc.Addresses = addresses
+	return nil
+}
+
 // PasswordValid returns whether the given password is valid
 // for the given machine.
 func (m *Machine) PasswordValid(password string) bool {
@@ -285,6 +311,7 @@ func IsHasAssignedUnitsError(err error) bool {
 // TODO(wallyworld): move this method to a service
 func (m *Machine) Containers() ([]string, error) {
 	var mc machineContainers
+	m.st.noteRead()
 	err := m.st.containerRefs.FindId(m.Id()).One(&mc)
 	if err == nil {
 		return mc.Children, nil
@@ -453,6 +480,7 @@ func (m *Machine) Remove() (err error) {
 // been removed.
 func (m *Machine) Refresh() error {
 	doc := machineDoc{}
+	m.st.noteRead()
 	err := m.st.machines.FindId(m.doc.Id).One(&doc)
 	if err == mgo.ErrNotFound {
 		return errors.NotFoundf("machine %v", m)
@@ -524,6 +552,7 @@ func (m *Machine) InstanceId() (instance.Id, error) {
 func (m *Machine) Units() (units []*Unit, err error) {
 	defer utils.ErrorContextf(&err, "cannot get units assigned to machine %v", m)
 	pudocs := []unitDoc{}
+	m.st.noteRead()
 	err = m.st.units.Find(D{{"machineid", m.doc.Id}}).All(&pudocs)
 	if err != nil {
 		return nil, err
@@ -531,6 +560,7 @@ func (m *Machine) Units() (units []*Unit, err error) {
 	for _, pudoc := range pudocs {
 		units = append(units, newUnit(m.st, &pudoc))
 		docs := []unitDoc{}
+		m.st.noteRead()
 		err = m.st.units.Find(D{{"principal", pudoc.Name}}).All(&docs)
 		if err != nil {
 			return nil, err
@@ -301,6 +301,81 @@ func (s *MachineSuite) TestMachineWaitAgentAlive(c *C) {
 	c.Assert(alive, Equals, false)
 }
 
+func (s *MachineSuite) TestWatchAgentPresence(c *C) {
+	w := s.machine.WatchAgentPresence()
+	defer testing.AssertStop(c, w)
+
+	// Initial event.
+	wc := testing.NewNotifyWatcherC(c, s.State, w)
+	wc.AssertOneChange()
+
+	// Other changes to the machine are not reported.
+	err := s.machine.SetPassword("new password")
+	c.Assert(err, IsNil)
+	wc.AssertNoChange()
+
+	// The agent being found alive, and then lost, is.
+	pinger, err := s.machine.SetAgentAlive()
+	c.Assert(err, IsNil)
+	wc.AssertOneChange()
+	err = pinger.Kill()
+	c.Assert(err, IsNil)
+	wc.AssertOneChange()
+
+	// Stop, check closed.
+	testing.AssertStop(c, w)
+	wc.AssertClosed()
+}
+
+func (s *MachineSuite) TestSetAddresses(c *C) {
+	c.Assert(s.machine.Addresses(), HasLen, 0)
+	addresses := []state.Address{
+		{Value: "10.0.0.1", Type: state.Ipv4Address, NetworkScope: state.NetworkCloudLocal},
+		{Value: "example.com", Type: state.HostName, NetworkScope: state.NetworkPublic},
+	}
+	err := s.machine.SetAddresses(addresses)
+	c.Assert(err, IsNil)
+	c.Assert(s.machine.Addresses(), DeepEquals, addresses)
+
+	err = s.machine.Refresh()
+	c.Assert(err, IsNil)
+	c.Assert(s.machine.Addresses(), DeepEquals, addresses)
+
+	err = s.machine.EnsureDead()
+	c.Assert(err, IsNil)
+	err = s.machine.SetAddresses(nil)
+	c.Assert(err, ErrorMatches, `cannot set addresses of machine 0: not found or dead`)
+}
+
+func (s *MachineSuite) TestWatchAddresses(c *C) {
+	w := s.machine.WatchAddresses()
+	defer testing.AssertStop(c, w)
+
+	// Initial event.
+	wc := testing.NewNotifyWatcherC(c, s.State, w)
+	wc.AssertOneChange()
+
+	// Other changes to the machine are not reported.
+	err := s.machine.SetPassword("new password")
+	c.Assert(err, IsNil)
+	wc.AssertNoChange()
+
+	// Changes to the addresses are.
+	addresses := []state.Address{{Value: "10.0.0.1", Type: state.Ipv4Address}}
+	err = s.machine.SetAddresses(addresses)
+	c.Assert(err, IsNil)
+	wc.AssertOneChange()
+
+	// Setting the same addresses again is not a change.
+	err = s.machine.SetAddresses(addresses)
+	c.Assert(err, IsNil)
+	wc.AssertNoChange()
+
+	// Stop, check closed.
+	testing.AssertStop(c, w)
+	wc.AssertClosed()
+}
+
 func (s *MachineSuite) TestMachineInstanceId(c *C) {
 	machine, err := s.State.AddMachine("series", state.JobHostUnits)
 	c.Assert(err, IsNil)
@@ -0,0 +1,126 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package state
+
+import (
+	"fmt"
+	"time"
+
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/txn"
+
+	"launchpad.net/juju-core/errors"
+	"launchpad.net/juju-core/utils"
+)
+
+// Metric holds a single reading of a metric of a metered charm.
+type Metric struct {
+	Key   string
+	Value string
+	Time  time.Time
+}
+
+// metricBatchDoc holds a batch of metrics sent
+// by a unit, identified by a UUID chosen by the unit.
+type metricBatchDoc struct {
+	UUID    string `bson:"_id"`
+	Unit    string
+	Created time.Time
+	Metrics []Metric
+}
+
+// MetricBatch represents a batch of metrics sent by a unit.
+type MetricBatch struct {
+	st  *State
+	doc metricBatchDoc
+}
+
+// UUID returns the identifier of the batch.
+func (m *MetricBatch) UUID() string {
+	return m.doc.UUID
+}
+
+// Unit returns the name of the unit that sent the batch.
+func (m *MetricBatch) Unit() string {
+	return m.doc.Unit
+}
+
+// Created returns the time at which the unit created the batch.
+func (m *MetricBatch) Created() time.Time {
+	return m.doc.Created
+}
+
+// Metrics returns the metrics held in the batch.
+func (m *MetricBatch) Metrics() []Metric {
+	metrics := make([]Metric, len(m.doc.Metrics))
+	copy(metrics, m.doc.Metrics)
+	return metrics
+}
+
+// AddMetrics records a batch of metrics sent by the unit, identified
+// by the given UUID. Adding a batch that the unit has already added
+// returns the batch as first added, so that a unit may safely retry
+// sending it; the UUID of a batch added by another unit may not be
+// reused.
+func (u *Unit) AddMetrics(uuid string, created time.Time, metrics []Metric) (*MetricBatch, error) {
+	if !utils.IsValidUUIDString(uuid) {
+		return nil, fmt.Errorf("cannot add metrics: invalid batch UUID %q", uuid)
+	}
+	if len(metrics) == 0 {
+		return nil, fmt.Errorf("cannot add metrics batch %q: no metrics", uuid)
+	}
+	for _, m := range metrics {
+		if m.Key == "" {
+			return nil, fmt.Errorf("cannot add metrics batch %q: metric with empty key", uuid)
+		}
+	}
+	doc := metricBatchDoc{
+		UUID:    uuid,
+		Unit:    u.Name(),
+		Created: created,
+		Metrics: metrics,
+	}
+	ops := []txn.Op{{
+		C:      u.st.units.Name,
+		Id:     u.doc.Name,
+		Assert: notDeadDoc,
+	}, {
+		C:      u.st.metrics.Name,
+		Id:     uuid,
+		Assert: txn.DocMissing,
+		Insert: &doc,
+	}}
+	err := u.st.runTransaction(ops)
+	if err == nil {
+		return &MetricBatch{st: u.st, doc: doc}, nil
+	}
+	if err != txn.ErrAborted {
+		return nil, fmt.Errorf("cannot add metrics batch %q: %v", uuid, err)
+	}
+	existing, err := u.st.MetricBatch(uuid)
+	if errors.IsNotFoundError(err) {
+		return nil, fmt.Errorf("cannot add metrics batch %q: %v", uuid, errDead)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if existing.Unit() != u.Name() {
+		return nil, fmt.Errorf("cannot add metrics batch %q: already added by another unit", uuid)
+	}
+	return existing, nil
+}
+
+// MetricBatch returns the metrics batch with the given UUID.
+func (st *State) MetricBatch(uuid string) (*MetricBatch, error) {
+	var doc metricBatchDoc
+	st.noteRead()
+	err := st.metrics.FindId(uuid).One(&doc)
+	if err == mgo.ErrNotFound {
+		return nil, errors.NotFoundf("metrics batch %q", uuid)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot get metrics batch %q: %v", uuid, err)
+	}
+	return &MetricBatch{st: st, doc: doc}, nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package state_test
+
+import (
+	"time"
+
+	. "launchpad.net/gocheck"
+
+	"launchpad.net/juju-core/errors"
+	"launchpad.net/juju-core/state"
+)
+
+type MetricsSuite struct {
+	ConnSuite
+	unit *state.Unit
+}
+
+var _ = Suite(&MetricsSuite{})
+
+const batchUUID = "1b4a2c5d-6e7f-4a8b-9c0d-1e2f3a4b5c6d"
+
+func (s *MetricsSuite) SetUpTest(c *C) {
+	s.ConnSuite.SetUpTest(c)
+	svc, err := s.State.AddService("wordpress", s.AddTestingCharm(c, "wordpress"))
+	c.Assert(err, IsNil)
+	s.unit, err = svc.AddUnit()
+	c.Assert(err, IsNil)
+}
+
+func (s *MetricsSuite) TestAddMetrics(c *C) {
+	created := time.Date(2013, 10, 1, 12, 0, 0, 0, time.UTC)
+	metrics := []state.Metric{{Key: "pings", Value: "5", Time: created}}
+	batch, err := s.unit.AddMetrics(batchUUID, created, metrics)
+	c.Assert(err, IsNil)
+	c.Assert(batch.UUID(), Equals, batchUUID)
+	c.Assert(batch.Unit(), Equals, s.unit.Name())
+
+	batch, err = s.State.MetricBatch(batchUUID)
+	c.Assert(err, IsNil)
+	c.Assert(batch.Unit(), Equals, s.unit.Name())
+	c.Assert(batch.Created().Equal(created), Equals, true)
+	c.Assert(batch.Metrics(), HasLen, 1)
+	c.Assert(batch.Metrics()[0].Key, Equals, "pings")
+	c.Assert(batch.Metrics()[0].Value, Equals, "5")
+	c.Assert(batch.Metrics()[0].Time.Equal(created), Equals, true)
+
+	// Adding the batch again returns it as first added.
+	later := []state.Metric{{Key: "pings", Value: "6", Time: created}}
+	batch, err = s.unit.AddMetrics(batchUUID, created, later)
+	c.Assert(err, IsNil)
+	c.Assert(batch.Metrics()[0].Value, Equals, "5")
+
+	// Another unit may not reuse the UUID.
+	svc, err := s.unit.Service()
+	c.Assert(err, IsNil)
+	other, err := svc.AddUnit()
+	c.Assert(err, IsNil)
+	_, err = other.AddMetrics(batchUUID, created, metrics)
+	c.Assert(err, ErrorMatches, `cannot add metrics batch ".*": already added by another unit`)
+}
+
+func (s *MetricsSuite) TestAddMetricsInvalid(c *C) {
+	created := time.Now()
+	metrics := []state.Metric{{Key: "pings", Value: "5", Time: created}}
+	_, err := s.unit.AddMetrics("not-a-uuid", created, metrics)
+	c.Assert(err, ErrorMatches, `cannot add metrics: invalid batch UUID "not-a-uuid"`)
+	_, err = s.unit.AddMetrics(batchUUID, created, nil)
+	c.Assert(err, ErrorMatches, `cannot add metrics batch ".*": no metrics`)
+	_, err = s.unit.AddMetrics(batchUUID, created, []state.Metric{{Value: "5"}})
+	c.Assert(err, ErrorMatches, `cannot add metrics batch ".*": metric with empty key`)
+
+	err = s.unit.EnsureDead()
+	c.Assert(err, IsNil)
+	_, err = s.unit.AddMetrics(batchUUID, created, metrics)
+	c.Assert(err, ErrorMatches, `cannot add metrics batch ".*": not found or dead`)
+	_, err = s.State.MetricBatch(batchUUID)
+	c.Assert(errors.IsNotFoundError(err), Equals, true)
+}
@@ -182,6 +182,7 @@ func (s *Service) EnsureMinUnits() (err error) {
 // aliveUnitsCount returns the number a alive units for the service.
 func aliveUnitsCount(service *Service) (int, error) {
 	query := D{{"service", service.doc.Name}, {"life", Alive}}
+	service.st.noteRead()
 	return service.st.units.Find(query).Count()
 }
 
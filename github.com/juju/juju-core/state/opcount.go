@@ -0,0 +1,80 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package state
+
+import (
+	"sync/atomic"
+)
+
+// OpCounts holds the numbers of database operations made
+// through a State while operation counting was enabled.
+// Operations made by watchers are not counted.
+type OpCounts struct {
+	// Reads holds the number of queries made.
+	Reads uint64
+
+	// Transactions holds the number of transactions run,
+	// and Writes the number of document operations they held.
+	Transactions uint64
+	Writes       uint64
+}
+
+// Sub returns the operations counted in c but not in old.
+func (c OpCounts) Sub(old OpCounts) OpCounts {
+	return OpCounts{
+		Reads:        c.Reads - old.Reads,
+		Transactions: c.Transactions - old.Transactions,
+		Writes:       c.Writes - old.Writes,
+	}
+}
+
+// opCounter counts the operations made through a State. The counts
+// come first so that they are aligned for atomic access.
+type opCounter struct {
+	reads        uint64
+	transactions uint64
+	writes       uint64
+	enabled      int32
+}
+
+// SetOpCounting enables or disables the counting of the database
+// operations made through st. Counting is disabled by default; when
+// disabled it costs no more than an atomic load per operation.
+func (st *State) SetOpCounting(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&st.ops.enabled, v)
+}
+
+// OpCounting reports whether operation counting is enabled.
+func (st *State) OpCounting() bool {
+	return atomic.LoadInt32(&st.ops.enabled) != 0
+}
+
+// OpCounts returns the numbers of operations counted so far.
+func (st *State) OpCounts() OpCounts {
+	return OpCounts{
+		Reads:        atomic.LoadUint64(&st.ops.reads),
+		Transactions: atomic.LoadUint64(&st.ops.transactions),
+		Writes:       atomic.LoadUint64(&st.ops.writes),
+	}
+}
+
+// noteRead counts a query, if counting is enabled.
+func (st *State) noteRead() {
+	if st.OpCounting() {
+		atomic.AddUint64(&st.ops.reads, 1)
+	}
+}
+
+// noteTransaction counts a transaction holding the
+// given number of operations, if counting is enabled.
+func (st *State) noteTransaction(n int) {
+	if st.OpCounting() {
+		atomic.AddUint64(&st.ops.transactions, 1)
+		atomic.AddUint64(&st.ops.writes, uint64(n))
+	}
+}
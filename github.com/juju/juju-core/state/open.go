@@ -211,26 +211,31 @@ func newState(session *mgo.Session, info *Info) (*State, error) {
 		}
 	}
 	st := &State{
-		info:           info,
-		db:             db,
-		environments:   db.C("environments"),
-		charms:         db.C("charms"),
-		machines:       db.C("machines"),
-		containerRefs:  db.C("containerRefs"),
-		instanceData:   db.C("instanceData"),
-		relations:      db.C("relations"),
-		relationScopes: db.C("relationscopes"),
-		services:       db.C("services"),
-		minUnits:       db.C("minunits"),
-		settings:       db.C("settings"),
-		settingsrefs:   db.C("settingsrefs"),
-		constraints:    db.C("constraints"),
-		units:          db.C("units"),
-		users:          db.C("users"),
-		presence:       pdb.C("presence"),
-		cleanups:       db.C("cleanups"),
-		annotations:    db.C("annotations"),
-		statuses:       db.C("statuses"),
+		info:            info,
+		db:              db,
+		environments:    db.C("environments"),
+		charms:          db.C("charms"),
+		machines:        db.C("machines"),
+		containerRefs:   db.C("containerRefs"),
+		instanceData:    db.C("instanceData"),
+		relations:       db.C("relations"),
+		relationScopes:  db.C("relationscopes"),
+		services:        db.C("services"),
+		minUnits:        db.C("minunits"),
+		settings:        db.C("settings"),
+		settingsrefs:    db.C("settingsrefs"),
+		constraints:     db.C("constraints"),
+		units:           db.C("units"),
+		users:           db.C("users"),
+		presence:        pdb.C("presence"),
+		cleanups:        db.C("cleanups"),
+		annotations:     db.C("annotations"),
+		statuses:        db.C("statuses"),
+		blocks:          db.C("blocks"),
+		apiSessions:     db.C("apisessions"),
+		lastConnections: db.C("lastconnections"),
+		metrics:         db.C("metrics"),
+		ops:             &opCounter{},
 	}
 	log := db.C("txns.log")
 	logInfo := mgo.CollectionInfo{Capped: true, MaxBytes: logSize}
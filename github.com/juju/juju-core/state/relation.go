@@ -65,6 +65,7 @@ func (r *Relation) String() string {
 // removed.
 func (r *Relation) Refresh() error {
 	doc := relationDoc{}
+	r.st.noteRead()
 	err := r.st.relations.FindId(r.doc.Key).One(&doc)
 	if err == mgo.ErrNotFound {
 		return errors.NotFoundf("relation %v", r)
@@ -188,6 +189,7 @@ func (r *Relation) removeOps(ignoreService string, departingUnit *Unit) ([]txn.O
 			svc := &Service{st: r.st}
 			hasLastRef := D{{"life", Dying}, {"unitcount", 0}, {"relationcount", 1}}
 			removable := append(D{{"_id", ep.ServiceName}}, hasLastRef...)
+			r.st.noteRead()
 			if err := r.st.services.Find(removable).One(&svc.doc); err == nil {
 				ops = append(ops, svc.removeOps(hasLastRef)...)
 				continue
@@ -74,6 +74,7 @@ func (ru *RelationUnit) EnterScope(settings map[string]interface{}) error {
 	if err != nil {
 		return err
 	}
+	ru.st.noteRead()
 	if count, err := ru.st.relationScopes.FindId(ruKey).Count(); err != nil {
 		return err
 	} else if count != 0 {
@@ -102,6 +103,7 @@ func (ru *RelationUnit) EnterScope(settings map[string]interface{}) error {
 	//   before we create the scope doc, because the existence of a scope doc
 	//   is considered to be a guarantee of the existence of a settings doc.
 	settingsChanged := func() (bool, error) { return false, nil }
+	ru.st.noteRead()
 	if count, err := ru.st.settings.FindId(ruKey).Count(); err != nil {
 		return err
 	} else if count == 0 {
@@ -136,6 +138,7 @@ func (ru *RelationUnit) EnterScope(settings map[string]interface{}) error {
 	if err := ru.st.runTransaction(ops); err != txn.ErrAborted {
 		return err
 	}
+	ru.st.noteRead()
 	if count, err := ru.st.relationScopes.FindId(ruKey).Count(); err != nil {
 		return err
 	} else if count != 0 {
@@ -203,6 +206,7 @@ func (ru *RelationUnit) subordinateOps() ([]txn.Op, string, error) {
 	serviceName, unitName := related[0].ServiceName, ru.unit.doc.Name
 	selSubordinate := D{{"service", serviceName}, {"principal", unitName}}
 	var lDoc lifeDoc
+	ru.st.noteRead()
 	if err := ru.st.units.Find(selSubordinate).One(&lDoc); err == mgo.ErrNotFound {
 		service, err := ru.st.Service(serviceName)
 		if err != nil {
@@ -256,6 +260,7 @@ func (ru *RelationUnit) LeaveScope() error {
 	// the database is actually changed).
 	desc := fmt.Sprintf("unit %q in relation %q", ru.unit, ru.relation)
 	for attempt := 0; attempt < 3; attempt++ {
+		ru.st.noteRead()
 		count, err := ru.st.relationScopes.FindId(key).Count()
 		if err != nil {
 			return fmt.Errorf("cannot examine scope for %s: %v", desc, err)
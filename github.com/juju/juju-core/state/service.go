@@ -378,6 +378,7 @@ func (s *Service) changeCharmOps(ch *Charm, force bool) ([]txn.Op, error) {
 	// Create or replace service settings.
 	var settingsOp txn.Op
 	newKey := serviceSettingsKey(s.doc.Name, ch.URL())
+	s.st.noteRead()
 	if count, err := s.st.settings.FindId(newKey).Count(); err != nil {
 		return nil, err
 	} else if count == 0 {
@@ -468,6 +469,7 @@ func (s *Service) SetCharm(ch *Charm, force bool) (err error) {
 		var ops []txn.Op
 		// Make sure the service doesn't have this charm already.
 		sel := D{{"_id", s.doc.Name}, {"charmurl", ch.URL()}}
+		s.st.noteRead()
 		if count, err := s.st.services.Find(sel).Count(); err != nil {
 			return err
 		} else if count == 1 {
@@ -515,6 +517,7 @@ func (s *Service) String() string {
 // state. It returns an error that satisfies IsNotFound if the service has
 // been removed.
 func (s *Service) Refresh() error {
+	s.st.noteRead()
 	err := s.st.services.FindId(s.doc.Name).One(&s.doc)
 	if err == mgo.ErrNotFound {
 		return errors.NotFoundf("service %q", s)
@@ -696,6 +699,7 @@ func (s *Service) Unit(name string) (*Unit, error) {
 	}
 	udoc := &unitDoc{}
 	sel := D{{"_id", name}, {"service", s.doc.Name}}
+	s.st.noteRead()
 	if err := s.st.units.Find(sel).One(udoc); err != nil {
 		return nil, fmt.Errorf("cannot get unit %q from service %q: %v", name, s.doc.Name, err)
 	}
@@ -705,6 +709,7 @@ func (s *Service) Unit(name string) (*Unit, error) {
 // AllUnits returns all units of the service.
 func (s *Service) AllUnits() (units []*Unit, err error) {
 	docs := []unitDoc{}
+	s.st.noteRead()
 	err = s.st.units.Find(D{{"service", s.doc.Name}}).All(&docs)
 	if err != nil {
 		return nil, fmt.Errorf("cannot get all units from service %q: %v", s, err)
@@ -719,6 +724,7 @@ func (s *Service) AllUnits() (units []*Unit, err error) {
 func (s *Service) Relations() (relations []*Relation, err error) {
 	defer utils.ErrorContextf(&err, "can't get relations for service %q", s)
 	docs := []relationDoc{}
+	s.st.noteRead()
 	err = s.st.relations.Find(D{{"endpoints.servicename", s.doc.Name}}).All(&docs)
 	if err != nil {
 		return nil, err
@@ -805,6 +811,7 @@ func (s *Service) SetConstraints(cons constraints.Value) (err error) {
 // otherwise, it will be created with a ref count of 1.
 func settingsIncRefOp(st *State, serviceName string, curl *charm.URL, canCreate bool) (txn.Op, error) {
 	key := serviceSettingsKey(serviceName, curl)
+	st.noteRead()
 	if count, err := st.settingsrefs.FindId(key).Count(); err != nil {
 		return txn.Op{}, err
 	} else if count == 0 {
@@ -833,6 +840,7 @@ func settingsIncRefOp(st *State, serviceName string, curl *charm.URL, canCreate
 func settingsDecRefOps(st *State, serviceName string, curl *charm.URL) ([]txn.Op, error) {
 	key := serviceSettingsKey(serviceName, curl)
 	var doc settingsRefsDoc
+	st.noteRead()
 	if err := st.settingsrefs.FindId(key).One(&doc); err == mgo.ErrNotFound {
 		return nil, errors.NotFoundf("service %q settings for charm %q", serviceName, curl)
 	} else if err != nil {
@@ -1408,6 +1408,30 @@ func (s *ServiceSuite) TestWatchService(c *C) {
 	testing.NewNotifyWatcherC(c, s.State, w).AssertOneChange()
 }
 
+func (s *ServiceSuite) TestWatchConfigSettings(c *C) {
+	svc, err := s.State.AddService("dummy-service", s.AddTestingCharm(c, "dummy"))
+	c.Assert(err, IsNil)
+	w := svc.WatchConfigSettings()
+	defer testing.AssertStop(c, w)
+
+	// Initial event.
+	wc := testing.NewNotifyWatcherC(c, s.State, w)
+	wc.AssertOneChange()
+
+	// Change the settings, check one event.
+	err = svc.UpdateConfigSettings(charm.Settings{"skill-level": 3})
+	c.Assert(err, IsNil)
+	wc.AssertOneChange()
+
+	// Other changes to the service are not reported.
+	err = svc.SetExposed()
+	c.Assert(err, IsNil)
+	wc.AssertNoChange()
+
+	testing.AssertStop(c, w)
+	wc.AssertClosed()
+}
+
 func (s *ServiceSuite) TestAnnotatorForService(c *C) {
 	testAnnotator(c, func() (state.Annotator, error) {
 		return s.State.Service("mysql")
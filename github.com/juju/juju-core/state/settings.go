@@ -214,6 +214,7 @@ func (c *Settings) Read() error {
 // key. It returns the settings and the current rxnRevno.
 func readSettingsDoc(st *State, key string) (map[string]interface{}, int64, error) {
 	config := map[string]interface{}{}
+	st.noteRead()
 	err := st.settings.FindId(key).One(config)
 	if err != nil {
 		return nil, 0, err
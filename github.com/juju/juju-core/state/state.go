@@ -94,8 +94,13 @@ type State struct {
 	cleanups         *mgo.Collection
 	annotations      *mgo.Collection
 	statuses         *mgo.Collection
+	blocks           *mgo.Collection
+	apiSessions      *mgo.Collection
+	lastConnections  *mgo.Collection
+	metrics          *mgo.Collection
 	runner           *txn.Runner
 	transactionHooks chan ([]transactionHook)
+	ops              *opCounter
 	watcher          *watcher.Watcher
 	pwatcher         *presence.Watcher
 	// mu guards allManager.
@@ -130,6 +135,7 @@ func (st *State) runTransaction(ops []txn.Op) error {
 			transactionHooks[0].Before()
 		}
 	}
+	st.noteTransaction(len(ops))
 	return st.runner.Run(ops, "", nil)
 }
 
@@ -421,6 +427,7 @@ func onAbort(txnErr, err error) error {
 // ordered by id.
 func (st *State) AllMachines() (machines []*Machine, err error) {
 	mdocs := machineDocSlice{}
+	st.noteRead()
 	err = st.machines.Find(nil).All(&mdocs)
 	if err != nil {
 		return nil, fmt.Errorf("cannot get all machines: %v", err)
@@ -432,6 +439,23 @@ func (st *State) AllMachines() (machines []*Machine, err error) {
 	return
 }
 
+// StateServerMachines returns the ids of the machines that are alive
+// and run the ManageState job, sorted by id.
+func (st *State) StateServerMachines() ([]string, error) {
+	mdocs := machineDocSlice{}
+	sel := D{{"life", Alive}, {"jobs", JobManageState}}
+	st.noteRead()
+	if err := st.machines.Find(sel).Select(D{{"_id", 1}}).All(&mdocs); err != nil {
+		return nil, fmt.Errorf("cannot get state server machines: %v", err)
+	}
+	sort.Sort(mdocs)
+	ids := make([]string, len(mdocs))
+	for i, doc := range mdocs {
+		ids[i] = doc.Id
+	}
+	return ids, nil
+}
+
 type machineDocSlice []machineDoc
 
 func (ms machineDocSlice) Len() int      { return len(ms) }
@@ -486,6 +510,7 @@ func machineIdLessThan(id1, id2 string) bool {
 func (st *State) Machine(id string) (*Machine, error) {
 	mdoc := &machineDoc{}
 	sel := D{{"_id", id}}
+	st.noteRead()
 	err := st.machines.Find(sel).One(mdoc)
 	if err == mgo.ErrNotFound {
 		return nil, errors.NotFoundf("machine %s", id)
@@ -589,36 +614,74 @@ func (st *State) Remover(tag string) (Remover, error) {
 	return nil, fmt.Errorf("entity %q does not support removal", tag)
 }
 
-// entity returns the entity for the given tag.
-func (st *State) entity(tag string) (interface{}, error) {
+// Tagger attempts to return a Tagger with the given tag.
+func (st *State) Tagger(tag string) (Tagger, error) {
+	e, err := st.entity(tag)
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := e.(Tagger); ok {
+		return e, nil
+	}
+	return nil, fmt.Errorf("entity %q does not have a tag", tag)
+}
+
+// CheckTag returns an error if the given string is not well formed
+// as the tag of a machine, unit, user, service or environment. It
+// does not check that the entity exists.
+func CheckTag(tag string) error {
+	_, _, err := splitTag(tag)
+	return err
+}
+
+// splitTag returns the kind of entity named by the given tag and its
+// id, as used to look it up, or an error if the tag is not well formed.
+func splitTag(tag string) (kind, id string, err error) {
 	i := strings.Index(tag, "-")
 	if i <= 0 || i >= len(tag)-1 {
-		return nil, fmt.Errorf("invalid entity tag %q", tag)
+		return "", "", fmt.Errorf("invalid entity tag %q", tag)
 	}
-	prefix, id := tag[0:i], tag[i+1:]
-	switch prefix {
+	kind, id = tag[0:i], tag[i+1:]
+	switch kind {
 	case "machine":
 		id = MachineIdFromTag(tag)
 		if !IsMachineId(id) {
-			return nil, fmt.Errorf("invalid entity tag %q", tag)
+			return "", "", fmt.Errorf("invalid entity tag %q", tag)
 		}
-		return st.Machine(id)
 	case "unit":
 		i := strings.LastIndex(id, "-")
 		if i == -1 {
-			return nil, fmt.Errorf("invalid entity tag %q", tag)
+			return "", "", fmt.Errorf("invalid entity tag %q", tag)
 		}
-		name := id[:i] + "/" + id[i+1:]
-		if !IsUnitName(name) {
-			return nil, fmt.Errorf("invalid entity tag %q", tag)
+		id = id[:i] + "/" + id[i+1:]
+		if !IsUnitName(id) {
+			return "", "", fmt.Errorf("invalid entity tag %q", tag)
 		}
-		return st.Unit(name)
-	case "user":
-		return st.User(id)
 	case "service":
 		if !IsServiceName(id) {
-			return nil, fmt.Errorf("invalid entity tag %q", tag)
+			return "", "", fmt.Errorf("invalid entity tag %q", tag)
 		}
+	case "user", "environment":
+	default:
+		return "", "", fmt.Errorf("invalid entity tag %q", tag)
+	}
+	return kind, id, nil
+}
+
+// entity returns the entity for the given tag.
+func (st *State) entity(tag string) (interface{}, error) {
+	kind, id, err := splitTag(tag)
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case "machine":
+		return st.Machine(id)
+	case "unit":
+		return st.Unit(id)
+	case "user":
+		return st.User(id)
+	case "service":
 		return st.Service(id)
 	case "environment":
 		conf, err := st.EnvironConfig()
@@ -632,7 +695,7 @@ func (st *State) entity(tag string) (interface{}, error) {
 		}
 		return st.Environment()
 	}
-	return nil, fmt.Errorf("invalid entity tag %q", tag)
+	panic("unreachable")
 }
 
 // ParseTag, given an entity tag, returns the collection name and id
@@ -666,6 +729,30 @@ func (st *State) ParseTag(tag string) (string, string, error) {
 	return coll, id, nil
 }
 
+// Revision returns the revision of the entity with the given tag,
+// which changes whenever the entity does. It may be used to detect
+// whether an entity has changed since it was last read.
+func (st *State) Revision(tag string) (int64, error) {
+	coll, id, err := st.ParseTag(tag)
+	if err != nil {
+		return 0, err
+	}
+	if coll == st.machines.Name {
+		id = MachineIdFromTag(tag)
+	}
+	doc := struct {
+		TxnRevno int64 `bson:"txn-revno"`
+	}{}
+	st.noteRead()
+	err = st.db.C(coll).FindId(id).Select(D{{"txn-revno", 1}}).One(&doc)
+	if err == mgo.ErrNotFound {
+		return 0, errors.NotFoundf("entity %q", tag)
+	} else if err != nil {
+		return 0, fmt.Errorf("cannot get revision of %q: %v", tag, err)
+	}
+	return doc.TxnRevno, nil
+}
+
 // AddCharm adds the ch charm with curl to the state.  bundleUrl must be
 // set to a URL where the bundle for ch may be downloaded from.
 // On success the newly added charm state is returned.
@@ -687,6 +774,7 @@ func (st *State) AddCharm(ch charm.Charm, curl *charm.URL, bundleURL *url.URL, b
 // Charm returns the charm with the given URL.
 func (st *State) Charm(curl *charm.URL) (*Charm, error) {
 	cdoc := &charmDoc{}
+	st.noteRead()
 	err := st.charms.Find(D{{"_id", curl}}).One(cdoc)
 	if err == mgo.ErrNotFound {
 		return nil, errors.NotFoundf("charm %q", curl)
@@ -801,6 +889,7 @@ func (st *State) Service(name string) (service *Service, err error) {
 	}
 	sdoc := &serviceDoc{}
 	sel := D{{"_id", name}}
+	st.noteRead()
 	err = st.services.Find(sel).One(sdoc)
 	if err == mgo.ErrNotFound {
 		return nil, errors.NotFoundf("service %q", name)
@@ -814,6 +903,7 @@ func (st *State) Service(name string) (service *Service, err error) {
 // AllServices returns all deployed services in the environment.
 func (st *State) AllServices() (services []*Service, err error) {
 	sdocs := []serviceDoc{}
+	st.noteRead()
 	err = st.services.Find(D{}).All(&sdocs)
 	if err != nil {
 		return nil, fmt.Errorf("cannot get all services")
@@ -1043,6 +1133,7 @@ func (st *State) EndpointsRelation(endpoints ...Endpoint) (*Relation, error) {
 // be derived unambiguously from the relation's endpoints).
 func (st *State) KeyRelation(key string) (*Relation, error) {
 	doc := relationDoc{}
+	st.noteRead()
 	err := st.relations.Find(D{{"_id", key}}).One(&doc)
 	if err == mgo.ErrNotFound {
 		return nil, errors.NotFoundf("relation %q", key)
@@ -1056,6 +1147,7 @@ func (st *State) KeyRelation(key string) (*Relation, error) {
 // Relation returns the existing relation with the given id.
 func (st *State) Relation(id int) (*Relation, error) {
 	doc := relationDoc{}
+	st.noteRead()
 	err := st.relations.Find(D{{"id", id}}).One(&doc)
 	if err == mgo.ErrNotFound {
 		return nil, errors.NotFoundf("relation %d", id)
@@ -1072,6 +1164,7 @@ func (st *State) Unit(name string) (*Unit, error) {
 		return nil, fmt.Errorf("%q is not a valid unit name", name)
 	}
 	doc := unitDoc{}
+	st.noteRead()
 	err := st.units.FindId(name).One(&doc)
 	if err == mgo.ErrNotFound {
 		return nil, errors.NotFoundf("unit %q", name)
@@ -1244,6 +1337,7 @@ func (st *State) newCleanupOp(kind, prefix string) txn.Op {
 
 // NeedsCleanup returns true if documents previously marked for removal exist.
 func (st *State) NeedsCleanup() (bool, error) {
+	st.noteRead()
 	count, err := st.cleanups.Count()
 	if err != nil {
 		return false, err
@@ -1256,6 +1350,7 @@ func (st *State) NeedsCleanup() (bool, error) {
 // of the system.
 func (st *State) Cleanup() error {
 	doc := cleanupDoc{}
+	st.noteRead()
 	iter := st.cleanups.Find(nil).Iter()
 	for iter.Next(&doc) {
 		var err error
@@ -1291,6 +1386,7 @@ func (st *State) cleanupSettings(prefix string) error {
 	// system, and will not be under watch, and are therefore safe to
 	// delete directly.
 	sel := D{{"_id", D{{"$regex", "^" + prefix}}}}
+	st.noteRead()
 	if count, err := st.settings.Find(sel).Count(); err != nil {
 		return fmt.Errorf("cannot detect cleanup targets: %v", err)
 	} else if count != 0 {
@@ -1307,6 +1403,7 @@ func (st *State) cleanupUnits(prefix string) error {
 	// transactions, because they could be in any state at all.
 	unit := &Unit{st: st}
 	sel := D{{"_id", D{{"$regex", "^" + prefix}}}, {"life", Alive}}
+	st.noteRead()
 	iter := st.units.Find(sel).Iter()
 	for iter.Next(&unit.doc) {
 		if err := unit.Destroy(); err != nil {
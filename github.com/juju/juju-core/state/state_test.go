@@ -750,6 +750,28 @@ func (s *StateSuite) TestWatchServicesBulkEvents(c *gc.C) {
 	wc.AssertNoChange()
 }
 
+func (s *StateSuite) TestWatchServicesSnapshot(c *gc.C) {
+	dummyCharm := s.AddTestingCharm(c, "dummy")
+	w := s.State.WatchServices()
+	defer statetesting.AssertStop(c, w)
+	wc := statetesting.NewStringsWatcherC(c, s.State, w)
+	wc.AssertChange()
+	snapshotter, ok := w.(state.Snapshotter)
+	c.Assert(ok, gc.Equals, true)
+
+	_, err := s.State.AddService("service1", dummyCharm)
+	c.Assert(err, gc.IsNil)
+	_, err = s.State.AddService("service0", dummyCharm)
+	c.Assert(err, gc.IsNil)
+	snapshot, err := snapshotter.Snapshot()
+	c.Assert(err, gc.IsNil)
+	c.Assert(snapshot, gc.DeepEquals, []string{"service0", "service1"})
+
+	// Taking a snapshot leaves the changes to be reported.
+	wc.AssertChange("service0", "service1")
+	wc.AssertNoChange()
+}
+
 func (s *StateSuite) TestWatchServicesLifecycle(c *gc.C) {
 	// Initial event is empty when no services.
 	w := s.State.WatchServices()
@@ -1194,6 +1216,123 @@ func (s *StateSuite) TestWatchForEnvironConfigChanges(c *gc.C) {
 	wc.AssertNoChange()
 }
 
+func (s *StateSuite) TestWatchForEnvironConstraintsChanges(c *gc.C) {
+	w := s.State.WatchForEnvironConstraintsChanges()
+	defer statetesting.AssertStop(c, w)
+
+	wc := statetesting.NewNotifyWatcherC(c, s.State, w)
+	wc.AssertOneChange()
+
+	err := s.State.SetEnvironConstraints(constraints.MustParse("mem=4G"))
+	c.Assert(err, gc.IsNil)
+	wc.AssertOneChange()
+
+	err = s.State.SetEnvironConstraints(constraints.MustParse("arch=amd64"))
+	c.Assert(err, gc.IsNil)
+	wc.AssertOneChange()
+
+	statetesting.AssertStop(c, w)
+	wc.AssertClosed()
+}
+
+func (s *StateSuite) TestWatchInOrder(c *gc.C) {
+	m, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, gc.IsNil)
+	env, err := s.State.Environment()
+	c.Assert(err, gc.IsNil)
+	w, err := s.State.WatchInOrder([]string{m.Tag(), env.Tag(), m.Tag()})
+	c.Assert(err, gc.IsNil)
+	defer statetesting.AssertStop(c, w)
+
+	// next returns the tags reported by w until n have been read.
+	next := func(n int) []string {
+		s.State.StartSync()
+		var tags []string
+		for len(tags) < n {
+			select {
+			case changes, ok := <-w.Changes():
+				c.Assert(ok, gc.Equals, true)
+				tags = append(tags, changes...)
+			case <-time.After(testing.LongWait):
+				c.Fatalf("watcher did not send change")
+			}
+		}
+		return tags
+	}
+	c.Assert(next(2), gc.DeepEquals, []string{m.Tag(), env.Tag()})
+
+	// Changes are reported in the order they were made.
+	err = m.SetProvisioned("i-0", "fake-nonce", nil)
+	c.Assert(err, gc.IsNil)
+	err = statetesting.SetAgentVersion(s.State, version.MustParse("1.2.3"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(next(2), gc.DeepEquals, []string{m.Tag(), env.Tag()})
+
+	err = statetesting.SetAgentVersion(s.State, version.MustParse("1.2.4"))
+	c.Assert(err, gc.IsNil)
+	err = m.Destroy()
+	c.Assert(err, gc.IsNil)
+	c.Assert(next(2), gc.DeepEquals, []string{env.Tag(), m.Tag()})
+
+	s.State.StartSync()
+	select {
+	case changes := <-w.Changes():
+		c.Fatalf("unexpected change: %v", changes)
+	case <-time.After(testing.ShortWait):
+	}
+}
+
+func (s *StateSuite) TestWatchStateServers(c *gc.C) {
+	m0, err := s.State.AddMachine("series", state.JobManageState)
+	c.Assert(err, gc.IsNil)
+	w := s.State.WatchStateServers()
+	defer statetesting.AssertStop(c, w)
+
+	// Initial event.
+	wc := statetesting.NewNotifyWatcherC(c, s.State, w)
+	wc.AssertOneChange()
+	ids, err := s.State.StateServerMachines()
+	c.Assert(err, gc.IsNil)
+	c.Assert(ids, gc.DeepEquals, []string{m0.Id()})
+
+	// Add a machine that does not manage state: not reported.
+	m1, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, gc.IsNil)
+	wc.AssertNoChange()
+
+	// Add a state server: reported.
+	m2, err := s.State.AddMachine("series", state.JobHostUnits, state.JobManageState)
+	c.Assert(err, gc.IsNil)
+	wc.AssertOneChange()
+	ids, err = s.State.StateServerMachines()
+	c.Assert(err, gc.IsNil)
+	c.Assert(ids, gc.DeepEquals, []string{m0.Id(), m2.Id()})
+
+	// Alter a state server without changing the set: not reported.
+	err = m2.SetProvisioned("i-2", "fake-nonce", nil)
+	c.Assert(err, gc.IsNil)
+	err = m1.Destroy()
+	c.Assert(err, gc.IsNil)
+	wc.AssertNoChange()
+
+	// Destroy a state server: reported.
+	err = m0.Destroy()
+	c.Assert(err, gc.IsNil)
+	wc.AssertOneChange()
+	ids, err = s.State.StateServerMachines()
+	c.Assert(err, gc.IsNil)
+	c.Assert(ids, gc.DeepEquals, []string{m2.Id()})
+}
+
+func (s *StateSuite) TestWatchInOrderBadTag(c *gc.C) {
+	_, err := s.State.WatchInOrder([]string{"environment-foo"})
+	c.Assert(err, gc.ErrorMatches, `environment "environment-foo" not found`)
+	_, err = s.State.WatchInOrder([]string{"user-admin"})
+	c.Assert(err, gc.ErrorMatches, `entity "user-admin" cannot be watched`)
+	_, err = s.State.WatchInOrder([]string{"foo"})
+	c.Assert(err, gc.ErrorMatches, `invalid entity name "foo"`)
+}
+
 func (s *StateSuite) TestWatchEnvironConfigCorruptConfig(c *gc.C) {
 	cfg, err := s.State.EnvironConfig()
 	c.Assert(err, gc.IsNil)
@@ -1665,6 +1804,32 @@ func (s *StateSuite) TestParseTag(c *gc.C) {
 	c.Assert(err, gc.IsNil)
 }
 
+func (s *StateSuite) TestRevision(c *gc.C) {
+	m, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, gc.IsNil)
+	rev, err := s.State.Revision(m.Tag())
+	c.Assert(err, gc.IsNil)
+
+	// Reading the entity does not change its revision.
+	err = m.Refresh()
+	c.Assert(err, gc.IsNil)
+	again, err := s.State.Revision(m.Tag())
+	c.Assert(err, gc.IsNil)
+	c.Assert(again, gc.Equals, rev)
+
+	// Changing it does.
+	err = m.SetProvisioned("i-0", "fake-nonce", nil)
+	c.Assert(err, gc.IsNil)
+	changed, err := s.State.Revision(m.Tag())
+	c.Assert(err, gc.IsNil)
+	c.Assert(changed > rev, gc.Equals, true)
+
+	_, err = s.State.Revision("machine-42")
+	c.Assert(err, jc.Satisfies, errors.IsNotFoundError)
+	_, err = s.State.Revision("foo-bar")
+	c.Assert(err, gc.ErrorMatches, `invalid entity name "foo-bar"`)
+}
+
 func (s *StateSuite) TestCleanup(c *gc.C) {
 	needed, err := s.State.NeedsCleanup()
 	c.Assert(err, gc.IsNil)
@@ -1886,3 +2051,63 @@ func (s *StateSuite) TestContainerTypeFromId(c *gc.C) {
 	c.Assert(state.ContainerTypeFromId("0/lxc/1"), gc.Equals, instance.LXC)
 	c.Assert(state.ContainerTypeFromId("0/lxc/1/kvm/0"), gc.Equals, instance.KVM)
 }
+
+func (s *StateSuite) TestOpCounting(c *gc.C) {
+	c.Assert(s.State.OpCounting(), gc.Equals, false)
+	m, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, gc.IsNil)
+	c.Assert(s.State.OpCounts(), gc.DeepEquals, state.OpCounts{})
+
+	s.State.SetOpCounting(true)
+	defer s.State.SetOpCounting(false)
+	c.Assert(s.State.OpCounting(), gc.Equals, true)
+	_, err = s.State.Machine(m.Id())
+	c.Assert(err, gc.IsNil)
+	err = m.SetPassword("foo")
+	c.Assert(err, gc.IsNil)
+	c.Assert(s.State.OpCounts(), gc.DeepEquals, state.OpCounts{
+		Reads:        1,
+		Transactions: 1,
+		Writes:       1,
+	})
+
+	before := s.State.OpCounts()
+	err = m.Refresh()
+	c.Assert(err, gc.IsNil)
+	c.Assert(s.State.OpCounts().Sub(before), gc.DeepEquals, state.OpCounts{Reads: 1})
+}
+
+func (s *StateSuite) TestWatchMachineRemovals(c *gc.C) {
+	m0, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, gc.IsNil)
+	err = m0.EnsureDead()
+	c.Assert(err, gc.IsNil)
+	m1, err := s.State.AddMachine("series", state.JobHostUnits)
+	c.Assert(err, gc.IsNil)
+
+	// The initial event holds the machines already dead.
+	w := s.State.WatchMachineRemovals()
+	defer statetesting.AssertStop(c, w)
+	wc := statetesting.NewStringsWatcherC(c, s.State, w)
+	wc.AssertChange(m0.Id())
+	wc.AssertNoChange()
+
+	// Machines becoming dying are not reported.
+	err = m1.Destroy()
+	c.Assert(err, gc.IsNil)
+	wc.AssertNoChange()
+
+	// Machines becoming dead are, once.
+	err = m1.EnsureDead()
+	c.Assert(err, gc.IsNil)
+	wc.AssertChange(m1.Id())
+	wc.AssertNoChange()
+
+	// Removals are not reported.
+	err = m0.Remove()
+	c.Assert(err, gc.IsNil)
+	wc.AssertNoChange()
+
+	statetesting.AssertStop(c, w)
+	wc.AssertClosed()
+}
@@ -27,6 +27,7 @@ type statusDoc struct {
 // by the caller before.
 func getStatus(st *State, globalKey string) (statusDoc, error) {
 	var doc statusDoc
+	st.noteRead()
 	err := st.statuses.FindId(globalKey).One(&doc)
 	if err == mgo.ErrNotFound {
 		return statusDoc{}, errors.NotFoundf("status")
@@ -453,6 +453,7 @@ func (u *Unit) PrivateAddress() (string, bool) {
 // Refresh refreshes the contents of the Unit from the underlying
 // state. It an error that satisfies IsNotFound if the unit has been removed.
 func (u *Unit) Refresh() error {
+	u.st.noteRead()
 	err := u.st.units.FindId(u.doc.Name).One(&u.doc)
 	if err == mgo.ErrNotFound {
 		return errors.NotFoundf("unit %q", u)
@@ -580,12 +581,14 @@ func (u *Unit) SetCharmURL(curl *charm.URL) (err error) {
 			return fmt.Errorf("unit %q is dead", u)
 		}
 		sel := D{{"_id", u.doc.Name}, {"charmurl", curl}}
+		u.st.noteRead()
 		if count, err := u.st.units.Find(sel).Count(); err != nil {
 			return err
 		} else if count == 1 {
 			// Already set
 			return nil
 		}
+		u.st.noteRead()
 		if count, err := u.st.charms.FindId(curl).Count(); err != nil {
 			return err
 		} else if count < 1 {
@@ -711,6 +714,7 @@ func (u *Unit) AssignedMachineId() (id string, err error) {
 		return u.doc.MachineId, nil
 	}
 	pudoc := unitDoc{}
+	u.st.noteRead()
 	err = u.st.units.Find(D{{"_id", u.doc.Principal}}).One(&pudoc)
 	if err == mgo.ErrNotFound {
 		return "", errors.NotFoundf("principal unit %q of %q", u.doc.Principal, u)
@@ -943,6 +947,7 @@ func (u *Unit) AssignToNewMachineOrContainer() (err error) {
 	if err != nil {
 		return err
 	}
+	u.st.noteRead()
 	err = query.One(&host)
 	if err == mgo.ErrNotFound {
 		// No existing clean, empty machine so create a new one.
@@ -1036,6 +1041,7 @@ func (u *Unit) findCleanMachineQuery(requireEmpty bool, cons *constraints.Value)
 	// If we need empty machines, first build up a list of machine ids which have containers
 	// so we can exclude those.
 	if requireEmpty {
+		u.st.noteRead()
 		err := u.st.containerRefs.Find(D{hasContainerTerm}).Select(bson.M{"_id": 1}).All(&containerRefs)
 		if err != nil {
 			return nil, err
@@ -1083,6 +1089,7 @@ func (u *Unit) findCleanMachineQuery(requireEmpty bool, cons *constraints.Value)
 		suitableTerms = append(suitableTerms, bson.DocElem{"cpupower", D{{"$gte", *cons.CpuPower}}})
 	}
 	if len(suitableTerms) > 0 {
+		u.st.noteRead()
 		err := u.st.instanceData.Find(suitableTerms).Select(bson.M{"_id": 1}).All(&suitableInstanceData)
 		if err != nil {
 			return nil, err
@@ -1128,6 +1135,7 @@ func (u *Unit) assignToCleanMaybeEmptyMachine(requireEmpty bool) (m *Machine, er
 	// Possible solution: pick the highest and the smallest id of all
 	// unused machines, and try to assign to the first one >= a random id in the
 	// middle.
+	u.st.noteRead()
 	iter := query.Batch(2).Prefetch(0).Iter()
 	var mdoc machineDoc
 	for iter.Next(&mdoc) {
@@ -1064,6 +1064,34 @@ func (s *UnitSuite) TestWatchUnit(c *C) {
 	testing.NewNotifyWatcherC(c, s.State, w).AssertOneChange()
 }
 
+func (s *UnitSuite) TestWatchResolved(c *C) {
+	w := s.unit.WatchResolved()
+	defer testing.AssertStop(c, w)
+
+	// Initial event.
+	wc := testing.NewNotifyWatcherC(c, s.State, w)
+	wc.AssertOneChange()
+
+	// Other changes to the unit are not reported.
+	unit, err := s.State.Unit(s.unit.Name())
+	c.Assert(err, IsNil)
+	err = unit.SetPublicAddress("example.foobar.com")
+	c.Assert(err, IsNil)
+	wc.AssertNoChange()
+
+	// Setting and clearing the resolved mode are.
+	err = unit.SetResolved(state.ResolvedRetryHooks)
+	c.Assert(err, IsNil)
+	wc.AssertOneChange()
+	err = unit.ClearResolved()
+	c.Assert(err, IsNil)
+	wc.AssertOneChange()
+
+	// Stop, check closed.
+	testing.AssertStop(c, w)
+	wc.AssertClosed()
+}
+
 func (s *UnitSuite) TestAnnotatorForUnit(c *C) {
 	testAnnotator(c, func() (state.Annotator, error) {
 		return s.State.Unit("wordpress/0")
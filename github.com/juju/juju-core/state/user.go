@@ -47,6 +47,7 @@ func (st *State) AddUser(name, password string) (*User, error) {
 // getUser fetches information about the user with the
 // given name into the provided userDoc.
 func (st *State) getUser(name string, udoc *userDoc) error {
+	st.noteRead()
 	err := st.users.Find(D{{"_id", name}}).One(udoc)
 	if err == mgo.ErrNotFound {
 		err = errors.NotFoundf("user %q", name)
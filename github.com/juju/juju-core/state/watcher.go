@@ -16,6 +16,7 @@ import (
 	"launchpad.net/juju-core/environs/config"
 	"launchpad.net/juju-core/errors"
 	"launchpad.net/juju-core/instance"
+	"launchpad.net/juju-core/state/presence"
 	"launchpad.net/juju-core/state/watcher"
 	"launchpad.net/juju-core/utils/set"
 )
@@ -38,6 +39,13 @@ type StringsWatcher interface {
 	Changes() <-chan []string
 }
 
+// Snapshotter is implemented by StringsWatchers that can report, at
+// any time, the full set of ids they watch, as sent in their initial
+// event, without disturbing the changes they report.
+type Snapshotter interface {
+	Snapshot() ([]string, error)
+}
+
 // commonWatcher is part of all client watchers.
 type commonWatcher struct {
 	st   *State
@@ -211,6 +219,21 @@ func (w *lifecycleWatcher) initial() (ids *set.Strings, err error) {
 	return ids, nil
 }
 
+// Snapshot implements Snapshotter. It returns the ids of all the
+// watched entities, sorted.
+func (w *lifecycleWatcher) Snapshot() ([]string, error) {
+	ids := &set.Strings{}
+	var doc lifeDoc
+	iter := w.coll.Find(w.members).Select(lifeFields).Iter()
+	for iter.Next(&doc) {
+		ids.Add(doc.Id)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return ids.SortedValues(), nil
+}
+
 func (w *lifecycleWatcher) merge(ids *set.Strings, updates map[string]bool) error {
 	// Separate ids into those thought to exist and those known to be removed.
 	changed := []string{}
@@ -329,6 +352,21 @@ func (w *minUnitsWatcher) initial() (*set.Strings, error) {
 	return serviceNames, iter.Err()
 }
 
+// Snapshot implements Snapshotter. It returns the names of all the
+// services requiring a minimum number of units, sorted.
+func (w *minUnitsWatcher) Snapshot() ([]string, error) {
+	serviceNames := new(set.Strings)
+	doc := &minUnitsDoc{}
+	iter := w.st.minUnits.Find(nil).Iter()
+	for iter.Next(doc) {
+		serviceNames.Add(doc.ServiceName)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return serviceNames.SortedValues(), nil
+}
+
 func (w *minUnitsWatcher) merge(serviceNames *set.Strings, change watcher.Change) error {
 	serviceName := change.Id.(string)
 	if change.Revno == -1 {
@@ -383,6 +421,420 @@ func (w *minUnitsWatcher) Changes() <-chan []string {
 	return w.out
 }
 
+// machineRemovalsWatcher notifies about machines that are Dead, and so
+// waiting to be removed. The first event holds the ids of all the Dead
+// machines; subsequent events hold the ids of those that have become
+// Dead since.
+type machineRemovalsWatcher struct {
+	commonWatcher
+	// dead holds the ids of the Dead machines already seen.
+	dead map[string]bool
+	out  chan []string
+}
+
+// WatchMachineRemovals returns a StringsWatcher that notifies of
+// machines, including containers, that are waiting to be removed.
+func (st *State) WatchMachineRemovals() StringsWatcher {
+	w := &machineRemovalsWatcher{
+		commonWatcher: commonWatcher{st: st},
+		dead:          make(map[string]bool),
+		out:           make(chan []string),
+	}
+	go func() {
+		defer w.tomb.Done()
+		defer close(w.out)
+		w.tomb.Kill(w.loop())
+	}()
+	return w
+}
+
+func (w *machineRemovalsWatcher) initial() (*set.Strings, error) {
+	ids := new(set.Strings)
+	var doc lifeDoc
+	iter := w.st.machines.Find(D{{"life", Dead}}).Select(lifeFields).Iter()
+	for iter.Next(&doc) {
+		w.dead[doc.Id] = true
+		ids.Add(doc.Id)
+	}
+	return ids, iter.Err()
+}
+
+func (w *machineRemovalsWatcher) merge(ids *set.Strings, change watcher.Change) error {
+	id := change.Id.(string)
+	if change.Revno == -1 {
+		delete(w.dead, id)
+		ids.Remove(id)
+		return nil
+	}
+	if w.dead[id] {
+		return nil
+	}
+	var doc lifeDoc
+	err := w.st.machines.FindId(id).Select(lifeFields).One(&doc)
+	if err == mgo.ErrNotFound {
+		// We'll hear about the removal soon enough.
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if doc.Life == Dead {
+		w.dead[id] = true
+		ids.Add(id)
+	}
+	return nil
+}
+
+func (w *machineRemovalsWatcher) loop() (err error) {
+	ch := make(chan watcher.Change)
+	w.st.watcher.WatchCollection(w.st.machines.Name, ch)
+	defer w.st.watcher.UnwatchCollection(w.st.machines.Name, ch)
+	ids, err := w.initial()
+	if err != nil {
+		return err
+	}
+	out := w.out
+	for {
+		select {
+		case <-w.tomb.Dying():
+			return tomb.ErrDying
+		case change, ok := <-ch:
+			if !ok {
+				return watcher.MustErr(w.st.watcher)
+			}
+			if err = w.merge(ids, change); err != nil {
+				return err
+			}
+			if !ids.IsEmpty() {
+				out = w.out
+			}
+		case out <- ids.Values():
+			out = nil
+			ids = new(set.Strings)
+		}
+	}
+	return nil
+}
+
+func (w *machineRemovalsWatcher) Changes() <-chan []string {
+	return w.out
+}
+
+// stateServersWatcher notifies when the set of machines returned by
+// StateServerMachines changes.
+type stateServersWatcher struct {
+	commonWatcher
+	out chan struct{}
+}
+
+// WatchStateServers returns a NotifyWatcher that notifies when the
+// set of state server machines changes: when a machine running the
+// ManageState job is added, or ceases to be alive.
+func (st *State) WatchStateServers() NotifyWatcher {
+	w := &stateServersWatcher{
+		commonWatcher: commonWatcher{st: st},
+		out:           make(chan struct{}),
+	}
+	go func() {
+		defer w.tomb.Done()
+		defer close(w.out)
+		w.tomb.Kill(w.loop())
+	}()
+	return w
+}
+
+// Changes returns the event channel for the stateServersWatcher.
+func (w *stateServersWatcher) Changes() <-chan struct{} {
+	return w.out
+}
+
+func (w *stateServersWatcher) loop() error {
+	in := make(chan watcher.Change)
+	w.st.watcher.WatchCollection(w.st.machines.Name, in)
+	defer w.st.watcher.UnwatchCollection(w.st.machines.Name, in)
+	ids, err := w.st.StateServerMachines()
+	if err != nil {
+		return err
+	}
+	out := w.out
+	for {
+		select {
+		case <-w.tomb.Dying():
+			return tomb.ErrDying
+		case <-w.st.watcher.Dead():
+			return watcher.MustErr(w.st.watcher)
+		case ch := <-in:
+			if _, ok := collect(ch, in, w.tomb.Dying()); !ok {
+				return tomb.ErrDying
+			}
+			latest, err := w.st.StateServerMachines()
+			if err != nil {
+				return err
+			}
+			if !sameStrings(ids, latest) {
+				ids = latest
+				out = w.out
+			}
+		case out <- struct{}{}:
+			out = nil
+		}
+	}
+	return nil
+}
+
+// sameStrings returns whether a and b hold the same strings in the
+// same order.
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// presenceWatcher notifies of changes to the presence of an agent,
+// as reported by the agent's pinger.
+type presenceWatcher struct {
+	commonWatcher
+	key string
+	out chan struct{}
+}
+
+// WatchAgentPresence returns a NotifyWatcher that notifies when the
+// machine's agent is found to be alive, or lost; see AgentAlive.
+func (m *Machine) WatchAgentPresence() NotifyWatcher {
+	return newPresenceWatcher(m.st, m.globalKey())
+}
+
+// WatchAgentPresence returns a NotifyWatcher that notifies when the
+// unit's agent is found to be alive, or lost; see AgentAlive.
+func (u *Unit) WatchAgentPresence() NotifyWatcher {
+	return newPresenceWatcher(u.st, u.globalKey())
+}
+
+func newPresenceWatcher(st *State, key string) NotifyWatcher {
+	w := &presenceWatcher{
+		commonWatcher: commonWatcher{st: st},
+		key:           key,
+		out:           make(chan struct{}),
+	}
+	go func() {
+		defer w.tomb.Done()
+		defer close(w.out)
+		w.tomb.Kill(w.loop())
+	}()
+	return w
+}
+
+// Changes returns the event channel for the presenceWatcher.
+func (w *presenceWatcher) Changes() <-chan struct{} {
+	return w.out
+}
+
+func (w *presenceWatcher) loop() error {
+	in := make(chan presence.Change)
+	w.st.pwatcher.Watch(w.key, in)
+	defer w.st.pwatcher.Unwatch(w.key, in)
+	// The presence watcher reports the agent's initial
+	// presence, which gives the initial event.
+	var out chan struct{}
+	var alive, seen bool
+	for {
+		select {
+		case <-w.tomb.Dying():
+			return tomb.ErrDying
+		case <-w.st.pwatcher.Dead():
+			return w.st.pwatcher.Err()
+		case change := <-in:
+			if !seen || change.Alive != alive {
+				alive, seen = change.Alive, true
+				out = w.out
+			}
+		case out <- struct{}{}:
+			out = nil
+		}
+	}
+	return nil
+}
+
+// addressesWatcher notifies of changes to a machine's addresses.
+type addressesWatcher struct {
+	commonWatcher
+	id  string
+	out chan struct{}
+}
+
+// WatchAddresses returns a NotifyWatcher that notifies when the
+// machine's addresses change, but not of the machine's other changes.
+func (m *Machine) WatchAddresses() NotifyWatcher {
+	w := &addressesWatcher{
+		commonWatcher: commonWatcher{st: m.st},
+		id:            m.doc.Id,
+		out:           make(chan struct{}),
+	}
+	go func() {
+		defer w.tomb.Done()
+		defer close(w.out)
+		w.tomb.Kill(w.loop())
+	}()
+	return w
+}
+
+// Changes returns the event channel for the addressesWatcher.
+func (w *addressesWatcher) Changes() <-chan struct{} {
+	return w.out
+}
+
+// addresses returns the machine's addresses and its document's
+// txn-revno.
+func (w *addressesWatcher) addresses() ([]Address, int64, error) {
+	var doc struct {
+		Addresses []Address
+		TxnRevno  int64 `bson:"txn-revno"`
+	}
+	fields := D{{"addresses", 1}, {"txn-revno", 1}}
+	err := w.st.machines.FindId(w.id).Select(fields).One(&doc)
+	if err == mgo.ErrNotFound {
+		return nil, 0, errors.NotFoundf("machine %v", w.id)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return doc.Addresses, doc.TxnRevno, nil
+}
+
+func (w *addressesWatcher) loop() error {
+	addresses, revno, err := w.addresses()
+	if err != nil {
+		return err
+	}
+	in := make(chan watcher.Change)
+	w.st.watcher.Watch(w.st.machines.Name, w.id, revno, in)
+	defer w.st.watcher.Unwatch(w.st.machines.Name, w.id, in)
+	out := w.out
+	for {
+		select {
+		case <-w.tomb.Dying():
+			return tomb.ErrDying
+		case <-w.st.watcher.Dead():
+			return watcher.MustErr(w.st.watcher)
+		case ch := <-in:
+			if _, ok := collect(ch, in, w.tomb.Dying()); !ok {
+				return tomb.ErrDying
+			}
+			latest, _, err := w.addresses()
+			if err != nil {
+				return err
+			}
+			if !addressesEqual(latest, addresses) {
+				addresses = latest
+				out = w.out
+			}
+		case out <- struct{}{}:
+			out = nil
+		}
+	}
+	return nil
+}
+
+// addressesEqual reports whether a and b hold
+// the same addresses in the same order.
+func addressesEqual(a, b []Address) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// resolvedWatcher notifies of changes to a unit's resolved mode.
+type resolvedWatcher struct {
+	commonWatcher
+	name string
+	out  chan struct{}
+}
+
+// WatchResolved returns a NotifyWatcher that notifies when the unit's
+// resolved mode changes, as when an operator asks for a failed hook
+// to be retried, but not of the unit's other changes.
+func (u *Unit) WatchResolved() NotifyWatcher {
+	w := &resolvedWatcher{
+		commonWatcher: commonWatcher{st: u.st},
+		name:          u.doc.Name,
+		out:           make(chan struct{}),
+	}
+	go func() {
+		defer w.tomb.Done()
+		defer close(w.out)
+		w.tomb.Kill(w.loop())
+	}()
+	return w
+}
+
+// Changes returns the event channel for the resolvedWatcher.
+func (w *resolvedWatcher) Changes() <-chan struct{} {
+	return w.out
+}
+
+// resolved returns the unit's resolved mode and its document's
+// txn-revno.
+func (w *resolvedWatcher) resolved() (ResolvedMode, int64, error) {
+	var doc struct {
+		Resolved ResolvedMode
+		TxnRevno int64 `bson:"txn-revno"`
+	}
+	fields := D{{"resolved", 1}, {"txn-revno", 1}}
+	err := w.st.units.FindId(w.name).Select(fields).One(&doc)
+	if err == mgo.ErrNotFound {
+		return "", 0, errors.NotFoundf("unit %q", w.name)
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	return doc.Resolved, doc.TxnRevno, nil
+}
+
+func (w *resolvedWatcher) loop() error {
+	mode, revno, err := w.resolved()
+	if err != nil {
+		return err
+	}
+	in := make(chan watcher.Change)
+	w.st.watcher.Watch(w.st.units.Name, w.name, revno, in)
+	defer w.st.watcher.Unwatch(w.st.units.Name, w.name, in)
+	out := w.out
+	for {
+		select {
+		case <-w.tomb.Dying():
+			return tomb.ErrDying
+		case <-w.st.watcher.Dead():
+			return watcher.MustErr(w.st.watcher)
+		case ch := <-in:
+			if _, ok := collect(ch, in, w.tomb.Dying()); !ok {
+				return tomb.ErrDying
+			}
+			latest, _, err := w.resolved()
+			if err != nil {
+				return err
+			}
+			if latest != mode {
+				mode = latest
+				out = w.out
+			}
+		case out <- struct{}{}:
+			out = nil
+		}
+	}
+	return nil
+}
+
 // RelationScopeWatcher observes changes to the set of units
 // in a particular relation scope.
 type RelationScopeWatcher struct {
@@ -995,6 +1447,12 @@ func (m *Machine) WatchHardwareCharacteristics() NotifyWatcher {
 	return newEntityWatcher(m.st, m.st.instanceData, m.doc.Id)
 }
 
+// Watch returns a watcher for observing changes to the environment,
+// such as to its lifecycle.
+func (e *Environment) Watch() NotifyWatcher {
+	return newEntityWatcher(e.st, e.st.environments, e.doc.UUID)
+}
+
 // Watch returns a watcher for observing changes to a machine.
 func (m *Machine) Watch() NotifyWatcher {
 	return newEntityWatcher(m.st, m.st.machines, m.doc.Id)
@@ -1005,6 +1463,14 @@ func (s *Service) Watch() NotifyWatcher {
 	return newEntityWatcher(s.st, s.st.services, s.doc.Name)
 }
 
+// WatchConfigSettings returns a watcher for observing changes to the
+// service's configuration settings for its current charm. As for
+// Unit.WatchConfigSettings, the watcher is valid only while the
+// service's charm URL is not changed.
+func (s *Service) WatchConfigSettings() NotifyWatcher {
+	return newEntityWatcher(s.st, s.st.settings, s.settingsKey())
+}
+
 // Watch returns a watcher for observing changes to a unit.
 func (u *Unit) Watch() NotifyWatcher {
 	return newEntityWatcher(u.st, u.st.units, u.doc.Name)
@@ -1017,6 +1483,12 @@ func (st *State) WatchForEnvironConfigChanges() NotifyWatcher {
 	return newEntityWatcher(st, st.settings, environGlobalKey)
 }
 
+// WatchForEnvironConstraintsChanges returns a NotifyWatcher that
+// fires when the environment constraints change.
+func (st *State) WatchForEnvironConstraintsChanges() NotifyWatcher {
+	return newEntityWatcher(st, st.constraints, environGlobalKey)
+}
+
 // WatchConfigSettings returns a watcher for observing changes to the
 // unit's service configuration settings. The unit must have a charm URL
 // set before this method is called, and the returned watcher will be
@@ -1281,3 +1753,121 @@ func (w *cleanupWatcher) loop() (err error) {
 	}
 	panic("unreachable")
 }
+
+// orderedWatcher notifies about changes to a group of documents,
+// reporting them in the order they were made.
+type orderedWatcher struct {
+	commonWatcher
+	tags  map[docKey]string
+	order []string
+	out   chan []string
+}
+
+// WatchInOrder returns a StringsWatcher reporting changes to the
+// entities with the given tags, each event holding the tags of the
+// entities that changed. The tags may name machines, services and
+// units, whose documents hold their lifecycle, or the environment,
+// whose configuration is watched. The first event holds all the tags.
+//
+// Unlike separate watchers on the same entities, which each deliver
+// their changes independently, the group is fed by a single
+// subscription to the state watcher, so the tags in an event, and
+// in successive events, follow the order in which the changes were
+// committed. A tag already pending delivery keeps its place when its
+// entity changes again. The cost is that a change to any member waits on the
+// delivery of changes to all the others, and that the changed
+// entities must be read again to find out what changed.
+func (st *State) WatchInOrder(tags []string) (StringsWatcher, error) {
+	w := &orderedWatcher{
+		commonWatcher: commonWatcher{st: st},
+		tags:          make(map[docKey]string),
+		out:           make(chan []string),
+	}
+	for _, tag := range tags {
+		key, err := st.orderedWatchKey(tag)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := w.tags[key]; !ok {
+			w.tags[key] = tag
+			w.order = append(w.order, tag)
+		}
+	}
+	go func() {
+		defer w.tomb.Done()
+		defer close(w.out)
+		w.tomb.Kill(w.loop())
+	}()
+	return w, nil
+}
+
+// docKey identifies a document watched by an orderedWatcher.
+type docKey struct {
+	coll string
+	id   interface{}
+}
+
+// orderedWatchKey returns the key of the document to watch
+// for changes to the entity with the given tag.
+func (st *State) orderedWatchKey(tag string) (docKey, error) {
+	if strings.HasPrefix(tag, "environment-") {
+		env, err := st.Environment()
+		if err != nil {
+			return docKey{}, err
+		}
+		if env.Tag() != tag {
+			return docKey{}, errors.NotFoundf("environment %q", tag)
+		}
+		return docKey{st.settings.Name, environGlobalKey}, nil
+	}
+	coll, id, err := st.ParseTag(tag)
+	if err != nil {
+		return docKey{}, err
+	}
+	if coll == st.users.Name {
+		return docKey{}, fmt.Errorf("entity %q cannot be watched", tag)
+	}
+	return docKey{coll, id}, nil
+}
+
+// Changes returns the event channel for w.
+func (w *orderedWatcher) Changes() <-chan []string {
+	return w.out
+}
+
+func (w *orderedWatcher) loop() error {
+	in := make(chan watcher.Change)
+	for key, tag := range w.tags {
+		doc := &struct {
+			TxnRevno int64 `bson:"txn-revno"`
+		}{}
+		coll := w.st.db.C(key.coll)
+		if err := coll.FindId(key.id).Select(D{{"txn-revno", 1}}).One(doc); err == mgo.ErrNotFound {
+			doc.TxnRevno = -1
+		} else if err != nil {
+			return fmt.Errorf("cannot watch %q: %v", tag, err)
+		}
+		w.st.watcher.Watch(key.coll, key.id, doc.TxnRevno, in)
+		defer w.st.watcher.Unwatch(key.coll, key.id, in)
+	}
+	changes := append([]string{}, w.order...)
+	out := w.out
+	for {
+		select {
+		case <-w.tomb.Dying():
+			return tomb.ErrDying
+		case <-w.st.watcher.Dead():
+			return watcher.MustErr(w.st.watcher)
+		case ch := <-in:
+			tag := w.tags[docKey{ch.C, ch.Id}]
+			if !hasString(changes, tag) {
+				changes = append(changes, tag)
+			}
+			out = w.out
+		case out <- changes:
+			changes = nil
+			out = nil
+		}
+	}
+	panic("unreachable")
+}